@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+	"github.com/jsteffee/icloud-photo-sync/pkg/email"
+	"github.com/jsteffee/icloud-photo-sync/pkg/photos"
+	"github.com/jsteffee/icloud-photo-sync/pkg/redis"
+	"github.com/jsteffee/icloud-photo-sync/pkg/scraper"
+	"github.com/jsteffee/icloud-photo-sync/pkg/storage"
+)
+
+// checkResult is the outcome of one configuration probe
+type checkResult struct {
+	name   string
+	status string // PASS, FAIL, or SKIP
+	detail string
+}
+
+// runCheck probes each configured subsystem with a lightweight operation, without syncing
+// anything, and writes a pass/fail report to w. Reports whether every probe passed
+func runCheck(cfg *config.Config, w io.Writer) bool {
+	var results []checkResult
+	pass := func(name string, format string, args ...any) {
+		results = append(results, checkResult{name: name, status: "PASS", detail: fmt.Sprintf(format, args...)})
+	}
+	fail := func(name string, err error) {
+		results = append(results, checkResult{name: name, status: "FAIL", detail: err.Error()})
+	}
+	skip := func(name string, detail string) {
+		results = append(results, checkResult{name: name, status: "SKIP", detail: detail})
+	}
+
+	// Redis: connecting pings the server
+	redisClient, err := redis.NewClientWithOptions(cfg.RedisURL, redis.Options{
+		MaxRetries:   cfg.RedisMaxRetries,
+		DialTimeout:  time.Duration(cfg.RedisDialTimeout) * time.Second,
+		ReadTimeout:  time.Duration(cfg.RedisReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.RedisWriteTimeout) * time.Second,
+		Password:     cfg.RedisPassword,
+		KeyPrefix:    cfg.RedisKeyPrefix,
+	})
+	if err != nil {
+		fail("Redis", err)
+	} else {
+		pass("Redis", "reachable")
+		redisClient.Close()
+	}
+
+	// Image directory: create, write, and remove a probe file
+	storageManager, err := storage.NewManagerWithOptions(cfg.ImageDir, storage.Options{
+		Layout:        storage.Layout(cfg.ImageLayout),
+		HashAlgorithm: storage.HashAlgorithm(cfg.HashAlgorithm),
+		HashContent:   storage.HashContent(cfg.HashContent),
+		CACertPath:    cfg.ExtraCACert,
+		DirMode:       cfg.ImageDirMode,
+	})
+	if err == nil {
+		err = storageManager.CheckWritable()
+	}
+	if err != nil {
+		fail("Image directory", err)
+	} else {
+		pass("Image directory", "%s is writable", cfg.ImageDir)
+	}
+
+	// Email: send a test message, which authenticates with the server or API
+	emailSender, err := email.NewSender(cfg.SMTPConfig)
+	if err == nil {
+		err = emailSender.SendAlert("iCloud Photo Sync: configuration check",
+			"This is a test email sent by the iCloud Photo Sync configuration check (--check).", cfg.SMTPDestination)
+	}
+	if err != nil {
+		fail("Email", fmt.Errorf("failed to send test email: %w", err))
+	} else {
+		pass("Email", "sent a test email to %s via %s", cfg.SMTPDestination, cfg.SMTPConfig.Backend)
+	}
+
+	// Google Photos: listing albums refreshes the OAuth token
+	if cfg.GooglePhotosConfig == nil {
+		skip("Google Photos", "not configured")
+	} else {
+		albumName := cfg.GooglePhotosConfig.AlbumName
+		photosClient, err := photos.NewClient(cfg.GooglePhotosConfig)
+		if err == nil {
+			if albumName == "" {
+				// Uploads go to the library only, so there's no album to look up
+				err = photosClient.RefreshAccessToken()
+			} else {
+				_, err = photosClient.FindAlbumByName(albumName)
+			}
+		}
+		switch {
+		case err == nil && albumName == "":
+			skip("Google Photos", "token is valid; no GOOGLE_PHOTOS_ALBUM_NAME set, so photos are uploaded to the library only and no album was checked")
+		case err == nil:
+			pass("Google Photos", "token is valid and album %q exists", albumName)
+		case errors.Is(err, photos.ErrAlbumNotFound):
+			pass("Google Photos", "token is valid; album %q will be created on the first upload", albumName)
+		default:
+			fail("Google Photos", err)
+		}
+	}
+
+	// Albums: fetch each one, bounded by the scraper timeout
+	for i, albumURL := range cfg.AlbumURLs {
+		name := fmt.Sprintf("Album %d", i+1)
+		albumScraper := scraper.NewScraperWithOptions(albumURL, scraper.Options{
+			Timeout: time.Duration(cfg.ScraperTimeout) * time.Second,
+			Header:  cfg.ICloudHeader,
+		})
+		if err := albumScraper.Validate(context.Background()); err != nil {
+			fail(name, err)
+			continue
+		}
+		pass(name, "%s is reachable (%s)", albumScraper.AlbumName(), albumURL)
+	}
+
+	ok := true
+	for _, result := range results {
+		fmt.Fprintf(w, "%-4s  %s: %s\n", result.status, result.name, result.detail)
+		if result.status == "FAIL" {
+			ok = false
+		}
+	}
+	if ok {
+		fmt.Fprintln(w, "All checks passed")
+	} else {
+		fmt.Fprintln(w, "Some checks failed")
+	}
+	return ok
+}
@@ -1,16 +1,18 @@
 module github.com/jsteffee/icloud-photo-sync
 
-go 1.18
+go 1.23.0
 
 require (
 	github.com/Shogoki/icloud-shared-album-go v0.2.0
+	github.com/cespare/xxhash/v2 v2.2.0
 	github.com/redis/go-redis/v9 v9.5.1
 	golang.org/x/oauth2 v0.19.0
 	gopkg.in/mail.v2 v2.3.1
+	lukechampine.com/blake3 v1.4.1
 )
 
 require (
-	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 )
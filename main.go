@@ -1,41 +1,150 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"sort"
 	"syscall"
 	"time"
 
+	"github.com/jsteffee/icloud-photo-sync/pkg/audit"
 	"github.com/jsteffee/icloud-photo-sync/pkg/config"
 	"github.com/jsteffee/icloud-photo-sync/pkg/email"
+	"github.com/jsteffee/icloud-photo-sync/pkg/immich"
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+	"github.com/jsteffee/icloud-photo-sync/pkg/manifest"
+	"github.com/jsteffee/icloud-photo-sync/pkg/notify"
 	"github.com/jsteffee/icloud-photo-sync/pkg/photos"
 	"github.com/jsteffee/icloud-photo-sync/pkg/redis"
+	"github.com/jsteffee/icloud-photo-sync/pkg/s3"
 	"github.com/jsteffee/icloud-photo-sync/pkg/scraper"
+	"github.com/jsteffee/icloud-photo-sync/pkg/slack"
 	"github.com/jsteffee/icloud-photo-sync/pkg/storage"
+	"github.com/jsteffee/icloud-photo-sync/pkg/telegram"
 )
 
 func main() {
+	once := flag.Bool("once", false, "run a single sync and exit (nonzero exit code if any photo failed)")
+	manifestPath := flag.String("manifest", "", "write a JSON manifest of all synced images to this file (\"-\" for stdout) and exit")
+	check := flag.Bool("check", false, "check Redis, email, Google Photos, the albums, and the image directory, print a report, and exit (nonzero exit code if any check failed)")
+	reconcile := flag.Bool("reconcile-google-photos", false, "upload every image that was emailed but not uploaded to Google Photos from the image directory, and exit (nonzero exit code if any upload failed)")
+	migrate := flag.Bool("migrate-keys", false, "move what's recorded as delivered to the current HASH_ALGO, HASH_CONTENT and DEDUP_KEY so switching them doesn't resend photos, and exit (nonzero exit code if any image couldn't be migrated)")
+	flag.Parse()
+
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	if *once {
+		cfg.RunOnce = true
+	}
+	logging.SetLevel(cfg.LogLevel)
+
+	if *check {
+		if !runCheck(cfg, os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
 
-	redisClient, err := redis.NewClient(cfg.RedisURL)
+	redisClient, err := redis.NewClientWithOptions(cfg.RedisURL, redis.Options{
+		MaxRetries:    cfg.RedisMaxRetries,
+		PoolSize:      cfg.RedisPoolSize,
+		DialTimeout:   time.Duration(cfg.RedisDialTimeout) * time.Second,
+		ReadTimeout:   time.Duration(cfg.RedisReadTimeout) * time.Second,
+		WriteTimeout:  time.Duration(cfg.RedisWriteTimeout) * time.Second,
+		Password:      cfg.RedisPassword,
+		HashCacheSize: cfg.HashCacheSize,
+		KeyPrefix:     cfg.RedisKeyPrefix,
+		ReplicaURL:    cfg.RedisReplicaURL,
+	})
 	if err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
 	defer redisClient.Close()
 
-	storageManager, err := storage.NewManager(cfg.ImageDir)
+	if cfg.ResetQuarantine {
+		removed, err := redisClient.ResetAllFailures()
+		if err != nil {
+			log.Fatalf("Failed to reset quarantine: %v", err)
+		}
+		logging.Infof("Reset quarantine: cleared %d failure/dead-letter entries", removed)
+	}
+
+	storageManager, err := storage.NewManagerWithOptions(cfg.ImageDir, storage.Options{
+		Layout:                 storage.Layout(cfg.ImageLayout),
+		HashAlgorithm:          storage.HashAlgorithm(cfg.HashAlgorithm),
+		HashContent:            storage.HashContent(cfg.HashContent),
+		AllowedTypes:           cfg.AllowedTypes,
+		BlockedTypes:           cfg.BlockedTypes,
+		DownloadTimeout:        downloadTimeout(cfg.DownloadTimeout),
+		DownloadTimeoutPerMB:   time.Duration(cfg.DownloadTimeoutPerMB) * time.Second,
+		AllowDeletedFiles:      cfg.DeleteAfterUpload,
+		MaxConnsPerHost:        maxConnsPerHost(cfg.MaxConnsPerHost),
+		MaxIdleConnsPerHost:    cfg.MaxIdleConnsPerHost,
+		MaxDownloadsPerHost:    cfg.MaxDownloadsPerHost,
+		NormalizeOrientation:   cfg.NormalizeOrientation,
+		StripGPS:               cfg.StripGPS,
+		StripAllEXIF:           cfg.StripAllEXIF,
+		CACertPath:             cfg.ExtraCACert,
+		MaxDownloadBytesPerSec: cfg.MaxDownloadBytesPerSec,
+		MinWidth:               cfg.MinImageWidth,
+		MinHeight:              cfg.MinImageHeight,
+		DirMode:                cfg.ImageDirMode,
+		FileMode:               cfg.ImageFileMode,
+	})
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
+	if cfg.MaxDownloadBytesPerSec > 0 {
+		logging.Infof("Downloads limited to %d bytes/sec in total", cfg.MaxDownloadBytesPerSec)
+	}
+	if cfg.MinImageWidth > 0 || cfg.MinImageHeight > 0 {
+		logging.Infof("Skipping images smaller than %dx%d", cfg.MinImageWidth, cfg.MinImageHeight)
+	}
+
+	storageManager.SetURLCache(redisClient)
+
+	// Runs are skipped until the image directory is writable again, so this only warns
+	if err := storageManager.CheckWritable(); err != nil {
+		logging.Warnf("%v. Sync runs will be skipped until %s is writable.", err, cfg.ImageDir)
+	}
+
+	if *manifestPath != "" {
+		if err := writeManifest(*manifestPath, redisClient, storageManager); err != nil {
+			log.Fatalf("Failed to export manifest: %v", err)
+		}
+		return
+	}
+
+	albumScrapers := newAlbumScrapers(cfg)
+
+	if *migrate {
+		failed, err := migrateKeys(cfg, redisClient, storageManager, albumScrapers)
+		if err != nil {
+			log.Fatalf("Failed to migrate keys: %v", err)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Opt-in, since it reads every stored image
+	if cfg.VerifyExistingFiles != "off" {
+		verifyExistingFiles(cfg, storageManager)
+	}
 
 	emailSender, err := email.NewSender(cfg.SMTPConfig)
 	if err != nil {
 		log.Fatalf("Failed to initialize email sender: %v", err)
 	}
+	emailSender.SetConcurrency(cfg.EmailConcurrency)
 
 	// Initialize Google Photos client if configured
 	var photosClient *photos.Client
@@ -44,190 +153,925 @@ func main() {
 		if err != nil {
 			log.Fatalf("Failed to initialize Google Photos client: %v", err)
 		}
-		log.Printf("Google Photos integration enabled for album: %s", cfg.GooglePhotosConfig.AlbumName)
+		photosClient.SetUploadTokenStore(redisClient)
+		logging.Infof("Google Photos integration enabled for album: %s", cfg.GooglePhotosConfig.AlbumName)
+		if cfg.GooglePhotosConfig.VerifyUploads {
+			logging.Infof("Google Photos uploads will be verified after creation")
+		}
+		if cfg.GooglePhotosConfig.SkipIfInAlbum {
+			logging.Infof("Google Photos uploads will skip photos already in the album")
+		}
+		if cfg.GooglePhotosConfig.AlbumRotation != "none" {
+			logging.Infof("Google Photos albums rotate %s by capture date", cfg.GooglePhotosConfig.AlbumRotation)
+		}
 	} else {
-		log.Printf("Google Photos integration disabled (no configuration provided)")
+		logging.Infof("Google Photos integration disabled (no configuration provided)")
 	}
 
-	// Create scrapers for each album URL
-	albumScrapers := make([]*scraper.Scraper, 0, len(cfg.AlbumURLs))
-	for _, albumURL := range cfg.AlbumURLs {
-		albumScrapers = append(albumScrapers, scraper.NewScraper(albumURL))
+	if *reconcile {
+		if photosClient == nil {
+			log.Fatalf("--reconcile-google-photos requires Google Photos to be configured")
+		}
+		failed, err := reconcileGooglePhotos(cfg, redisClient, storageManager, photosClient)
+		if err != nil {
+			log.Fatalf("Failed to reconcile Google Photos: %v", err)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	registry, err := buildNotifiers(cfg, storageManager, emailSender, photosClient)
+	if err != nil {
+		log.Fatalf("Failed to initialize notifiers: %v", err)
 	}
 
-	log.Printf("Starting iCloud Photo Sync Service")
-	log.Printf("Album URLs: %v", cfg.AlbumURLs)
-	log.Printf("Number of albums: %d", len(cfg.AlbumURLs))
-	log.Printf("Run interval: %d seconds", cfg.RunInterval)
-	log.Printf("Max items per run: %d", cfg.MaxItems)
-	log.Printf("Image directory: %s", cfg.ImageDir)
+	validateAlbums(albumScrapers, cfg)
+
+	var auditLog *audit.Logger
+	if cfg.AuditLog != "" {
+		auditLog, err = audit.New(cfg.AuditLog, cfg.AuditLogMaxBytes)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		defer auditLog.Close()
+	}
+
+	logging.Infof("Starting iCloud Photo Sync Service")
+	logging.Infof("Album URLs: %v", cfg.AlbumURLs)
+	logging.Infof("Number of albums: %d", len(cfg.AlbumURLs))
+	if len(cfg.DisabledAlbumURLs) > 0 {
+		logging.Infof("Disabled albums (not synced): %v", cfg.DisabledAlbumURLs)
+	}
+	logging.Infof("Run interval: %d seconds", cfg.RunInterval)
+	logging.Infof("Scraper timeout: %d seconds (%d retries on transient errors)", cfg.ScraperTimeout, cfg.ScraperRetries)
+	if len(cfg.ICloudHeader) > 0 {
+		logging.Infof("Sending %d extra headers with album requests to iCloud (ICLOUD_COOKIE/ICLOUD_HEADERS)", len(cfg.ICloudHeader))
+	}
+	if cfg.ScrapeCacheTTL > 0 {
+		logging.Infof("Album listings are reused for %d seconds before fetching them again", cfg.ScrapeCacheTTL)
+	}
+	logging.Infof("Max items per run: %d", cfg.MaxItems)
+	if cfg.MaxRunDuration > 0 {
+		logging.Infof("Max run duration: %d seconds", cfg.MaxRunDuration)
+	}
+	if cfg.MinPhotoAge > 0 {
+		logging.Infof("Photos are synced once they've been in their album for %d seconds", cfg.MinPhotoAge)
+	}
+	logging.Infof("Max consecutive failures before quarantine: %d", cfg.MaxFailures)
+	if cfg.EmailMaxAttempts > 0 {
+		logging.Infof("Max email attempts before dead-lettering: %d", cfg.EmailMaxAttempts)
+	}
+	logging.Infof("Notifiers: %v", registry.Names())
+	logging.Infof("Email backend: %s", cfg.SMTPConfig.Backend)
+	if cfg.SyncLock {
+		logging.Infof("Sync lock enabled: only one instance sharing this Redis syncs at a time (lock TTL %d seconds)", cfg.SyncLockTTL)
+	}
+	logging.Infof("Image directory: %s (layout: %s)", cfg.ImageDir, cfg.ImageLayout)
+	if cfg.ImageDirMode != 0 {
+		logging.Infof("Image directories are created with mode %04o", cfg.ImageDirMode)
+	}
+	if cfg.ImageFileMode != 0 {
+		logging.Infof("Images are stored with mode %04o", cfg.ImageFileMode)
+	}
+	logging.Infof("Hash algorithm: %s over %s content (dedup key: %s)", cfg.HashAlgorithm, cfg.HashContent, cfg.DedupKey)
+	logging.Infof("Log level: %s", cfg.LogLevel)
+	if auditLog != nil {
+		logging.Infof("Audit log: %s (rotated at %d bytes)", cfg.AuditLog, cfg.AuditLogMaxBytes)
+	}
+	if cfg.ExtraCACert != "" {
+		logging.Infof("Trusting extra CA certificates from %s for downloads and Google Photos", cfg.ExtraCACert)
+	}
+	if cfg.NormalizeOrientation {
+		logging.Infof("Normalizing JPEG orientation: sideways photos are rotated upright after download")
+	}
+	if cfg.StripAllEXIF {
+		logging.Infof("Stripping EXIF, XMP and IPTC metadata from downloaded JPEGs")
+	} else if cfg.StripGPS {
+		logging.Infof("Stripping the location from downloaded JPEGs")
+	}
+	if cfg.QuietHours != nil {
+		logging.Infof("Quiet hours: %s (pausing %v)", cfg.QuietHours, cfg.QuietHours.Notifiers)
+	}
 
 	// Handle graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
+	// Serve the outcome of the runs for dashboards and probes
+	status := &syncStatus{}
+	if cfg.StatusAddr != "" {
+		serveStatus(cfg.StatusAddr, status)
+	}
+
 	// Run initial sync
-	runSync(albumScrapers, storageManager, redisClient, emailSender, photosClient, cfg)
+	summary, err := runSync(albumScrapers, storageManager, redisClient, registry, auditLog, cfg)
+	status.recordRun(summary, err, time.Now())
 
-	// Set up ticker for periodic runs
-	ticker := time.NewTicker(time.Duration(cfg.RunInterval) * time.Second)
-	defer ticker.Stop()
+	// In run-once mode (cron, Kubernetes CronJobs) exit after the first sync instead of looping
+	if cfg.RunOnce {
+		if err != nil {
+			logging.Errorf("Run-once mode: sync failed: %v, exiting with status 1", err)
+			redisClient.Close()
+			os.Exit(1)
+		}
+		if summary.Failed > 0 {
+			logging.Errorf("Run-once mode: sync finished with %d failures, exiting with status 1", summary.Failed)
+			redisClient.Close()
+			os.Exit(1)
+		}
+		logging.Infof("Run-once mode: sync finished successfully, exiting")
+		return
+	}
+
+	// Schedule the next run: the normal interval after a successful run, or a shorter,
+	// backed-off retry interval after a run that failed outright
+	failedRuns := 0
+	nextRun := func(summary runSummary, err error) time.Duration {
+		var delay time.Duration
+		if err == nil {
+			failedRuns = 0
+			delay = quietHoursDelay(cfg, summary.Deferred, time.Duration(cfg.RunInterval)*time.Second, time.Now())
+		} else {
+			failedRuns++
+			delay = retryDelay(cfg, failedRuns)
+			logging.Warnf("Sync run failed: %v. Retrying in %v", err, delay)
+		}
+		status.setNextRun(time.Now().Add(delay))
+		return delay
+	}
+	timer := time.NewTimer(nextRun(summary, err))
+	defer timer.Stop()
 
 	// Main loop
 	for {
 		select {
-		case <-ticker.C:
-			runSync(albumScrapers, storageManager, redisClient, emailSender, photosClient, cfg)
+		case <-timer.C:
+			summary, err := runSync(albumScrapers, storageManager, redisClient, registry, auditLog, cfg)
+			status.recordRun(summary, err, time.Now())
+			timer.Reset(nextRun(summary, err))
 		case <-sigChan:
-			log.Println("Received shutdown signal, exiting...")
+			logging.Infof("Received shutdown signal, exiting...")
 			return
 		}
 	}
 }
 
-func runSync(
-	albumScrapers []*scraper.Scraper,
+// manifestServices are the store tracking keys included in the manifest (see notify.Notifier.Name)
+var manifestServices = []string{"email", "contact_sheet", "google_photos", "webhook", "archive", "hook", "s3", "immich", "telegram", "slack"}
+
+// writeManifest exports every synced image as a JSON manifest to path ("-" for stdout)
+func writeManifest(path string, redisClient *redis.Client, storageManager *storage.Manager) error {
+	m, err := manifest.Build(redisClient, storageManager, manifestServices)
+	if err != nil {
+		return err
+	}
+
+	if path == "-" {
+		return manifest.Write(os.Stdout, m)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create manifest file: %w", err)
+	}
+	if err := manifest.Write(f, m); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write manifest file: %w", err)
+	}
+	logging.Infof("Wrote manifest of %d images to %s", len(m.Images), path)
+	return nil
+}
+
+// newAlbumScrapers creates a scraper for each album URL
+func newAlbumScrapers(cfg *config.Config) []*scraper.Scraper {
+	albumScrapers := make([]*scraper.Scraper, 0, len(cfg.AlbumURLs))
+	for _, albumURL := range cfg.AlbumURLs {
+		albumScrapers = append(albumScrapers, scraper.NewScraperWithOptions(albumURL, scraper.Options{
+			Timeout:  time.Duration(cfg.ScraperTimeout) * time.Second,
+			Retries:  cfg.ScraperRetries,
+			CacheTTL: time.Duration(cfg.ScrapeCacheTTL) * time.Second,
+			Header:   cfg.ICloudHeader,
+		}))
+	}
+	return albumScrapers
+}
+
+// validateAlbums checks every album URL before the first sync so config mistakes surface immediately
+// Malformed URLs are always fatal; unreachable albums are fatal only with ALBUM_VALIDATION=strict
+func validateAlbums(albumScrapers []*scraper.Scraper, cfg *config.Config) {
+	if cfg.AlbumValidation == "off" {
+		return
+	}
+
+	for i, albumScraper := range albumScrapers {
+		albumURL := cfg.AlbumURLs[i]
+		err := albumScraper.Validate(context.Background())
+		switch {
+		case err == nil:
+			logging.Infof("Album %d OK: %s (%s)", i+1, albumScraper.AlbumName(), albumURL)
+		case errors.Is(err, scraper.ErrInvalidAlbumURL), cfg.AlbumValidation == "strict":
+			log.Fatalf("Album %d failed validation: %v", i+1, err)
+		default:
+			logging.Warnf("Album %d failed validation and may not sync: %v", i+1, err)
+		}
+	}
+}
+
+// buildNotifiers registers every configured delivery sink in the order they run
+func buildNotifiers(
+	cfg *config.Config,
 	storageManager *storage.Manager,
-	redisClient *redis.Client,
 	emailSender *email.Sender,
 	photosClient *photos.Client,
-	cfg *config.Config,
-) {
-	log.Println("Starting sync run...")
+) (*notify.Registry, error) {
+	registry := notify.NewRegistry()
 
-	// Collect image URLs from all albums
-	var allImageURLs []string
-	for i, albumScraper := range albumScrapers {
-		imageURLs, err := albumScraper.GetImageURLs()
+	// Kept to set up links to the originals once the notifier hosting them is known
+	var emailNotifier *notify.EmailNotifier
+	switch {
+	case cfg.EmailZip:
+		zipNotifier := notify.NewEmailZipNotifier(emailSender, storageManager, cfg.SMTPDestination, cfg.EmailZipMaxBytes)
+		zipNotifier.SetPartialSend(cfg.EmailZipPartialSend)
+		registry.Register(zipNotifier)
+	case cfg.EmailContactSheet == "only":
+		// Replaces the per-image emails, so it shares their tracking key
+		registry.Register(notify.NewContactSheetNotifier(emailSender, cfg.SMTPDestination, "email", cfg.ContactSheetColumns, cfg.ContactSheetThumbSize, os.TempDir()))
+	default:
+		emailNotifier = notify.NewEmailNotifier(emailSender, cfg.SMTPDestination)
+		registry.RegisterWithConcurrency(emailNotifier, cfg.EmailConcurrency)
+	}
+	if cfg.EmailContactSheet == "also" {
+		registry.Register(notify.NewContactSheetNotifier(emailSender, cfg.SMTPDestination, "contact_sheet", cfg.ContactSheetColumns, cfg.ContactSheetThumbSize, os.TempDir()))
+	}
+	var originalLinker notify.OriginalLinker
+
+	if photosClient != nil {
+		var onUnavailable func(err error) error
+		if cfg.AlertDestination != "" {
+			onUnavailable = func(err error) error {
+				return sendGooglePhotosAlert(emailSender, cfg, err)
+			}
+		}
+		googlePhotosNotifier := notify.NewGooglePhotosNotifier(photosClient, cfg.GooglePhotosConfig.AlbumName, onUnavailable)
+		googlePhotosNotifier.SetAlbumRotation(cfg.GooglePhotosConfig.AlbumRotation)
+		registry.RegisterWithConcurrency(googlePhotosNotifier, cfg.GooglePhotosConcurrency)
+	}
+
+	if cfg.WebhookURL != "" {
+		registry.Register(notify.NewWebhookNotifier(cfg.WebhookURL))
+	}
+
+	var nameTemplate *notify.NameTemplate
+	if cfg.ArchiveNameTemplate != "" {
+		var err error
+		if nameTemplate, err = notify.ParseNameTemplate(cfg.ArchiveNameTemplate); err != nil {
+			return nil, fmt.Errorf("invalid ARCHIVE_NAME_TEMPLATE: %w", err)
+		}
+		logging.Infof("Naming archived files and S3 objects with template: %s", cfg.ArchiveNameTemplate)
+	}
+
+	if cfg.ArchiveDir != "" {
+		archiveNotifier, err := notify.NewArchiveNotifier(cfg.ArchiveDir)
 		if err != nil {
-			log.Printf("Error scraping album %d: %v", i+1, err)
-			continue
+			return nil, err
+		}
+		if nameTemplate != nil {
+			archiveNotifier.SetNameTemplate(nameTemplate)
 		}
-		log.Printf("Found %d image URLs in album %d", len(imageURLs), i+1)
-		allImageURLs = append(allImageURLs, imageURLs...)
+		if cfg.ArchiveBaseURL != "" {
+			archiveNotifier.SetBaseURL(cfg.ArchiveBaseURL)
+			originalLinker = archiveNotifier
+		}
+		registry.Register(archiveNotifier)
 	}
 
-	log.Printf("Found %d total image URLs across all albums", len(allImageURLs))
+	if cfg.PostHook != "" {
+		registry.Register(notify.NewHookNotifier(cfg.PostHook, time.Duration(cfg.PostHookTimeout)*time.Second))
+	}
 
-	// Get Google Photos album ID if configured (cache it for the run)
-	// If AlbumName is not set, photos will be uploaded to library only (for partner sharing)
-	var googlePhotosAlbumID string
-	if photosClient != nil {
-		if cfg.GooglePhotosConfig.AlbumName != "" {
-			// Album name is specified - get or create the album
-			albumID, err := photosClient.GetOrCreateAlbumID()
-			if err != nil {
-				log.Printf("Error getting/creating Google Photos album: %v. Google Photos sync will be skipped for this run.", err)
-				photosClient = nil // Disable Google Photos for this run
-			} else {
-				googlePhotosAlbumID = albumID
-				log.Printf("Using Google Photos album ID: %s", googlePhotosAlbumID)
-			}
-		} else {
-			// No album name specified - upload to library only (for partner sharing)
-			log.Printf("No album name specified - photos will be uploaded to library only (partner sharing will work if enabled)")
+	if cfg.S3Config != nil {
+		s3Client, err := s3.NewClient(cfg.S3Config)
+		if err != nil {
+			return nil, err
 		}
+		s3Notifier := notify.NewS3Notifier(s3Client, cfg.S3Config.Prefix, cfg.S3Config.KeyFormat)
+		s3Notifier.SetLinkExpiry(time.Duration(cfg.S3Config.LinkExpiry) * time.Second)
+		if nameTemplate != nil {
+			s3Notifier.SetNameTemplate(nameTemplate)
+		}
+		originalLinker = s3Notifier // Preferred over the archive: reachable without exposing a server
+		registry.Register(s3Notifier)
+		logging.Infof("S3 upload enabled for bucket: %s", cfg.S3Config.Bucket)
 	}
 
-	processedCount := 0
-	log.Printf("Starting to process %d image URLs", len(allImageURLs))
-	for i, imageURL := range allImageURLs {
-		if processedCount >= cfg.MaxItems {
-			log.Printf("Reached MAX_ITEMS limit (%d), stopping for this run", cfg.MaxItems)
-			break
+	if cfg.ImmichConfig != nil {
+		immichClient, err := immich.NewClient(cfg.ImmichConfig)
+		if err != nil {
+			return nil, err
 		}
+		registry.Register(notify.NewImmichNotifier(immichClient))
+		logging.Infof("Immich upload enabled for server: %s", cfg.ImmichConfig.URL)
+	}
 
-		log.Printf("Processing image %d/%d: %s", i+1, len(allImageURLs), imageURL)
+	if cfg.TelegramConfig != nil {
+		telegramClient, err := telegram.NewClient(cfg.TelegramConfig)
+		if err != nil {
+			return nil, err
+		}
+		registry.Register(notify.NewChatNotifier("telegram", telegramClient))
+		logging.Infof("Telegram posting enabled for chat: %s", cfg.TelegramConfig.ChatID)
+	}
 
-		// Download and hash the image (high-quality version only - original or medium)
-		// The scraper ensures only high-quality images are selected (skips thumbnails)
-		// This same high-quality image will be used for both email and Google Photos
-		imagePath, hash, err := storageManager.DownloadAndHash(imageURL)
+	if cfg.SlackConfig != nil {
+		slackClient, err := slack.NewClient(cfg.SlackConfig)
 		if err != nil {
-			log.Printf("Error downloading image %s: %v", imageURL, err)
-			continue
+			return nil, err
 		}
-		log.Printf("Downloaded and hashed image: %s (hash: %s)", imagePath, hash)
+		registry.Register(notify.NewChatNotifier("slack", slackClient))
+		logging.Infof("Slack posting enabled for channel: %s", cfg.SlackConfig.ChannelID)
+	}
 
-		// Check processing status for both email and Google Photos independently
-		emailExists, err := redisClient.HashExistsForEmail(hash)
+	if cfg.EmailAttachment == "medium" && emailNotifier != nil && originalLinker != nil {
+		emailNotifier.SetMediumCopy(originalLinker, cfg.EmailMediumSize, os.TempDir())
+		logging.Infof("Emailing %dpx copies with links to the originals", cfg.EmailMediumSize)
+	}
+	if cfg.EmailAttachment == "thumbnail" && emailNotifier != nil {
+		emailNotifier.SetThumbnail(originalLinker, cfg.EmailThumbnailSize, os.TempDir())
+		logging.Infof("Emailing %dpx thumbnails instead of the photos", cfg.EmailThumbnailSize)
+	}
+	if cfg.EmailDerivative == "medium" {
+		logging.Infof("Emailing the smallest iCloud size of at least %dpx where one is offered", emailScaleSize(cfg))
+	}
+
+	return registry, nil
+}
+
+// sendGooglePhotosAlert emails the operator that Google Photos uploads have stopped and why
+func sendGooglePhotosAlert(emailSender *email.Sender, cfg *config.Config, err error) error {
+	var subject, problem, action string
+	switch {
+	case errors.Is(err, photos.ErrAlbumFull):
+		subject = "iCloud Photo Sync: Google Photos album is full"
+		problem = "the Google Photos album has reached the 20,000 item limit"
+		action = "Set GOOGLE_PHOTOS_ALBUM_NAME to a new album and restart the service."
+	case errors.Is(err, photos.ErrStorageQuotaExceeded):
+		subject = "iCloud Photo Sync: Google Photos storage is full"
+		problem = "the Google account has run out of storage"
+		action = "Free up space or upgrade the account's storage plan; uploads resume automatically on the next run."
+	default:
+		subject = "iCloud Photo Sync: Google Photos re-authorization required"
+		problem = "the refresh token was revoked or has expired"
+		action = "Generate a new GOOGLE_PHOTOS_REFRESH_TOKEN (see get_refresh_token.py) and restart the service."
+	}
+
+	body := fmt.Sprintf("iCloud Photo Sync can no longer upload to Google Photos because %s.\n\nError: %v\n\n%s "+
+		"Photos will continue to be emailed in the meantime.", problem, err, action)
+	if err := emailSender.SendAlert(subject, body, cfg.AlertDestination); err != nil {
+		return fmt.Errorf("failed to send Google Photos alert email: %w", err)
+	}
+	logging.Infof("Sent Google Photos alert to %s", cfg.AlertDestination)
+	return nil
+}
+
+// downloadTimeout converts DOWNLOAD_TIMEOUT to a storage option, where 0 means "no timeout"
+// rather than the storage default
+func downloadTimeout(seconds int) time.Duration {
+	if seconds == 0 {
+		return -1
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// quietHoursDelay shortens the delay before the next run so that the deliveries queued during
+// quiet hours go out as soon as the window ends rather than up to RUN_INTERVAL later
+func quietHoursDelay(cfg *config.Config, deferred int, delay time.Duration, now time.Time) time.Duration {
+	if deferred == 0 || cfg.QuietHours == nil || !cfg.QuietHours.Active(now) {
+		return delay
+	}
+	untilEnd := cfg.QuietHours.NextEnd(now).Sub(now)
+	if untilEnd >= delay {
+		return delay
+	}
+	logging.Infof("Next run at the end of quiet hours, in %v", untilEnd.Round(time.Second))
+	return untilEnd
+}
+
+// maxConnsPerHost converts MAX_CONNS_PER_HOST to a storage option, where 0 means "no limit"
+// rather than the storage default
+func maxConnsPerHost(conns int) int {
+	if conns == 0 {
+		return -1
+	}
+	return conns
+}
+
+// emailScaleSize returns the longest side emailed photos are scaled to, which is also the
+// smallest iCloud size worth downloading for them with EMAIL_DERIVATIVE=medium
+func emailScaleSize(cfg *config.Config) int {
+	if cfg.EmailAttachment == "thumbnail" {
+		return cfg.EmailThumbnailSize
+	}
+	return cfg.EmailMediumSize
+}
+
+// syncLockOwner identifies this process as the holder of the sync lock
+var syncLockOwner = func() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), time.Now().UnixNano())
+}()
+
+// errSyncLockLost stops a run whose sync lock expired, since another instance may take over
+var errSyncLockLost = errors.New("lost the sync lock")
+
+// runLocked runs a one-off task while holding the sync lock when SYNC_LOCK is set, so it
+// doesn't race a sync run of another instance. The task's ctx is canceled if the lock is lost
+func runLocked(cfg *config.Config, redisClient *redis.Client, task func(ctx context.Context) (int, error)) (int, error) {
+	if !cfg.SyncLock {
+		return task(context.Background())
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	release, err := acquireSyncLock(redisClient, time.Duration(cfg.SyncLockTTL)*time.Second, cancel)
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire sync lock: %w", err)
+	}
+	if release == nil {
+		return 0, errors.New("another instance holds the sync lock; try again once its run finishes")
+	}
+	defer release()
+	return task(ctx)
+}
+
+// acquireSyncLock takes the Redis sync lock for ttl and keeps refreshing it until the returned
+// release function is called. If the lock is lost (e.g. Redis was unreachable for longer than
+// ttl), cancel is called so the run stops. Returns a nil release function if another instance
+// holds the lock
+func acquireSyncLock(redisClient *redis.Client, ttl time.Duration, cancel context.CancelCauseFunc) (func(), error) {
+	acquired, holder, err := redisClient.AcquireLock("sync", syncLockOwner, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		logging.Infof("Skipping this run: previous run still in progress (sync lock held by %s)", holder)
+		return nil, nil
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(ttl / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				held, err := redisClient.RefreshLock("sync", syncLockOwner, ttl)
+				if err != nil {
+					logging.Errorf("Error refreshing sync lock: %v", err)
+				} else if !held {
+					logging.Warnf("Lost the sync lock; stopping this run so another instance can take over")
+					cancel(errSyncLockLost)
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		if err := redisClient.ReleaseLock("sync", syncLockOwner); err != nil {
+			logging.Errorf("Error releasing sync lock: %v", err)
+		}
+	}, nil
+}
+
+// retryDelay returns how long to wait before retrying after failedRuns consecutive failed runs:
+// RETRY_INTERVAL doubled for each further failure, capped at RUN_INTERVAL
+func retryDelay(cfg *config.Config, failedRuns int) time.Duration {
+	runInterval := time.Duration(cfg.RunInterval) * time.Second
+	delay := time.Duration(cfg.RetryInterval) * time.Second
+	if delay <= 0 {
+		return runInterval
+	}
+	for i := 1; i < failedRuns && delay < runInterval; i++ {
+		delay *= 2
+	}
+	if delay > runInterval {
+		delay = runInterval
+	}
+	return delay
+}
+
+// runSummary is the outcome of a sync run
+type runSummary struct {
+	Scraped  int     // Image URLs found across all albums
+	New      int     // Images delivered to at least one notifier for the first time
+	Emailed  int     // Images delivered by the email notifier
+	Uploaded int     // Images uploaded by the Google Photos notifier
+	Failed   int     // Scrape, download, and delivery failures
+	Deferred int     // Deliveries queued by quiet hours, sent by the first run once they end
+	Errors   []error // Errors behind the failures, in the order they happened
+}
+
+// runSync performs one sync pass over all albums and returns a summary of what it did,
+// including the failures (scrape, download, or delivery errors) encountered during the run
+// An error is returned if the run could not do anything at all (Redis unreachable or
+// every album failed to scrape) so the next run can be retried sooner
+func runSync(
+	albumScrapers []*scraper.Scraper,
+	storageManager *storage.Manager,
+	redisClient *redis.Client,
+	registry *notify.Registry,
+	auditLog *audit.Logger,
+	cfg *config.Config,
+) (runSummary, error) {
+	logging.Infof("Starting sync run...")
+	var summary runSummary
+	fail := func(err error) {
+		summary.Failed++
+		summary.Errors = append(summary.Errors, err)
+	}
+
+	// The run stops between images once the context is done: when it exceeds MAX_RUN_DURATION,
+	// so a huge backlog can't run into the next one, or when the sync lock is lost
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	if cfg.MaxRunDuration > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, time.Duration(cfg.MaxRunDuration)*time.Second,
+			fmt.Errorf("reached MAX_RUN_DURATION (%ds)", cfg.MaxRunDuration))
+		defer cancelTimeout()
+	}
+
+	if err := redisClient.Ping(); err != nil {
+		logging.Errorf("Error reaching Redis: %v. Skipping this run.", err)
+		fail(err)
+		return summary, err
+	}
+
+	// Only one instance sharing this Redis may sync at a time
+	if cfg.SyncLock {
+		release, err := acquireSyncLock(redisClient, time.Duration(cfg.SyncLockTTL)*time.Second, cancel)
+		if err != nil {
+			logging.Errorf("Error acquiring sync lock: %v. Skipping this run.", err)
+			fail(err)
+			return summary, err
+		}
+		if release == nil {
+			return summary, nil
+		}
+		defer release()
+	}
+
+	// A read-only mount or full disk would fail every download, so don't start
+	if err := storageManager.CheckWritable(); err != nil {
+		logging.Errorf("%v. Check the %s mount and free disk space. Skipping this run.", err, cfg.ImageDir)
+		fail(err)
+		return summary, err
+	}
+
+	// With MAX_ALBUMS_PER_RUN, only this run's share of the albums is scraped
+	skipped, nextCursor := selectAlbums(redisClient, len(albumScrapers), cfg.MaxAlbumsPerRun)
+
+	// Collect image URLs from each album, remembering which album each came from
+	albumImages := make([][]albumImage, len(albumScrapers))
+	scrapeFailures, scraped := 0, 0
+	for i, albumScraper := range albumScrapers {
+		if skipped[i] {
+			continue
+		}
+		scraped++
+		albumPhotos, err := albumScraper.GetPhotos()
 		if err != nil {
-			log.Printf("Error checking Redis for email hash %s: %v", hash, err)
+			logging.Errorf("Error scraping album %d: %v", i+1, err)
+			fail(fmt.Errorf("failed to scrape album %d: %w", i+1, err))
+			scrapeFailures++
 			continue
 		}
-		log.Printf("Email tracking check for hash %s: exists=%v", hash, emailExists)
+		logging.Infof("Found %d image URLs in album %d", len(albumPhotos), i+1)
+		recent := 0
+		for _, photo := range albumPhotos {
+			if photoTooRecent(photo, time.Duration(cfg.MinPhotoAge)*time.Second, time.Now()) {
+				recent++
+				continue
+			}
+			image := albumImage{
+				url:         photo.URL,
+				guid:        photo.GUID,
+				album:       i,
+				taken:       photo.Taken,
+				caption:     photo.Caption,
+				contributor: photo.Contributor,
+			}
+			if cfg.EmailDerivative == "medium" {
+				image.derivative, _ = photo.SmallestAtLeast(emailScaleSize(cfg))
+			}
+			albumImages[i] = append(albumImages[i], image)
+		}
+		if recent > 0 {
+			logging.Infof("Leaving %d photos added to album %d in the last %d seconds for a later run, so iCloud can finish processing them (MIN_PHOTO_AGE)", recent, i+1, cfg.MinPhotoAge)
+		}
+	}
+
+	// With nothing to scrape (every album skipped) there is nothing to fail
+	if scraped > 0 && scrapeFailures == scraped {
+		return summary, fmt.Errorf("all %d albums failed to scrape", scrapeFailures)
+	}
+
+	// The same asset shared in several albums only needs to be fetched once per run, unless
+	// the albums are emailed to different destinations
+	albumImages = dedupeAlbumImages(albumImages, cfg.AlbumPriorities, cfg.AlbumDestinations)
+
+	// Order each album by capture date if configured, so a MAX_ITEMS-limited run picks the
+	// newest (or oldest) photos first
+	sortAlbumImages(albumImages, cfg.ProcessOrder)
 
-		gphotosExists := false
-		if photosClient != nil {
-			var err2 error
-			gphotosExists, err2 = redisClient.HashExistsForGooglePhotos(hash)
-			if err2 != nil {
-				log.Printf("Error checking Redis for Google Photos hash %s: %v", hash, err2)
-			} else {
-				log.Printf("Google Photos tracking check for hash %s: exists=%v", hash, gphotosExists)
+	// Interleave the albums so the MAX_ITEMS budget is shared fairly between them, after the
+	// albums with a higher priority
+	allImages := interleaveAlbums(albumImages, cfg.AlbumPriorities)
+	summary.Scraped = len(allImages)
+	logging.Infof("Found %d total image URLs across all albums", len(allImages))
+
+	// During quiet hours the paused notifiers only record what they'd have delivered
+	quiet := cfg.QuietHours != nil && cfg.QuietHours.Active(time.Now())
+	if quiet {
+		logging.Infof("Quiet hours (%s): deferring %v until %s", cfg.QuietHours, cfg.QuietHours.Notifiers,
+			cfg.QuietHours.NextEnd(time.Now()).Format("15:04"))
+	}
+
+	// Prepare the notifiers for this run; one that can't be prepared is skipped until the next run
+	var stages []*notifierStage
+	for _, notifier := range registry.Notifiers() {
+		if preparer, ok := notifier.(notify.Preparer); ok {
+			if err := preparer.Prepare(); err != nil {
+				logging.Errorf("Error preparing %s notifier: %v. It will be skipped for this run.", notifier.Name(), err)
+				fail(fmt.Errorf("failed to prepare %s notifier: %w", notifier.Name(), err))
+				continue
 			}
 		}
+		stages = append(stages, &notifierStage{
+			notifier: notifier,
+			workers:  registry.Concurrency(notifier),
+			deferred: quiet && cfg.QuietHours.Pauses(notifier.Name()),
+		})
+	}
+
+	pipeline := newSyncPipeline(ctx, albumScrapers, storageManager, redisClient, cfg, stages)
+	pipeline.auditLog = auditLog
+	pipeline.skipped = skipped
+	if cfg.RunProgressTTL > 0 {
+		pipeline.progress = loadRunProgress(redisClient, cfg.AlbumURLs, time.Duration(cfg.RunProgressTTL)*time.Second)
+	}
+	pipeline.run(allImages)
+	abortErr := pipeline.aborted()
+	albumProcessed := pipeline.albumProcessed
+	summary.New = pipeline.processedCount
+	summary.Deferred = pipeline.deferredQueued()
+	summary.Failed += pipeline.failedCount
+	summary.Errors = append(summary.Errors, pipeline.errors...)
+
+	// Deliver anything batched by notifiers (e.g. zipped email) and mark it as processed
+	for _, err := range pipeline.flush(registry.Notifiers()) {
+		fail(err)
+	}
+	summary.Emailed = pipeline.deliveredCounts["email"]
+	summary.Uploaded = pipeline.deliveredCounts["google_photos"]
+
+	// Only now that batched deliveries are done may delivered files be removed
+	pipeline.deleteDelivered(registry.Names())
 
-		// Skip if already processed for both services
-		if emailExists && (photosClient == nil || gphotosExists) {
-			log.Printf("Image with hash %s already processed for all services, skipping", hash)
+	logging.Infof("Sync run completed. Scraped %d image URLs, processed %d new images (%d emailed, %d uploaded), %d failures",
+		summary.Scraped, summary.New, summary.Emailed, summary.Uploaded, summary.Failed)
+	for i, count := range albumProcessed {
+		if skipped[i] {
 			continue
 		}
+		logging.Infof("  album %d (%s): %d new images", i+1, albumScrapers[i].AlbumName(), count)
+	}
 
-		// Process image for email and/or Google Photos as needed
-		// Both services use the same high-quality downloaded image file
-		emailSuccess := false
-		googlePhotosSuccess := false
-
-		// Email the image if not already emailed
-		if !emailExists {
-			log.Printf("Emailing high-quality image: %s (hash: %s)", imagePath, hash)
-			if err := emailSender.SendImage(imagePath, cfg.SMTPDestination); err != nil {
-				log.Printf("Error sending email for image %s: %v", imagePath, err)
-			} else {
-				emailSuccess = true
-				// Mark as processed for email
-				if err := redisClient.SetHashForEmail(hash, imageURL); err != nil {
-					log.Printf("Error storing email hash in Redis: %v", err)
-				}
-			}
-		} else {
-			log.Printf("Image with hash %s already emailed, skipping email", hash)
-			emailSuccess = true // Already processed
+	// Move the album rotation on only once this run's albums are synced, so albums that failed
+	// to scrape or still have images left are scraped again by the next run
+	if nextCursor >= 0 && scrapeFailures == 0 && pipeline.completed {
+		if err := redisClient.SetAlbumCursor(nextCursor); err != nil {
+			logging.Errorf("Error storing album rotation in Redis: %v", err)
 		}
+	}
+
+	// Report images that are currently failing so operators can see what's stuck
+	failureCounts, err := redisClient.GetFailureCounts()
+	if err != nil {
+		logging.Errorf("Error reading failure counts from Redis: %v", err)
+	} else if len(failureCounts) > 0 {
+		logging.Warnf("%d images have recorded failures:", len(failureCounts))
+		for hash, count := range failureCounts {
+			logging.Warnf("  hash %s: %d consecutive failures", hash, count)
+		}
+	}
 
-		// Upload to Google Photos if configured and not already uploaded
-		if photosClient != nil && !gphotosExists {
-			if googlePhotosAlbumID != "" {
-				log.Printf("Uploading high-quality image to Google Photos album: %s (hash: %s)", imagePath, hash)
-			} else {
-				log.Printf("Uploading high-quality image to Google Photos library (for partner sharing): %s (hash: %s)", imagePath, hash)
+	if abortErr != nil {
+		fail(abortErr)
+		return summary, abortErr
+	}
+	return summary, nil
+}
+
+// albumCursorStore reads the album rotation (MAX_ALBUMS_PER_RUN); satisfied by *redis.Client
+type albumCursorStore interface {
+	AlbumCursor() (int, error)
+}
+
+// selectAlbums picks the albums this run scrapes when MAX_ALBUMS_PER_RUN is set: the next
+// maxAlbums albums after the previous run's, wrapping around, so every album is covered over
+// several runs. Returns the albums left out of this run, and the cursor to store once this
+// run's albums are synced (-1 when every album is scraped)
+func selectAlbums(store albumCursorStore, count int, maxAlbums int) ([]bool, int) {
+	skipped := make([]bool, count)
+	if maxAlbums == 0 || maxAlbums >= count {
+		return skipped, -1
+	}
+	cursor, err := store.AlbumCursor()
+	if err != nil {
+		logging.Errorf("Error reading album rotation from Redis, starting from the first album: %v", err)
+	}
+	// The album list may have shrunk since the cursor was stored
+	cursor %= count
+	for i := range skipped {
+		skipped[i] = true
+	}
+	var selected []int
+	for n := 0; n < maxAlbums; n++ {
+		album := (cursor + n) % count
+		skipped[album] = false
+		selected = append(selected, album+1)
+	}
+	logging.Infof("Scraping albums %v of %d this run (MAX_ALBUMS_PER_RUN=%d)", selected, count, maxAlbums)
+	return skipped, (cursor + maxAlbums) % count
+}
+
+// albumImage is an image URL together with the index of the album it was scraped from
+type albumImage struct {
+	url         string
+	guid        string // iCloud asset GUID (may be empty)
+	album       int
+	taken       time.Time // Capture time (zero if unknown)
+	caption     string
+	contributor string             // Who added the photo to the album (may be empty)
+	derivative  scraper.Derivative // Smaller size to email instead of the original (zero if none)
+}
+
+// photoTooRecent reports whether a photo was added to its album less than minAge before now,
+// judged by its capture time when iCloud doesn't say when it was added. Photos without either
+// time are never too recent
+func photoTooRecent(photo scraper.Photo, minAge time.Duration, now time.Time) bool {
+	if minAge <= 0 {
+		return false
+	}
+	added := photo.Added
+	if added.IsZero() {
+		added = photo.Taken
+	}
+	return !added.IsZero() && now.Sub(added) < minAge
+}
+
+// dedupeAlbumImages removes images that appear in more than one album with the same
+// destination (by asset GUID, or by URL when no GUID is known). The album with the highest
+// priority wins, and the first listed among albums sharing a priority. Albums with different
+// destinations each keep their copy, so the photo is emailed to each of them
+func dedupeAlbumImages(albumImages [][]albumImage, priorities []int, destinations []string) [][]albumImage {
+	type assetKey struct {
+		destination string
+		asset       string
+	}
+	winners := make(map[assetKey]int)
+	deduped := make([][]albumImage, len(albumImages))
+	for _, i := range albumsByPriority(len(albumImages), priorities) {
+		var destination string
+		if i < len(destinations) {
+			destination = destinations[i]
+		}
+		for _, image := range albumImages[i] {
+			key := assetKey{destination: destination, asset: image.guid}
+			if key.asset == "" {
+				key.asset = image.url
 			}
-			if err := photosClient.UploadPhoto(imagePath, googlePhotosAlbumID); err != nil {
-				log.Printf("Error uploading to Google Photos for image %s: %v", imagePath, err)
-			} else {
-				googlePhotosSuccess = true
-				// Mark as processed for Google Photos
-				if err := redisClient.SetHashForGooglePhotos(hash, imageURL); err != nil {
-					log.Printf("Error storing Google Photos hash in Redis: %v", err)
-				}
+			if winner, seen := winners[key]; seen {
+				logging.Debugf("Photo %s in album %d is a duplicate of album %d, processing it from album %d only", key.asset, i+1, winner+1, winner+1)
+				continue
 			}
-		} else if photosClient != nil && gphotosExists {
-			log.Printf("Image with hash %s already uploaded to Google Photos, skipping upload", hash)
-			googlePhotosSuccess = true // Already processed
+			winners[key] = i
+			deduped[i] = append(deduped[i], image)
 		}
+	}
+	return deduped
+}
 
-		// Only count as processed if we actually did something new
-		if emailSuccess || googlePhotosSuccess {
-			processedCount++
-			log.Printf("Successfully processed image %s (hash: %s) - Email: %v, Google Photos: %v", 
-				imagePath, hash, emailSuccess, googlePhotosSuccess)
-		} else {
-			log.Printf("Failed to process image %s (hash: %s) for both email and Google Photos - Email: %v, Google Photos: %v", 
-				imagePath, hash, emailSuccess, googlePhotosSuccess)
+// interleaveAlbums merges per-album image lists round-robin (first image of each album,
+// then the second of each, ...) so no album can starve the others of the MAX_ITEMS budget.
+// Albums with a higher priority come first, so they get the budget before the others; albums
+// sharing a priority are interleaved
+func interleaveAlbums(albumImages [][]albumImage, priorities []int) []albumImage {
+	order := albumsByPriority(len(albumImages), priorities)
+	var all []albumImage
+	for start := 0; start < len(order); {
+		end := start
+		for end < len(order) && albumPriority(priorities, order[end]) == albumPriority(priorities, order[start]) {
+			end++
 		}
+		for depth := 0; ; depth++ {
+			added := false
+			for _, album := range order[start:end] {
+				if depth < len(albumImages[album]) {
+					all = append(all, albumImages[album][depth])
+					added = true
+				}
+			}
+			if !added {
+				break
+			}
+		}
+		start = end
+	}
+	return all
+}
+
+// albumsByPriority returns the indexes of count albums, highest priority first and in list
+// order among albums sharing a priority
+func albumsByPriority(count int, priorities []int) []int {
+	order := make([]int, count)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return albumPriority(priorities, order[i]) > albumPriority(priorities, order[j])
+	})
+	return order
+}
+
+// albumPriority returns an album's priority, 0 if none is configured
+func albumPriority(priorities []int, album int) int {
+	if album < len(priorities) {
+		return priorities[album]
 	}
+	return 0
+}
 
-	log.Printf("Sync run completed. Processed %d new images", processedCount)
+// sortAlbumImages sorts each album's images by capture date: newest or oldest first
+// Any other order keeps the album order; photos without a capture date always go last
+func sortAlbumImages(albumImages [][]albumImage, order string) {
+	if order != "newest" && order != "oldest" {
+		return
+	}
+	for _, images := range albumImages {
+		sort.SliceStable(images, func(i, j int) bool {
+			a, b := images[i].taken, images[j].taken
+			if a.IsZero() || b.IsZero() {
+				return !a.IsZero() && b.IsZero()
+			}
+			if order == "newest" {
+				return a.After(b)
+			}
+			return a.Before(b)
+		})
+	}
 }
 
+// recordFailure increments the failure count for an image and quarantines it
+// once it reaches cfg.MaxFailures consecutive failures
+func recordFailure(
+	storageManager *storage.Manager,
+	redisClient syncStore,
+	cfg *config.Config,
+	imagePath string,
+	hash string,
+	imageURL string,
+) {
+	count, err := redisClient.IncrementFailureCount(hash)
+	if err != nil {
+		logging.Errorf("Error storing failure count in Redis: %v", err)
+		return
+	}
+	logging.Warnf("Image with hash %s has failed %d consecutive times", hash, count)
+
+	if cfg.MaxFailures <= 0 || count < int64(cfg.MaxFailures) {
+		return
+	}
+
+	quarantinePath, err := storageManager.QuarantineImage(imagePath)
+	if err != nil {
+		logging.Errorf("Error quarantining image %s: %v", imagePath, err)
+		return
+	}
+	if err := redisClient.SetDeadLettered(hash, imageURL); err != nil {
+		logging.Errorf("Error storing dead-letter mark in Redis: %v", err)
+		return
+	}
+	logging.Warnf("Image with hash %s failed %d times and was quarantined to %s (set RESET_QUARANTINE=true to retry)", hash, count, quarantinePath)
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+	"github.com/jsteffee/icloud-photo-sync/pkg/scraper"
+)
+
+// albumURLs returns the URLs of images, in order
+func albumURLs(images []albumImage) []string {
+	var urls []string
+	for _, image := range images {
+		urls = append(urls, image.url)
+	}
+	return urls
+}
+
+func TestDedupeAlbumImages(t *testing.T) {
+	tests := []struct {
+		name         string
+		albumImages  [][]albumImage
+		priorities   []int
+		destinations []string
+		want         [][]string // URLs kept in each album
+	}{
+		{
+			name: "no duplicates",
+			albumImages: [][]albumImage{
+				{{url: "a", guid: "1"}, {url: "b", guid: "2"}},
+				{{url: "c", guid: "3"}},
+			},
+			want: [][]string{{"a", "b"}, {"c"}},
+		},
+		{
+			name: "shared asset kept in the first album",
+			albumImages: [][]albumImage{
+				{{url: "a", guid: "1"}, {url: "b", guid: "2"}},
+				{{url: "b2", guid: "2"}, {url: "c", guid: "3"}},
+			},
+			want: [][]string{{"a", "b"}, {"c"}},
+		},
+		{
+			name: "shared asset kept in the album with the higher priority",
+			albumImages: [][]albumImage{
+				{{url: "a", guid: "1"}, {url: "b", guid: "2"}},
+				{{url: "b2", guid: "2"}, {url: "c", guid: "3"}},
+			},
+			priorities: []int{0, 5},
+			want:       [][]string{{"a"}, {"b2", "c"}},
+		},
+		{
+			name: "shared asset kept in albums with different destinations",
+			albumImages: [][]albumImage{
+				{{url: "a", guid: "1"}, {url: "b", guid: "2"}},
+				{{url: "b2", guid: "2"}, {url: "c", guid: "3"}},
+				{{url: "b3", guid: "2"}},
+			},
+			destinations: []string{"", "family@example.com", "family@example.com"},
+			want:         [][]string{{"a", "b"}, {"b2", "c"}, nil},
+		},
+		{
+			name: "matched by URL without a GUID",
+			albumImages: [][]albumImage{
+				{{url: "a"}, {url: "b"}},
+				{{url: "b"}, {url: "b", guid: "2"}},
+			},
+			want: [][]string{{"a", "b"}, {"b"}},
+		},
+		{
+			name: "duplicate within an album",
+			albumImages: [][]albumImage{
+				{{url: "a", guid: "1"}, {url: "a2", guid: "1"}},
+			},
+			want: [][]string{{"a"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deduped := dedupeAlbumImages(tt.albumImages, tt.priorities, tt.destinations)
+			if len(deduped) != len(tt.want) {
+				t.Fatalf("dedupeAlbumImages() returned %d albums, want %d", len(deduped), len(tt.want))
+			}
+			for i, images := range deduped {
+				if got := albumURLs(images); !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("album %d = %v, want %v", i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestInterleaveAlbums(t *testing.T) {
+	tests := []struct {
+		name        string
+		albumImages [][]albumImage
+		priorities  []int
+		want        []string
+	}{
+		{
+			name:        "no albums",
+			albumImages: nil,
+			want:        nil,
+		},
+		{
+			name: "round-robin",
+			albumImages: [][]albumImage{
+				{{url: "a1"}, {url: "a2"}, {url: "a3"}},
+				{{url: "b1"}},
+				{{url: "c1"}, {url: "c2"}},
+			},
+			want: []string{"a1", "b1", "c1", "a2", "c2", "a3"},
+		},
+		{
+			name: "higher priority first",
+			albumImages: [][]albumImage{
+				{{url: "a1"}, {url: "a2"}},
+				{{url: "b1"}, {url: "b2"}},
+				{{url: "c1"}},
+			},
+			priorities: []int{0, 10, 0},
+			want:       []string{"b1", "b2", "a1", "c1", "a2"},
+		},
+		{
+			name: "priorities missing for later albums count as 0",
+			albumImages: [][]albumImage{
+				{{url: "a1"}},
+				{{url: "b1"}},
+			},
+			priorities: []int{-1},
+			want:       []string{"b1", "a1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := albumURLs(interleaveAlbums(tt.albumImages, tt.priorities)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("interleaveAlbums() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name          string
+		runInterval   int
+		retryInterval int
+		failedRuns    int
+		want          time.Duration
+	}{
+		{name: "retries disabled", runInterval: 3600, retryInterval: 0, failedRuns: 1, want: time.Hour},
+		{name: "first failure", runInterval: 3600, retryInterval: 60, failedRuns: 1, want: time.Minute},
+		{name: "doubled per failure", runInterval: 3600, retryInterval: 60, failedRuns: 3, want: 4 * time.Minute},
+		{name: "capped at RUN_INTERVAL", runInterval: 3600, retryInterval: 60, failedRuns: 10, want: time.Hour},
+		{name: "RETRY_INTERVAL above RUN_INTERVAL", runInterval: 600, retryInterval: 900, failedRuns: 1, want: 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{RunInterval: tt.runInterval, RetryInterval: tt.retryInterval}
+			if got := retryDelay(cfg, tt.failedRuns); got != tt.want {
+				t.Errorf("retryDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortAlbumImages(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 6, d, 12, 0, 0, 0, time.UTC) }
+	album := func() []albumImage {
+		return []albumImage{
+			{url: "undated1"},
+			{url: "mid", taken: day(15)},
+			{url: "old", taken: day(1)},
+			{url: "undated2"},
+			{url: "new", taken: day(30)},
+		}
+	}
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{order: "newest", want: []string{"new", "mid", "old", "undated1", "undated2"}},
+		{order: "oldest", want: []string{"old", "mid", "new", "undated1", "undated2"}},
+		{order: "album", want: []string{"undated1", "mid", "old", "undated2", "new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			albumImages := [][]albumImage{album(), album()}
+			sortAlbumImages(albumImages, tt.order)
+			for i, images := range albumImages {
+				if got := albumURLs(images); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("album %d = %v, want %v", i+1, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestQuietHoursDelay(t *testing.T) {
+	quietHours := &config.QuietHours{Start: 22 * 60, End: 7 * 60, Location: time.UTC, Notifiers: []string{"email"}}
+	tests := []struct {
+		name       string
+		quietHours *config.QuietHours
+		deferred   int
+		now        time.Time
+		want       time.Duration
+	}{
+		{
+			name:     "no quiet hours",
+			deferred: 3,
+			now:      time.Date(2024, 6, 15, 6, 30, 0, 0, time.UTC),
+			want:     time.Hour,
+		},
+		{
+			name:       "outside quiet hours",
+			quietHours: quietHours,
+			deferred:   3,
+			now:        time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC),
+			want:       time.Hour,
+		},
+		{
+			name:       "nothing deferred",
+			quietHours: quietHours,
+			now:        time.Date(2024, 6, 15, 6, 30, 0, 0, time.UTC),
+			want:       time.Hour,
+		},
+		{
+			name:       "window ends before the next run",
+			quietHours: quietHours,
+			deferred:   3,
+			now:        time.Date(2024, 6, 15, 6, 30, 0, 0, time.UTC),
+			want:       30 * time.Minute,
+		},
+		{
+			name:       "window ends after the next run",
+			quietHours: quietHours,
+			deferred:   3,
+			now:        time.Date(2024, 6, 15, 23, 0, 0, 0, time.UTC),
+			want:       time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{QuietHours: tt.quietHours}
+			if got := quietHoursDelay(cfg, tt.deferred, time.Hour, tt.now); got != tt.want {
+				t.Errorf("quietHoursDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPhotoTooRecent(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		photo  scraper.Photo
+		minAge time.Duration
+		want   bool
+	}{
+		{name: "MIN_PHOTO_AGE unset", photo: scraper.Photo{Added: now}, want: false},
+		{name: "added recently", photo: scraper.Photo{Added: now.Add(-time.Minute)}, minAge: time.Hour, want: true},
+		{name: "added long ago", photo: scraper.Photo{Added: now.Add(-2 * time.Hour)}, minAge: time.Hour, want: false},
+		{name: "added exactly minAge ago", photo: scraper.Photo{Added: now.Add(-time.Hour)}, minAge: time.Hour, want: false},
+		{name: "taken recently without an added time", photo: scraper.Photo{Taken: now.Add(-time.Minute)}, minAge: time.Hour, want: true},
+		{name: "added time preferred over taken", photo: scraper.Photo{Added: now.Add(-2 * time.Hour), Taken: now.Add(-time.Minute)}, minAge: time.Hour, want: false},
+		{name: "no times", photo: scraper.Photo{}, minAge: time.Hour, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := photoTooRecent(tt.photo, tt.minAge, now); got != tt.want {
+				t.Errorf("photoTooRecent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeCursorStore is an albumCursorStore holding a fixed cursor
+type fakeCursorStore struct {
+	cursor int
+	err    error
+}
+
+func (s fakeCursorStore) AlbumCursor() (int, error) {
+	return s.cursor, s.err
+}
+
+func TestSelectAlbums(t *testing.T) {
+	tests := []struct {
+		name        string
+		store       fakeCursorStore
+		count       int
+		maxAlbums   int
+		wantSkipped []bool
+		wantNext    int
+	}{
+		{
+			name:        "MAX_ALBUMS_PER_RUN unset",
+			count:       3,
+			wantSkipped: []bool{false, false, false},
+			wantNext:    -1,
+		},
+		{
+			name:        "MAX_ALBUMS_PER_RUN covers every album",
+			count:       3,
+			maxAlbums:   3,
+			wantSkipped: []bool{false, false, false},
+			wantNext:    -1,
+		},
+		{
+			name:        "first run",
+			count:       4,
+			maxAlbums:   2,
+			wantSkipped: []bool{false, false, true, true},
+			wantNext:    2,
+		},
+		{
+			name:        "wraps around",
+			store:       fakeCursorStore{cursor: 3},
+			count:       4,
+			maxAlbums:   2,
+			wantSkipped: []bool{false, true, true, false},
+			wantNext:    1,
+		},
+		{
+			name:        "album list shrank",
+			store:       fakeCursorStore{cursor: 5},
+			count:       4,
+			maxAlbums:   2,
+			wantSkipped: []bool{true, false, false, true},
+			wantNext:    3,
+		},
+		{
+			name:        "cursor unreadable",
+			store:       fakeCursorStore{err: errors.New("connection refused")},
+			count:       4,
+			maxAlbums:   3,
+			wantSkipped: []bool{false, false, false, true},
+			wantNext:    3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skipped, next := selectAlbums(tt.store, tt.count, tt.maxAlbums)
+			if !reflect.DeepEqual(skipped, tt.wantSkipped) {
+				t.Errorf("selectAlbums() skipped = %v, want %v", skipped, tt.wantSkipped)
+			}
+			if next != tt.wantNext {
+				t.Errorf("selectAlbums() next cursor = %d, want %d", next, tt.wantNext)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+	"github.com/jsteffee/icloud-photo-sync/pkg/redis"
+	"github.com/jsteffee/icloud-photo-sync/pkg/scraper"
+	"github.com/jsteffee/icloud-photo-sync/pkg/storage"
+)
+
+// migrateKeys brings what's recorded as delivered up to the current keying scheme (HASH_ALGO,
+// HASH_CONTENT and DEDUP_KEY), so switching schemes doesn't send every photo again. Stored
+// images are hashed again and their Redis keys and files moved to the new hash; with
+// DEDUP_KEY guid or both, the albums are fetched and each photo's GUID is recorded for the
+// destinations its hash was delivered to. Returns the number of images that couldn't be migrated
+func migrateKeys(cfg *config.Config, redisClient *redis.Client, storageManager *storage.Manager, albumScrapers []*scraper.Scraper) (int, error) {
+	return runLocked(cfg, redisClient, func(ctx context.Context) (int, error) {
+		failed, err := rehashImages(ctx, cfg, redisClient, storageManager)
+		if err != nil || cfg.DedupKey == "hash" {
+			return failed, err
+		}
+		guidFailed, err := backfillGUIDs(ctx, cfg, redisClient, storageManager, albumScrapers)
+		return failed + guidFailed, err
+	})
+}
+
+// rehashImages moves every stored image whose file name isn't its hash under the current
+// scheme to that hash, in Redis first so an interrupted migration can simply be run again.
+// Refuses to run while stored images are rewritten after download, since their files no
+// longer hash like the photos downloaded by future runs
+func rehashImages(ctx context.Context, cfg *config.Config, redisClient *redis.Client, storageManager *storage.Manager) (int, error) {
+	if cfg.NormalizeOrientation {
+		return 0, fmt.Errorf("--migrate-keys can't be used with NORMALIZE_ORIENTATION, which rewrites photos after they're hashed")
+	}
+	if cfg.StripGPS || cfg.StripAllEXIF {
+		return 0, fmt.Errorf("--migrate-keys can't be used with STRIP_GPS or STRIP_ALL_EXIF, which rewrite photos after they're hashed")
+	}
+	images, err := storageManager.ListImages()
+	if err != nil {
+		return 0, err
+	}
+
+	hashes := make(map[string]string)
+	known := make(map[string]bool)
+	var moves []storage.StoredImage // With the new hash
+	failed := 0
+	for _, image := range images {
+		if err := context.Cause(ctx); err != nil {
+			return failed, err
+		}
+		known[image.Hash] = true
+		newHash, err := storageManager.HashFile(image.Path)
+		if err != nil {
+			logging.Errorf("Error hashing %s: %v", image.Path, err)
+			failed++
+			continue
+		}
+		known[newHash] = true
+		if newHash == image.Hash {
+			continue
+		}
+		hashes[image.Hash] = newHash
+		moves = append(moves, storage.StoredImage{Path: image.Path, Hash: newHash})
+	}
+	logging.Infof("Found %d of %d stored images to re-key to %s over %s content", len(moves), len(images), cfg.HashAlgorithm, cfg.HashContent)
+
+	changed, err := redisClient.RekeyHashes(hashes)
+	if err != nil {
+		return failed, fmt.Errorf("failed to re-key Redis: %w", err)
+	}
+	logging.Infof("Moved %d Redis keys to the new hashes", changed)
+
+	for _, move := range moves {
+		if _, err := storageManager.RenameImage(move.Path, move.Hash); err != nil {
+			logging.Errorf("Error renaming %s to hash %s: %v", move.Path, move.Hash, err)
+			failed++
+		}
+	}
+
+	// Delivered images whose files are gone (e.g. DELETE_AFTER_UPLOAD) can't be hashed again
+	missing := make(map[string]bool)
+	for _, service := range manifestServices {
+		delivered, err := redisClient.ListHashesFor(service)
+		if err != nil {
+			return failed, err
+		}
+		for hash := range delivered {
+			if !known[hash] {
+				missing[hash] = true
+			}
+		}
+	}
+	if len(missing) > 0 {
+		logging.Warnf("%d delivered images are no longer in %s and keep their old hash; photos among them still in an album are sent again", len(missing), cfg.ImageDir)
+	}
+	return failed, nil
+}
+
+// backfillGUIDs records the asset GUID of every album photo for each destination that has its
+// content hash, downloading photos to learn their hash (unchanged files aren't downloaded again)
+func backfillGUIDs(ctx context.Context, cfg *config.Config, redisClient *redis.Client, storageManager *storage.Manager, albumScrapers []*scraper.Scraper) (int, error) {
+	recorded, failed := 0, 0
+	for i, albumScraper := range albumScrapers {
+		albumPhotos, err := albumScraper.GetPhotosContext(ctx)
+		if err != nil {
+			logging.Errorf("Error fetching album %d: %v", i+1, err)
+			failed++
+			continue
+		}
+		albumName := albumScraper.AlbumName()
+		for _, photo := range albumPhotos {
+			if err := context.Cause(ctx); err != nil {
+				return failed, err
+			}
+			if photo.GUID == "" {
+				continue
+			}
+			if done, err := guidRecorded(redisClient, photo.GUID); err != nil {
+				return failed, err
+			} else if done {
+				continue
+			}
+
+			imagePath, hash, err := storageManager.DownloadAndHashForAlbum(photo.URL, albumName)
+			if errors.Is(err, storage.ErrTypeNotAllowed) || errors.Is(err, storage.ErrImageTooSmall) {
+				continue
+			}
+			if err != nil {
+				logging.Errorf("Error downloading %s to learn its hash: %v", photo.URL, err)
+				failed++
+				continue
+			}
+
+			delivered := false
+			for _, service := range manifestServices {
+				exists, err := redisClient.HashExistsFor(service, hash)
+				if err != nil {
+					return failed, err
+				}
+				if !exists {
+					continue
+				}
+				if err := redisClient.SetGUIDFor(service, photo.GUID, hash); err != nil {
+					return failed, err
+				}
+				delivered = true
+			}
+			if delivered {
+				recorded++
+				if cfg.DeleteAfterUpload && imagePath != "" {
+					// Only downloaded again to hash it; it was deleted once delivered
+					if err := storageManager.DeleteImage(imagePath); err != nil {
+						logging.Warnf("Error deleting %s: %v", imagePath, err)
+					}
+				}
+			}
+		}
+	}
+	logging.Infof("Recorded GUIDs for %d delivered photos", recorded)
+	return failed, nil
+}
+
+// guidRecorded reports whether a GUID is recorded for any destination, i.e. the photo was
+// delivered with GUIDs tracked or has been migrated already
+func guidRecorded(redisClient *redis.Client, guid string) (bool, error) {
+	for _, service := range manifestServices {
+		exists, err := redisClient.GUIDExistsFor(service, guid)
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
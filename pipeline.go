@@ -0,0 +1,1286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/audit"
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+	"github.com/jsteffee/icloud-photo-sync/pkg/notify"
+	"github.com/jsteffee/icloud-photo-sync/pkg/scraper"
+	"github.com/jsteffee/icloud-photo-sync/pkg/storage"
+)
+
+// syncStore records what each notifier has been delivered, and the failures and album history
+// around it; implemented by *redis.Client
+type syncStore interface {
+	HashExistsFor(service string, hash string) (bool, error)
+	SetHashFor(service string, hash string, imageURL string) error
+	GUIDExistsFor(service string, guid string) (bool, error)
+	SetGUIDFor(service string, guid string, hash string) error
+	IncrementFailureCount(hash string) (int64, error)
+	ResetFailures(hash string) error
+	IsDeadLettered(hash string) (bool, error)
+	SetDeadLettered(hash string, imageURL string) error
+	IncrementAttemptsFor(service string, hash string) (int64, error)
+	ResetAttemptsFor(service string, hash string) error
+	IsDeadLetteredFor(service string, hash string) (bool, error)
+	SetDeadLetteredFor(service string, hash string, imageURL string) error
+	AddAlbumHash(albumURL string, hash string) error
+	AlbumHashCount(albumURL string) (int64, error)
+	AlbumWelcomed(albumURL string) (bool, error)
+	SetAlbumWelcomed(albumURL string) error
+	AddDeferred(service string, hash string, entry string) error
+	Deferred(service string) (map[string]string, error)
+	DeferredCount(service string) (int64, error)
+	RemoveDeferred(service string, hash string) error
+}
+
+// syncPipeline processes one run's images in stages: download workers fetch and hash images
+// and hand each new image to a separate stage per notifier, each with its own worker pool,
+// so a stalled destination (e.g. a slow Google Photos upload) doesn't hold up downloads or
+// the other destinations
+type syncPipeline struct {
+	ctx            context.Context // Done once the run must stop taking new images (see context.Cause)
+	albumScrapers  []*scraper.Scraper
+	storageManager *storage.Manager
+	redisClient    syncStore
+	cfg            *config.Config
+	stages         []*notifierStage
+	auditLog       *audit.Logger // Optional - records every image synced (AUDIT_LOG)
+	progress       *runProgress  // Optional - lets an interrupted run be resumed (RUN_PROGRESS_TTL)
+	totalImages    int
+	abortErr       atomic.Pointer[error] // Set when the run must stop, e.g. the image directory became unwritable
+	heldBack       atomic.Bool           // Set when MAX_ITEMS or a similar limit leaves images for the next run
+	completed      bool                  // Set by run when it got through every image, leaving none for the next run
+
+	deleteMu  sync.Mutex
+	deletable map[string]*deletableImage // Image files to delete after the run, by hash (DELETE_AFTER_UPLOAD)
+
+	// refreshed holds albums fetched again this run because a download URL had expired, as
+	// each album's photos by asset GUID. refreshMu is held while fetching so an album is only
+	// fetched once however many of its URLs expired
+	refreshMu sync.Mutex
+	refreshed map[int]map[string]scraper.Photo
+
+	// backfill marks albums that have never been synced; their images count against
+	// BACKFILL_MAX_ITEMS instead of MAX_ITEMS and MAX_ITEMS_PER_ALBUM
+	backfill []bool
+	// markSeen marks albums that have never been synced whose images are only recorded as
+	// delivered, without notifying (INITIAL_SYNC_MODE=mark-seen-only)
+	markSeen []bool
+	// welcome marks albums that have never been synced, to introduce with an email (WELCOME_EMAIL)
+	welcome []bool
+	// skipped marks albums left out of this run (MAX_ALBUMS_PER_RUN), which have no images and
+	// so aren't treated as syncing for the first time
+	skipped []bool
+
+	mu                 sync.Mutex // Guards the fields below
+	dispatched         int        // New images handed to the notifier stages (counts against MAX_ITEMS)
+	backfillDispatched int        // New images from backfilling albums (counts against BACKFILL_MAX_ITEMS)
+	albumDispatched    []int
+	seenHashes         map[string]bool // Images already dispatched this run, by hash
+	dispatchedTo       map[string]bool // Deliveries handed to the notifier stages this run, by tracking name and hash
+	limitLogged        map[string]bool // Budgets whose exhaustion has been logged
+	processedCount     int
+	markedSeenCount    int // Images recorded as delivered without notifying (INITIAL_SYNC_MODE)
+	deferredCount      int // Deliveries held back by quiet hours (QUIET_HOURS)
+	tooSmallCount      int // Images skipped for being below MIN_IMAGE_WIDTH or MIN_IMAGE_HEIGHT
+	albumProcessed     []int
+	failedCount        int
+	errors             []error        // Errors behind the failures, in the order they happened
+	deliveredCounts    map[string]int // Images delivered this run, by notifier name
+}
+
+// notifierStage delivers images to one notifier using its own workers
+type notifierStage struct {
+	notifier    notify.Notifier
+	workers     int
+	jobs        chan *syncJob
+	unavailable atomic.Bool // Set once the notifier reports it can't deliver anything else this run
+	deferred    bool        // Paused for quiet hours: new images are recorded as deferred instead
+}
+
+// syncJob is a downloaded image on its way through the notifier stages
+type syncJob struct {
+	image     albumImage
+	imagePath string
+	hash      string
+	metadata  notify.Metadata
+
+	fileMu sync.Mutex // Serializes downloading the image again when its file has gone missing
+
+	mu               sync.Mutex // Guards the fields below
+	remaining        int        // Stages that still have to process the image
+	alreadyDelivered int        // Notifiers that had the image before this run
+	delivered        []string
+	queued           []string // Notifiers among delivered that batch the image until they're flushed
+	failed           []string
+}
+
+// indexedImage is an image with its position in the run, for logging
+type indexedImage struct {
+	index int
+	image albumImage
+}
+
+// newSyncPipeline creates a pipeline delivering to the given notifier stages
+func newSyncPipeline(
+	ctx context.Context,
+	albumScrapers []*scraper.Scraper,
+	storageManager *storage.Manager,
+	redisClient syncStore,
+	cfg *config.Config,
+	stages []*notifierStage,
+) *syncPipeline {
+	return &syncPipeline{
+		ctx:             ctx,
+		albumScrapers:   albumScrapers,
+		storageManager:  storageManager,
+		redisClient:     redisClient,
+		cfg:             cfg,
+		stages:          stages,
+		backfill:        make([]bool, len(albumScrapers)),
+		markSeen:        make([]bool, len(albumScrapers)),
+		welcome:         make([]bool, len(albumScrapers)),
+		albumDispatched: make([]int, len(albumScrapers)),
+		seenHashes:      make(map[string]bool),
+		dispatchedTo:    make(map[string]bool),
+		limitLogged:     make(map[string]bool),
+		deletable:       make(map[string]*deletableImage),
+		albumProcessed:  make([]int, len(albumScrapers)),
+		deliveredCounts: make(map[string]int),
+	}
+}
+
+// run processes images until they are exhausted, MAX_ITEMS new images have been dispatched, or
+// the context is done, and returns once every stage has finished. Images already handed to a
+// download worker are still finished when the context is done
+func (p *syncPipeline) run(images []albumImage) {
+	p.totalImages = len(images)
+	p.detectFirstRuns()
+	p.sendWelcomes(images)
+	p.drainDeferred()
+
+	// At most MAX_ITEMS (plus BACKFILL_MAX_ITEMS) images are dispatched, so buffering that many
+	// means the download stage never waits on a slow notifier stage
+	buffer := p.cfg.MaxItems + p.cfg.BackfillMaxItems
+	if buffer > len(images) {
+		buffer = len(images)
+	}
+	var stageWG sync.WaitGroup
+	for _, stage := range p.stages {
+		stage.jobs = make(chan *syncJob, buffer)
+		for w := 0; w < stage.workers; w++ {
+			stageWG.Add(1)
+			go func(stage *notifierStage) {
+				defer stageWG.Done()
+				for job := range stage.jobs {
+					p.deliver(stage, job)
+				}
+			}(stage)
+		}
+	}
+
+	downloadWorkers := p.cfg.DownloadConcurrency
+	if downloadWorkers < 1 {
+		downloadWorkers = 1
+	}
+	queue := make(chan indexedImage)
+	var downloadWG sync.WaitGroup
+	for w := 0; w < downloadWorkers; w++ {
+		downloadWG.Add(1)
+		go func() {
+			defer downloadWG.Done()
+			for item := range queue {
+				p.download(item.index, item.image)
+			}
+		}()
+	}
+
+	logging.Infof("Starting to process %d image URLs (%d download workers)", len(images), downloadWorkers)
+	interrupted := false
+	for i, image := range images {
+		if p.aborted() != nil {
+			interrupted = true
+			break
+		}
+		if p.ctx.Err() != nil {
+			logging.Warnf("Stopping after %d of %d image URLs (%v), leaving the rest for the next run", i, len(images), context.Cause(p.ctx))
+			interrupted = true
+			break
+		}
+		if p.progress != nil && p.progress.finished(image) {
+			continue
+		}
+		// Marking an album's history as seen isn't limited, so it finishes in one run
+		if !p.markSeen[image.album] && (p.budgetExhausted(image.album) || p.albumCapReached(image.album)) {
+			p.heldBack.Store(true)
+			continue
+		}
+		queue <- indexedImage{index: i, image: image}
+	}
+	close(queue)
+	downloadWG.Wait()
+
+	for _, stage := range p.stages {
+		close(stage.jobs)
+	}
+	stageWG.Wait()
+	if p.progress != nil && !interrupted && p.aborted() == nil {
+		p.progress.clear(p.skipped)
+	}
+	p.completed = !interrupted && p.aborted() == nil && !p.heldBack.Load()
+	if p.markedSeenCount > 0 {
+		logging.Infof("Marked %d images from newly added albums as seen without notifying (INITIAL_SYNC_MODE=mark-seen-only)", p.markedSeenCount)
+	}
+	if p.deferredCount > 0 {
+		logging.Infof("Deferred %d deliveries until quiet hours end (QUIET_HOURS)", p.deferredCount)
+	}
+	if p.tooSmallCount > 0 {
+		logging.Infof("Skipped %d images below the minimum resolution (MIN_IMAGE_WIDTH/MIN_IMAGE_HEIGHT)", p.tooSmallCount)
+	}
+}
+
+// detectFirstRuns finds the albums that have never been synced when BACKFILL_MAX_ITEMS is set,
+// INITIAL_SYNC_MODE is mark-seen-only (which takes precedence), or WELCOME_EMAIL is enabled
+func (p *syncPipeline) detectFirstRuns() {
+	if !p.tracksAlbumHashes() {
+		return
+	}
+	for i, albumURL := range p.cfg.AlbumURLs {
+		if i >= len(p.backfill) {
+			break
+		}
+		if i < len(p.skipped) && p.skipped[i] {
+			continue
+		}
+		count, err := p.redisClient.AlbumHashCount(albumURL)
+		if err != nil {
+			logging.Errorf("Error checking Redis for album %d sync history: %v", i+1, err)
+			continue
+		}
+		if count > 0 {
+			continue
+		}
+		p.welcome[i] = p.cfg.WelcomeEmail
+		if p.cfg.InitialSyncMode == "mark-seen-only" {
+			p.markSeen[i] = true
+			logging.Infof("Album %d has never been synced, marking its images as seen without notifying (INITIAL_SYNC_MODE=mark-seen-only)", i+1)
+		} else if p.cfg.BackfillMaxItems > 0 {
+			p.backfill[i] = true
+			logging.Infof("Album %d has never been synced, using BACKFILL_MAX_ITEMS limit (%d) for it this run", i+1, p.cfg.BackfillMaxItems)
+		}
+	}
+}
+
+// budget returns the counter and limit an album's images count against
+// Must be called with p.mu held
+func (p *syncPipeline) budget(album int) (*int, int, string) {
+	if p.backfill[album] {
+		return &p.backfillDispatched, p.cfg.BackfillMaxItems, "BACKFILL_MAX_ITEMS"
+	}
+	return &p.dispatched, p.cfg.MaxItems, "MAX_ITEMS"
+}
+
+// budgetExhausted reports whether the budget an album's images count against is used up
+func (p *syncPipeline) budgetExhausted(album int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	used, limit, name := p.budget(album)
+	if *used < limit {
+		return false
+	}
+	if !p.limitLogged[name] {
+		logging.Infof("Reached %s limit (%d) for this run", name, limit)
+		p.limitLogged[name] = true
+	}
+	return true
+}
+
+// albumCapReachedLocked reports whether an album has used up its MAX_ITEMS_PER_ALBUM budget
+// Backfilling albums are only limited by BACKFILL_MAX_ITEMS. Must be called with p.mu held
+func (p *syncPipeline) albumCapReachedLocked(album int) bool {
+	return !p.backfill[album] && p.cfg.MaxItemsPerAlbum > 0 && p.albumDispatched[album] >= p.cfg.MaxItemsPerAlbum
+}
+
+// albumCapReached reports whether an album has used up its MAX_ITEMS_PER_ALBUM budget
+func (p *syncPipeline) albumCapReached(album int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.albumCapReachedLocked(album)
+}
+
+// tracksAlbumHashes reports whether the hashes synced from each album are recorded, to tell
+// which albums have never been synced
+func (p *syncPipeline) tracksAlbumHashes() bool {
+	return p.cfg.BackfillMaxItems > 0 || p.cfg.InitialSyncMode == "mark-seen-only" || p.cfg.WelcomeEmail
+}
+
+// sendWelcomes introduces each album syncing for the first time through the notifiers that
+// support it (WELCOME_EMAIL). An album is only introduced once, even if its first run ends
+// before any of its images is synced
+func (p *syncPipeline) sendWelcomes(images []albumImage) {
+	var welcomers []notify.Welcomer
+	for _, stage := range p.stages {
+		if welcomer, ok := stage.notifier.(notify.Welcomer); ok && !stage.deferred {
+			welcomers = append(welcomers, welcomer)
+		}
+	}
+	if len(welcomers) == 0 {
+		return
+	}
+	for i, welcome := range p.welcome {
+		if !welcome {
+			continue
+		}
+		welcomed, err := p.redisClient.AlbumWelcomed(p.cfg.AlbumURLs[i])
+		if err != nil {
+			logging.Errorf("Error checking Redis for album %d welcome: %v", i+1, err)
+			continue
+		}
+		if !welcomed {
+			p.sendWelcome(i, images, welcomers)
+		}
+	}
+}
+
+// sendWelcome introduces one album, with its first listed image as the cover
+func (p *syncPipeline) sendWelcome(album int, images []albumImage, welcomers []notify.Welcomer) {
+	albumName := p.albumScrapers[album].AlbumName()
+	var coverPath string
+	photoCount := 0
+	for _, image := range images {
+		if image.album != album {
+			continue
+		}
+		photoCount++
+		if photoCount > 1 {
+			continue
+		}
+		// The cover is downloaded like any image, so the download stage reuses it
+		path, _, err := p.storageManager.DownloadAndHashForAlbum(image.url, albumName)
+		if err != nil {
+			logging.Warnf("Error downloading cover photo for album %d, introducing it without one: %v", album+1, err)
+			continue
+		}
+		coverPath = path
+	}
+
+	for _, welcomer := range welcomers {
+		if err := welcomer.Welcome(albumName, p.cfg.AlbumDestinations[album], coverPath, photoCount); err != nil {
+			logging.Errorf("Error sending welcome email for album %d: %v", album+1, err)
+			return
+		}
+	}
+	if err := p.redisClient.SetAlbumWelcomed(p.cfg.AlbumURLs[album]); err != nil {
+		logging.Errorf("Error storing album welcome in Redis: %v", err)
+		return
+	}
+	logging.Infof("Sent welcome email for album %d (%s)", album+1, albumName)
+}
+
+// recordAlbumHash notes that an album's image has been synced, ending its first run
+func (p *syncPipeline) recordAlbumHash(album int, hash string) {
+	if !p.tracksAlbumHashes() || album >= len(p.cfg.AlbumURLs) {
+		return
+	}
+	if err := p.redisClient.AddAlbumHash(p.cfg.AlbumURLs[album], hash); err != nil {
+		logging.Errorf("Error storing album hash in Redis: %v", err)
+	}
+}
+
+// abort stops dispatching new downloads for the rest of the run; images already downloaded
+// still go through the notifier stages. Only the first error is kept
+func (p *syncPipeline) abort(err error) {
+	if p.abortErr.CompareAndSwap(nil, &err) {
+		logging.Errorf("==================================================================")
+		logging.Errorf("ABORTING SYNC RUN: %v", err)
+		logging.Errorf("==================================================================")
+	}
+}
+
+// aborted returns the error that stopped the run, or nil
+func (p *syncPipeline) aborted() error {
+	if err := p.abortErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// usesGUID reports whether an asset GUID identifies delivered photos (DEDUP_KEY guid or both)
+func (p *syncPipeline) usesGUID(guid string) bool {
+	return guid != "" && (p.cfg.DedupKey == "guid" || p.cfg.DedupKey == "both")
+}
+
+// guidDelivered reports whether every available notifier has delivered the asset with this
+// GUID to an album's destination, checked before downloading. Errors are logged and treated
+// as not delivered
+func (p *syncPipeline) guidDelivered(guid string, destination string) bool {
+	if !p.usesGUID(guid) {
+		return false
+	}
+	for _, stage := range p.stages {
+		if stage.unavailable.Load() {
+			continue
+		}
+		name := p.trackingName(stage.notifier.Name(), destination)
+		exists, err := p.redisClient.GUIDExistsFor(name, guid)
+		if err != nil {
+			logging.Errorf("Error checking Redis for %s GUID %s: %v", name, guid, err)
+			return false
+		}
+		if !exists {
+			return false
+		}
+	}
+	return true
+}
+
+// trackingName returns the name a notifier's deliveries to an album's destination (see
+// notify.Metadata.Destination) are recorded under in the store. Emails to an album's own
+// destination are recorded apart from those to SMTP_DESTINATION, so a photo shared by albums
+// with different destinations reaches each of them
+func (p *syncPipeline) trackingName(name string, destination string) string {
+	if name != "email" || destination == "" || destination == p.cfg.SMTPDestination {
+		return name
+	}
+	return name + ":" + destination
+}
+
+// trackingNames returns every name a notifier's deliveries are recorded under: one per album
+// destination (see trackingName)
+func (p *syncPipeline) trackingNames(name string) []string {
+	names := []string{name}
+	for _, destination := range p.cfg.AlbumDestinations {
+		if trackingName := p.trackingName(name, destination); !slices.Contains(names, trackingName) {
+			names = append(names, trackingName)
+		}
+	}
+	return names
+}
+
+// drainDeferred delivers the images queued for the notifiers paused by quiet hours
+// (QUIET_HOURS) once they are over, before the albums' images. They don't count against
+// MAX_ITEMS, since they were found by an earlier run
+func (p *syncPipeline) drainDeferred() {
+	if p.cfg.QuietHours == nil {
+		return
+	}
+	for _, stage := range p.stages {
+		name := stage.notifier.Name()
+		if stage.deferred || !p.cfg.QuietHours.Pauses(name) {
+			continue
+		}
+		for _, trackingName := range p.trackingNames(name) {
+			entries, err := p.redisClient.Deferred(trackingName)
+			if err != nil {
+				logging.Errorf("Error reading deferred %s deliveries from Redis: %v", trackingName, err)
+				p.fail(err)
+				continue
+			}
+			if len(entries) > 0 {
+				logging.Infof("Quiet hours are over: sending %d %s deliveries deferred during them", len(entries), trackingName)
+			}
+			for hash, entry := range entries {
+				if stage.unavailable.Load() || p.ctx.Err() != nil {
+					// Left queued for the next run
+					return
+				}
+				p.deliverDeferred(stage, trackingName, hash, entry)
+			}
+		}
+	}
+}
+
+// deliverDeferred delivers one image queued during quiet hours and takes it off the queue. An
+// image that fails stays queued for the next run; one whose file is gone is left to the album
+// sync, which delivers it like any new image if it's still in an album
+func (p *syncPipeline) deliverDeferred(stage *notifierStage, trackingName string, hash string, entry string) {
+	name := stage.notifier.Name()
+	var metadata notify.Metadata
+	if err := json.Unmarshal([]byte(entry), &metadata); err != nil {
+		logging.Errorf("Error decoding deferred %s delivery of image %s, dropping it: %v", trackingName, hash, err)
+		p.removeDeferred(trackingName, hash)
+		return
+	}
+	exists, err := p.deliveredTo(trackingName, hash, metadata.GUID)
+	if err != nil {
+		logging.Errorf("Error checking Redis for %s hash %s: %v", trackingName, hash, err)
+		p.fail(err)
+		return
+	}
+	if exists {
+		p.removeDeferred(trackingName, hash)
+		return
+	}
+	imagePath, err := p.storageManager.GetImagePath(hash)
+	if err != nil {
+		logging.Warnf("Deferred %s delivery of image %s is no longer on disk, leaving it to the album sync: %v", trackingName, hash, err)
+		p.removeDeferred(trackingName, hash)
+		return
+	}
+
+	err = stage.notifier.Process(hash, imagePath, metadata)
+	switch {
+	case err == nil:
+		p.markDelivered(trackingName, hash, metadata)
+		p.countDelivered(name)
+		p.recordAudit(audit.Event{
+			Hash:   hash,
+			Album:  metadata.Album,
+			URL:    metadata.ImageURL,
+			Sinks:  []string{name},
+			Result: audit.ResultDelivered,
+		})
+	case errors.Is(err, notify.ErrQueued):
+		// Marked as processed once the notifier is flushed at the end of the run
+		logging.Debugf("Queued deferred image %s for %s (hash: %s)", imagePath, name, hash)
+	default:
+		logging.Errorf("Error delivering deferred image %s to %s: %v", imagePath, name, err)
+		p.fail(fmt.Errorf("failed to deliver %s to %s: %w", imagePath, name, err))
+		if errors.Is(err, notify.ErrUnavailable) && !stage.unavailable.Swap(true) {
+			logging.Warnf("%s will be skipped for the rest of this run", name)
+		}
+		return
+	}
+	// The albums' copy of the image needn't go to this notifier again this run
+	p.mu.Lock()
+	p.dispatchedTo[trackingName+":"+hash] = true
+	p.mu.Unlock()
+	p.removeDeferred(trackingName, hash)
+}
+
+// removeDeferred takes an image off a notifier's deferred queue
+func (p *syncPipeline) removeDeferred(trackingName string, hash string) {
+	if err := p.redisClient.RemoveDeferred(trackingName, hash); err != nil {
+		logging.Errorf("Error removing deferred %s hash from Redis: %v", trackingName, err)
+	}
+}
+
+// deferredQueued returns how many deliveries are queued until quiet hours end, for the
+// notifiers paused this run
+func (p *syncPipeline) deferredQueued() int {
+	total := 0
+	for _, stage := range p.stages {
+		if !stage.deferred {
+			continue
+		}
+		for _, trackingName := range p.trackingNames(stage.notifier.Name()) {
+			count, err := p.redisClient.DeferredCount(trackingName)
+			if err != nil {
+				logging.Errorf("Error counting deferred %s deliveries in Redis: %v", trackingName, err)
+				continue
+			}
+			total += int(count)
+		}
+	}
+	return total
+}
+
+// deliveredTo reports whether a notifier already has an image, by content hash and/or asset
+// GUID according to DEDUP_KEY. With "guid", images without a GUID fall back to the hash
+func (p *syncPipeline) deliveredTo(name string, hash string, guid string) (bool, error) {
+	if p.usesGUID(guid) {
+		exists, err := p.redisClient.GUIDExistsFor(name, guid)
+		if err != nil || exists || p.cfg.DedupKey == "guid" {
+			return exists, err
+		}
+	}
+	return p.redisClient.HashExistsFor(name, hash)
+}
+
+// markDelivered records that a notifier has an image. The content hash is always recorded,
+// so DEDUP_KEY can be switched back to hash without resending anything
+func (p *syncPipeline) markDelivered(name string, hash string, metadata notify.Metadata) {
+	if err := p.redisClient.SetHashFor(name, hash, metadata.ImageURL); err != nil {
+		logging.Errorf("Error storing %s hash in Redis: %v", name, err)
+	}
+	if p.usesGUID(metadata.GUID) {
+		if err := p.redisClient.SetGUIDFor(name, metadata.GUID, hash); err != nil {
+			logging.Errorf("Error storing %s GUID in Redis: %v", name, err)
+		}
+	}
+}
+
+// downloadFailed handles an error from downloading an image
+func (p *syncPipeline) downloadFailed(imageURL string, err error) {
+	switch {
+	case errors.Is(err, storage.ErrTypeNotAllowed):
+		logging.Debugf("Skipping image %s: %v", imageURL, err)
+	case errors.Is(err, storage.ErrURLExpired):
+		// Not counted as a failed run: the next run's album fetch has fresh URLs
+		logging.Warnf("Skipping image %s until the next run fetches a fresh URL: %v", imageURL, err)
+	case errors.Is(err, storage.ErrImageTooSmall):
+		logging.Infof("Skipping image %s: %v", imageURL, err)
+		p.mu.Lock()
+		p.tooSmallCount++
+		p.mu.Unlock()
+	case errors.Is(err, storage.ErrImageDirUnwritable):
+		// Every other download would fail the same way; not counted against the image
+		p.abort(fmt.Errorf("cannot write to %s, check the mount and free disk space: %w", p.cfg.ImageDir, err))
+	default:
+		logging.Errorf("Error downloading image %s: %v", imageURL, err)
+		p.fail(fmt.Errorf("failed to download %s: %w", imageURL, err))
+	}
+}
+
+// refreshURL fetches the image's album again to find a fresh download URL for its asset after
+// the URL it was listed with expired, updating image. Returns false if there is none
+func (p *syncPipeline) refreshURL(image *albumImage, expiredErr error) (string, bool) {
+	photos, err := p.refreshedPhotos(image.album)
+	if err != nil {
+		logging.Errorf("Error fetching album %d again for a fresh URL for %s: %v", image.album+1, image.url, err)
+		return "", false
+	}
+	photo, ok := photos[image.guid]
+	if !ok || photo.URL == image.url {
+		logging.Debugf("Album %d has no fresh URL for asset %s (%v)", image.album+1, image.guid, expiredErr)
+		return "", false
+	}
+	logging.Infof("Download URL for asset %s expired, retrying with a fresh one", image.guid)
+	image.url = photo.URL
+	if image.derivative.URL != "" {
+		image.derivative, _ = photo.SmallestAtLeast(emailScaleSize(p.cfg))
+	}
+	return photo.URL, true
+}
+
+// refreshedPhotos returns an album's photos by asset GUID, fetching the album the first time
+// it's needed this run
+func (p *syncPipeline) refreshedPhotos(album int) (map[string]scraper.Photo, error) {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+	if photos, ok := p.refreshed[album]; ok {
+		return photos, nil
+	}
+
+	// A cached listing holds the expired URL; fetching it again also refreshes the cache for
+	// later runs
+	p.albumScrapers[album].Invalidate()
+	fetched, err := p.albumScrapers[album].GetPhotosContext(p.ctx)
+	if err != nil {
+		return nil, err
+	}
+	photos := make(map[string]scraper.Photo, len(fetched))
+	for _, photo := range fetched {
+		if photo.GUID != "" {
+			photos[photo.GUID] = photo
+		}
+	}
+	if p.refreshed == nil {
+		p.refreshed = make(map[int]map[string]scraper.Photo)
+	}
+	p.refreshed[album] = photos
+	return photos, nil
+}
+
+// deletableImage is an image file to delete at the end of the run (DELETE_AFTER_UPLOAD)
+type deletableImage struct {
+	path         string
+	destinations map[string]bool // Destinations of the albums it was synced from (see trackingName)
+}
+
+// markDeletable remembers an image file to delete at the end of the run if DELETE_AFTER_UPLOAD
+// is set; it is only deleted once every enabled notifier has it recorded in the store, for
+// every destination it was synced for
+func (p *syncPipeline) markDeletable(hash string, imagePath string, destination string) {
+	if !p.cfg.DeleteAfterUpload || imagePath == "" {
+		return
+	}
+	p.deleteMu.Lock()
+	defer p.deleteMu.Unlock()
+	image := p.deletable[hash]
+	if image == nil {
+		image = &deletableImage{path: imagePath, destinations: make(map[string]bool)}
+		p.deletable[hash] = image
+	}
+	image.destinations[destination] = true
+}
+
+// flush delivers anything batched by the notifiers (e.g. zipped email) and marks it as
+// processed. Returns the errors of the notifiers that couldn't deliver everything
+func (p *syncPipeline) flush(notifiers []notify.Notifier) []error {
+	var errs []error
+	for _, notifier := range notifiers {
+		flusher, ok := notifier.(notify.Flusher)
+		if !ok {
+			continue
+		}
+		deliveries, err := flusher.Flush()
+		for _, delivery := range deliveries {
+			p.markDelivered(p.trackingName(notifier.Name(), delivery.Metadata.Destination), delivery.Hash, delivery.Metadata)
+			p.countDelivered(notifier.Name())
+			p.recordAudit(audit.Event{
+				Hash:   delivery.Hash,
+				Album:  delivery.Metadata.Album,
+				URL:    delivery.Metadata.ImageURL,
+				Sinks:  []string{notifier.Name()},
+				Result: audit.ResultDelivered,
+			})
+		}
+		if err != nil {
+			logging.Errorf("Error flushing %s notifier: %v", notifier.Name(), err)
+			errs = append(errs, fmt.Errorf("failed to flush %s notifier: %w", notifier.Name(), err))
+		}
+	}
+	return errs
+}
+
+// deleteDelivered deletes the files marked deletable this run that every named notifier has
+// delivered. Called after batched notifiers have been flushed, since they still read the files
+func (p *syncPipeline) deleteDelivered(notifierNames []string) {
+	p.deleteMu.Lock()
+	defer p.deleteMu.Unlock()
+
+	deleted := 0
+	for hash, image := range p.deletable {
+		if !p.deliveredEverywhere(notifierNames, hash, image.destinations) {
+			continue
+		}
+		if err := p.storageManager.DeleteImage(image.path); err != nil {
+			logging.Errorf("Error deleting delivered image %s: %v", image.path, err)
+			continue
+		}
+		deleted++
+	}
+	p.deletable = make(map[string]*deletableImage)
+	if deleted > 0 {
+		logging.Infof("Deleted %d delivered images from %s (DELETE_AFTER_UPLOAD)", deleted, p.cfg.ImageDir)
+	}
+}
+
+// deliveredEverywhere reports whether every named notifier has an image recorded in the store
+// for each of the destinations. Errors are logged and treated as not delivered
+func (p *syncPipeline) deliveredEverywhere(notifierNames []string, hash string, destinations map[string]bool) bool {
+	for _, name := range notifierNames {
+		for destination := range destinations {
+			trackingName := p.trackingName(name, destination)
+			exists, err := p.redisClient.HashExistsFor(trackingName, hash)
+			if err != nil {
+				logging.Errorf("Error checking Redis for %s hash %s: %v", trackingName, hash, err)
+			}
+			if err != nil || !exists {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// fail counts a failure that isn't tied to a single notifier (download or Redis errors)
+func (p *syncPipeline) fail(err error) {
+	p.mu.Lock()
+	p.failedCount++
+	p.errors = append(p.errors, err)
+	p.mu.Unlock()
+}
+
+// countDelivered records that a notifier delivered an image this run
+func (p *syncPipeline) countDelivered(name string) {
+	p.mu.Lock()
+	p.deliveredCounts[name]++
+	p.mu.Unlock()
+}
+
+// download fetches and hashes one image and dispatches it to every notifier that still needs it
+func (p *syncPipeline) download(index int, image albumImage) {
+	imageURL := image.url
+	logging.Debugf("Processing image %d/%d from album %d: %s", index+1, p.totalImages, image.album+1, imageURL)
+
+	// With GUID dedup, an asset every notifier already has needn't be downloaded at all
+	destination := p.cfg.AlbumDestinations[image.album]
+	if p.guidDelivered(image.guid, destination) {
+		logging.Debugf("Asset %s already processed for all notifiers, skipping", image.guid)
+		return
+	}
+
+	// Download and hash the image (high-quality version only - original or medium)
+	// The scraper ensures only high-quality images are selected (skips thumbnails)
+	// This same high-quality image is handed to every notifier
+	albumName := p.albumScrapers[image.album].AlbumName()
+	imagePath, hash, err := p.storageManager.DownloadAndHashForAlbum(imageURL, albumName)
+	if errors.Is(err, storage.ErrURLExpired) && image.guid != "" {
+		// Retry once with the asset's fresh URL rather than failing on the stale one every run
+		if fresh, ok := p.refreshURL(&image, err); ok {
+			imageURL = fresh
+			imagePath, hash, err = p.storageManager.DownloadAndHashForAlbum(imageURL, albumName)
+		}
+	}
+	if err != nil {
+		p.downloadFailed(imageURL, err)
+		return
+	}
+	if imagePath == "" {
+		logging.Debugf("Image %s is unchanged and was deleted after delivery (hash: %s)", imageURL, hash)
+	} else {
+		logging.Debugf("Downloaded and hashed image: %s (hash: %s)", imagePath, hash)
+	}
+
+	// Skip images that were dead-lettered after repeated failures
+	deadLettered, err := p.redisClient.IsDeadLettered(hash)
+	if err != nil {
+		logging.Errorf("Error checking Redis for dead-lettered hash %s: %v", hash, err)
+		p.fail(err)
+		return
+	}
+	if deadLettered {
+		logging.Warnf("Image with hash %s is quarantined after repeated failures, skipping", hash)
+		if imagePath == "" {
+			return
+		}
+		if _, err := p.storageManager.QuarantineImage(imagePath); err != nil {
+			logging.Errorf("Error quarantining image %s: %v", imagePath, err)
+		}
+		p.recordProgress(image)
+		return
+	}
+
+	if p.markSeen[image.album] {
+		p.markSeenImage(image, hash, imagePath, albumName)
+		return
+	}
+
+	// Check processing status for each notifier independently
+	var pending []*notifierStage
+	var deferred []string
+	alreadyDelivered := 0
+	for _, stage := range p.stages {
+		if stage.unavailable.Load() {
+			continue
+		}
+		name := stage.notifier.Name()
+		trackingName := p.trackingName(name, destination)
+		exists, err := p.deliveredTo(trackingName, hash, image.guid)
+		if err != nil {
+			logging.Errorf("Error checking Redis for %s hash %s: %v", trackingName, hash, err)
+			p.fail(err)
+			return
+		}
+		logging.Debugf("%s tracking check for hash %s: exists=%v", trackingName, hash, exists)
+		gaveUp := false
+		if !exists && p.maxAttempts(name) > 0 {
+			if gaveUp, err = p.redisClient.IsDeadLetteredFor(trackingName, hash); err != nil {
+				logging.Errorf("Error checking Redis for %s dead-lettered hash %s: %v", name, hash, err)
+				p.fail(err)
+				return
+			}
+		}
+		switch {
+		case exists:
+			alreadyDelivered++
+		case gaveUp:
+			logging.Debugf("Image with hash %s is dead-lettered for %s, skipping it there", hash, name)
+		case stage.deferred:
+			// Queued for the first run after the quiet hours (see drainDeferred)
+			deferred = append(deferred, trackingName)
+		default:
+			pending = append(pending, stage)
+		}
+	}
+
+	if len(deferred) > 0 {
+		p.deferDeliveries(deferred, hash, p.imageMetadata(image, imageURL, albumName, hash))
+	}
+
+	// Skip if already processed for every notifier
+	if len(pending) == 0 {
+		if len(deferred) > 0 {
+			logging.Infof("Image with hash %s deferred for %v until quiet hours end", hash, deferred)
+			p.recordAlbumHash(image.album, hash)
+			return
+		}
+		logging.Debugf("Image with hash %s already processed for all notifiers, skipping", hash)
+		p.recordAlbumHash(image.album, hash)
+		p.markDeletable(hash, imagePath, destination)
+		p.recordProgress(image)
+		return
+	}
+
+	// A notifier still needs an image whose file was deleted after delivery (e.g. one that was
+	// unavailable or only enabled since), so fetch it again
+	if imagePath == "" {
+		imagePath, hash, err = p.storageManager.RedownloadForAlbum(imageURL, albumName)
+		if err != nil {
+			p.downloadFailed(imageURL, err)
+			return
+		}
+		logging.Infof("Downloaded deleted image again for pending notifiers: %s (hash: %s)", imagePath, hash)
+	}
+
+	// Claim a slot in the run's budget; concurrent downloads may overshoot it, in which case
+	// the image is left on disk for the next run. An image shared by albums with different
+	// destinations is dispatched again only to the notifiers tracking it per destination, and
+	// counts against the budget once
+	p.mu.Lock()
+	used, limit, name := p.budget(image.album)
+	seen := p.seenHashes[hash]
+	pending = p.undispatchedLocked(pending, hash, destination)
+	switch {
+	case len(pending) == 0:
+		p.mu.Unlock()
+		logging.Debugf("Image with hash %s was already dispatched this run, skipping", hash)
+		return
+	case seen:
+		// Already counted against the budget when dispatched for another destination
+	case *used >= limit:
+		p.mu.Unlock()
+		p.heldBack.Store(true)
+		logging.Debugf("Reached %s limit (%d), leaving image %s for the next run", name, limit, hash)
+		return
+	case p.albumCapReachedLocked(image.album):
+		p.mu.Unlock()
+		p.heldBack.Store(true)
+		return
+	}
+	if !seen {
+		p.seenHashes[hash] = true
+		*used++
+		p.albumDispatched[image.album]++
+		if p.albumCapReachedLocked(image.album) {
+			logging.Infof("Album %d reached MAX_ITEMS_PER_ALBUM limit (%d), skipping its remaining images this run", image.album+1, p.cfg.MaxItemsPerAlbum)
+		}
+	}
+	for _, stage := range pending {
+		p.dispatchedTo[p.trackingName(stage.notifier.Name(), destination)+":"+hash] = true
+	}
+	p.mu.Unlock()
+
+	job := &syncJob{
+		image:            image,
+		imagePath:        imagePath,
+		hash:             hash,
+		metadata:         p.imageMetadata(image, imageURL, albumName, hash),
+		remaining:        len(pending),
+		alreadyDelivered: alreadyDelivered,
+	}
+	job.metadata.Derivative = p.downloadDerivative(image, hash, imagePath, pending)
+	for _, stage := range pending {
+		stage.jobs <- job
+	}
+}
+
+// imageMetadata describes an image to the notifiers
+func (p *syncPipeline) imageMetadata(image albumImage, imageURL string, albumName string, hash string) notify.Metadata {
+	return notify.Metadata{
+		ImageURL:    imageURL,
+		Album:       albumName,
+		Taken:       image.taken,
+		Caption:     image.caption,
+		GUID:        image.guid,
+		Contributor: image.contributor,
+		FileName:    p.storageManager.OriginalName(hash),
+		Destination: p.cfg.AlbumDestinations[image.album],
+	}
+}
+
+// deferDeliveries queues an image for the notifiers paused by quiet hours, by tracking name,
+// so the first run after them delivers it (see drainDeferred)
+func (p *syncPipeline) deferDeliveries(trackingNames []string, hash string, metadata notify.Metadata) {
+	entry, err := json.Marshal(metadata)
+	if err != nil {
+		logging.Errorf("Error encoding deferred delivery of image %s: %v", hash, err)
+		p.fail(err)
+		return
+	}
+	for _, name := range trackingNames {
+		if err := p.redisClient.AddDeferred(name, hash, string(entry)); err != nil {
+			logging.Errorf("Error storing deferred %s hash in Redis: %v", name, err)
+			p.fail(err)
+		}
+	}
+	p.mu.Lock()
+	p.deferredCount += len(trackingNames)
+	p.mu.Unlock()
+}
+
+// undispatchedLocked returns the stages an image hasn't been handed to yet this run for an
+// album's destination. Must be called with p.mu held
+func (p *syncPipeline) undispatchedLocked(stages []*notifierStage, hash string, destination string) []*notifierStage {
+	var undispatched []*notifierStage
+	for _, stage := range stages {
+		if !p.dispatchedTo[p.trackingName(stage.notifier.Name(), destination)+":"+hash] {
+			undispatched = append(undispatched, stage)
+		}
+	}
+	return undispatched
+}
+
+// downloadDerivative fetches the smaller size of an image chosen for email (EMAIL_DERIVATIVE)
+// when the email stage still needs it, so the other destinations keep the original. Returns
+// the derivative's path, or "" to email the original
+func (p *syncPipeline) downloadDerivative(image albumImage, hash string, imagePath string, pending []*notifierStage) string {
+	if image.derivative.URL == "" {
+		return ""
+	}
+	// A smaller size may not keep the animation, so an animated GIF is emailed as it is
+	if storage.IsAnimatedGIF(imagePath) {
+		return ""
+	}
+	for _, stage := range pending {
+		if stage.notifier.Name() != "email" {
+			continue
+		}
+		size := fmt.Sprintf("%dx%d", image.derivative.Width, image.derivative.Height)
+		path, err := p.storageManager.DownloadDerivative(image.derivative.URL, hash, size)
+		if err != nil {
+			logging.Warnf("Error downloading %s size of image %s, emailing the original instead: %v", size, hash, err)
+			return ""
+		}
+		logging.Debugf("Emailing %s size of image %s: %s", size, hash, path)
+		return path
+	}
+	return ""
+}
+
+// markSeenImage records an image from an album's first run as delivered to every notifier
+// without delivering it, so only images added later are notified
+func (p *syncPipeline) markSeenImage(image albumImage, hash string, imagePath string, albumName string) {
+	destination := p.cfg.AlbumDestinations[image.album]
+	metadata := notify.Metadata{ImageURL: image.url, Album: albumName, GUID: image.guid, Destination: destination}
+	for _, stage := range p.stages {
+		name := p.trackingName(stage.notifier.Name(), destination)
+		exists, err := p.deliveredTo(name, hash, image.guid)
+		if err != nil {
+			logging.Errorf("Error checking Redis for %s hash %s: %v", name, hash, err)
+			p.fail(err)
+			return
+		}
+		if !exists {
+			p.markDelivered(name, hash, metadata)
+		}
+	}
+	logging.Debugf("Marked image %s as seen without notifying (hash: %s)", image.url, hash)
+	p.recordAudit(audit.Event{
+		Hash:   hash,
+		Album:  albumName,
+		URL:    image.url,
+		Result: audit.ResultMarkedSeen,
+	})
+	p.recordAlbumHash(image.album, hash)
+	p.markDeletable(hash, imagePath, destination)
+	p.recordProgress(image)
+	p.mu.Lock()
+	p.markedSeenCount++
+	p.mu.Unlock()
+}
+
+// deliver hands a job to one notifier and finishes the job once every stage has seen it
+func (p *syncPipeline) deliver(stage *notifierStage, job *syncJob) {
+	name := stage.notifier.Name()
+	var delivered, queued, failed bool
+	if stage.unavailable.Load() {
+		logging.Debugf("Skipping %s for image %s: unavailable for the rest of this run", name, job.hash)
+	} else {
+		metadata, err := p.ensureFile(job)
+		if err == nil {
+			err = stage.notifier.Process(job.hash, job.imagePath, metadata)
+		}
+		switch {
+		case err == nil:
+			delivered = true
+			// Mark as processed for this notifier
+			p.markDelivered(p.trackingName(name, job.metadata.Destination), job.hash, job.metadata)
+			p.countDelivered(name)
+			if p.maxAttempts(name) > 0 {
+				if err := p.redisClient.ResetAttemptsFor(p.trackingName(name, job.metadata.Destination), job.hash); err != nil {
+					logging.Errorf("Error resetting %s attempts in Redis: %v", name, err)
+				}
+			}
+		case errors.Is(err, notify.ErrQueued):
+			// Marked as processed once the notifier is flushed at the end of the run
+			logging.Debugf("Queued image %s for %s (hash: %s)", job.imagePath, name, job.hash)
+			delivered, queued = true, true
+		default:
+			logging.Errorf("Error delivering image %s to %s: %v", job.imagePath, name, err)
+			failed = true
+			p.mu.Lock()
+			p.errors = append(p.errors, fmt.Errorf("failed to deliver %s to %s: %w", job.imagePath, name, err))
+			p.mu.Unlock()
+			if errors.Is(err, notify.ErrUnavailable) && !stage.unavailable.Swap(true) {
+				logging.Warnf("%s will be skipped for the rest of this run", name)
+			}
+			p.recordAttempt(name, job)
+		}
+	}
+
+	job.mu.Lock()
+	if delivered {
+		job.delivered = append(job.delivered, name)
+	}
+	if queued {
+		job.queued = append(job.queued, name)
+	}
+	if failed {
+		job.failed = append(job.failed, name)
+	}
+	job.remaining--
+	done := job.remaining == 0
+	job.mu.Unlock()
+
+	if done {
+		p.finish(job)
+	}
+}
+
+// ensureFile makes sure a job's file is still on disk before a notifier reads it, downloading
+// it again if it was removed since it was downloaded (e.g. by hand, or by DELETE_AFTER_UPLOAD
+// in an overlapping run) and REDOWNLOAD_MISSING_FILES is enabled. Returns the metadata to
+// deliver with, which drops an email derivative that has gone missing
+func (p *syncPipeline) ensureFile(job *syncJob) (notify.Metadata, error) {
+	metadata := job.metadata
+	if !p.cfg.RedownloadMissingFiles {
+		return metadata, nil
+	}
+	if metadata.Derivative != "" {
+		if _, err := os.Stat(metadata.Derivative); errors.Is(err, os.ErrNotExist) {
+			logging.Warnf("Email derivative %s is missing, sending the original instead", metadata.Derivative)
+			metadata.Derivative = ""
+		}
+	}
+
+	job.fileMu.Lock()
+	defer job.fileMu.Unlock()
+	if _, err := os.Stat(job.imagePath); !errors.Is(err, os.ErrNotExist) {
+		return metadata, nil
+	}
+	logging.Warnf("Image %s is no longer on disk, downloading it again (hash: %s)", job.imagePath, job.hash)
+	imagePath, hash, err := p.storageManager.RedownloadForAlbum(metadata.ImageURL, metadata.Album)
+	if errors.Is(err, storage.ErrURLExpired) && job.image.guid != "" {
+		image := job.image
+		if fresh, ok := p.refreshURL(&image, err); ok {
+			imagePath, hash, err = p.storageManager.RedownloadForAlbum(fresh, metadata.Album)
+		}
+	}
+	if err != nil {
+		return metadata, fmt.Errorf("image file is missing and downloading it again failed: %w", err)
+	}
+	if hash != job.hash || imagePath != job.imagePath {
+		return metadata, fmt.Errorf("image file is missing and its URL now has different content (hash: %s)", hash)
+	}
+	return metadata, nil
+}
+
+// maxAttempts returns how many failed deliveries the named notifier makes of an image before
+// giving up on it, or 0 to retry forever
+func (p *syncPipeline) maxAttempts(name string) int {
+	if name == "email" {
+		return p.cfg.EmailMaxAttempts
+	}
+	return 0
+}
+
+// recordAttempt counts a failed delivery of an image by the named notifier and dead-letters
+// the image for that notifier once it reaches maxAttempts, so one that can never be delivered
+// (e.g. too large for the mail provider) isn't retried every run
+func (p *syncPipeline) recordAttempt(name string, job *syncJob) {
+	limit := p.maxAttempts(name)
+	if limit == 0 {
+		return
+	}
+	trackingName := p.trackingName(name, job.metadata.Destination)
+	count, err := p.redisClient.IncrementAttemptsFor(trackingName, job.hash)
+	if err != nil {
+		logging.Errorf("Error storing %s attempts in Redis: %v", name, err)
+		return
+	}
+	if count < int64(limit) {
+		logging.Warnf("Delivering image %s to %s failed %d of %d allowed times", job.imagePath, name, count, limit)
+		return
+	}
+	if err := p.redisClient.SetDeadLetteredFor(trackingName, job.hash, job.metadata.ImageURL); err != nil {
+		logging.Errorf("Error storing %s dead letter in Redis: %v", name, err)
+		return
+	}
+	logging.Errorf("DEAD-LETTERED: giving up delivering image %s (hash: %s) to %s after %d failed attempts (set RESET_QUARANTINE=true to retry)",
+		job.imagePath, job.hash, name, count)
+}
+
+// finish records the outcome of an image once every stage has processed it
+func (p *syncPipeline) finish(job *syncJob) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Count as processed if at least one notifier has the image
+	switch {
+	case len(job.delivered) > 0 || job.alreadyDelivered > 0:
+		p.processedCount++
+		p.albumProcessed[job.image.album]++
+		p.recordAlbumHash(job.image.album, job.hash)
+		logging.Infof("Successfully processed image %s (hash: %s) - delivered: %v, failed: %v",
+			job.imagePath, job.hash, job.delivered, job.failed)
+		if err := p.redisClient.ResetFailures(job.hash); err != nil {
+			logging.Errorf("Error resetting failure count in Redis: %v", err)
+		}
+	case len(job.failed) > 0:
+		logging.Errorf("Failed to process image %s (hash: %s) for every notifier - failed: %v",
+			job.imagePath, job.hash, job.failed)
+		recordFailure(p.storageManager, p.redisClient, p.cfg, job.imagePath, job.hash, job.metadata.ImageURL)
+	}
+	if len(job.failed) > 0 {
+		p.failedCount++
+	} else {
+		p.markDeletable(job.hash, job.imagePath, job.metadata.Destination)
+	}
+	// An image waiting to be flushed isn't finished until the end of the run
+	if len(job.failed) == 0 && len(job.queued) == 0 {
+		p.recordProgress(job.image)
+	}
+	p.auditJob(job)
+}
+
+// recordProgress notes that the run has finished an image, when RUN_PROGRESS_TTL is set
+func (p *syncPipeline) recordProgress(image albumImage) {
+	if p.progress != nil {
+		p.progress.record(image)
+	}
+}
+
+// auditJob records the outcome of a job in the audit log. Queued deliveries are left out;
+// they're recorded once their notifier is flushed
+func (p *syncPipeline) auditJob(job *syncJob) {
+	if p.auditLog == nil {
+		return
+	}
+	var sinks []string
+	for _, name := range job.delivered {
+		if !slices.Contains(job.queued, name) {
+			sinks = append(sinks, name)
+		}
+	}
+	result := audit.ResultDelivered
+	switch {
+	case len(job.failed) == 0 && len(sinks) == 0:
+		return // Only queued
+	case len(job.failed) > 0 && len(job.delivered) == 0 && job.alreadyDelivered == 0:
+		result = audit.ResultFailed
+	case len(job.failed) > 0:
+		result = audit.ResultPartial
+	}
+	p.recordAudit(audit.Event{
+		Hash:   job.hash,
+		Album:  job.metadata.Album,
+		URL:    job.metadata.ImageURL,
+		Sinks:  sinks,
+		Failed: job.failed,
+		Result: result,
+	})
+}
+
+// recordAudit appends an event to the audit log, if there is one. Failing to record it is
+// logged but doesn't fail the image, which has already been delivered
+func (p *syncPipeline) recordAudit(event audit.Event) {
+	if p.auditLog == nil {
+		return
+	}
+	if err := p.auditLog.Record(event); err != nil {
+		logging.Errorf("Error writing audit log: %v", err)
+	}
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+	"github.com/jsteffee/icloud-photo-sync/pkg/notify"
+	"github.com/jsteffee/icloud-photo-sync/pkg/scraper"
+	"github.com/jsteffee/icloud-photo-sync/pkg/storage"
+)
+
+// fakeStore is an in-memory syncStore
+type fakeStore struct {
+	mu       sync.Mutex
+	sets     map[string]bool
+	counts   map[string]int64
+	deferred map[string]map[string]string // Deferred entries by service and hash
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{sets: make(map[string]bool), counts: make(map[string]int64), deferred: make(map[string]map[string]string)}
+}
+
+func (s *fakeStore) has(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sets[key], nil
+}
+
+func (s *fakeStore) set(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sets[key] = true
+	return nil
+}
+
+func (s *fakeStore) increment(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[key]++
+	return s.counts[key], nil
+}
+
+func (s *fakeStore) reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.counts, key)
+	return nil
+}
+
+func (s *fakeStore) HashExistsFor(service string, hash string) (bool, error) {
+	return s.has("hash:" + service + ":" + hash)
+}
+
+func (s *fakeStore) SetHashFor(service string, hash string, imageURL string) error {
+	return s.set("hash:" + service + ":" + hash)
+}
+
+func (s *fakeStore) GUIDExistsFor(service string, guid string) (bool, error) {
+	return s.has("guid:" + service + ":" + guid)
+}
+
+func (s *fakeStore) SetGUIDFor(service string, guid string, hash string) error {
+	return s.set("guid:" + service + ":" + guid)
+}
+
+func (s *fakeStore) IncrementFailureCount(hash string) (int64, error) {
+	return s.increment("failures:" + hash)
+}
+
+func (s *fakeStore) ResetFailures(hash string) error {
+	return s.reset("failures:" + hash)
+}
+
+func (s *fakeStore) IsDeadLettered(hash string) (bool, error) {
+	return s.has("deadletter:" + hash)
+}
+
+func (s *fakeStore) SetDeadLettered(hash string, imageURL string) error {
+	return s.set("deadletter:" + hash)
+}
+
+func (s *fakeStore) IncrementAttemptsFor(service string, hash string) (int64, error) {
+	return s.increment("attempts:" + service + ":" + hash)
+}
+
+func (s *fakeStore) ResetAttemptsFor(service string, hash string) error {
+	return s.reset("attempts:" + service + ":" + hash)
+}
+
+func (s *fakeStore) IsDeadLetteredFor(service string, hash string) (bool, error) {
+	return s.has("deadletter:" + service + ":" + hash)
+}
+
+func (s *fakeStore) SetDeadLetteredFor(service string, hash string, imageURL string) error {
+	return s.set("deadletter:" + service + ":" + hash)
+}
+
+func (s *fakeStore) AddAlbumHash(albumURL string, hash string) error {
+	_, err := s.increment("album:" + albumURL)
+	return err
+}
+
+func (s *fakeStore) AlbumHashCount(albumURL string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts["album:"+albumURL], nil
+}
+
+func (s *fakeStore) AlbumWelcomed(albumURL string) (bool, error) {
+	return s.has("welcomed:" + albumURL)
+}
+
+func (s *fakeStore) SetAlbumWelcomed(albumURL string) error {
+	return s.set("welcomed:" + albumURL)
+}
+
+func (s *fakeStore) AddDeferred(service string, hash string, entry string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.deferred[service] == nil {
+		s.deferred[service] = make(map[string]string)
+	}
+	s.deferred[service][hash] = entry
+	return nil
+}
+
+func (s *fakeStore) Deferred(service string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make(map[string]string, len(s.deferred[service]))
+	for hash, entry := range s.deferred[service] {
+		entries[hash] = entry
+	}
+	return entries, nil
+}
+
+func (s *fakeStore) DeferredCount(service string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.deferred[service])), nil
+}
+
+func (s *fakeStore) RemoveDeferred(service string, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.deferred[service], hash)
+	return nil
+}
+
+// fakeNotifier records the images it's asked to deliver and answers every one with err
+type fakeNotifier struct {
+	name string
+	err  error
+
+	mu           sync.Mutex
+	received     map[string]int // Process calls, by hash
+	destinations []string       // Destinations of the Process calls, in order
+}
+
+func newFakeNotifier(name string, err error) *fakeNotifier {
+	return &fakeNotifier{name: name, err: err, received: make(map[string]int)}
+}
+
+func (n *fakeNotifier) Name() string {
+	return n.name
+}
+
+func (n *fakeNotifier) Process(hash string, imagePath string, metadata notify.Metadata) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.received[hash]++
+	n.destinations = append(n.destinations, metadata.Destination)
+	return n.err
+}
+
+// calls returns the number of Process calls
+func (n *fakeNotifier) calls() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	total := 0
+	for _, count := range n.received {
+		total += count
+	}
+	return total
+}
+
+// fakeBatcher queues every image until it's flushed, like the zipped email notifier
+type fakeBatcher struct {
+	fakeNotifier
+	flushErr error
+	queued   []notify.Delivery
+}
+
+func (n *fakeBatcher) Process(hash string, imagePath string, metadata notify.Metadata) error {
+	n.fakeNotifier.Process(hash, imagePath, metadata)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.queued = append(n.queued, notify.Delivery{Hash: hash, Metadata: metadata})
+	return notify.ErrQueued
+}
+
+func (n *fakeBatcher) Flush() ([]notify.Delivery, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	deliveries := n.queued
+	n.queued = nil
+	return deliveries, n.flushErr
+}
+
+// testRun serves distinct images for a pipeline to download
+type testRun struct {
+	server   *httptest.Server
+	storage  *storage.Manager
+	scrapers []*scraper.Scraper
+	cfg      *config.Config
+}
+
+func newTestRun(t *testing.T, albums int) *testRun {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		// A path ending in /copy serves the same image as the path without it
+		w.Write([]byte("image " + strings.TrimSuffix(r.URL.Path, "/copy")))
+	}))
+	t.Cleanup(server.Close)
+
+	imageDir := t.TempDir()
+	manager, err := storage.NewManager(imageDir)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	run := &testRun{
+		server:  server,
+		storage: manager,
+		cfg: &config.Config{
+			MaxItems:            100,
+			DownloadConcurrency: 2,
+			ImageDir:            imageDir,
+		},
+	}
+	for i := 0; i < albums; i++ {
+		albumURL := fmt.Sprintf("https://www.icloud.com/sharedalbum/#B0g5qAGN1JIFd%d", i)
+		run.scrapers = append(run.scrapers, scraper.NewScraper(albumURL))
+		run.cfg.AlbumURLs = append(run.cfg.AlbumURLs, albumURL)
+		run.cfg.AlbumDestinations = append(run.cfg.AlbumDestinations, "")
+	}
+	return run
+}
+
+// images lists count distinct images from an album
+func (r *testRun) images(album int, count int) []albumImage {
+	var images []albumImage
+	for i := 0; i < count; i++ {
+		images = append(images, albumImage{album: album, url: fmt.Sprintf("%s/%d/%d", r.server.URL, album, i)})
+	}
+	return images
+}
+
+// pipeline creates a pipeline delivering to the notifiers with one worker each
+func (r *testRun) pipeline(store syncStore, notifiers ...notify.Notifier) *syncPipeline {
+	var stages []*notifierStage
+	for _, notifier := range notifiers {
+		stages = append(stages, &notifierStage{notifier: notifier, workers: 1})
+	}
+	return newSyncPipeline(context.Background(), r.scrapers, r.storage, store, r.cfg, stages)
+}
+
+func TestSyncPipeline_DeliversOncePerNotifier(t *testing.T) {
+	run := newTestRun(t, 1)
+	store := newFakeStore()
+	first := newFakeNotifier("first", nil)
+	second := newFakeNotifier("second", nil)
+
+	// The first image is listed twice under different URLs
+	images := run.images(0, 5)
+	images = append(images, albumImage{album: 0, url: images[0].url + "/copy"})
+
+	p := run.pipeline(store, first, second)
+	p.run(images)
+
+	for _, notifier := range []*fakeNotifier{first, second} {
+		if len(notifier.received) != 5 {
+			t.Errorf("%s received %d images, want 5", notifier.name, len(notifier.received))
+		}
+		for hash, count := range notifier.received {
+			if count != 1 {
+				t.Errorf("%s received image %s %d times, want 1", notifier.name, hash, count)
+			}
+			if exists, _ := store.HashExistsFor(notifier.name, hash); !exists {
+				t.Errorf("%s hash %s not marked delivered", notifier.name, hash)
+			}
+		}
+		if got := p.deliveredCounts[notifier.name]; got != 5 {
+			t.Errorf("deliveredCounts[%s] = %d, want 5", notifier.name, got)
+		}
+	}
+	if p.processedCount != 5 {
+		t.Errorf("processedCount = %d, want 5", p.processedCount)
+	}
+
+	// The next run finds everything delivered
+	run.pipeline(store, first, second).run(images)
+	if first.calls() != 5 || second.calls() != 5 {
+		t.Errorf("Process calls after second run = %d and %d, want 5 and 5", first.calls(), second.calls())
+	}
+}
+
+func TestSyncPipeline_MaxItems(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxItems         int
+		maxItemsPerAlbum int
+		wantPerRun       int
+	}{
+		{name: "MAX_ITEMS", maxItems: 3, wantPerRun: 3},
+		{name: "MAX_ITEMS_PER_ALBUM", maxItems: 100, maxItemsPerAlbum: 2, wantPerRun: 4},
+		{name: "MAX_ITEMS below the album caps", maxItems: 3, maxItemsPerAlbum: 2, wantPerRun: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := newTestRun(t, 2)
+			run.cfg.MaxItems = tt.maxItems
+			run.cfg.MaxItemsPerAlbum = tt.maxItemsPerAlbum
+			store := newFakeStore()
+			notifier := newFakeNotifier("test", nil)
+			images := append(run.images(0, 5), run.images(1, 5)...)
+
+			p := run.pipeline(store, notifier)
+			p.run(images)
+			if got := notifier.calls(); got != tt.wantPerRun {
+				t.Errorf("Process calls = %d, want %d", got, tt.wantPerRun)
+			}
+			if tt.maxItemsPerAlbum > 0 {
+				for album, count := range p.albumDispatched {
+					if count > tt.maxItemsPerAlbum {
+						t.Errorf("album %d dispatched %d images, want at most %d", album+1, count, tt.maxItemsPerAlbum)
+					}
+				}
+			}
+
+			// Images left over are delivered by the following runs, each within the budget
+			for runs := 1; notifier.calls() < len(images); runs++ {
+				if runs > len(images) {
+					t.Fatalf("only %d of %d images delivered after %d runs", notifier.calls(), len(images), runs)
+				}
+				before := notifier.calls()
+				run.pipeline(store, notifier).run(images)
+				if got := notifier.calls() - before; got == 0 || got > tt.wantPerRun {
+					t.Fatalf("run %d delivered %d images, want 1 to %d", runs+1, got, tt.wantPerRun)
+				}
+			}
+			if len(notifier.received) != len(images) {
+				t.Errorf("received %d distinct images, want %d", len(notifier.received), len(images))
+			}
+		})
+	}
+}
+
+func TestSyncPipeline_FlushMarksQueued(t *testing.T) {
+	run := newTestRun(t, 1)
+	store := newFakeStore()
+	batcher := &fakeBatcher{fakeNotifier: *newFakeNotifier("batch", nil)}
+	images := run.images(0, 3)
+
+	p := run.pipeline(store, batcher)
+	p.run(images)
+	if batcher.calls() != 3 {
+		t.Fatalf("Process calls = %d, want 3", batcher.calls())
+	}
+	for hash := range batcher.received {
+		if exists, _ := store.HashExistsFor("batch", hash); exists {
+			t.Errorf("queued hash %s marked delivered before Flush", hash)
+		}
+	}
+
+	if errs := p.flush([]notify.Notifier{batcher}); len(errs) != 0 {
+		t.Fatalf("flush() errors = %v", errs)
+	}
+	for hash := range batcher.received {
+		if exists, _ := store.HashExistsFor("batch", hash); !exists {
+			t.Errorf("hash %s not marked delivered after Flush", hash)
+		}
+	}
+	if got := p.deliveredCounts["batch"]; got != 3 {
+		t.Errorf("deliveredCounts[batch] = %d, want 3", got)
+	}
+
+	// A flush that fails is reported
+	batcher.flushErr = errors.New("smtp down")
+	if errs := p.flush([]notify.Notifier{batcher}); len(errs) != 1 || !errors.Is(errs[0], batcher.flushErr) {
+		t.Errorf("flush() errors = %v, want the flush error", errs)
+	}
+}
+
+func TestSyncPipeline_UnavailableNotifier(t *testing.T) {
+	run := newTestRun(t, 1)
+	store := newFakeStore()
+	healthy := newFakeNotifier("healthy", nil)
+	revoked := newFakeNotifier("revoked", fmt.Errorf("token revoked: %w", notify.ErrUnavailable))
+	images := run.images(0, 5)
+
+	p := run.pipeline(store, healthy, revoked)
+	p.run(images)
+
+	if got := revoked.calls(); got != 1 {
+		t.Errorf("unavailable notifier Process calls = %d, want 1", got)
+	}
+	if !p.stages[1].unavailable.Load() {
+		t.Error("unavailable notifier's stage not marked unavailable")
+	}
+	if got := healthy.calls(); got != 5 {
+		t.Errorf("healthy notifier Process calls = %d, want 5", got)
+	}
+	for hash := range healthy.received {
+		if exists, _ := store.HashExistsFor("revoked", hash); exists {
+			t.Errorf("hash %s marked delivered to the unavailable notifier", hash)
+		}
+	}
+	if p.failedCount != 1 {
+		t.Errorf("failedCount = %d, want 1", p.failedCount)
+	}
+}
+
+func TestSyncPipeline_QuietHoursDeferred(t *testing.T) {
+	run := newTestRun(t, 1)
+	run.cfg.QuietHours = &config.QuietHours{Start: 22 * 60, End: 7 * 60, Location: time.UTC, Notifiers: []string{"email"}}
+	store := newFakeStore()
+	email := newFakeNotifier("email", nil)
+	webhook := newFakeNotifier("webhook", nil)
+	images := run.images(0, 3)
+
+	p := run.pipeline(store, email, webhook)
+	p.stages[0].deferred = true
+	p.run(images)
+	if email.calls() != 0 {
+		t.Errorf("paused notifier Process calls = %d, want 0", email.calls())
+	}
+	if webhook.calls() != 3 {
+		t.Errorf("other notifier Process calls = %d, want 3", webhook.calls())
+	}
+	if p.deferredCount != 3 {
+		t.Errorf("deferredCount = %d, want 3", p.deferredCount)
+	}
+	if got := p.deferredQueued(); got != 3 {
+		t.Errorf("deferredQueued() = %d, want 3", got)
+	}
+
+	// Once the quiet hours are over, the queue is drained, even if the photos have since left
+	// the album
+	p = run.pipeline(store, email, webhook)
+	p.run(nil)
+	if email.calls() != 3 || webhook.calls() != 3 {
+		t.Errorf("Process calls after quiet hours = %d and %d, want 3 and 3", email.calls(), webhook.calls())
+	}
+	for hash := range webhook.received {
+		if exists, _ := store.HashExistsFor("email", hash); !exists {
+			t.Errorf("deferred hash %s not marked delivered", hash)
+		}
+	}
+	if count, _ := store.DeferredCount("email"); count != 0 {
+		t.Errorf("DeferredCount() after draining = %d, want 0", count)
+	}
+	if p.deliveredCounts["email"] != 3 {
+		t.Errorf("deliveredCounts[email] = %d, want 3", p.deliveredCounts["email"])
+	}
+
+	// The album's images aren't delivered again
+	run.pipeline(store, email, webhook).run(images)
+	if email.calls() != 3 || webhook.calls() != 3 {
+		t.Errorf("Process calls after draining = %d and %d, want 3 and 3", email.calls(), webhook.calls())
+	}
+}
+
+func TestSyncPipeline_QuietHoursDrainWithAlbum(t *testing.T) {
+	run := newTestRun(t, 1)
+	run.cfg.QuietHours = &config.QuietHours{Start: 22 * 60, End: 7 * 60, Location: time.UTC, Notifiers: []string{"email"}}
+	store := newFakeStore()
+	email := &fakeBatcher{fakeNotifier: *newFakeNotifier("email", nil)}
+	images := run.images(0, 2)
+
+	p := run.pipeline(store, email)
+	p.stages[0].deferred = true
+	p.run(images)
+
+	// A batching notifier gets each drained image once, not again from the album
+	p = run.pipeline(store, email)
+	p.run(images)
+	if email.calls() != 2 {
+		t.Errorf("Process calls after quiet hours = %d, want 2", email.calls())
+	}
+	if errs := p.flush([]notify.Notifier{email}); len(errs) != 0 {
+		t.Fatalf("flush() errors = %v", errs)
+	}
+	for hash := range email.received {
+		if exists, _ := store.HashExistsFor("email", hash); !exists {
+			t.Errorf("drained hash %s not marked delivered after Flush", hash)
+		}
+	}
+}
+
+func TestSyncPipeline_SharedPhotoDestinations(t *testing.T) {
+	run := newTestRun(t, 2)
+	run.cfg.SMTPDestination = "me@example.com"
+	run.cfg.AlbumDestinations[1] = "family@example.com"
+	store := newFakeStore()
+	email := newFakeNotifier("email", nil)
+	webhook := newFakeNotifier("webhook", nil)
+
+	// The same photo is in both albums, which are emailed to different destinations
+	images := []albumImage{
+		{album: 0, url: run.server.URL + "/shared"},
+		{album: 1, url: run.server.URL + "/shared/copy"},
+	}
+
+	p := run.pipeline(store, email, webhook)
+	p.run(images)
+	if email.calls() != 2 {
+		t.Fatalf("email Process calls = %d, want 2 (one per destination)", email.calls())
+	}
+	sort.Strings(email.destinations)
+	if want := []string{"", "family@example.com"}; !reflect.DeepEqual(email.destinations, want) {
+		t.Errorf("email destinations = %q, want %q", email.destinations, want)
+	}
+	if webhook.calls() != 1 {
+		t.Errorf("webhook Process calls = %d, want 1", webhook.calls())
+	}
+	for hash := range email.received {
+		for _, name := range []string{"email", "email:family@example.com", "webhook"} {
+			if exists, _ := store.HashExistsFor(name, hash); !exists {
+				t.Errorf("%s hash %s not marked delivered", name, hash)
+			}
+		}
+	}
+
+	// The next run finds the photo delivered to both destinations
+	run.pipeline(store, email, webhook).run(images)
+	if email.calls() != 2 || webhook.calls() != 1 {
+		t.Errorf("Process calls after second run = %d and %d, want 2 and 1", email.calls(), webhook.calls())
+	}
+}
+
+func TestSyncPipeline_DetectFirstRuns(t *testing.T) {
+	tests := []struct {
+		name             string
+		backfillMaxItems int
+		initialSyncMode  string
+		skipped          []bool
+		wantBackfill     []bool
+		wantMarkSeen     []bool
+	}{
+		{
+			name:         "BACKFILL_MAX_ITEMS unset",
+			wantBackfill: []bool{false, false, false},
+			wantMarkSeen: []bool{false, false, false},
+		},
+		{
+			name:             "albums never synced",
+			backfillMaxItems: 5,
+			wantBackfill:     []bool{false, true, true},
+			wantMarkSeen:     []bool{false, false, false},
+		},
+		{
+			name:             "albums left out of the run",
+			backfillMaxItems: 5,
+			skipped:          []bool{false, false, true},
+			wantBackfill:     []bool{false, true, false},
+			wantMarkSeen:     []bool{false, false, false},
+		},
+		{
+			name:            "INITIAL_SYNC_MODE mark-seen-only",
+			initialSyncMode: "mark-seen-only",
+			wantBackfill:    []bool{false, false, false},
+			wantMarkSeen:    []bool{false, true, true},
+		},
+		{
+			name:             "mark-seen-only takes precedence over BACKFILL_MAX_ITEMS",
+			backfillMaxItems: 5,
+			initialSyncMode:  "mark-seen-only",
+			skipped:          []bool{false, false, true},
+			wantBackfill:     []bool{false, false, false},
+			wantMarkSeen:     []bool{false, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := newTestRun(t, 3)
+			run.cfg.BackfillMaxItems = tt.backfillMaxItems
+			run.cfg.InitialSyncMode = tt.initialSyncMode
+			store := newFakeStore()
+			// Only the first album has been synced before
+			store.AddAlbumHash(run.cfg.AlbumURLs[0], "abc")
+
+			p := run.pipeline(store)
+			p.skipped = tt.skipped
+			p.detectFirstRuns()
+			if !reflect.DeepEqual(p.backfill, tt.wantBackfill) {
+				t.Errorf("backfill = %v, want %v", p.backfill, tt.wantBackfill)
+			}
+			if !reflect.DeepEqual(p.markSeen, tt.wantMarkSeen) {
+				t.Errorf("markSeen = %v, want %v", p.markSeen, tt.wantMarkSeen)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Results recorded for an image
+const (
+	ResultDelivered  = "delivered"   // Every destination that still needed the image has it
+	ResultPartial    = "partial"     // Some destinations have the image and others failed
+	ResultFailed     = "failed"      // No destination has the image
+	ResultMarkedSeen = "marked-seen" // Recorded as delivered without delivering (INITIAL_SYNC_MODE=mark-seen-only)
+)
+
+// Event is one line of the audit log: what happened to one image
+type Event struct {
+	Time   time.Time `json:"time"`
+	Hash   string    `json:"hash"`
+	Album  string    `json:"album"`
+	URL    string    `json:"url"`
+	Sinks  []string  `json:"sinks"`            // Destinations the image was delivered to
+	Failed []string  `json:"failed,omitempty"` // Destinations that failed to take the image
+	Result string    `json:"result"`
+}
+
+// Logger appends events to a file as JSON lines. Once the file would grow past maxBytes it is
+// renamed with the time it was rotated and a new file is started; rotated files are kept,
+// since the log is a permanent record. Safe for concurrent use
+type Logger struct {
+	path     string
+	maxBytes int64 // 0 = never rotate
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// New opens the audit log at path for appending, creating it and its directory if needed
+func New(path string, maxBytes int64) (*Logger, error) {
+	l := &Logger{path: path, maxBytes: maxBytes}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the log file for appending. Must be called with l.mu held (or before l is shared)
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to get audit log info: %w", err)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Record appends an event, stamping it with the current time if it has none
+func (l *Logger) Record(event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Time = event.Time.UTC()
+	if event.Sinks == nil {
+		event.Sinks = []string{}
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+	var rotateErr error
+	if l.maxBytes > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxBytes {
+		rotateErr = l.rotate(event.Time)
+		if l.file == nil {
+			return rotateErr
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return rotateErr
+}
+
+// rotate renames the current file after the time it was rotated and starts a new one. If the
+// file can't be renamed it is reopened, so events keep being recorded. Must be called with
+// l.mu held
+func (l *Logger) rotate(now time.Time) error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+	l.file = nil
+	rotated := l.path + "." + now.Format("20060102T150405Z")
+	for i := 1; ; i++ {
+		// Several rotations within a second get a counter rather than overwriting each other
+		if _, err := os.Lstat(rotated); os.IsNotExist(err) {
+			break
+		}
+		rotated = fmt.Sprintf("%s.%s-%d", l.path, now.Format("20060102T150405Z"), i)
+	}
+	if err := os.Rename(l.path, rotated); err != nil {
+		if openErr := l.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	return l.open()
+}
+
+// Close closes the log file
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
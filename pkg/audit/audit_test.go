@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvents decodes every line of an audit log file
+func readEvents(t *testing.T, path string) []Event {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Line %q is not a JSON event: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestLogger_Record(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "audit.log")
+	logger, err := New(path, 0)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	if err := logger.Record(Event{
+		Time:   at,
+		Hash:   "abc123",
+		Album:  "Family",
+		URL:    "https://example.com/photo.jpg",
+		Sinks:  []string{"email"},
+		Failed: []string{"google_photos"},
+		Result: ResultPartial,
+	}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if err := logger.Record(Event{Hash: "def456", Result: ResultMarkedSeen}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	events := readEvents(t, path)
+	if len(events) != 2 {
+		t.Fatalf("Got %d events, want 2", len(events))
+	}
+	if !events[0].Time.Equal(at) || events[0].Time.Location() != time.UTC {
+		t.Errorf("Time = %v, want %v in UTC", events[0].Time, at)
+	}
+	if events[0].Hash != "abc123" || events[0].Album != "Family" || events[0].URL != "https://example.com/photo.jpg" {
+		t.Errorf("Event = %+v, want the recorded hash, album and URL", events[0])
+	}
+	if len(events[0].Sinks) != 1 || events[0].Sinks[0] != "email" || len(events[0].Failed) != 1 || events[0].Result != ResultPartial {
+		t.Errorf("Event = %+v, want sinks [email], failed [google_photos], result partial", events[0])
+	}
+	if events[1].Time.IsZero() {
+		t.Error("Event without a time should be stamped with the current time")
+	}
+
+	// Appends to an existing log rather than truncating it
+	logger, err = New(path, 0)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := logger.Record(Event{Hash: "ghi789", Result: ResultDelivered}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	logger.Close()
+	if events := readEvents(t, path); len(events) != 3 {
+		t.Errorf("Got %d events after reopening, want 3", len(events))
+	}
+
+	if err := logger.Record(Event{Hash: "jkl012"}); err == nil {
+		t.Error("Record() after Close() should fail")
+	}
+}
+
+func TestLogger_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	logger, err := New(path, 300)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer logger.Close()
+
+	const count = 10
+	for i := 0; i < count; i++ {
+		if err := logger.Record(Event{Hash: strings.Repeat("a", 64), Sinks: []string{"email"}, Result: ResultDelivered}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+	logger.Close()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", dir, err)
+	}
+	if len(entries) < 3 {
+		t.Fatalf("Got %d files, want the log rotated at least twice", len(entries))
+	}
+	total := 0
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "audit.log") {
+			t.Errorf("Unexpected file %s", entry.Name())
+		}
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", entry.Name(), err)
+		}
+		if info.Size() > 300 {
+			t.Errorf("%s is %d bytes, want at most 300", entry.Name(), info.Size())
+		}
+		// Rotated files are kept, so no event is lost
+		total += len(readEvents(t, filepath.Join(dir, entry.Name())))
+	}
+	if total != count {
+		t.Errorf("Got %d events across the rotated files, want %d", total, count)
+	}
+}
@@ -0,0 +1,24 @@
+package certs
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// LoadPool returns the system certificate pool with the certificates in the PEM file at path
+// added
+func LoadPool(path string) (*x509.CertPool, error) {
+	pemData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("no valid certificates found in CA certificate file %s", path)
+	}
+	return pool, nil
+}
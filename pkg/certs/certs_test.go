@@ -0,0 +1,41 @@
+package certs
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPool(t *testing.T) {
+	// Use the self-signed certificate of a test TLS server as the extra CA
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caPath, caPEM, 0644); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	pool, err := LoadPool(caPath)
+	if err != nil {
+		t.Fatalf("LoadPool() error = %v", err)
+	}
+	if _, err := server.Certificate().Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+		t.Errorf("certificate not trusted by the loaded pool: %v", err)
+	}
+
+	if _, err := LoadPool(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("LoadPool() expected error for a missing file")
+	}
+	invalidPath := filepath.Join(t.TempDir(), "invalid.pem")
+	if err := os.WriteFile(invalidPath, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	if _, err := LoadPool(invalidPath); err == nil {
+		t.Error("LoadPool() expected error for a file without certificates")
+	}
+}
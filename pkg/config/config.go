@@ -1,11 +1,25 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+	"github.com/jsteffee/icloud-photo-sync/pkg/scraper"
+	"github.com/jsteffee/icloud-photo-sync/pkg/storage"
 )
 
 // SMTPConfig holds SMTP configuration
@@ -15,35 +29,254 @@ type SMTPConfig struct {
 	Username string
 	Password string
 	From     string // Optional "From" email address (defaults to Username if not set)
+	FromName string // Optional display name for the From and Reply-To headers
+	// InsecureSkipVerify disables certificate verification (needed for e.g. ProtonMail Bridge's self-signed cert)
+	InsecureSkipVerify bool
+	CACertPath         string // Optional PEM file of additional CA certificates to trust
+	// TLSMode is starttls, mandatory-starttls, implicit-tls, or none
+	// Empty keeps the port-based default (mandatory STARTTLS on port 25, implicit TLS on 465, opportunistic otherwise)
+	TLSMode       string
+	Timeout       int    // Seconds allowed for connecting and for each SMTP command (0 = no timeout)
+	SubjectPrefix string // Optional text prepended to every email subject (e.g. "[Photos]")
+	// Backend is smtp, or sendgrid or mailgun to send through that provider's HTTP API instead,
+	// for networks that block outbound SMTP. The API backends send from From and use Timeout
+	// for each request
+	Backend       string
+	APIKey        string // API key for the sendgrid and mailgun backends
+	APIURL        string // Optional - overrides the API's base URL (e.g. Mailgun's EU region)
+	MailgunDomain string // Sending domain for the mailgun backend
 }
 
 // GooglePhotosConfig holds Google Photos API configuration
 type GooglePhotosConfig struct {
-	ClientID     string
-	ClientSecret string
-	RefreshToken string
-	AlbumName    string
+	ClientID      string
+	ClientSecret  string
+	RefreshToken  string
+	AlbumName     string
+	VerifyUploads bool   // Look up each created media item to confirm Google accepted it
+	SkipIfInAlbum bool   // Don't upload or add photos the album already holds (matched by file name or media item)
+	AlbumRotation string // none, monthly, or yearly: file photos in AlbumName albums suffixed with their capture month or year
+	CACertPath    string // Optional PEM file of additional CA certificates to trust (EXTRA_CA_CERT)
+}
+
+// S3Config holds S3-compatible object storage configuration
+type S3Config struct {
+	Bucket          string
+	Prefix          string // Optional key prefix (e.g. "photos/")
+	Region          string
+	Endpoint        string // Optional - custom endpoint for non-AWS storage such as MinIO
+	AccessKeyID     string
+	SecretAccessKey string
+	KeyFormat       string // hash (default: <prefix><hash>.<ext>) or date (<prefix>YYYY/MM/DD/<hash>.<ext>)
+	LinkExpiry      int    // Seconds presigned download links in emails stay valid
+}
+
+// ImmichConfig holds the Immich server photos are uploaded to
+type ImmichConfig struct {
+	URL    string // Server URL without a trailing slash (e.g. https://immich.example.com)
+	APIKey string
+}
+
+// TelegramConfig holds the Telegram bot and chat photos are posted to
+type TelegramConfig struct {
+	BotToken string
+	ChatID   string // Numeric chat ID (e.g. -1001234567890) or @channelusername
+	APIURL   string // Optional - Bot API server without a trailing slash (defaults to https://api.telegram.org)
+}
+
+// SlackConfig holds the Slack channel photos are posted to
+type SlackConfig struct {
+	BotToken  string // Bot token (xoxb-...) with the files:write scope
+	ChannelID string
+}
+
+// QuietHours is a daily window during which some notifiers (email by default) are paused
+// Their deliveries are deferred until the window ends; downloads and other notifiers continue
+type QuietHours struct {
+	Start     int // Minutes after midnight the window opens
+	End       int // Minutes after midnight the window closes (before Start if it spans midnight)
+	Location  *time.Location
+	Notifiers []string // Names of the paused notifiers
+}
+
+// Active reports whether t falls within the quiet hours
+func (q *QuietHours) Active(t time.Time) bool {
+	local := t.In(q.Location)
+	minute := local.Hour()*60 + local.Minute()
+	if q.Start < q.End {
+		return minute >= q.Start && minute < q.End
+	}
+	return minute >= q.Start || minute < q.End
+}
+
+// NextEnd returns the first time after t at which the quiet hours end
+func (q *QuietHours) NextEnd(t time.Time) time.Time {
+	local := t.In(q.Location)
+	end := time.Date(local.Year(), local.Month(), local.Day(), q.End/60, q.End%60, 0, 0, q.Location)
+	if !end.After(local) {
+		end = time.Date(local.Year(), local.Month(), local.Day()+1, q.End/60, q.End%60, 0, 0, q.Location)
+	}
+	return end
+}
+
+// Pauses reports whether the named notifier is paused during the quiet hours
+func (q *QuietHours) Pauses(notifier string) bool {
+	for _, name := range q.Notifiers {
+		if name == notifier {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the window as HH:MM-HH:MM with its time zone
+func (q *QuietHours) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d %s", q.Start/60, q.Start%60, q.End/60, q.End%60, q.Location)
 }
 
 // AlbumConfig represents the configuration file structure
 type AlbumConfig struct {
-	AlbumURLs []string `json:"album_urls"`
+	AlbumURLs []AlbumEntry `json:"album_urls"`
+}
+
+// AlbumEntry is one album in the configuration file: either a bare URL string or an object
+// such as {"url": "...", "enabled": false, "priority": 10, "destination": "frame@example.com"}
+type AlbumEntry struct {
+	URL         string `json:"url"`
+	Enabled     *bool  `json:"enabled"`     // Optional - defaults to true
+	Priority    int    `json:"priority"`    // Optional - albums with a higher priority are processed first (default 0)
+	Destination string `json:"destination"` // Optional - comma-separated recipient(s) of the album's emails (default SMTP_DESTINATION)
+}
+
+// IsEnabled reports whether the album should be synced
+func (e AlbumEntry) IsEnabled() bool {
+	return e.Enabled == nil || *e.Enabled
+}
+
+// UnmarshalJSON accepts both the bare URL string and the object form
+func (e *AlbumEntry) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*e = AlbumEntry{}
+		return json.Unmarshal(data, &e.URL)
+	}
+
+	// Decoded through an alias type so this method isn't called recursively, rejecting unknown
+	// keys like the rest of the file
+	type albumEntry AlbumEntry
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var entry albumEntry
+	if err := decoder.Decode(&entry); err != nil {
+		return err
+	}
+	*e = AlbumEntry(entry)
+	return nil
 }
 
 // Config holds all application configuration
 type Config struct {
-	AlbumURLs         []string
-	RedisURL          string
-	SMTPConfig        *SMTPConfig
-	SMTPDestination   string
-	GooglePhotosConfig *GooglePhotosConfig // Optional - nil if not configured
-	RunInterval       int
-	MaxItems          int
-	ImageDir          string
+	AlbumURLs               []string
+	DisabledAlbumURLs       []string // Albums in the config file with "enabled": false; never scraped
+	AlbumPriorities         []int    // Priority of each album in AlbumURLs; higher priorities are processed first
+	AlbumDestinations       []string // Email recipient(s) of each album in AlbumURLs; empty for SMTP_DESTINATION
+	RedisURL                string
+	RedisPassword           string // Optional - overrides any password in RedisURL
+	RedisReplicaURL         string // Optional read replica for delivery checks; writes always go to RedisURL
+	RedisMaxRetries         int    // Retries per Redis command on network errors (0 = driver default of 3, -1 disables)
+	RedisPoolSize           int    // Redis connection pool size (0 = driver default)
+	RedisDialTimeout        int    // Seconds allowed for connecting to Redis (0 = driver default of 5)
+	RedisReadTimeout        int    // Seconds allowed for reading each Redis reply (0 = driver default of 3)
+	RedisWriteTimeout       int    // Seconds allowed for writing each Redis command (0 = driver default of 3)
+	HashCacheSize           int    // In-process LRU cache of delivered hashes in front of Redis (0 disables)
+	RedisKeyPrefix          string // Namespace for Redis keys, so deployments can share an instance (empty = default)
+	SMTPConfig              *SMTPConfig
+	SMTPDestination         string
+	EmailZip                bool                // Email new photos as zip archive(s) at the end of each run instead of one email per photo
+	EmailZipMaxBytes        int64               // Maximum image bytes per zip; larger batches are split across several zips
+	EmailZipPartialSend     bool                // Send the rest of a zip when one of its images can't be attached or sent (default true)
+	EmailContactSheet       string              // off (default), also (email a contact sheet of each run's photos too), or only (instead of one email per photo)
+	ContactSheetColumns     int                 // Thumbnails per row of a contact sheet
+	ContactSheetThumbSize   int                 // Longest side in pixels of each contact sheet thumbnail
+	EmailAttachment         string              // original (default), medium (attach a scaled copy and link to the original), or thumbnail (attach only a small preview)
+	EmailMediumSize         int                 // Longest side in pixels of the medium copy
+	EmailThumbnailSize      int                 // Longest side in pixels of the thumbnail
+	EmailDerivative         string              // original (default) or medium: email a smaller iCloud size when one is offered
+	AlertDestination        string              // Optional - operator address for alerts such as revoked Google Photos tokens
+	GooglePhotosConfig      *GooglePhotosConfig // Optional - nil if not configured
+	S3Config                *S3Config           // Optional - nil if S3_BUCKET is not set
+	ImmichConfig            *ImmichConfig       // Optional - nil if IMMICH_URL is not set
+	TelegramConfig          *TelegramConfig     // Optional - nil if TELEGRAM_BOT_TOKEN is not set
+	SlackConfig             *SlackConfig        // Optional - nil if SLACK_BOT_TOKEN is not set
+	WebhookURL              string              // Optional - URL to POST a JSON notification to for each new photo
+	ArchiveDir              string              // Optional - directory to copy each new photo into
+	ArchiveBaseURL          string              // Optional - URL ArchiveDir is served at, for links to originals
+	ArchiveNameTemplate     string              // Optional - text/template naming archived files and S3 objects
+	PostHook                string              // Optional - executable run for each new photo
+	PostHookTimeout         int                 // Seconds before the post hook is killed (0 = no timeout)
+	RunInterval             int
+	RetryInterval           int         // Seconds before retrying after a run fails outright, doubling up to RunInterval (0 disables)
+	MaxRunDuration          int         // Seconds after which a run stops taking new images (0 = no limit)
+	RunProgressTTL          int         // Seconds an interrupted run's progress is kept for the next run to resume from (0 = not kept)
+	MinPhotoAge             int         // Seconds a photo must have been in its album before it's synced (0 = no minimum)
+	ScraperTimeout          int         // Seconds to wait for the iCloud API per album before giving up (0 = no timeout)
+	ScraperRetries          int         // Times an album fetch that failed with a transient error is retried (0 = no retries)
+	ScrapeCacheTTL          int         // Seconds an album's photo listing is reused before fetching it again (0 = every run)
+	ICloudHeader            http.Header // Optional - sent with album requests to iCloud (ICLOUD_HEADERS, and ICLOUD_COOKIE as Cookie)
+	DownloadTimeout         int         // Seconds allowed per image download (0 = no timeout)
+	DownloadTimeoutPerMB    int         // Extra seconds allowed per megabyte of a download's Content-Length
+	MaxConnsPerHost         int         // Connections open at once to each download host (0 = no limit)
+	MaxIdleConnsPerHost     int         // Connections kept open per download host for reuse
+	MaxDownloadsPerHost     int         // Downloads in flight at once to each download host (0 = no limit)
+	MaxDownloadBytesPerSec  int64       // Combined download rate in bytes per second across all downloads (0 = no limit)
+	MinImageWidth           int         // Skip downloaded images narrower than this many pixels (0 = no minimum)
+	MinImageHeight          int         // Skip downloaded images shorter than this many pixels (0 = no minimum)
+	ExtraCACert             string      // Optional PEM file of additional CA certificates to trust for downloads and Google Photos
+	AlbumValidation         string      // Startup album check: strict (exit on unreachable album), warn (default), or off
+	RunOnce                 bool        // Run a single sync and exit instead of looping
+	StatusAddr              string      // Optional - address to serve the JSON sync status and a liveness probe on
+	SyncLock                bool        // Take a Redis lock for each run so only one instance syncs at a time
+	SyncLockTTL             int         // Seconds the sync lock outlives an instance that stopped renewing it
+	MaxItems                int
+	MaxItemsPerAlbum        int         // Maximum new items per album per run (0 = no per-album cap)
+	MaxAlbumsPerRun         int         // Albums scraped per run, rotating through them across runs (0 = all)
+	BackfillMaxItems        int         // Maximum new items per run from albums that have never been synced (0 = use MaxItems)
+	InitialSyncMode         string      // notify (default) or mark-seen-only: record a new album's existing photos without delivering them
+	WelcomeEmail            bool        // Email an introduction with a cover photo the first time an album is synced
+	ProcessOrder            string      // Order photos are processed in within each album: album (default), newest, or oldest
+	DownloadConcurrency     int         // Images downloaded and hashed at once
+	EmailConcurrency        int         // Emails sent at once, across every email stage
+	GooglePhotosConcurrency int         // Google Photos uploads at once
+	MaxFailures             int         // Consecutive failures before an image is quarantined (0 disables)
+	EmailMaxAttempts        int         // Failed email attempts before an image's email is dead-lettered (0 = retry forever)
+	ResetQuarantine         bool        // Clear all failure counts and dead-lettered images on startup
+	DeleteAfterUpload       bool        // Delete local files once every enabled destination has them
+	RedownloadMissingFiles  bool        // Download a tracked image again when its file is gone but a destination still needs it (default true)
+	QuietHours              *QuietHours // Optional - nil if QUIET_HOURS is not set
+	ImageDir                string
+	ImageLayout             string        // flat (default), hash, album, or album-hash
+	ImageDirMode            os.FileMode   // Permissions of directories created under ImageDir (0 = 0755 less the umask)
+	ImageFileMode           os.FileMode   // Permissions of stored images (0 = 0600)
+	HashAlgorithm           string        // sha256 (default), sha1, blake3, or xxhash
+	HashContent             string        // What is hashed: file (default) or pixels, which ignores image metadata
+	NormalizeOrientation    bool          // Rotate downloaded JPEGs upright by their EXIF orientation
+	StripGPS                bool          // Clear the location from downloaded JPEGs before they're delivered
+	StripAllEXIF            bool          // Remove all EXIF, XMP and IPTC metadata from downloaded JPEGs
+	VerifyExistingFiles     string        // off (default), log, or quarantine: check stored images against their hashes at startup
+	DedupKey                string        // What identifies an already-delivered photo: hash (default), guid, or both
+	LogLevel                logging.Level // Minimum severity logged: debug, info (default), warn, or error
+	AuditLog                string        // Optional - file to append a JSON line to for every image synced
+	AuditLogMaxBytes        int64         // Size at which the audit log is rotated (0 = never rotate)
+	AllowedTypes            []string      // Optional - only sync these MIME types / type families (e.g. image/jpeg, video/*)
+	BlockedTypes            []string      // Optional - never sync these MIME types / type families
 }
 
 // Load loads configuration from environment variables and config file
 func Load() (*Config, error) {
+	// Variables from a .env file fill in any that aren't set in the environment
+	if err := loadEnvFile(); err != nil {
+		return nil, err
+	}
+
 	cfg := &Config{}
 
 	// Get image directory (default: /images)
@@ -53,58 +286,360 @@ func Load() (*Config, error) {
 	}
 	cfg.ImageDir = imageDir
 
-	// Load album URLs from config file
+	// Optional image directory layout (default: flat)
+	cfg.ImageLayout = os.Getenv("IMAGE_LAYOUT")
+	switch cfg.ImageLayout {
+	case "":
+		cfg.ImageLayout = "flat"
+	case "flat", "hash", "album", "album-hash":
+	default:
+		return nil, fmt.Errorf("IMAGE_LAYOUT must be one of flat, hash, album, album-hash: got %q", cfg.ImageLayout)
+	}
+
+	// Optional permissions for what's created in the image directory, as octal (e.g. 0750)
+	imageDirMode, err := parseFileMode("IMAGE_DIR_MODE")
+	if err != nil {
+		return nil, err
+	}
+	cfg.ImageDirMode = imageDirMode
+	imageFileMode, err := parseFileMode("IMAGE_FILE_MODE")
+	if err != nil {
+		return nil, err
+	}
+	cfg.ImageFileMode = imageFileMode
+
+	// Optional hash algorithm (default: sha256)
+	// Changing this invalidates existing Redis tracking keys since the hash space changes
+	cfg.HashAlgorithm = os.Getenv("HASH_ALGO")
+	switch cfg.HashAlgorithm {
+	case "":
+		cfg.HashAlgorithm = "sha256"
+	case "sha256", "sha1", "blake3", "xxhash":
+	default:
+		return nil, fmt.Errorf("HASH_ALGO must be one of sha256, sha1, blake3, xxhash: got %q", cfg.HashAlgorithm)
+	}
+
+	// Optional hash content (default: file). pixels hashes decoded JPEG/PNG pixels so
+	// metadata-only edits don't change the hash; like HASH_ALGO, changing it changes the hashes
+	cfg.HashContent = os.Getenv("HASH_CONTENT")
+	switch cfg.HashContent {
+	case "":
+		cfg.HashContent = "file"
+	case "file", "pixels":
+	default:
+		return nil, fmt.Errorf("HASH_CONTENT must be one of file, pixels: got %q", cfg.HashContent)
+	}
+
+	if v := os.Getenv("NORMALIZE_ORIENTATION"); v != "" {
+		normalizeOrientation, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("NORMALIZE_ORIENTATION must be a valid boolean: %v", err)
+		}
+		cfg.NormalizeOrientation = normalizeOrientation
+	}
+
+	if v := os.Getenv("STRIP_GPS"); v != "" {
+		stripGPS, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("STRIP_GPS must be a valid boolean: %v", err)
+		}
+		cfg.StripGPS = stripGPS
+	}
+
+	if v := os.Getenv("STRIP_ALL_EXIF"); v != "" {
+		stripAllEXIF, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("STRIP_ALL_EXIF must be a valid boolean: %v", err)
+		}
+		cfg.StripAllEXIF = stripAllEXIF
+	}
+
+	cfg.VerifyExistingFiles = os.Getenv("VERIFY_EXISTING_FILES")
+	switch cfg.VerifyExistingFiles {
+	case "":
+		cfg.VerifyExistingFiles = "off"
+	case "off", "log", "quarantine":
+	default:
+		return nil, fmt.Errorf("VERIFY_EXISTING_FILES must be one of off, log, quarantine: got %q", cfg.VerifyExistingFiles)
+	}
+	// Rotated or stripped photos are rewritten after they're hashed, so they'd all look corrupt
+	if cfg.VerifyExistingFiles != "off" && cfg.NormalizeOrientation {
+		return nil, fmt.Errorf("VERIFY_EXISTING_FILES can't be used with NORMALIZE_ORIENTATION, which rewrites photos after they're hashed")
+	}
+	if cfg.VerifyExistingFiles != "off" && (cfg.StripGPS || cfg.StripAllEXIF) {
+		return nil, fmt.Errorf("VERIFY_EXISTING_FILES can't be used with STRIP_GPS or STRIP_ALL_EXIF, which rewrite photos after they're hashed")
+	}
+
+	// Optional file type filters, as extensions or MIME types (comma-separated)
+	allowedTypes, err := parseMediaTypes("ALLOWED_TYPES")
+	if err != nil {
+		return nil, err
+	}
+	cfg.AllowedTypes = allowedTypes
+	blockedTypes, err := parseMediaTypes("BLOCKED_TYPES")
+	if err != nil {
+		return nil, err
+	}
+	cfg.BlockedTypes = blockedTypes
+
+	// Load album URLs from the config file and/or ALBUM_URLS (comma- or newline-separated)
+	// Both sources are merged, file URLs first, with duplicates removed
+	// The config file is optional when ALBUM_URLS is set
+	envAlbumURLs := splitAlbumURLs(os.Getenv("ALBUM_URLS"))
+	for _, albumURL := range envAlbumURLs {
+		if err := scraper.ValidateAlbumURL(albumURL); err != nil {
+			return nil, fmt.Errorf("ALBUM_URLS entry %q: %w", albumURL, err)
+		}
+	}
 	configPath := filepath.Join(imageDir, "config.json")
 	albumConfig, err := loadAlbumConfig(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load album config from %s: %w", configPath, err)
+		if len(envAlbumURLs) == 0 || !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to load album config from %s: %w", configPath, err)
+		}
+		albumConfig = &AlbumConfig{}
+	}
+	// Disabled albums are left out entirely; their tracking state in Redis is kept for when
+	// they're enabled again. Disabling an album in the file also overrides ALBUM_URLS
+	var fileAlbumURLs []string
+	disabled := make(map[string]bool)
+	priorities := make(map[string]int) // Albums only in ALBUM_URLS get the default priority of 0
+	destinations := make(map[string]string)
+	for _, entry := range albumConfig.AlbumURLs {
+		if entry.IsEnabled() {
+			if _, ok := priorities[entry.URL]; !ok {
+				priorities[entry.URL] = entry.Priority
+				destinations[entry.URL] = entry.Destination
+			}
+			fileAlbumURLs = append(fileAlbumURLs, entry.URL)
+		} else if !disabled[entry.URL] {
+			disabled[entry.URL] = true
+			cfg.DisabledAlbumURLs = append(cfg.DisabledAlbumURLs, entry.URL)
+		}
+	}
+	for _, albumURL := range mergeAlbumURLs(fileAlbumURLs, envAlbumURLs) {
+		if !disabled[albumURL] {
+			cfg.AlbumURLs = append(cfg.AlbumURLs, albumURL)
+			cfg.AlbumPriorities = append(cfg.AlbumPriorities, priorities[albumURL])
+			cfg.AlbumDestinations = append(cfg.AlbumDestinations, destinations[albumURL])
+		}
 	}
-	if len(albumConfig.AlbumURLs) == 0 {
-		return nil, fmt.Errorf("no album URLs found in config file at %s", configPath)
+	if len(cfg.AlbumURLs) == 0 {
+		if len(cfg.DisabledAlbumURLs) > 0 {
+			return nil, fmt.Errorf("all %d albums in %s are disabled", len(cfg.DisabledAlbumURLs), configPath)
+		}
+		return nil, fmt.Errorf("no album URLs found in config file at %s or ALBUM_URLS", configPath)
 	}
-	cfg.AlbumURLs = albumConfig.AlbumURLs
 
 	cfg.RedisURL = os.Getenv("REDIS_URL")
 	if cfg.RedisURL == "" {
 		return nil, fmt.Errorf("REDIS_URL is required")
 	}
 
+	redisPassword, err := getSecret("REDIS_PASSWORD")
+	if err != nil {
+		return nil, err
+	}
+	cfg.RedisPassword = redisPassword
+
+	// Optional read replica; checked like REDIS_URL when the client connects
+	cfg.RedisReplicaURL = os.Getenv("REDIS_REPLICA_URL")
+
+	// Optional Redis driver tuning so transient connection drops are retried
+	redisMaxRetriesStr := os.Getenv("REDIS_MAX_RETRIES")
+	if redisMaxRetriesStr != "" {
+		redisMaxRetries, err := strconv.Atoi(redisMaxRetriesStr)
+		if err != nil {
+			return nil, fmt.Errorf("REDIS_MAX_RETRIES must be a valid integer: %v", err)
+		}
+		if redisMaxRetries < -1 {
+			return nil, fmt.Errorf("REDIS_MAX_RETRIES must be -1 (disable retries) or greater")
+		}
+		cfg.RedisMaxRetries = redisMaxRetries
+	}
+
+	redisPoolSizeStr := os.Getenv("REDIS_POOL_SIZE")
+	if redisPoolSizeStr != "" {
+		redisPoolSize, err := strconv.Atoi(redisPoolSizeStr)
+		if err != nil {
+			return nil, fmt.Errorf("REDIS_POOL_SIZE must be a valid integer: %v", err)
+		}
+		if redisPoolSize < 0 {
+			return nil, fmt.Errorf("REDIS_POOL_SIZE must not be negative")
+		}
+		cfg.RedisPoolSize = redisPoolSize
+	}
+
+	redisDialTimeoutStr := os.Getenv("REDIS_DIAL_TIMEOUT")
+	if redisDialTimeoutStr != "" {
+		redisDialTimeout, err := strconv.Atoi(redisDialTimeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("REDIS_DIAL_TIMEOUT must be a valid integer: %v", err)
+		}
+		if redisDialTimeout < 0 {
+			return nil, fmt.Errorf("REDIS_DIAL_TIMEOUT must not be negative")
+		}
+		cfg.RedisDialTimeout = redisDialTimeout
+	}
+
+	redisReadTimeoutStr := os.Getenv("REDIS_READ_TIMEOUT")
+	if redisReadTimeoutStr != "" {
+		redisReadTimeout, err := strconv.Atoi(redisReadTimeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("REDIS_READ_TIMEOUT must be a valid integer: %v", err)
+		}
+		if redisReadTimeout < 0 {
+			return nil, fmt.Errorf("REDIS_READ_TIMEOUT must not be negative")
+		}
+		cfg.RedisReadTimeout = redisReadTimeout
+	}
+
+	redisWriteTimeoutStr := os.Getenv("REDIS_WRITE_TIMEOUT")
+	if redisWriteTimeoutStr != "" {
+		redisWriteTimeout, err := strconv.Atoi(redisWriteTimeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("REDIS_WRITE_TIMEOUT must be a valid integer: %v", err)
+		}
+		if redisWriteTimeout < 0 {
+			return nil, fmt.Errorf("REDIS_WRITE_TIMEOUT must not be negative")
+		}
+		cfg.RedisWriteTimeout = redisWriteTimeout
+	}
+
+	hashCacheSizeStr := os.Getenv("HASH_CACHE_SIZE")
+	if hashCacheSizeStr != "" {
+		hashCacheSize, err := strconv.Atoi(hashCacheSizeStr)
+		if err != nil {
+			return nil, fmt.Errorf("HASH_CACHE_SIZE must be a valid integer: %v", err)
+		}
+		if hashCacheSize < 0 {
+			return nil, fmt.Errorf("HASH_CACHE_SIZE must not be negative")
+		}
+		cfg.HashCacheSize = hashCacheSize
+	}
+
+	// Optional Redis key namespace; a trailing ":" is dropped since one is added when building keys
+	cfg.RedisKeyPrefix = strings.TrimSuffix(os.Getenv("REDIS_KEY_PREFIX"), ":")
+
+	// Optional email backend (default: smtp). The API backends don't need the SMTP server settings
+	emailBackend := os.Getenv("EMAIL_BACKEND")
+	switch emailBackend {
+	case "":
+		emailBackend = "smtp"
+	case "smtp", "sendgrid", "mailgun":
+	default:
+		return nil, fmt.Errorf("EMAIL_BACKEND must be one of smtp, sendgrid, mailgun: got %q", emailBackend)
+	}
+	useSMTP := emailBackend == "smtp"
+
 	smtpServer := os.Getenv("SMTP_SERVER")
-	if smtpServer == "" {
+	if smtpServer == "" && useSMTP {
 		return nil, fmt.Errorf("SMTP_SERVER is required")
 	}
 
 	smtpPortStr := os.Getenv("SMTP_PORT")
-	if smtpPortStr == "" {
+	if smtpPortStr == "" && useSMTP {
 		return nil, fmt.Errorf("SMTP_PORT is required")
 	}
-	smtpPort, err := strconv.Atoi(smtpPortStr)
-	if err != nil {
-		return nil, fmt.Errorf("SMTP_PORT must be a valid integer: %v", err)
+	smtpPort := 0
+	if smtpPortStr != "" {
+		smtpPort, err = strconv.Atoi(smtpPortStr)
+		if err != nil {
+			return nil, fmt.Errorf("SMTP_PORT must be a valid integer: %v", err)
+		}
 	}
 
 	smtpUsername := os.Getenv("SMTP_USERNAME")
-	if smtpUsername == "" {
+	if smtpUsername == "" && useSMTP {
 		return nil, fmt.Errorf("SMTP_USERNAME is required")
 	}
 
-	smtpPassword := os.Getenv("SMTP_PASSWORD")
-	if smtpPassword == "" {
+	smtpPassword, err := getSecret("SMTP_PASSWORD")
+	if err != nil {
+		return nil, err
+	}
+	if smtpPassword == "" && useSMTP {
 		return nil, fmt.Errorf("SMTP_PASSWORD is required")
 	}
 
-	// Optional SMTP_FROM environment variable
+	// Optional SMTP_FROM environment variable, either an address or "Name <address>"
 	smtpFrom := os.Getenv("SMTP_FROM")
+	if smtpFrom == "" && !useSMTP {
+		return nil, fmt.Errorf("SMTP_FROM is required when EMAIL_BACKEND is %s", emailBackend)
+	}
+	var smtpFromName string
 	if smtpFrom == "" {
 		smtpFrom = smtpUsername // Default to username if not specified
+	} else {
+		fromAddress, err := mail.ParseAddress(smtpFrom)
+		if err != nil {
+			return nil, fmt.Errorf("SMTP_FROM must be an email address or \"Name <address>\": %v", err)
+		}
+		smtpFrom = fromAddress.Address
+		smtpFromName = fromAddress.Name
+	}
+
+	emailAPIKey, err := getSecret("EMAIL_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if emailAPIKey == "" && !useSMTP {
+		return nil, fmt.Errorf("EMAIL_API_KEY is required when EMAIL_BACKEND is %s", emailBackend)
+	}
+	emailAPIURL := strings.TrimSuffix(os.Getenv("EMAIL_API_URL"), "/")
+	if emailAPIURL != "" {
+		u, err := url.Parse(emailAPIURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("EMAIL_API_URL must be an http or https URL")
+		}
+	}
+	mailgunDomain := os.Getenv("MAILGUN_DOMAIN")
+	if mailgunDomain == "" && emailBackend == "mailgun" {
+		return nil, fmt.Errorf("MAILGUN_DOMAIN is required when EMAIL_BACKEND is mailgun")
+	}
+
+	smtpInsecureSkipVerify := false
+	if v := os.Getenv("SMTP_INSECURE_SKIP_VERIFY"); v != "" {
+		smtpInsecureSkipVerify, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("SMTP_INSECURE_SKIP_VERIFY must be a valid boolean: %v", err)
+		}
+	}
+
+	smtpTLSMode := os.Getenv("SMTP_TLS_MODE")
+	switch smtpTLSMode {
+	case "", "starttls", "mandatory-starttls", "implicit-tls", "none":
+	default:
+		return nil, fmt.Errorf("SMTP_TLS_MODE must be one of starttls, mandatory-starttls, implicit-tls, none: got %q", smtpTLSMode)
+	}
+
+	smtpTimeout := 30 // Default: 30 seconds
+	if v := os.Getenv("SMTP_TIMEOUT"); v != "" {
+		smtpTimeout, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("SMTP_TIMEOUT must be a valid integer: %v", err)
+		}
+		if smtpTimeout < 0 {
+			return nil, fmt.Errorf("SMTP_TIMEOUT must not be negative")
+		}
 	}
 
 	cfg.SMTPConfig = &SMTPConfig{
-		Server:   smtpServer,
-		Port:     smtpPort,
-		Username: smtpUsername,
-		Password: smtpPassword,
-		From:     smtpFrom,
+		Server:             smtpServer,
+		Port:               smtpPort,
+		Username:           smtpUsername,
+		Password:           smtpPassword,
+		From:               smtpFrom,
+		FromName:           smtpFromName,
+		InsecureSkipVerify: smtpInsecureSkipVerify,
+		CACertPath:         os.Getenv("SMTP_CA_CERT"),
+		TLSMode:            smtpTLSMode,
+		Timeout:            smtpTimeout,
+		SubjectPrefix:      strings.TrimSpace(os.Getenv("EMAIL_SUBJECT_PREFIX")),
+		Backend:            emailBackend,
+		APIKey:             emailAPIKey,
+		APIURL:             emailAPIURL,
+		MailgunDomain:      mailgunDomain,
 	}
 
 	cfg.SMTPDestination = os.Getenv("SMTP_DESTINATION")
@@ -112,6 +647,171 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("SMTP_DESTINATION is required")
 	}
 
+	emailZipStr := os.Getenv("EMAIL_ZIP")
+	if emailZipStr != "" {
+		emailZip, err := strconv.ParseBool(emailZipStr)
+		if err != nil {
+			return nil, fmt.Errorf("EMAIL_ZIP must be a valid boolean: %v", err)
+		}
+		cfg.EmailZip = emailZip
+	}
+
+	emailZipMaxMBStr := os.Getenv("EMAIL_ZIP_MAX_MB")
+	if emailZipMaxMBStr == "" {
+		cfg.EmailZipMaxBytes = 20 * 1024 * 1024 // Default: 20 MB, under most providers' attachment limits
+	} else {
+		emailZipMaxMB, err := strconv.Atoi(emailZipMaxMBStr)
+		if err != nil {
+			return nil, fmt.Errorf("EMAIL_ZIP_MAX_MB must be a valid integer: %v", err)
+		}
+		if emailZipMaxMB <= 0 {
+			return nil, fmt.Errorf("EMAIL_ZIP_MAX_MB must be positive")
+		}
+		cfg.EmailZipMaxBytes = int64(emailZipMaxMB) * 1024 * 1024
+	}
+
+	cfg.EmailZipPartialSend = true
+	if partialSendStr := os.Getenv("EMAIL_ZIP_PARTIAL_SEND"); partialSendStr != "" {
+		partialSend, err := strconv.ParseBool(partialSendStr)
+		if err != nil {
+			return nil, fmt.Errorf("EMAIL_ZIP_PARTIAL_SEND must be a valid boolean: %v", err)
+		}
+		cfg.EmailZipPartialSend = partialSend
+	}
+
+	cfg.EmailContactSheet = os.Getenv("EMAIL_CONTACT_SHEET")
+	switch cfg.EmailContactSheet {
+	case "":
+		cfg.EmailContactSheet = "off"
+	case "off", "also", "only":
+	default:
+		return nil, fmt.Errorf("EMAIL_CONTACT_SHEET must be one of off, also, only: got %q", cfg.EmailContactSheet)
+	}
+	if cfg.EmailContactSheet == "only" && cfg.EmailZip {
+		return nil, fmt.Errorf("EMAIL_CONTACT_SHEET=only can't be combined with EMAIL_ZIP")
+	}
+
+	contactSheetColumnsStr := os.Getenv("CONTACT_SHEET_COLUMNS")
+	if contactSheetColumnsStr == "" {
+		cfg.ContactSheetColumns = 4
+	} else {
+		contactSheetColumns, err := strconv.Atoi(contactSheetColumnsStr)
+		if err != nil {
+			return nil, fmt.Errorf("CONTACT_SHEET_COLUMNS must be a valid integer: %v", err)
+		}
+		if contactSheetColumns < 1 {
+			return nil, fmt.Errorf("CONTACT_SHEET_COLUMNS must be at least 1")
+		}
+		cfg.ContactSheetColumns = contactSheetColumns
+	}
+
+	contactSheetThumbSizeStr := os.Getenv("CONTACT_SHEET_THUMB_SIZE")
+	if contactSheetThumbSizeStr == "" {
+		cfg.ContactSheetThumbSize = 256
+	} else {
+		contactSheetThumbSize, err := strconv.Atoi(contactSheetThumbSizeStr)
+		if err != nil {
+			return nil, fmt.Errorf("CONTACT_SHEET_THUMB_SIZE must be a valid integer: %v", err)
+		}
+		if contactSheetThumbSize < 1 {
+			return nil, fmt.Errorf("CONTACT_SHEET_THUMB_SIZE must be at least 1")
+		}
+		cfg.ContactSheetThumbSize = contactSheetThumbSize
+	}
+
+	cfg.EmailAttachment = os.Getenv("EMAIL_ATTACHMENT")
+	switch cfg.EmailAttachment {
+	case "":
+		cfg.EmailAttachment = "original"
+	case "original", "medium", "thumbnail":
+	default:
+		return nil, fmt.Errorf("EMAIL_ATTACHMENT must be one of original, medium, thumbnail: got %q", cfg.EmailAttachment)
+	}
+	if cfg.EmailAttachment != "original" && cfg.EmailZip {
+		return nil, fmt.Errorf("EMAIL_ATTACHMENT=%s can't be combined with EMAIL_ZIP", cfg.EmailAttachment)
+	}
+	if cfg.EmailAttachment != "original" && cfg.EmailContactSheet == "only" {
+		return nil, fmt.Errorf("EMAIL_ATTACHMENT=%s can't be combined with EMAIL_CONTACT_SHEET=only", cfg.EmailAttachment)
+	}
+
+	emailMediumSizeStr := os.Getenv("EMAIL_MEDIUM_SIZE")
+	if emailMediumSizeStr == "" {
+		cfg.EmailMediumSize = 1280 // Default: a few hundred KB as JPEG
+	} else {
+		emailMediumSize, err := strconv.Atoi(emailMediumSizeStr)
+		if err != nil {
+			return nil, fmt.Errorf("EMAIL_MEDIUM_SIZE must be a valid integer: %v", err)
+		}
+		if emailMediumSize < 1 {
+			return nil, fmt.Errorf("EMAIL_MEDIUM_SIZE must be at least 1")
+		}
+		cfg.EmailMediumSize = emailMediumSize
+	}
+
+	emailThumbnailSizeStr := os.Getenv("EMAIL_THUMBNAIL_SIZE")
+	if emailThumbnailSizeStr == "" {
+		cfg.EmailThumbnailSize = 320 // Default: a few tens of KB as JPEG
+	} else {
+		emailThumbnailSize, err := strconv.Atoi(emailThumbnailSizeStr)
+		if err != nil {
+			return nil, fmt.Errorf("EMAIL_THUMBNAIL_SIZE must be a valid integer: %v", err)
+		}
+		if emailThumbnailSize < 1 {
+			return nil, fmt.Errorf("EMAIL_THUMBNAIL_SIZE must be at least 1")
+		}
+		cfg.EmailThumbnailSize = emailThumbnailSize
+	}
+
+	cfg.EmailDerivative = os.Getenv("EMAIL_DERIVATIVE")
+	switch cfg.EmailDerivative {
+	case "":
+		cfg.EmailDerivative = "original"
+	case "original", "medium":
+	default:
+		return nil, fmt.Errorf("EMAIL_DERIVATIVE must be one of original, medium: got %q", cfg.EmailDerivative)
+	}
+
+	// Optional operator alert address (empty disables alert emails)
+	cfg.AlertDestination = os.Getenv("ALERT_EMAIL")
+
+	// Optional extra notification sinks (empty disables them)
+	cfg.WebhookURL = os.Getenv("WEBHOOK_URL")
+	if cfg.WebhookURL != "" {
+		u, err := url.Parse(cfg.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("WEBHOOK_URL must be an http or https URL")
+		}
+	}
+	cfg.ArchiveDir = os.Getenv("ARCHIVE_DIR")
+	cfg.ArchiveBaseURL = os.Getenv("ARCHIVE_BASE_URL")
+	if cfg.ArchiveBaseURL != "" {
+		u, err := url.Parse(cfg.ArchiveBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("ARCHIVE_BASE_URL must be an http or https URL")
+		}
+	}
+	cfg.ArchiveNameTemplate = os.Getenv("ARCHIVE_NAME_TEMPLATE")
+	if cfg.ArchiveNameTemplate != "" {
+		if _, err := template.New("ARCHIVE_NAME_TEMPLATE").Parse(cfg.ArchiveNameTemplate); err != nil {
+			return nil, fmt.Errorf("ARCHIVE_NAME_TEMPLATE must be a valid template: %v", err)
+		}
+	}
+
+	cfg.PostHook = os.Getenv("POST_HOOK")
+	postHookTimeoutStr := os.Getenv("POST_HOOK_TIMEOUT")
+	if postHookTimeoutStr == "" {
+		cfg.PostHookTimeout = 60 // Default: 1 minute
+	} else {
+		postHookTimeout, err := strconv.Atoi(postHookTimeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("POST_HOOK_TIMEOUT must be a valid integer: %v", err)
+		}
+		if postHookTimeout < 0 {
+			return nil, fmt.Errorf("POST_HOOK_TIMEOUT must not be negative")
+		}
+		cfg.PostHookTimeout = postHookTimeout
+	}
+
 	// Optional variables with defaults
 	runIntervalStr := os.Getenv("RUN_INTERVAL")
 	if runIntervalStr == "" {
@@ -124,60 +824,1034 @@ func Load() (*Config, error) {
 		cfg.RunInterval = runInterval
 	}
 
-	maxItemsStr := os.Getenv("MAX_ITEMS")
-	if maxItemsStr == "" {
-		cfg.MaxItems = 5 // Default: 5 items
+	retryIntervalStr := os.Getenv("RETRY_INTERVAL")
+	if retryIntervalStr == "" {
+		cfg.RetryInterval = 60 // Default: 1 minute
 	} else {
-		maxItems, err := strconv.Atoi(maxItemsStr)
+		retryInterval, err := strconv.Atoi(retryIntervalStr)
 		if err != nil {
-			return nil, fmt.Errorf("MAX_ITEMS must be a valid integer: %v", err)
+			return nil, fmt.Errorf("RETRY_INTERVAL must be a valid integer: %v", err)
 		}
-		cfg.MaxItems = maxItems
+		if retryInterval < 0 {
+			return nil, fmt.Errorf("RETRY_INTERVAL must not be negative")
+		}
+		cfg.RetryInterval = retryInterval
 	}
 
-	// Google Photos configuration (optional - only enabled if all vars are provided)
-	googlePhotosClientID := os.Getenv("GOOGLE_PHOTOS_CLIENT_ID")
-	googlePhotosClientSecret := os.Getenv("GOOGLE_PHOTOS_CLIENT_SECRET")
-	googlePhotosRefreshToken := os.Getenv("GOOGLE_PHOTOS_REFRESH_TOKEN")
-	googlePhotosAlbumName := os.Getenv("GOOGLE_PHOTOS_ALBUM_NAME") // Optional - empty means upload to library only (for partner sharing)
-
-	// If any Google Photos env var is set, ClientID, ClientSecret, and RefreshToken must all be set
-	// AlbumName is optional - if not provided, photos will be uploaded to library only
-	if googlePhotosClientID != "" || googlePhotosClientSecret != "" || googlePhotosRefreshToken != "" {
-		if googlePhotosClientID == "" {
-			return nil, fmt.Errorf("GOOGLE_PHOTOS_CLIENT_ID is required when Google Photos is enabled")
-		}
-		if googlePhotosClientSecret == "" {
-			return nil, fmt.Errorf("GOOGLE_PHOTOS_CLIENT_SECRET is required when Google Photos is enabled")
+	if maxRunDurationStr := os.Getenv("MAX_RUN_DURATION"); maxRunDurationStr != "" {
+		maxRunDuration, err := strconv.Atoi(maxRunDurationStr)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_RUN_DURATION must be a valid integer: %v", err)
 		}
-		if googlePhotosRefreshToken == "" {
-			return nil, fmt.Errorf("GOOGLE_PHOTOS_REFRESH_TOKEN is required when Google Photos is enabled")
+		if maxRunDuration < 0 {
+			return nil, fmt.Errorf("MAX_RUN_DURATION must not be negative")
 		}
-		// AlbumName is optional - empty string means upload to library only (for partner sharing)
+		cfg.MaxRunDuration = maxRunDuration
+	}
 
-		cfg.GooglePhotosConfig = &GooglePhotosConfig{
-			ClientID:     googlePhotosClientID,
-			ClientSecret: googlePhotosClientSecret,
-			RefreshToken: googlePhotosRefreshToken,
-			AlbumName:    googlePhotosAlbumName, // Empty string = upload to library only
+	if runProgressTTLStr := os.Getenv("RUN_PROGRESS_TTL"); runProgressTTLStr != "" {
+		runProgressTTL, err := strconv.Atoi(runProgressTTLStr)
+		if err != nil {
+			return nil, fmt.Errorf("RUN_PROGRESS_TTL must be a valid integer: %v", err)
+		}
+		if runProgressTTL < 0 {
+			return nil, fmt.Errorf("RUN_PROGRESS_TTL must not be negative")
 		}
+		cfg.RunProgressTTL = runProgressTTL
 	}
 
-	return cfg, nil
-}
+	if minPhotoAgeStr := os.Getenv("MIN_PHOTO_AGE"); minPhotoAgeStr != "" {
+		minPhotoAge, err := strconv.Atoi(minPhotoAgeStr)
+		if err != nil {
+			return nil, fmt.Errorf("MIN_PHOTO_AGE must be a valid integer: %v", err)
+		}
+		if minPhotoAge < 0 {
+			return nil, fmt.Errorf("MIN_PHOTO_AGE must not be negative")
+		}
+		cfg.MinPhotoAge = minPhotoAge
+	}
 
-// loadAlbumConfig loads the album configuration from a JSON file
-func loadAlbumConfig(configPath string) (*AlbumConfig, error) {
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+	scraperTimeoutStr := os.Getenv("SCRAPER_TIMEOUT")
+	if scraperTimeoutStr == "" {
+		cfg.ScraperTimeout = 120 // Default: 2 minutes
+	} else {
+		scraperTimeout, err := strconv.Atoi(scraperTimeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("SCRAPER_TIMEOUT must be a valid integer: %v", err)
+		}
+		if scraperTimeout < 0 {
+			return nil, fmt.Errorf("SCRAPER_TIMEOUT must not be negative")
+		}
+		cfg.ScraperTimeout = scraperTimeout
 	}
 
-	var albumConfig AlbumConfig
-	if err := json.Unmarshal(data, &albumConfig); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	scraperRetriesStr := os.Getenv("SCRAPER_RETRIES")
+	if scraperRetriesStr == "" {
+		cfg.ScraperRetries = 2 // Default: 2 retries
+	} else {
+		scraperRetries, err := strconv.Atoi(scraperRetriesStr)
+		if err != nil {
+			return nil, fmt.Errorf("SCRAPER_RETRIES must be a valid integer: %v", err)
+		}
+		if scraperRetries < 0 {
+			return nil, fmt.Errorf("SCRAPER_RETRIES must not be negative")
+		}
+		cfg.ScraperRetries = scraperRetries
 	}
 
-	return &albumConfig, nil
-}
+	if v := os.Getenv("SCRAPE_CACHE_TTL"); v != "" {
+		scrapeCacheTTL, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("SCRAPE_CACHE_TTL must be a valid integer: %v", err)
+		}
+		if scrapeCacheTTL < 0 {
+			return nil, fmt.Errorf("SCRAPE_CACHE_TTL must not be negative")
+		}
+		cfg.ScrapeCacheTTL = scrapeCacheTTL
+	}
 
+	// Optional headers for albums that are only served to a signed-in iCloud web session
+	icloudHeaders, err := getSecret("ICLOUD_HEADERS")
+	if err != nil {
+		return nil, err
+	}
+	if icloudHeaders != "" {
+		header, err := parseHeaders(icloudHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("ICLOUD_HEADERS %v", err)
+		}
+		cfg.ICloudHeader = header
+	}
+	icloudCookie, err := getSecret("ICLOUD_COOKIE")
+	if err != nil {
+		return nil, err
+	}
+	if icloudCookie = strings.TrimSpace(icloudCookie); icloudCookie != "" {
+		if strings.ContainsAny(icloudCookie, "\r\n") {
+			return nil, fmt.Errorf("ICLOUD_COOKIE must be a single line")
+		}
+		if cfg.ICloudHeader == nil {
+			cfg.ICloudHeader = make(http.Header)
+		}
+		cfg.ICloudHeader.Set("Cookie", icloudCookie)
+	}
+
+	downloadTimeoutStr := os.Getenv("DOWNLOAD_TIMEOUT")
+	if downloadTimeoutStr == "" {
+		cfg.DownloadTimeout = 60 // Default: 1 minute
+	} else {
+		downloadTimeout, err := strconv.Atoi(downloadTimeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("DOWNLOAD_TIMEOUT must be a valid integer: %v", err)
+		}
+		if downloadTimeout < 0 {
+			return nil, fmt.Errorf("DOWNLOAD_TIMEOUT must not be negative")
+		}
+		cfg.DownloadTimeout = downloadTimeout
+	}
+
+	downloadTimeoutPerMBStr := os.Getenv("DOWNLOAD_TIMEOUT_PER_MB")
+	if downloadTimeoutPerMBStr != "" {
+		downloadTimeoutPerMB, err := strconv.Atoi(downloadTimeoutPerMBStr)
+		if err != nil {
+			return nil, fmt.Errorf("DOWNLOAD_TIMEOUT_PER_MB must be a valid integer: %v", err)
+		}
+		if downloadTimeoutPerMB < 0 {
+			return nil, fmt.Errorf("DOWNLOAD_TIMEOUT_PER_MB must not be negative")
+		}
+		cfg.DownloadTimeoutPerMB = downloadTimeoutPerMB
+	}
+
+	maxConnsPerHostStr := os.Getenv("MAX_CONNS_PER_HOST")
+	if maxConnsPerHostStr == "" {
+		cfg.MaxConnsPerHost = 8 // Default: enough for parallel downloads without tripping CDN rate limits
+	} else {
+		maxConnsPerHost, err := strconv.Atoi(maxConnsPerHostStr)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_CONNS_PER_HOST must be a valid integer: %v", err)
+		}
+		if maxConnsPerHost < 0 {
+			return nil, fmt.Errorf("MAX_CONNS_PER_HOST must not be negative")
+		}
+		cfg.MaxConnsPerHost = maxConnsPerHost
+	}
+
+	maxIdleConnsPerHostStr := os.Getenv("MAX_IDLE_CONNS_PER_HOST")
+	if maxIdleConnsPerHostStr == "" {
+		cfg.MaxIdleConnsPerHost = 8
+	} else {
+		maxIdleConnsPerHost, err := strconv.Atoi(maxIdleConnsPerHostStr)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_IDLE_CONNS_PER_HOST must be a valid integer: %v", err)
+		}
+		if maxIdleConnsPerHost < 1 {
+			return nil, fmt.Errorf("MAX_IDLE_CONNS_PER_HOST must be at least 1")
+		}
+		cfg.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	}
+
+	if maxDownloadsPerHostStr := os.Getenv("MAX_DOWNLOADS_PER_HOST"); maxDownloadsPerHostStr != "" {
+		maxDownloadsPerHost, err := strconv.Atoi(maxDownloadsPerHostStr)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_DOWNLOADS_PER_HOST must be a valid integer: %v", err)
+		}
+		if maxDownloadsPerHost < 0 {
+			return nil, fmt.Errorf("MAX_DOWNLOADS_PER_HOST must not be negative")
+		}
+		cfg.MaxDownloadsPerHost = maxDownloadsPerHost
+	}
+
+	if maxDownloadBytesPerSecStr := os.Getenv("MAX_DOWNLOAD_BYTES_PER_SEC"); maxDownloadBytesPerSecStr != "" {
+		maxDownloadBytesPerSec, err := strconv.ParseInt(maxDownloadBytesPerSecStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_DOWNLOAD_BYTES_PER_SEC must be a valid integer: %v", err)
+		}
+		if maxDownloadBytesPerSec < 0 {
+			return nil, fmt.Errorf("MAX_DOWNLOAD_BYTES_PER_SEC must not be negative")
+		}
+		cfg.MaxDownloadBytesPerSec = maxDownloadBytesPerSec
+	}
+
+	if minImageWidthStr := os.Getenv("MIN_IMAGE_WIDTH"); minImageWidthStr != "" {
+		minImageWidth, err := strconv.Atoi(minImageWidthStr)
+		if err != nil {
+			return nil, fmt.Errorf("MIN_IMAGE_WIDTH must be a valid integer: %v", err)
+		}
+		if minImageWidth < 0 {
+			return nil, fmt.Errorf("MIN_IMAGE_WIDTH must not be negative")
+		}
+		cfg.MinImageWidth = minImageWidth
+	}
+
+	if minImageHeightStr := os.Getenv("MIN_IMAGE_HEIGHT"); minImageHeightStr != "" {
+		minImageHeight, err := strconv.Atoi(minImageHeightStr)
+		if err != nil {
+			return nil, fmt.Errorf("MIN_IMAGE_HEIGHT must be a valid integer: %v", err)
+		}
+		if minImageHeight < 0 {
+			return nil, fmt.Errorf("MIN_IMAGE_HEIGHT must not be negative")
+		}
+		cfg.MinImageHeight = minImageHeight
+	}
+
+	// Optional - e.g. the root CA of a TLS-inspecting proxy
+	cfg.ExtraCACert = os.Getenv("EXTRA_CA_CERT")
+
+	cfg.AlbumValidation = os.Getenv("ALBUM_VALIDATION")
+	switch cfg.AlbumValidation {
+	case "":
+		cfg.AlbumValidation = "warn"
+	case "strict", "warn", "off":
+	default:
+		return nil, fmt.Errorf("ALBUM_VALIDATION must be one of strict, warn, off: got %q", cfg.AlbumValidation)
+	}
+
+	runOnceStr := os.Getenv("RUN_ONCE")
+	if runOnceStr != "" {
+		runOnce, err := strconv.ParseBool(runOnceStr)
+		if err != nil {
+			return nil, fmt.Errorf("RUN_ONCE must be a valid boolean: %v", err)
+		}
+		cfg.RunOnce = runOnce
+	}
+
+	if statusAddr := os.Getenv("STATUS_ADDR"); statusAddr != "" {
+		if _, _, err := net.SplitHostPort(statusAddr); err != nil {
+			return nil, fmt.Errorf("STATUS_ADDR must be a host:port address (e.g. :8080): %v", err)
+		}
+		cfg.StatusAddr = statusAddr
+	}
+
+	syncLockStr := os.Getenv("SYNC_LOCK")
+	if syncLockStr != "" {
+		syncLock, err := strconv.ParseBool(syncLockStr)
+		if err != nil {
+			return nil, fmt.Errorf("SYNC_LOCK must be a valid boolean: %v", err)
+		}
+		cfg.SyncLock = syncLock
+	}
+
+	syncLockTTLStr := os.Getenv("SYNC_LOCK_TTL")
+	if syncLockTTLStr == "" {
+		cfg.SyncLockTTL = 120 // Default: 2 minutes
+	} else {
+		syncLockTTL, err := strconv.Atoi(syncLockTTLStr)
+		if err != nil {
+			return nil, fmt.Errorf("SYNC_LOCK_TTL must be a valid integer: %v", err)
+		}
+		if syncLockTTL < 10 {
+			return nil, fmt.Errorf("SYNC_LOCK_TTL must be at least 10 seconds")
+		}
+		cfg.SyncLockTTL = syncLockTTL
+	}
+
+	maxItemsStr := os.Getenv("MAX_ITEMS")
+	if maxItemsStr == "" {
+		cfg.MaxItems = 5 // Default: 5 items
+	} else {
+		maxItems, err := strconv.Atoi(maxItemsStr)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_ITEMS must be a valid integer: %v", err)
+		}
+		cfg.MaxItems = maxItems
+	}
+
+	maxItemsPerAlbumStr := os.Getenv("MAX_ITEMS_PER_ALBUM")
+	if maxItemsPerAlbumStr != "" {
+		maxItemsPerAlbum, err := strconv.Atoi(maxItemsPerAlbumStr)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_ITEMS_PER_ALBUM must be a valid integer: %v", err)
+		}
+		if maxItemsPerAlbum < 0 {
+			return nil, fmt.Errorf("MAX_ITEMS_PER_ALBUM must not be negative")
+		}
+		cfg.MaxItemsPerAlbum = maxItemsPerAlbum
+	}
+
+	maxAlbumsPerRunStr := os.Getenv("MAX_ALBUMS_PER_RUN")
+	if maxAlbumsPerRunStr != "" {
+		maxAlbumsPerRun, err := strconv.Atoi(maxAlbumsPerRunStr)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_ALBUMS_PER_RUN must be a valid integer: %v", err)
+		}
+		if maxAlbumsPerRun < 0 {
+			return nil, fmt.Errorf("MAX_ALBUMS_PER_RUN must not be negative")
+		}
+		cfg.MaxAlbumsPerRun = maxAlbumsPerRun
+	}
+
+	downloadConcurrencyStr := os.Getenv("DOWNLOAD_CONCURRENCY")
+	if downloadConcurrencyStr == "" {
+		cfg.DownloadConcurrency = 1 // Default: one at a time
+	} else {
+		downloadConcurrency, err := strconv.Atoi(downloadConcurrencyStr)
+		if err != nil {
+			return nil, fmt.Errorf("DOWNLOAD_CONCURRENCY must be a valid integer: %v", err)
+		}
+		if downloadConcurrency < 1 {
+			return nil, fmt.Errorf("DOWNLOAD_CONCURRENCY must be at least 1")
+		}
+		cfg.DownloadConcurrency = downloadConcurrency
+	}
+
+	emailConcurrencyStr := os.Getenv("EMAIL_CONCURRENCY")
+	if emailConcurrencyStr == "" {
+		cfg.EmailConcurrency = 1 // Default: one at a time
+	} else {
+		emailConcurrency, err := strconv.Atoi(emailConcurrencyStr)
+		if err != nil {
+			return nil, fmt.Errorf("EMAIL_CONCURRENCY must be a valid integer: %v", err)
+		}
+		if emailConcurrency < 1 {
+			return nil, fmt.Errorf("EMAIL_CONCURRENCY must be at least 1")
+		}
+		cfg.EmailConcurrency = emailConcurrency
+	}
+
+	googlePhotosConcurrencyStr := os.Getenv("GOOGLE_PHOTOS_CONCURRENCY")
+	if googlePhotosConcurrencyStr == "" {
+		cfg.GooglePhotosConcurrency = 1 // Default: one at a time
+	} else {
+		googlePhotosConcurrency, err := strconv.Atoi(googlePhotosConcurrencyStr)
+		if err != nil {
+			return nil, fmt.Errorf("GOOGLE_PHOTOS_CONCURRENCY must be a valid integer: %v", err)
+		}
+		if googlePhotosConcurrency < 1 {
+			return nil, fmt.Errorf("GOOGLE_PHOTOS_CONCURRENCY must be at least 1")
+		}
+		cfg.GooglePhotosConcurrency = googlePhotosConcurrency
+	}
+
+	backfillMaxItemsStr := os.Getenv("BACKFILL_MAX_ITEMS")
+	if backfillMaxItemsStr != "" {
+		backfillMaxItems, err := strconv.Atoi(backfillMaxItemsStr)
+		if err != nil {
+			return nil, fmt.Errorf("BACKFILL_MAX_ITEMS must be a valid integer: %v", err)
+		}
+		if backfillMaxItems < 0 {
+			return nil, fmt.Errorf("BACKFILL_MAX_ITEMS must not be negative")
+		}
+		cfg.BackfillMaxItems = backfillMaxItems
+	}
+
+	cfg.InitialSyncMode = os.Getenv("INITIAL_SYNC_MODE")
+	switch cfg.InitialSyncMode {
+	case "":
+		cfg.InitialSyncMode = "notify"
+	case "notify", "mark-seen-only":
+	default:
+		return nil, fmt.Errorf("INITIAL_SYNC_MODE must be one of notify, mark-seen-only: got %q", cfg.InitialSyncMode)
+	}
+
+	welcomeEmailStr := os.Getenv("WELCOME_EMAIL")
+	if welcomeEmailStr != "" {
+		welcomeEmail, err := strconv.ParseBool(welcomeEmailStr)
+		if err != nil {
+			return nil, fmt.Errorf("WELCOME_EMAIL must be a valid boolean: %v", err)
+		}
+		cfg.WelcomeEmail = welcomeEmail
+	}
+
+	cfg.ProcessOrder = os.Getenv("PROCESS_ORDER")
+	switch cfg.ProcessOrder {
+	case "":
+		cfg.ProcessOrder = "album"
+	case "album", "newest", "oldest":
+	default:
+		return nil, fmt.Errorf("PROCESS_ORDER must be one of album, newest, oldest: got %q", cfg.ProcessOrder)
+	}
+
+	if quietHoursStr := os.Getenv("QUIET_HOURS"); quietHoursStr != "" {
+		quietHours, err := parseQuietHours(quietHoursStr)
+		if err != nil {
+			return nil, fmt.Errorf("QUIET_HOURS %v", err)
+		}
+		quietHours.Notifiers = []string{"email"}
+		if v := os.Getenv("QUIET_HOURS_NOTIFIERS"); v != "" {
+			quietHours.Notifiers = nil
+			for _, name := range strings.Split(v, ",") {
+				name = strings.TrimSpace(name)
+				switch name {
+				case "":
+					continue
+				case "email", "contact_sheet", "google_photos", "webhook", "archive", "hook", "s3", "immich", "telegram", "slack":
+				default:
+					return nil, fmt.Errorf("QUIET_HOURS_NOTIFIERS must list notifiers among email, contact_sheet, google_photos, webhook, archive, hook, s3, immich, telegram, slack: got %q", name)
+				}
+				quietHours.Notifiers = append(quietHours.Notifiers, name)
+			}
+		}
+		cfg.QuietHours = quietHours
+	}
+
+	cfg.LogLevel = logging.LevelInfo
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		logLevel, err := logging.ParseLevel(v)
+		if err != nil {
+			return nil, fmt.Errorf("LOG_LEVEL must be one of debug, info, warn, error: %v", err)
+		}
+		cfg.LogLevel = logLevel
+	}
+
+	cfg.AuditLog = os.Getenv("AUDIT_LOG")
+	auditLogMaxMBStr := os.Getenv("AUDIT_LOG_MAX_MB")
+	if auditLogMaxMBStr == "" {
+		cfg.AuditLogMaxBytes = 100 * 1024 * 1024 // Default: 100 MB
+	} else {
+		auditLogMaxMB, err := strconv.Atoi(auditLogMaxMBStr)
+		if err != nil {
+			return nil, fmt.Errorf("AUDIT_LOG_MAX_MB must be a valid integer: %v", err)
+		}
+		if auditLogMaxMB < 0 {
+			return nil, fmt.Errorf("AUDIT_LOG_MAX_MB must not be negative")
+		}
+		cfg.AuditLogMaxBytes = int64(auditLogMaxMB) * 1024 * 1024
+	}
+
+	cfg.DedupKey = os.Getenv("DEDUP_KEY")
+	switch cfg.DedupKey {
+	case "":
+		cfg.DedupKey = "hash"
+	case "hash", "guid", "both":
+	default:
+		return nil, fmt.Errorf("DEDUP_KEY must be one of hash, guid, both: got %q", cfg.DedupKey)
+	}
+
+	maxFailuresStr := os.Getenv("MAX_FAILURES")
+	if maxFailuresStr == "" {
+		cfg.MaxFailures = 5 // Default: quarantine after 5 consecutive failures
+	} else {
+		maxFailures, err := strconv.Atoi(maxFailuresStr)
+		if err != nil {
+			return nil, fmt.Errorf("MAX_FAILURES must be a valid integer: %v", err)
+		}
+		if maxFailures < 0 {
+			return nil, fmt.Errorf("MAX_FAILURES must not be negative")
+		}
+		cfg.MaxFailures = maxFailures
+	}
+
+	if emailMaxAttemptsStr := os.Getenv("EMAIL_MAX_ATTEMPTS"); emailMaxAttemptsStr != "" {
+		emailMaxAttempts, err := strconv.Atoi(emailMaxAttemptsStr)
+		if err != nil {
+			return nil, fmt.Errorf("EMAIL_MAX_ATTEMPTS must be a valid integer: %v", err)
+		}
+		if emailMaxAttempts < 0 {
+			return nil, fmt.Errorf("EMAIL_MAX_ATTEMPTS must not be negative")
+		}
+		cfg.EmailMaxAttempts = emailMaxAttempts
+	}
+
+	resetQuarantineStr := os.Getenv("RESET_QUARANTINE")
+	if resetQuarantineStr != "" {
+		resetQuarantine, err := strconv.ParseBool(resetQuarantineStr)
+		if err != nil {
+			return nil, fmt.Errorf("RESET_QUARANTINE must be a valid boolean: %v", err)
+		}
+		cfg.ResetQuarantine = resetQuarantine
+	}
+
+	deleteAfterUploadStr := os.Getenv("DELETE_AFTER_UPLOAD")
+	if deleteAfterUploadStr != "" {
+		deleteAfterUpload, err := strconv.ParseBool(deleteAfterUploadStr)
+		if err != nil {
+			return nil, fmt.Errorf("DELETE_AFTER_UPLOAD must be a valid boolean: %v", err)
+		}
+		cfg.DeleteAfterUpload = deleteAfterUpload
+	}
+
+	cfg.RedownloadMissingFiles = true
+	if redownloadStr := os.Getenv("REDOWNLOAD_MISSING_FILES"); redownloadStr != "" {
+		redownload, err := strconv.ParseBool(redownloadStr)
+		if err != nil {
+			return nil, fmt.Errorf("REDOWNLOAD_MISSING_FILES must be a valid boolean: %v", err)
+		}
+		cfg.RedownloadMissingFiles = redownload
+	}
+
+	// Google Photos configuration (optional - only enabled if all vars are provided)
+	googlePhotosClientID := os.Getenv("GOOGLE_PHOTOS_CLIENT_ID")
+	googlePhotosClientSecret, err := getSecret("GOOGLE_PHOTOS_CLIENT_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	googlePhotosRefreshToken, err := getSecret("GOOGLE_PHOTOS_REFRESH_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	googlePhotosAlbumName := os.Getenv("GOOGLE_PHOTOS_ALBUM_NAME") // Optional - empty means upload to library only (for partner sharing)
+	googlePhotosVerifyUploads := false
+	if v := os.Getenv("GOOGLE_PHOTOS_VERIFY_UPLOADS"); v != "" {
+		googlePhotosVerifyUploads, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("GOOGLE_PHOTOS_VERIFY_UPLOADS must be a valid boolean: %v", err)
+		}
+	}
+
+	googlePhotosSkipIfInAlbum := false
+	if v := os.Getenv("GOOGLE_PHOTOS_SKIP_IF_IN_ALBUM"); v != "" {
+		googlePhotosSkipIfInAlbum, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("GOOGLE_PHOTOS_SKIP_IF_IN_ALBUM must be a valid boolean: %v", err)
+		}
+	}
+
+	googlePhotosAlbumRotation := os.Getenv("GOOGLE_PHOTOS_ALBUM_ROTATION")
+	switch googlePhotosAlbumRotation {
+	case "":
+		googlePhotosAlbumRotation = "none"
+	case "none", "monthly", "yearly":
+	default:
+		return nil, fmt.Errorf("GOOGLE_PHOTOS_ALBUM_ROTATION must be one of none, monthly, yearly: got %q", googlePhotosAlbumRotation)
+	}
+
+	// If any Google Photos env var is set, ClientID, ClientSecret, and RefreshToken must all be set
+	// AlbumName is optional - if not provided, photos will be uploaded to library only
+	if googlePhotosClientID != "" || googlePhotosClientSecret != "" || googlePhotosRefreshToken != "" {
+		if googlePhotosClientID == "" {
+			return nil, fmt.Errorf("GOOGLE_PHOTOS_CLIENT_ID is required when Google Photos is enabled")
+		}
+		if googlePhotosClientSecret == "" {
+			return nil, fmt.Errorf("GOOGLE_PHOTOS_CLIENT_SECRET is required when Google Photos is enabled")
+		}
+		if googlePhotosRefreshToken == "" {
+			return nil, fmt.Errorf("GOOGLE_PHOTOS_REFRESH_TOKEN is required when Google Photos is enabled")
+		}
+		// AlbumName is optional - empty string means upload to library only (for partner sharing)
+		if googlePhotosAlbumRotation != "none" && googlePhotosAlbumName == "" {
+			return nil, fmt.Errorf("GOOGLE_PHOTOS_ALBUM_ROTATION requires GOOGLE_PHOTOS_ALBUM_NAME")
+		}
+
+		cfg.GooglePhotosConfig = &GooglePhotosConfig{
+			ClientID:      googlePhotosClientID,
+			ClientSecret:  googlePhotosClientSecret,
+			RefreshToken:  googlePhotosRefreshToken,
+			AlbumName:     googlePhotosAlbumName, // Empty string = upload to library only
+			VerifyUploads: googlePhotosVerifyUploads,
+			SkipIfInAlbum: googlePhotosSkipIfInAlbum,
+			AlbumRotation: googlePhotosAlbumRotation,
+			CACertPath:    cfg.ExtraCACert,
+		}
+	}
+
+	// S3 configuration (optional - only enabled if S3_BUCKET is set)
+	s3AccessKeyID := os.Getenv("S3_ACCESS_KEY_ID")
+	s3SecretAccessKey, err := getSecret("S3_SECRET_ACCESS_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if s3Bucket := os.Getenv("S3_BUCKET"); s3Bucket != "" {
+		if s3AccessKeyID == "" {
+			return nil, fmt.Errorf("S3_ACCESS_KEY_ID is required when S3_BUCKET is set")
+		}
+		if s3SecretAccessKey == "" {
+			return nil, fmt.Errorf("S3_SECRET_ACCESS_KEY is required when S3_BUCKET is set")
+		}
+
+		s3Region := os.Getenv("S3_REGION")
+		if s3Region == "" {
+			s3Region = "us-east-1"
+		}
+
+		s3Endpoint := os.Getenv("S3_ENDPOINT")
+		if s3Endpoint != "" {
+			u, err := url.Parse(s3Endpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("S3_ENDPOINT must be an http or https URL: got %q", s3Endpoint)
+			}
+		}
+
+		s3KeyFormat := os.Getenv("S3_KEY_FORMAT")
+		switch s3KeyFormat {
+		case "":
+			s3KeyFormat = "hash"
+		case "hash", "date":
+		default:
+			return nil, fmt.Errorf("S3_KEY_FORMAT must be one of hash, date: got %q", s3KeyFormat)
+		}
+
+		s3LinkExpiry := 7 * 24 * 60 * 60 // Default: 7 days, the longest S3 allows
+		if v := os.Getenv("S3_LINK_EXPIRY"); v != "" {
+			s3LinkExpiry, err = strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("S3_LINK_EXPIRY must be a valid integer: %v", err)
+			}
+			if s3LinkExpiry < 1 || s3LinkExpiry > 7*24*60*60 {
+				return nil, fmt.Errorf("S3_LINK_EXPIRY must be between 1 and 604800 seconds (7 days)")
+			}
+		}
+
+		cfg.S3Config = &S3Config{
+			Bucket:          s3Bucket,
+			Prefix:          os.Getenv("S3_PREFIX"),
+			Region:          s3Region,
+			Endpoint:        s3Endpoint,
+			AccessKeyID:     s3AccessKeyID,
+			SecretAccessKey: s3SecretAccessKey,
+			KeyFormat:       s3KeyFormat,
+			LinkExpiry:      s3LinkExpiry,
+		}
+	}
+
+	// Immich configuration (optional - only enabled if IMMICH_URL is set)
+	immichAPIKey, err := getSecret("IMMICH_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if immichURL := strings.TrimSuffix(os.Getenv("IMMICH_URL"), "/"); immichURL != "" {
+		u, err := url.Parse(immichURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("IMMICH_URL must be an http or https URL: got %q", immichURL)
+		}
+		if immichAPIKey == "" {
+			return nil, fmt.Errorf("IMMICH_API_KEY is required when IMMICH_URL is set")
+		}
+		cfg.ImmichConfig = &ImmichConfig{
+			URL:    immichURL,
+			APIKey: immichAPIKey,
+		}
+	}
+
+	// Telegram configuration (optional - only enabled if TELEGRAM_BOT_TOKEN is set)
+	telegramBotToken, err := getSecret("TELEGRAM_BOT_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	if telegramBotToken != "" {
+		chatID := os.Getenv("TELEGRAM_CHAT_ID")
+		if chatID == "" {
+			return nil, fmt.Errorf("TELEGRAM_CHAT_ID is required when TELEGRAM_BOT_TOKEN is set")
+		}
+		apiURL := strings.TrimSuffix(os.Getenv("TELEGRAM_API_URL"), "/")
+		if apiURL != "" {
+			u, err := url.Parse(apiURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("TELEGRAM_API_URL must be an http or https URL: got %q", apiURL)
+			}
+		}
+		cfg.TelegramConfig = &TelegramConfig{
+			BotToken: telegramBotToken,
+			ChatID:   chatID,
+			APIURL:   apiURL,
+		}
+	}
+
+	// Slack configuration (optional - only enabled if SLACK_BOT_TOKEN is set)
+	slackBotToken, err := getSecret("SLACK_BOT_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	if slackBotToken != "" {
+		channelID := os.Getenv("SLACK_CHANNEL_ID")
+		if channelID == "" {
+			return nil, fmt.Errorf("SLACK_CHANNEL_ID is required when SLACK_BOT_TOKEN is set")
+		}
+		cfg.SlackConfig = &SlackConfig{
+			BotToken:  slackBotToken,
+			ChannelID: channelID,
+		}
+	}
+
+	// Medium attachments link to the original, so somewhere must host it
+	if cfg.EmailAttachment == "medium" && cfg.S3Config == nil && (cfg.ArchiveDir == "" || cfg.ArchiveBaseURL == "") {
+		return nil, fmt.Errorf("EMAIL_ATTACHMENT=medium requires S3_BUCKET, or ARCHIVE_DIR with ARCHIVE_BASE_URL, to host the originals")
+	}
+
+	return cfg, nil
+}
+
+// loadEnvFile sets environment variables from the KEY=VALUE lines of the file named by ENV_FILE,
+// or .env in the working directory if it exists. Variables already set in the environment take
+// precedence. Blank lines, # comments, and an "export " prefix are ignored, and values may be
+// wrapped in single or double quotes
+func loadEnvFile() error {
+	path := os.Getenv("ENV_FILE")
+	optional := path == ""
+	if optional {
+		path = ".env"
+	}
+	data, err := os.ReadFile(path)
+	if optional && errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read env file: %w", err)
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return fmt.Errorf("%s line %d: expected KEY=VALUE", path, i+1)
+		}
+		value, err := parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s line %d: %s: %v", path, i+1, key, err)
+		}
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("%s line %d: failed to set %s: %w", path, i+1, key, err)
+		}
+	}
+	return nil
+}
+
+// parseEnvValue unquotes a .env value: double quotes allow escapes such as \n, single quotes
+// are taken literally, and an unquoted value ends at a " #" comment
+func parseEnvValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid double-quoted value")
+		}
+		return unquoted, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("unterminated single-quoted value")
+		}
+		return value[1 : len(value)-1], nil
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
+
+// parseFileMode parses an octal permission mode from the named environment variable, e.g.
+// 0640. Returns 0 if the variable isn't set
+func parseFileMode(name string) (os.FileMode, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(v, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an octal mode such as 0750: got %q", name, v)
+	}
+	if mode == 0 || mode > 0777 {
+		return 0, fmt.Errorf("%s must be between 0001 and 0777: got %q", name, v)
+	}
+	return os.FileMode(mode), nil
+}
+
+// getSecret reads a sensitive value from the named environment variable or, following the
+// Docker secrets convention, from the file named by <name>_FILE. Setting both is an error.
+// A trailing newline in the file is ignored.
+func getSecret(name string) (string, error) {
+	value := os.Getenv(name)
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("only one of %s and %s_FILE may be set", name, name)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// parseHeaders parses newline-separated "Name: Value" header lines; blank lines are ignored
+func parseHeaders(value string) (http.Header, error) {
+	header := make(http.Header)
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, headerValue, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("must be \"Name: Value\" lines: got %q", line)
+		}
+		header.Add(name, strings.TrimSpace(headerValue))
+	}
+	return header, nil
+}
+
+// parseQuietHours parses a window such as "22:00-07:00", optionally followed by an IANA time
+// zone ("22:00-07:00 Europe/Berlin"); without one the local time zone is used
+func parseQuietHours(value string) (*QuietHours, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("must be HH:MM-HH:MM with an optional time zone: got %q", value)
+	}
+
+	startStr, endStr, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return nil, fmt.Errorf("must be HH:MM-HH:MM with an optional time zone: got %q", value)
+	}
+	start, err := time.Parse("15:04", startStr)
+	if err != nil {
+		return nil, fmt.Errorf("has an invalid start time %q", startStr)
+	}
+	end, err := time.Parse("15:04", endStr)
+	if err != nil {
+		return nil, fmt.Errorf("has an invalid end time %q", endStr)
+	}
+
+	quietHours := &QuietHours{
+		Start:    start.Hour()*60 + start.Minute(),
+		End:      end.Hour()*60 + end.Minute(),
+		Location: time.Local,
+	}
+	if quietHours.Start == quietHours.End {
+		return nil, fmt.Errorf("must not start and end at the same time: got %q", value)
+	}
+	if len(fields) == 2 {
+		location, err := time.LoadLocation(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("has an unknown time zone %q: %v", fields[1], err)
+		}
+		quietHours.Location = location
+	}
+	return quietHours, nil
+}
+
+// parseMediaTypes reads a comma-separated list of extensions or MIME types from an environment
+// variable and normalizes each entry to a MIME type or type family
+func parseMediaTypes(name string) ([]string, error) {
+	var mediaTypes []string
+	for _, entry := range strings.Split(os.Getenv(name), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		mediaType, err := storage.ParseMediaType(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	return mediaTypes, nil
+}
+
+// splitAlbumURLs parses a comma- or newline-separated list of album URLs
+func splitAlbumURLs(value string) []string {
+	var albumURLs []string
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if albumURL := strings.TrimSpace(field); albumURL != "" {
+			albumURLs = append(albumURLs, albumURL)
+		}
+	}
+	return albumURLs
+}
+
+// mergeAlbumURLs combines album URL lists in order, dropping duplicates
+func mergeAlbumURLs(lists ...[]string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, list := range lists {
+		for _, albumURL := range list {
+			if seen[albumURL] {
+				continue
+			}
+			seen[albumURL] = true
+			merged = append(merged, albumURL)
+		}
+	}
+	return merged
+}
+
+// loadAlbumConfig loads the album configuration from a JSON file
+func loadAlbumConfig(configPath string) (*AlbumConfig, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Unknown keys are rejected so a typo like "album_url" isn't silently ignored. The entries
+	// are decoded one by one below, so their errors can name the entry
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var rawConfig struct {
+		AlbumURLs []json.RawMessage `json:"album_urls"`
+	}
+	if err := decoder.Decode(&rawConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %s", describeJSONError(data, err))
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("failed to parse config file: unexpected data after the closing brace")
+	}
+
+	offsets := albumEntryOffsets(data)
+	albumConfig := AlbumConfig{AlbumURLs: make([]AlbumEntry, len(rawConfig.AlbumURLs))}
+	for i, raw := range rawConfig.AlbumURLs {
+		if err := json.Unmarshal(raw, &albumConfig.AlbumURLs[i]); err != nil {
+			var offset int64
+			if i < len(offsets) {
+				offset = offsets[i]
+			}
+			return nil, fmt.Errorf("failed to parse config file: %s", describeAlbumEntryError(data, offset, i, err))
+		}
+	}
+
+	for i, entry := range albumConfig.AlbumURLs {
+		if err := scraper.ValidateAlbumURL(entry.URL); err != nil {
+			return nil, fmt.Errorf("album_urls[%d] %q: %w", i, entry.URL, err)
+		}
+		if entry.Destination == "" {
+			continue
+		}
+		// Stored as bare addresses, which every email backend accepts as a list
+		addresses, err := mail.ParseAddressList(entry.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("album_urls[%d] destination %q must be a comma-separated list of email addresses: %v", i, entry.Destination, err)
+		}
+		var destination []string
+		for _, address := range addresses {
+			destination = append(destination, address.Address)
+		}
+		albumConfig.AlbumURLs[i].Destination = strings.Join(destination, ", ")
+	}
+
+	return &albumConfig, nil
+}
+
+// describeJSONError explains a JSON decoding error, including the line and column for syntax
+// and type errors (e.g. a trailing comma or a string where a list was expected)
+func describeJSONError(data []byte, err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		line, column := jsonPosition(data, syntaxErr.Offset-1)
+		return fmt.Sprintf("syntax error at line %d, column %d: %v", line, column, err)
+	case errors.As(err, &typeErr):
+		line, column := jsonPosition(data, typeErr.Offset-1)
+		return fmt.Sprintf("%q at line %d, column %d must be %s, got %s", typeErr.Field, line, column, typeErr.Type, typeErr.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		return fmt.Sprintf("%v (the only supported key is \"album_urls\")", err)
+	case errors.Is(err, io.EOF):
+		return "file is empty"
+	default:
+		return err.Error()
+	}
+}
+
+// albumEntryOffsets returns where each album_urls entry starts in data, which has already been
+// decoded without error
+func albumEntryOffsets(data []byte) []int64 {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Token(); err != nil { // {
+		return nil
+	}
+	var offsets []int64
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return offsets
+		}
+		if key != "album_urls" {
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return offsets
+			}
+			continue
+		}
+		if _, err := decoder.Token(); err != nil { // [
+			return offsets
+		}
+		// A later "album_urls" key replaces the earlier one, as when decoding
+		offsets = nil
+		for decoder.More() {
+			// InputOffset is just past the previous token, before the whitespace and comma
+			offset := decoder.InputOffset()
+			for offset < int64(len(data)) && strings.ContainsRune(" \t\r\n,", rune(data[offset])) {
+				offset++
+			}
+			offsets = append(offsets, offset)
+			var entry json.RawMessage
+			if err := decoder.Decode(&entry); err != nil {
+				return offsets
+			}
+		}
+		if _, err := decoder.Token(); err != nil { // ]
+			return offsets
+		}
+	}
+	return offsets
+}
+
+// describeAlbumEntryError explains an error decoding the album_urls entry at index, which
+// starts at offset in data, naming the entry and its position in the file
+func describeAlbumEntryError(data []byte, offset int64, index int, err error) string {
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field == "":
+		line, column := jsonPosition(data, offset)
+		return fmt.Sprintf("album_urls[%d] at line %d, column %d must be a URL or an object, got %s", index, line, column, typeErr.Value)
+	case errors.As(err, &typeErr):
+		line, column := jsonPosition(data, offset+typeErr.Offset-1)
+		return fmt.Sprintf("album_urls[%d]: %q at line %d, column %d must be %s, got %s", index, typeErr.Field, line, column, typeErr.Type, typeErr.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		line, column := jsonPosition(data, offset)
+		return fmt.Sprintf("album_urls[%d] at line %d, column %d: %v (the supported keys are \"url\", \"enabled\", \"priority\" and \"destination\")", index, line, column, err)
+	default:
+		return fmt.Sprintf("album_urls[%d]: %v", index, err)
+	}
+}
+
+// jsonPosition returns the 1-based line and column of the byte at offset in data
+// The json package reports offsets just past the offending byte, hence the -1 at call sites
+func jsonPosition(data []byte, offset int64) (line, column int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
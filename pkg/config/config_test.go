@@ -1,9 +1,15 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
 )
 
 func TestLoad(t *testing.T) {
@@ -15,6 +21,46 @@ func TestLoad(t *testing.T) {
 		"RUN_INTERVAL", "MAX_ITEMS", "IMAGE_DIR",
 		"GOOGLE_PHOTOS_CLIENT_ID", "GOOGLE_PHOTOS_CLIENT_SECRET",
 		"GOOGLE_PHOTOS_REFRESH_TOKEN", "GOOGLE_PHOTOS_ALBUM_NAME",
+		"MAX_FAILURES", "RESET_QUARANTINE", "ALERT_EMAIL", "IMAGE_LAYOUT", "RUN_ONCE", "HASH_ALGO",
+		"EMAIL_ZIP", "EMAIL_ZIP_MAX_MB", "MAX_ITEMS_PER_ALBUM",
+		"SCRAPER_TIMEOUT", "ALBUM_VALIDATION",
+		"SMTP_INSECURE_SKIP_VERIFY", "SMTP_CA_CERT", "SMTP_TLS_MODE",
+		"WEBHOOK_URL", "ARCHIVE_DIR", "REDIS_MAX_RETRIES", "REDIS_POOL_SIZE", "HASH_CACHE_SIZE",
+		"REDIS_PASSWORD", "REDIS_PASSWORD_FILE", "SMTP_PASSWORD_FILE",
+		"GOOGLE_PHOTOS_CLIENT_SECRET_FILE", "GOOGLE_PHOTOS_REFRESH_TOKEN_FILE",
+		"POST_HOOK", "POST_HOOK_TIMEOUT", "RETRY_INTERVAL", "ALBUM_URLS",
+		"DOWNLOAD_CONCURRENCY", "EMAIL_CONCURRENCY", "GOOGLE_PHOTOS_CONCURRENCY", "SMTP_TIMEOUT",
+		"PROCESS_ORDER", "ALLOWED_TYPES", "BLOCKED_TYPES",
+		"BACKFILL_MAX_ITEMS", "S3_BUCKET", "S3_PREFIX", "S3_REGION", "S3_ENDPOINT",
+		"S3_ACCESS_KEY_ID", "S3_SECRET_ACCESS_KEY", "S3_SECRET_ACCESS_KEY_FILE", "S3_KEY_FORMAT",
+		"DOWNLOAD_TIMEOUT", "DOWNLOAD_TIMEOUT_PER_MB", "REDIS_KEY_PREFIX", "DELETE_AFTER_UPLOAD",
+		"MAX_CONNS_PER_HOST", "MAX_IDLE_CONNS_PER_HOST", "EMAIL_SUBJECT_PREFIX",
+		"QUIET_HOURS", "QUIET_HOURS_NOTIFIERS", "DEDUP_KEY", "LOG_LEVEL",
+		"EMAIL_ATTACHMENT", "EMAIL_MEDIUM_SIZE", "ARCHIVE_BASE_URL", "S3_LINK_EXPIRY",
+		"GOOGLE_PHOTOS_VERIFY_UPLOADS", "HASH_CONTENT", "MAX_DOWNLOADS_PER_HOST",
+		"SMTP_FROM", "EMAIL_BACKEND", "EMAIL_API_KEY", "EMAIL_API_KEY_FILE", "EMAIL_API_URL", "MAILGUN_DOMAIN",
+		"MAX_RUN_DURATION", "SYNC_LOCK", "SYNC_LOCK_TTL", "NORMALIZE_ORIENTATION",
+		"EXTRA_CA_CERT", "GOOGLE_PHOTOS_SKIP_IF_IN_ALBUM", "ENV_FILE",
+		"IMMICH_URL", "IMMICH_API_KEY", "IMMICH_API_KEY_FILE",
+		"EMAIL_MAX_ATTEMPTS", "EMAIL_DERIVATIVE", "ARCHIVE_NAME_TEMPLATE",
+		"INITIAL_SYNC_MODE", "GOOGLE_PHOTOS_ALBUM_ROTATION", "MAX_DOWNLOAD_BYTES_PER_SEC",
+		"EMAIL_THUMBNAIL_SIZE", "MIN_IMAGE_WIDTH", "MIN_IMAGE_HEIGHT",
+		"REDIS_REPLICA_URL",
+		"TELEGRAM_BOT_TOKEN", "TELEGRAM_BOT_TOKEN_FILE", "TELEGRAM_CHAT_ID", "TELEGRAM_API_URL",
+		"SLACK_BOT_TOKEN", "SLACK_BOT_TOKEN_FILE", "SLACK_CHANNEL_ID",
+		"IMAGE_DIR_MODE", "IMAGE_FILE_MODE", "MIN_PHOTO_AGE",
+		"AUDIT_LOG", "AUDIT_LOG_MAX_MB", "SCRAPER_RETRIES", "SCRAPE_CACHE_TTL",
+		"ICLOUD_COOKIE", "ICLOUD_COOKIE_FILE", "ICLOUD_HEADERS", "ICLOUD_HEADERS_FILE",
+		"WELCOME_EMAIL",
+		"RUN_PROGRESS_TTL",
+		"EMAIL_CONTACT_SHEET", "CONTACT_SHEET_COLUMNS", "CONTACT_SHEET_THUMB_SIZE",
+		"REDIS_DIAL_TIMEOUT", "REDIS_READ_TIMEOUT", "REDIS_WRITE_TIMEOUT",
+		"VERIFY_EXISTING_FILES",
+		"REDOWNLOAD_MISSING_FILES",
+		"MAX_ALBUMS_PER_RUN",
+		"STATUS_ADDR",
+		"EMAIL_ZIP_PARTIAL_SEND",
+		"STRIP_GPS", "STRIP_ALL_EXIF",
 	}
 	for _, key := range envVars {
 		originalEnv[key] = os.Getenv(key)
@@ -33,136 +79,2086 @@ func TestLoad(t *testing.T) {
 	// Create temporary directory for test config files
 	tmpDir := t.TempDir()
 
+	// secretFile writes a secret to a temp file and returns its path
+	secretDir := t.TempDir()
+	secretCount := 0
+	secretFile := func(content string) string {
+		secretCount++
+		path := filepath.Join(secretDir, fmt.Sprintf("secret-%d", secretCount))
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write secret file: %v", err)
+		}
+		return path
+	}
+
+	// baseEnv is a minimal valid environment; each case's env is applied on top of it, where an
+	// empty value unsets the variable
+	baseEnv := map[string]string{
+		"REDIS_URL":        "redis://localhost:6379",
+		"SMTP_SERVER":      "smtp.example.com",
+		"SMTP_PORT":        "587",
+		"SMTP_USERNAME":    "user@example.com",
+		"SMTP_PASSWORD":    "password",
+		"SMTP_DESTINATION": "dest@example.com",
+		"IMAGE_DIR":        tmpDir,
+	}
+
 	tests := []struct {
 		name       string
-		env        map[string]string
+		env        map[string]string // Overrides of baseEnv
 		configJSON string
 		wantErr    bool
 		validate   func(*testing.T, *Config)
 	}{
 		{
-			name: "all required fields",
+			name:       "all required fields",
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM1_TOKEN", "https://www.icloud.com/sharedalbum/#ALBUM2_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.SMTPConfig.InsecureSkipVerify {
+					t.Error("SMTPConfig.InsecureSkipVerify should default to false")
+				}
+				if len(cfg.AlbumURLs) != 2 {
+					t.Errorf("AlbumURLs length = %v, want 2", len(cfg.AlbumURLs))
+				}
+				if cfg.AlbumURLs[0] != "https://www.icloud.com/sharedalbum/#ALBUM1_TOKEN" {
+					t.Errorf("AlbumURLs[0] = %v, want https://www.icloud.com/sharedalbum/#ALBUM1_TOKEN", cfg.AlbumURLs[0])
+				}
+			},
+		},
+		{
+			name:       "missing config file",
+			configJSON: "",
+			wantErr:    true,
+		},
+		{
+			name:       "empty album URLs",
+			configJSON: `{"album_urls": []}`,
+			wantErr:    true,
+		},
+		{
+			name: "with optional fields",
+			env: map[string]string{
+				"RUN_INTERVAL":        "1800",
+				"RETRY_INTERVAL":      "30",
+				"RUN_ONCE":            "true",
+				"MAX_ITEMS":           "10",
+				"MAX_ITEMS_PER_ALBUM": "3",
+				"BACKFILL_MAX_ITEMS":  "200",
+				"ALERT_EMAIL":         "ops@example.com",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.RunInterval != 1800 {
+					t.Errorf("RunInterval = %v, want 1800", cfg.RunInterval)
+				}
+				if cfg.RetryInterval != 30 {
+					t.Errorf("RetryInterval = %v, want 30", cfg.RetryInterval)
+				}
+				if !cfg.RunOnce {
+					t.Error("RunOnce = false, want true")
+				}
+				if cfg.MaxItems != 10 {
+					t.Errorf("MaxItems = %v, want 10", cfg.MaxItems)
+				}
+				if cfg.MaxItemsPerAlbum != 3 {
+					t.Errorf("MaxItemsPerAlbum = %v, want 3", cfg.MaxItemsPerAlbum)
+				}
+				if cfg.BackfillMaxItems != 200 {
+					t.Errorf("BackfillMaxItems = %v, want 200", cfg.BackfillMaxItems)
+				}
+				if cfg.AlertDestination != "ops@example.com" {
+					t.Errorf("AlertDestination = %v, want ops@example.com", cfg.AlertDestination)
+				}
+				if cfg.AlbumValidation != "warn" {
+					t.Errorf("AlbumValidation = %v, want default warn", cfg.AlbumValidation)
+				}
+				if cfg.ScraperTimeout != 120 {
+					t.Errorf("ScraperTimeout = %v, want default 120", cfg.ScraperTimeout)
+				}
+				if cfg.ScraperRetries != 2 {
+					t.Errorf("ScraperRetries = %v, want default 2", cfg.ScraperRetries)
+				}
+				if cfg.SMTPConfig.Timeout != 30 {
+					t.Errorf("SMTPConfig.Timeout = %v, want default 30", cfg.SMTPConfig.Timeout)
+				}
+				if cfg.ProcessOrder != "album" {
+					t.Errorf("ProcessOrder = %v, want default album", cfg.ProcessOrder)
+				}
+				if cfg.InitialSyncMode != "notify" {
+					t.Errorf("InitialSyncMode = %v, want default notify", cfg.InitialSyncMode)
+				}
+				if cfg.DownloadTimeout != 60 || cfg.DownloadTimeoutPerMB != 0 {
+					t.Errorf("DownloadTimeout = %v, DownloadTimeoutPerMB = %v, want defaults 60 and 0", cfg.DownloadTimeout, cfg.DownloadTimeoutPerMB)
+				}
+				if cfg.MaxFailures != 5 {
+					t.Errorf("MaxFailures = %v, want default 5", cfg.MaxFailures)
+				}
+			},
+		},
+		{
+			name: "quarantine settings",
+			env: map[string]string{
+				"MAX_FAILURES":     "3",
+				"RESET_QUARANTINE": "true",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.MaxFailures != 3 {
+					t.Errorf("MaxFailures = %v, want 3", cfg.MaxFailures)
+				}
+				if !cfg.ResetQuarantine {
+					t.Error("ResetQuarantine = false, want true")
+				}
+			},
+		},
+		{
+			name: "invalid MAX_FAILURES",
+			env: map[string]string{
+				"MAX_FAILURES": "-1",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid IMAGE_LAYOUT",
+			env: map[string]string{
+				"IMAGE_LAYOUT": "by-date",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "HASH_CONTENT pixels",
+			env: map[string]string{
+				"HASH_CONTENT": "pixels",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.HashContent != "pixels" {
+					t.Errorf("HashContent = %v, want pixels", cfg.HashContent)
+				}
+			},
+		},
+		{
+			name: "invalid HASH_CONTENT",
+			env: map[string]string{
+				"HASH_CONTENT": "exif",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid HASH_ALGO",
+			env: map[string]string{
+				"HASH_ALGO": "md5",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "zip email settings",
+			env: map[string]string{
+				"EMAIL_ZIP":        "true",
+				"EMAIL_ZIP_MAX_MB": "10",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if !cfg.EmailZip {
+					t.Error("EmailZip = false, want true")
+				}
+				if cfg.EmailZipMaxBytes != 10*1024*1024 {
+					t.Errorf("EmailZipMaxBytes = %v, want %v", cfg.EmailZipMaxBytes, 10*1024*1024)
+				}
+			},
+		},
+		{
+			name: "SMTP TLS settings",
+			env: map[string]string{
+				"SMTP_INSECURE_SKIP_VERIFY": "true",
+				"SMTP_CA_CERT":              "/etc/ssl/internal-ca.pem",
+				"SMTP_TLS_MODE":             "implicit-tls",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if !cfg.SMTPConfig.InsecureSkipVerify {
+					t.Error("SMTPConfig.InsecureSkipVerify = false, want true")
+				}
+				if cfg.SMTPConfig.CACertPath != "/etc/ssl/internal-ca.pem" {
+					t.Errorf("SMTPConfig.CACertPath = %v, want /etc/ssl/internal-ca.pem", cfg.SMTPConfig.CACertPath)
+				}
+				if cfg.SMTPConfig.TLSMode != "implicit-tls" {
+					t.Errorf("SMTPConfig.TLSMode = %v, want implicit-tls", cfg.SMTPConfig.TLSMode)
+				}
+			},
+		},
+		{
+			name: "invalid SMTP_TLS_MODE",
+			env: map[string]string{
+				"SMTP_TLS_MODE": "ssl",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "notification sinks",
+			env: map[string]string{
+				"WEBHOOK_URL": "https://hooks.example.com/photos",
+				"ARCHIVE_DIR": "/archive",
+				"POST_HOOK":   "/usr/local/bin/on-photo",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.WebhookURL != "https://hooks.example.com/photos" {
+					t.Errorf("WebhookURL = %v, want https://hooks.example.com/photos", cfg.WebhookURL)
+				}
+				if cfg.ArchiveDir != "/archive" {
+					t.Errorf("ArchiveDir = %v, want /archive", cfg.ArchiveDir)
+				}
+				if cfg.PostHook != "/usr/local/bin/on-photo" {
+					t.Errorf("PostHook = %v, want /usr/local/bin/on-photo", cfg.PostHook)
+				}
+				if cfg.PostHookTimeout != 60 {
+					t.Errorf("PostHookTimeout = %v, want default 60", cfg.PostHookTimeout)
+				}
+			},
+		},
+		{
+			name: "invalid POST_HOOK_TIMEOUT",
+			env: map[string]string{
+				"POST_HOOK_TIMEOUT": "soon",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid WEBHOOK_URL",
+			env: map[string]string{
+				"WEBHOOK_URL": "hooks.example.com/photos",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "Redis driver options",
+			env: map[string]string{
+				"REDIS_MAX_RETRIES": "-1",
+				"REDIS_POOL_SIZE":   "4",
+				"HASH_CACHE_SIZE":   "1000",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.RedisMaxRetries != -1 {
+					t.Errorf("RedisMaxRetries = %v, want -1", cfg.RedisMaxRetries)
+				}
+				if cfg.RedisPoolSize != 4 {
+					t.Errorf("RedisPoolSize = %v, want 4", cfg.RedisPoolSize)
+				}
+				if cfg.HashCacheSize != 1000 {
+					t.Errorf("HashCacheSize = %v, want 1000", cfg.HashCacheSize)
+				}
+			},
+		},
+		{
+			name: "invalid REDIS_MAX_RETRIES",
+			env: map[string]string{
+				"REDIS_MAX_RETRIES": "-2",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "secrets from files",
+			env: map[string]string{
+				"SMTP_PASSWORD":                    "",
+				"REDIS_PASSWORD_FILE":              secretFile("redis-secret"),
+				"SMTP_PASSWORD_FILE":               secretFile("smtp-secret\n"),
+				"GOOGLE_PHOTOS_CLIENT_ID":          "client-id",
+				"GOOGLE_PHOTOS_CLIENT_SECRET_FILE": secretFile("client-secret\n"),
+				"GOOGLE_PHOTOS_REFRESH_TOKEN_FILE": secretFile("refresh-token"),
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.RedisPassword != "redis-secret" {
+					t.Errorf("RedisPassword = %q, want redis-secret", cfg.RedisPassword)
+				}
+				if cfg.SMTPConfig.Password != "smtp-secret" {
+					t.Errorf("SMTPConfig.Password = %q, want smtp-secret", cfg.SMTPConfig.Password)
+				}
+				if cfg.GooglePhotosConfig == nil {
+					t.Fatal("GooglePhotosConfig is nil, want configured")
+				}
+				if cfg.GooglePhotosConfig.ClientSecret != "client-secret" {
+					t.Errorf("GooglePhotosConfig.ClientSecret = %q, want client-secret", cfg.GooglePhotosConfig.ClientSecret)
+				}
+				if cfg.GooglePhotosConfig.RefreshToken != "refresh-token" {
+					t.Errorf("GooglePhotosConfig.RefreshToken = %q, want refresh-token", cfg.GooglePhotosConfig.RefreshToken)
+				}
+			},
+		},
+		{
+			name: "secret set directly and from file",
+			env: map[string]string{
+				"SMTP_PASSWORD_FILE": secretFile("smtp-secret"),
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "missing secret file",
+			env: map[string]string{
+				"SMTP_PASSWORD":      "",
+				"SMTP_PASSWORD_FILE": filepath.Join(tmpDir, "does-not-exist"),
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "album URLs from environment only",
+			env: map[string]string{
+				"ALBUM_URLS": "https://www.icloud.com/sharedalbum/#A_TOKEN, https://www.icloud.com/sharedalbum/#B_TOKEN\nhttps://www.icloud.com/sharedalbum/#A_TOKEN\n",
+			},
+			configJSON: "",
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				want := []string{"https://www.icloud.com/sharedalbum/#A_TOKEN", "https://www.icloud.com/sharedalbum/#B_TOKEN"}
+				if !reflect.DeepEqual(cfg.AlbumURLs, want) {
+					t.Errorf("AlbumURLs = %v, want %v", cfg.AlbumURLs, want)
+				}
+			},
+		},
+		{
+			name: "album URLs merged from file and environment",
+			env: map[string]string{
+				"ALBUM_URLS": "https://www.icloud.com/sharedalbum/#ALBUM_TOKEN,https://www.icloud.com/sharedalbum/#ENV_TOKEN",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				want := []string{"https://www.icloud.com/sharedalbum/#ALBUM_TOKEN", "https://www.icloud.com/sharedalbum/#ENV_TOKEN"}
+				if !reflect.DeepEqual(cfg.AlbumURLs, want) {
+					t.Errorf("AlbumURLs = %v, want %v", cfg.AlbumURLs, want)
+				}
+			},
+		},
+		{
+			name: "disabled album in object form",
+			env: map[string]string{
+				"ALBUM_URLS": "https://www.icloud.com/sharedalbum/#PAUSED_TOKEN",
+			},
+			configJSON: `{"album_urls": [
+				"https://www.icloud.com/sharedalbum/#ALBUM_TOKEN",
+				{"url": "https://www.icloud.com/sharedalbum/#PAUSED_TOKEN", "enabled": false},
+				{"url": "https://www.icloud.com/sharedalbum/#OBJECT_TOKEN"}
+			]}`,
+			wantErr: false,
+			validate: func(t *testing.T, cfg *Config) {
+				want := []string{"https://www.icloud.com/sharedalbum/#ALBUM_TOKEN", "https://www.icloud.com/sharedalbum/#OBJECT_TOKEN"}
+				if !reflect.DeepEqual(cfg.AlbumURLs, want) {
+					t.Errorf("AlbumURLs = %v, want %v", cfg.AlbumURLs, want)
+				}
+				wantDisabled := []string{"https://www.icloud.com/sharedalbum/#PAUSED_TOKEN"}
+				if !reflect.DeepEqual(cfg.DisabledAlbumURLs, wantDisabled) {
+					t.Errorf("DisabledAlbumURLs = %v, want %v", cfg.DisabledAlbumURLs, wantDisabled)
+				}
+			},
+		},
+		{
+			name: "album priorities",
+			env: map[string]string{
+				"ALBUM_URLS": "https://www.icloud.com/sharedalbum/#ENV_TOKEN",
+			},
+			configJSON: `{"album_urls": [
+				"https://www.icloud.com/sharedalbum/#ALBUM_TOKEN",
+				{"url": "https://www.icloud.com/sharedalbum/#KIDS_TOKEN", "priority": 10},
+				{"url": "https://www.icloud.com/sharedalbum/#OLD_TOKEN", "priority": -1}
+			]}`,
+			wantErr: false,
+			validate: func(t *testing.T, cfg *Config) {
+				if len(cfg.AlbumURLs) != 4 || cfg.AlbumURLs[1] != "https://www.icloud.com/sharedalbum/#KIDS_TOKEN" {
+					t.Errorf("AlbumURLs = %v, want the file albums in order, then ALBUM_URLS", cfg.AlbumURLs)
+				}
+				want := []int{0, 10, -1, 0}
+				if !reflect.DeepEqual(cfg.AlbumPriorities, want) {
+					t.Errorf("AlbumPriorities = %v, want %v", cfg.AlbumPriorities, want)
+				}
+			},
+		},
+		{
+			name: "album destinations",
+			env: map[string]string{
+				"ALBUM_URLS": "https://www.icloud.com/sharedalbum/#ENV_TOKEN",
+			},
+			configJSON: `{"album_urls": [
+				"https://www.icloud.com/sharedalbum/#ALBUM_TOKEN",
+				{"url": "https://www.icloud.com/sharedalbum/#KIDS_TOKEN", "destination": "Grandma <grandma@example.com>, frame@example.com"}
+			]}`,
+			wantErr: false,
+			validate: func(t *testing.T, cfg *Config) {
+				want := []string{"", "grandma@example.com, frame@example.com", ""}
+				if !reflect.DeepEqual(cfg.AlbumDestinations, want) {
+					t.Errorf("AlbumDestinations = %q, want %q", cfg.AlbumDestinations, want)
+				}
+			},
+		},
+		{
+			name:       "invalid album destination",
+			configJSON: `{"album_urls": [{"url": "https://www.icloud.com/sharedalbum/#ALBUM_TOKEN", "destination": "grandma at example.com"}]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "every album disabled",
+			configJSON: `{"album_urls": [{"url": "https://www.icloud.com/sharedalbum/#ALBUM_TOKEN", "enabled": false}]}`,
+			wantErr:    true,
+		},
+		{
+			name: "no album URLs in file or environment",
+			env: map[string]string{
+				"ALBUM_URLS": " , ",
+			},
+			configJSON: `{"album_urls": []}`,
+			wantErr:    true,
+		},
+		{
+			name: "pipeline concurrency",
+			env: map[string]string{
+				"DOWNLOAD_CONCURRENCY":      "4",
+				"GOOGLE_PHOTOS_CONCURRENCY": "2",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.DownloadConcurrency != 4 {
+					t.Errorf("DownloadConcurrency = %v, want 4", cfg.DownloadConcurrency)
+				}
+				if cfg.EmailConcurrency != 1 {
+					t.Errorf("EmailConcurrency = %v, want default 1", cfg.EmailConcurrency)
+				}
+				if cfg.GooglePhotosConcurrency != 2 {
+					t.Errorf("GooglePhotosConcurrency = %v, want 2", cfg.GooglePhotosConcurrency)
+				}
+			},
+		},
+		{
+			name: "zero DOWNLOAD_CONCURRENCY",
+			env: map[string]string{
+				"DOWNLOAD_CONCURRENCY": "0",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "custom SMTP_TIMEOUT",
+			env: map[string]string{
+				"SMTP_TIMEOUT": "5",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.SMTPConfig.Timeout != 5 {
+					t.Errorf("SMTPConfig.Timeout = %v, want 5", cfg.SMTPConfig.Timeout)
+				}
+			},
+		},
+		{
+			name: "negative SMTP_TIMEOUT",
+			env: map[string]string{
+				"SMTP_TIMEOUT": "-1",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "newest-first PROCESS_ORDER",
+			env: map[string]string{
+				"PROCESS_ORDER": "newest",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.ProcessOrder != "newest" {
+					t.Errorf("ProcessOrder = %v, want newest", cfg.ProcessOrder)
+				}
+			},
+		},
+		{
+			name: "invalid PROCESS_ORDER",
+			env: map[string]string{
+				"PROCESS_ORDER": "random",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "file type filters",
+			env: map[string]string{
+				"ALLOWED_TYPES": "jpg, PNG,",
+				"BLOCKED_TYPES": "image/gif,video/*",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if want := []string{"image/jpeg", "image/png"}; !reflect.DeepEqual(cfg.AllowedTypes, want) {
+					t.Errorf("AllowedTypes = %v, want %v", cfg.AllowedTypes, want)
+				}
+				if want := []string{"image/gif", "video/*"}; !reflect.DeepEqual(cfg.BlockedTypes, want) {
+					t.Errorf("BlockedTypes = %v, want %v", cfg.BlockedTypes, want)
+				}
+			},
+		},
+		{
+			name: "unknown ALLOWED_TYPES extension",
+			env: map[string]string{
+				"ALLOWED_TYPES": "jpg,notatype",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "negative BACKFILL_MAX_ITEMS",
+			env: map[string]string{
+				"BACKFILL_MAX_ITEMS": "-5",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "S3 upload with custom endpoint",
+			env: map[string]string{
+				"S3_BUCKET":            "photos",
+				"S3_ENDPOINT":          "http://minio:9000",
+				"S3_ACCESS_KEY_ID":     "minio",
+				"S3_SECRET_ACCESS_KEY": "minio-secret",
+				"S3_KEY_FORMAT":        "date",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				want := &S3Config{
+					Bucket:          "photos",
+					Region:          "us-east-1",
+					Endpoint:        "http://minio:9000",
+					AccessKeyID:     "minio",
+					SecretAccessKey: "minio-secret",
+					KeyFormat:       "date",
+					LinkExpiry:      604800,
+				}
+				if !reflect.DeepEqual(cfg.S3Config, want) {
+					t.Errorf("S3Config = %+v, want %+v", cfg.S3Config, want)
+				}
+			},
+		},
+		{
+			name: "S3 bucket without credentials",
+			env: map[string]string{
+				"S3_BUCKET": "photos",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "size-aware download timeout",
+			env: map[string]string{
+				"DOWNLOAD_TIMEOUT":        "15",
+				"DOWNLOAD_TIMEOUT_PER_MB": "2",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.DownloadTimeout != 15 {
+					t.Errorf("DownloadTimeout = %v, want 15", cfg.DownloadTimeout)
+				}
+				if cfg.DownloadTimeoutPerMB != 2 {
+					t.Errorf("DownloadTimeoutPerMB = %v, want 2", cfg.DownloadTimeoutPerMB)
+				}
+			},
+		},
+		{
+			name: "invalid DOWNLOAD_TIMEOUT",
+			env: map[string]string{
+				"DOWNLOAD_TIMEOUT": "1m",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "custom REDIS_KEY_PREFIX",
+			env: map[string]string{
+				"REDIS_KEY_PREFIX": "family-albums:",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.RedisKeyPrefix != "family-albums" {
+					t.Errorf("RedisKeyPrefix = %v, want family-albums", cfg.RedisKeyPrefix)
+				}
+			},
+		},
+		{
+			name: "DELETE_AFTER_UPLOAD enabled",
+			env: map[string]string{
+				"DELETE_AFTER_UPLOAD": "true",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if !cfg.DeleteAfterUpload {
+					t.Error("DeleteAfterUpload = false, want true")
+				}
+			},
+		},
+		{
+			name: "invalid DELETE_AFTER_UPLOAD",
+			env: map[string]string{
+				"DELETE_AFTER_UPLOAD": "sometimes",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "default connection limits",
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.MaxConnsPerHost != 8 {
+					t.Errorf("MaxConnsPerHost = %v, want 8", cfg.MaxConnsPerHost)
+				}
+				if cfg.MaxIdleConnsPerHost != 8 {
+					t.Errorf("MaxIdleConnsPerHost = %v, want 8", cfg.MaxIdleConnsPerHost)
+				}
+				if cfg.MaxDownloadsPerHost != 0 {
+					t.Errorf("MaxDownloadsPerHost = %v, want 0", cfg.MaxDownloadsPerHost)
+				}
+			},
+		},
+		{
+			name: "custom connection limits",
+			env: map[string]string{
+				"MAX_CONNS_PER_HOST":      "0",
+				"MAX_IDLE_CONNS_PER_HOST": "2",
+				"MAX_DOWNLOADS_PER_HOST":  "3",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.MaxConnsPerHost != 0 {
+					t.Errorf("MaxConnsPerHost = %v, want 0", cfg.MaxConnsPerHost)
+				}
+				if cfg.MaxIdleConnsPerHost != 2 {
+					t.Errorf("MaxIdleConnsPerHost = %v, want 2", cfg.MaxIdleConnsPerHost)
+				}
+				if cfg.MaxDownloadsPerHost != 3 {
+					t.Errorf("MaxDownloadsPerHost = %v, want 3", cfg.MaxDownloadsPerHost)
+				}
+			},
+		},
+		{
+			name: "negative MAX_CONNS_PER_HOST",
+			env: map[string]string{
+				"MAX_CONNS_PER_HOST": "-1",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "negative MAX_DOWNLOADS_PER_HOST",
+			env: map[string]string{
+				"MAX_DOWNLOADS_PER_HOST": "-2",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "zero MAX_IDLE_CONNS_PER_HOST",
+			env: map[string]string{
+				"MAX_IDLE_CONNS_PER_HOST": "0",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "EMAIL_SUBJECT_PREFIX",
+			env: map[string]string{
+				"EMAIL_SUBJECT_PREFIX": " [Photos] ",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.SMTPConfig.SubjectPrefix != "[Photos]" {
+					t.Errorf("SubjectPrefix = %q, want [Photos]", cfg.SMTPConfig.SubjectPrefix)
+				}
+			},
+		},
+		{
+			name: "QUIET_HOURS with time zone",
+			env: map[string]string{
+				"QUIET_HOURS":           "22:00-07:30 America/New_York",
+				"QUIET_HOURS_NOTIFIERS": "email, google_photos",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.QuietHours == nil {
+					t.Fatal("QuietHours = nil, want 22:00-07:30")
+				}
+				if cfg.QuietHours.Start != 22*60 || cfg.QuietHours.End != 7*60+30 {
+					t.Errorf("QuietHours = %d-%d, want %d-%d", cfg.QuietHours.Start, cfg.QuietHours.End, 22*60, 7*60+30)
+				}
+				if cfg.QuietHours.Location.String() != "America/New_York" {
+					t.Errorf("QuietHours.Location = %v, want America/New_York", cfg.QuietHours.Location)
+				}
+				if !reflect.DeepEqual(cfg.QuietHours.Notifiers, []string{"email", "google_photos"}) {
+					t.Errorf("QuietHours.Notifiers = %v, want [email google_photos]", cfg.QuietHours.Notifiers)
+				}
+			},
+		},
+		{
+			name: "QUIET_HOURS defaults to pausing email",
+			env: map[string]string{
+				"QUIET_HOURS": "22:00-07:00",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.QuietHours == nil || !reflect.DeepEqual(cfg.QuietHours.Notifiers, []string{"email"}) {
+					t.Errorf("QuietHours = %+v, want email paused", cfg.QuietHours)
+				}
+			},
+		},
+		{
+			name: "invalid QUIET_HOURS range",
+			env: map[string]string{
+				"QUIET_HOURS": "22:00",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid QUIET_HOURS time",
+			env: map[string]string{
+				"QUIET_HOURS": "25:00-07:00",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "empty QUIET_HOURS window",
+			env: map[string]string{
+				"QUIET_HOURS": "07:00-07:00",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "unknown QUIET_HOURS time zone",
+			env: map[string]string{
+				"QUIET_HOURS": "22:00-07:00 Mars/Olympus",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "unknown QUIET_HOURS_NOTIFIERS name",
+			env: map[string]string{
+				"QUIET_HOURS":           "22:00-07:00",
+				"QUIET_HOURS_NOTIFIERS": "pager",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "default DEDUP_KEY",
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.DedupKey != "hash" {
+					t.Errorf("DedupKey = %v, want hash", cfg.DedupKey)
+				}
+			},
+		},
+		{
+			name: "DEDUP_KEY guid",
+			env: map[string]string{
+				"DEDUP_KEY": "guid",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.DedupKey != "guid" {
+					t.Errorf("DedupKey = %v, want guid", cfg.DedupKey)
+				}
+			},
+		},
+		{
+			name: "invalid DEDUP_KEY",
+			env: map[string]string{
+				"DEDUP_KEY": "exif",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "LOG_LEVEL debug",
+			env: map[string]string{
+				"LOG_LEVEL": "debug",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.LogLevel != logging.LevelDebug {
+					t.Errorf("LogLevel = %v, want debug", cfg.LogLevel)
+				}
+			},
+		},
+		{
+			name: "invalid LOG_LEVEL",
+			env: map[string]string{
+				"LOG_LEVEL": "loud",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "EMAIL_ATTACHMENT medium with archive link",
+			env: map[string]string{
+				"EMAIL_ATTACHMENT":  "medium",
+				"EMAIL_MEDIUM_SIZE": "800",
+				"ARCHIVE_DIR":       "/archive",
+				"ARCHIVE_BASE_URL":  "https://photos.example.com/archive",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.EmailAttachment != "medium" || cfg.EmailMediumSize != 800 {
+					t.Errorf("EmailAttachment = %v (%d), want medium (800)", cfg.EmailAttachment, cfg.EmailMediumSize)
+				}
+				if cfg.ArchiveBaseURL != "https://photos.example.com/archive" {
+					t.Errorf("ArchiveBaseURL = %v, want https://photos.example.com/archive", cfg.ArchiveBaseURL)
+				}
+			},
+		},
+		{
+			name: "EMAIL_ATTACHMENT medium with S3",
+			env: map[string]string{
+				"EMAIL_ATTACHMENT":     "medium",
+				"S3_BUCKET":            "photos",
+				"S3_ACCESS_KEY_ID":     "key",
+				"S3_SECRET_ACCESS_KEY": "secret",
+				"S3_LINK_EXPIRY":       "3600",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.S3Config == nil || cfg.S3Config.LinkExpiry != 3600 {
+					t.Errorf("S3Config = %+v, want LinkExpiry 3600", cfg.S3Config)
+				}
+				if cfg.EmailMediumSize != 1280 {
+					t.Errorf("EmailMediumSize = %d, want default 1280", cfg.EmailMediumSize)
+				}
+			},
+		},
+		{
+			name: "EMAIL_ATTACHMENT medium without a host for originals",
+			env: map[string]string{
+				"EMAIL_ATTACHMENT": "medium",
+				"ARCHIVE_DIR":      "/archive",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid EMAIL_ATTACHMENT",
+			env: map[string]string{
+				"EMAIL_ATTACHMENT": "tiny",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "EMAIL_ATTACHMENT thumbnail without a host for originals",
+			env: map[string]string{
+				"EMAIL_ATTACHMENT": "thumbnail",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.EmailAttachment != "thumbnail" || cfg.EmailThumbnailSize != 320 {
+					t.Errorf("EmailAttachment = %q, EmailThumbnailSize = %d, want thumbnail and default 320", cfg.EmailAttachment, cfg.EmailThumbnailSize)
+				}
+			},
+		},
+		{
+			name: "custom EMAIL_THUMBNAIL_SIZE",
+			env: map[string]string{
+				"EMAIL_ATTACHMENT":     "thumbnail",
+				"EMAIL_THUMBNAIL_SIZE": "200",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.EmailThumbnailSize != 200 {
+					t.Errorf("EmailThumbnailSize = %d, want 200", cfg.EmailThumbnailSize)
+				}
+			},
+		},
+		{
+			name: "zero EMAIL_THUMBNAIL_SIZE",
+			env: map[string]string{
+				"EMAIL_THUMBNAIL_SIZE": "0",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid S3_LINK_EXPIRY",
+			env: map[string]string{
+				"S3_BUCKET":            "photos",
+				"S3_ACCESS_KEY_ID":     "key",
+				"S3_SECRET_ACCESS_KEY": "secret",
+				"S3_LINK_EXPIRY":       "604801",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid ARCHIVE_BASE_URL",
+			env: map[string]string{
+				"ARCHIVE_BASE_URL": "photos.example.com",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "GOOGLE_PHOTOS_VERIFY_UPLOADS enabled",
+			env: map[string]string{
+				"GOOGLE_PHOTOS_CLIENT_ID":      "gphotos-client-id",
+				"GOOGLE_PHOTOS_CLIENT_SECRET":  "gphotos-secret",
+				"GOOGLE_PHOTOS_REFRESH_TOKEN":  "gphotos-refresh-token",
+				"GOOGLE_PHOTOS_VERIFY_UPLOADS": "true",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.GooglePhotosConfig == nil {
+					t.Fatal("GooglePhotosConfig is nil, want configured")
+				}
+				if !cfg.GooglePhotosConfig.VerifyUploads {
+					t.Error("GooglePhotosConfig.VerifyUploads = false, want true")
+				}
+			},
+		},
+		{
+			name: "invalid GOOGLE_PHOTOS_VERIFY_UPLOADS",
+			env: map[string]string{
+				"GOOGLE_PHOTOS_VERIFY_UPLOADS": "maybe",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "sendgrid backend without SMTP server",
+			env: map[string]string{
+				"SMTP_SERVER":   "",
+				"SMTP_PORT":     "",
+				"SMTP_USERNAME": "",
+				"SMTP_PASSWORD": "",
+				"SMTP_FROM":     "photos@example.com",
+				"EMAIL_BACKEND": "sendgrid",
+				"EMAIL_API_KEY": "sg-key",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.SMTPConfig.Backend != "sendgrid" {
+					t.Errorf("SMTPConfig.Backend = %v, want sendgrid", cfg.SMTPConfig.Backend)
+				}
+				if cfg.SMTPConfig.APIKey != "sg-key" {
+					t.Errorf("SMTPConfig.APIKey = %v, want sg-key", cfg.SMTPConfig.APIKey)
+				}
+				if cfg.SMTPConfig.From != "photos@example.com" {
+					t.Errorf("SMTPConfig.From = %v, want photos@example.com", cfg.SMTPConfig.From)
+				}
+			},
+		},
+		{
+			name: "mailgun backend",
+			env: map[string]string{
+				"SMTP_SERVER":    "",
+				"SMTP_PORT":      "",
+				"SMTP_USERNAME":  "",
+				"SMTP_PASSWORD":  "",
+				"SMTP_FROM":      "photos@example.com",
+				"EMAIL_BACKEND":  "mailgun",
+				"EMAIL_API_KEY":  "mg-key",
+				"EMAIL_API_URL":  "https://api.eu.mailgun.net/",
+				"MAILGUN_DOMAIN": "mg.example.com",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.SMTPConfig.MailgunDomain != "mg.example.com" {
+					t.Errorf("SMTPConfig.MailgunDomain = %v, want mg.example.com", cfg.SMTPConfig.MailgunDomain)
+				}
+				if cfg.SMTPConfig.APIURL != "https://api.eu.mailgun.net" {
+					t.Errorf("SMTPConfig.APIURL = %v, want https://api.eu.mailgun.net", cfg.SMTPConfig.APIURL)
+				}
+			},
+		},
+		{
+			name: "mailgun backend without MAILGUN_DOMAIN",
+			env: map[string]string{
+				"SMTP_SERVER":   "",
+				"SMTP_PORT":     "",
+				"SMTP_USERNAME": "",
+				"SMTP_PASSWORD": "",
+				"SMTP_FROM":     "photos@example.com",
+				"EMAIL_BACKEND": "mailgun",
+				"EMAIL_API_KEY": "mg-key",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "sendgrid backend without EMAIL_API_KEY",
+			env: map[string]string{
+				"SMTP_SERVER":   "",
+				"SMTP_PORT":     "",
+				"SMTP_USERNAME": "",
+				"SMTP_PASSWORD": "",
+				"SMTP_FROM":     "photos@example.com",
+				"EMAIL_BACKEND": "sendgrid",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "sendgrid backend without SMTP_FROM",
+			env: map[string]string{
+				"SMTP_SERVER":   "",
+				"SMTP_PORT":     "",
+				"SMTP_USERNAME": "",
+				"SMTP_PASSWORD": "",
+				"EMAIL_BACKEND": "sendgrid",
+				"EMAIL_API_KEY": "sg-key",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid EMAIL_BACKEND",
+			env: map[string]string{
+				"EMAIL_BACKEND": "ses",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "MAX_RUN_DURATION",
+			env: map[string]string{
+				"MAX_RUN_DURATION": "1800",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.MaxRunDuration != 1800 {
+					t.Errorf("MaxRunDuration = %v, want 1800", cfg.MaxRunDuration)
+				}
+			},
+		},
+		{
+			name: "negative MAX_RUN_DURATION",
+			env: map[string]string{
+				"MAX_RUN_DURATION": "-60",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "SYNC_LOCK enabled",
+			env: map[string]string{
+				"SYNC_LOCK": "true",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if !cfg.SyncLock {
+					t.Error("SyncLock = false, want true")
+				}
+				if cfg.SyncLockTTL != 120 {
+					t.Errorf("SyncLockTTL = %v, want 120", cfg.SyncLockTTL)
+				}
+			},
+		},
+		{
+			name: "invalid SYNC_LOCK",
+			env: map[string]string{
+				"SYNC_LOCK": "always",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "SYNC_LOCK_TTL too short",
+			env: map[string]string{
+				"SYNC_LOCK":     "true",
+				"SYNC_LOCK_TTL": "5",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "NORMALIZE_ORIENTATION enabled",
+			env: map[string]string{
+				"NORMALIZE_ORIENTATION": "true",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if !cfg.NormalizeOrientation {
+					t.Error("NormalizeOrientation = false, want true")
+				}
+			},
+		},
+		{
+			name: "invalid NORMALIZE_ORIENTATION",
+			env: map[string]string{
+				"NORMALIZE_ORIENTATION": "upright",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "EXTRA_CA_CERT applies to Google Photos",
+			env: map[string]string{
+				"GOOGLE_PHOTOS_CLIENT_ID":     "gphotos-client-id",
+				"GOOGLE_PHOTOS_CLIENT_SECRET": "gphotos-secret",
+				"GOOGLE_PHOTOS_REFRESH_TOKEN": "gphotos-refresh-token",
+				"EXTRA_CA_CERT":               "/etc/ssl/proxy-ca.pem",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.ExtraCACert != "/etc/ssl/proxy-ca.pem" {
+					t.Errorf("ExtraCACert = %v, want /etc/ssl/proxy-ca.pem", cfg.ExtraCACert)
+				}
+				if cfg.GooglePhotosConfig == nil || cfg.GooglePhotosConfig.CACertPath != "/etc/ssl/proxy-ca.pem" {
+					t.Errorf("GooglePhotosConfig = %+v, want CACertPath /etc/ssl/proxy-ca.pem", cfg.GooglePhotosConfig)
+				}
+			},
+		},
+		{
+			name: "GOOGLE_PHOTOS_SKIP_IF_IN_ALBUM enabled",
+			env: map[string]string{
+				"GOOGLE_PHOTOS_CLIENT_ID":        "gphotos-client-id",
+				"GOOGLE_PHOTOS_CLIENT_SECRET":    "gphotos-secret",
+				"GOOGLE_PHOTOS_REFRESH_TOKEN":    "gphotos-refresh-token",
+				"GOOGLE_PHOTOS_SKIP_IF_IN_ALBUM": "true",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.GooglePhotosConfig == nil || !cfg.GooglePhotosConfig.SkipIfInAlbum {
+					t.Errorf("GooglePhotosConfig = %+v, want SkipIfInAlbum", cfg.GooglePhotosConfig)
+				}
+			},
+		},
+		{
+			name: "invalid GOOGLE_PHOTOS_SKIP_IF_IN_ALBUM",
+			env: map[string]string{
+				"GOOGLE_PHOTOS_CLIENT_ID":        "gphotos-client-id",
+				"GOOGLE_PHOTOS_CLIENT_SECRET":    "gphotos-secret",
+				"GOOGLE_PHOTOS_REFRESH_TOKEN":    "gphotos-refresh-token",
+				"GOOGLE_PHOTOS_SKIP_IF_IN_ALBUM": "sometimes",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "missing ENV_FILE",
+			env: map[string]string{
+				"ENV_FILE": filepath.Join(tmpDir, "missing.env"),
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "Immich enabled",
+			env: map[string]string{
+				"IMMICH_URL":     "https://immich.example.com/",
+				"IMMICH_API_KEY": "immich-key",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.ImmichConfig == nil {
+					t.Fatal("ImmichConfig is nil, want configured")
+				}
+				if cfg.ImmichConfig.URL != "https://immich.example.com" || cfg.ImmichConfig.APIKey != "immich-key" {
+					t.Errorf("ImmichConfig = %+v, want URL without trailing slash and the API key", cfg.ImmichConfig)
+				}
+			},
+		},
+		{
+			name: "IMMICH_URL without IMMICH_API_KEY",
+			env: map[string]string{
+				"IMMICH_URL": "https://immich.example.com",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid IMMICH_URL",
+			env: map[string]string{
+				"IMMICH_URL":     "immich.example.com",
+				"IMMICH_API_KEY": "immich-key",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "Telegram and Slack enabled",
+			env: map[string]string{
+				"TELEGRAM_BOT_TOKEN": "123:abc",
+				"TELEGRAM_CHAT_ID":   "-1001234567890",
+				"TELEGRAM_API_URL":   "http://telegram-bot-api:8081/",
+				"SLACK_BOT_TOKEN":    "xoxb-token",
+				"SLACK_CHANNEL_ID":   "C0123456789",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.TelegramConfig == nil || cfg.SlackConfig == nil {
+					t.Fatalf("TelegramConfig = %v, SlackConfig = %v, want both configured", cfg.TelegramConfig, cfg.SlackConfig)
+				}
+				want := TelegramConfig{BotToken: "123:abc", ChatID: "-1001234567890", APIURL: "http://telegram-bot-api:8081"}
+				if *cfg.TelegramConfig != want {
+					t.Errorf("TelegramConfig = %+v, want %+v", *cfg.TelegramConfig, want)
+				}
+				if cfg.SlackConfig.BotToken != "xoxb-token" || cfg.SlackConfig.ChannelID != "C0123456789" {
+					t.Errorf("SlackConfig = %+v, want the bot token and channel", *cfg.SlackConfig)
+				}
+			},
+		},
+		{
+			name: "TELEGRAM_BOT_TOKEN without TELEGRAM_CHAT_ID",
+			env: map[string]string{
+				"TELEGRAM_BOT_TOKEN": "123:abc",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid TELEGRAM_API_URL",
+			env: map[string]string{
+				"TELEGRAM_BOT_TOKEN": "123:abc",
+				"TELEGRAM_CHAT_ID":   "@family",
+				"TELEGRAM_API_URL":   "telegram-bot-api:8081",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "SLACK_BOT_TOKEN without SLACK_CHANNEL_ID",
+			env: map[string]string{
+				"SLACK_BOT_TOKEN": "xoxb-token",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "custom EMAIL_MAX_ATTEMPTS",
+			env: map[string]string{
+				"EMAIL_MAX_ATTEMPTS": "3",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.EmailMaxAttempts != 3 {
+					t.Errorf("EmailMaxAttempts = %v, want 3", cfg.EmailMaxAttempts)
+				}
+			},
+		},
+		{
+			name: "negative EMAIL_MAX_ATTEMPTS",
+			env: map[string]string{
+				"EMAIL_MAX_ATTEMPTS": "-1",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "EMAIL_DERIVATIVE medium",
+			env: map[string]string{
+				"EMAIL_DERIVATIVE": "medium",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.EmailDerivative != "medium" {
+					t.Errorf("EmailDerivative = %v, want medium", cfg.EmailDerivative)
+				}
+			},
+		},
+		{
+			name: "invalid EMAIL_DERIVATIVE",
+			env: map[string]string{
+				"EMAIL_DERIVATIVE": "thumbnail",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "ARCHIVE_NAME_TEMPLATE set",
+			env: map[string]string{
+				"ARCHIVE_NAME_TEMPLATE": `{{.Date.Format "2006/01"}}/{{.Album}}/{{.OriginalName}}{{.Ext}}`,
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.ArchiveNameTemplate != `{{.Date.Format "2006/01"}}/{{.Album}}/{{.OriginalName}}{{.Ext}}` {
+					t.Errorf("ArchiveNameTemplate = %v", cfg.ArchiveNameTemplate)
+				}
+			},
+		},
+		{
+			name: "invalid ARCHIVE_NAME_TEMPLATE",
+			env: map[string]string{
+				"ARCHIVE_NAME_TEMPLATE": "{{.Album",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "INITIAL_SYNC_MODE mark-seen-only",
+			env: map[string]string{
+				"INITIAL_SYNC_MODE": "mark-seen-only",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.InitialSyncMode != "mark-seen-only" {
+					t.Errorf("InitialSyncMode = %v, want mark-seen-only", cfg.InitialSyncMode)
+				}
+			},
+		},
+		{
+			name: "invalid INITIAL_SYNC_MODE",
+			env: map[string]string{
+				"INITIAL_SYNC_MODE": "silent",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "monthly GOOGLE_PHOTOS_ALBUM_ROTATION",
+			env: map[string]string{
+				"GOOGLE_PHOTOS_CLIENT_ID":      "gphotos-client-id",
+				"GOOGLE_PHOTOS_CLIENT_SECRET":  "gphotos-secret",
+				"GOOGLE_PHOTOS_REFRESH_TOKEN":  "gphotos-refresh-token",
+				"GOOGLE_PHOTOS_ALBUM_NAME":     "iCloud Sync",
+				"GOOGLE_PHOTOS_ALBUM_ROTATION": "monthly",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.GooglePhotosConfig == nil || cfg.GooglePhotosConfig.AlbumRotation != "monthly" {
+					t.Errorf("GooglePhotosConfig = %+v, want AlbumRotation monthly", cfg.GooglePhotosConfig)
+				}
+			},
+		},
+		{
+			name: "invalid GOOGLE_PHOTOS_ALBUM_ROTATION",
+			env: map[string]string{
+				"GOOGLE_PHOTOS_CLIENT_ID":      "gphotos-client-id",
+				"GOOGLE_PHOTOS_CLIENT_SECRET":  "gphotos-secret",
+				"GOOGLE_PHOTOS_REFRESH_TOKEN":  "gphotos-refresh-token",
+				"GOOGLE_PHOTOS_ALBUM_NAME":     "iCloud Sync",
+				"GOOGLE_PHOTOS_ALBUM_ROTATION": "weekly",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "GOOGLE_PHOTOS_ALBUM_ROTATION without GOOGLE_PHOTOS_ALBUM_NAME",
+			env: map[string]string{
+				"GOOGLE_PHOTOS_CLIENT_ID":      "gphotos-client-id",
+				"GOOGLE_PHOTOS_CLIENT_SECRET":  "gphotos-secret",
+				"GOOGLE_PHOTOS_REFRESH_TOKEN":  "gphotos-refresh-token",
+				"GOOGLE_PHOTOS_ALBUM_ROTATION": "yearly",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "custom MAX_DOWNLOAD_BYTES_PER_SEC",
+			env: map[string]string{
+				"MAX_DOWNLOAD_BYTES_PER_SEC": "2000000",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.MaxDownloadBytesPerSec != 2000000 {
+					t.Errorf("MaxDownloadBytesPerSec = %d, want 2000000", cfg.MaxDownloadBytesPerSec)
+				}
+			},
+		},
+		{
+			name: "negative MAX_DOWNLOAD_BYTES_PER_SEC",
+			env: map[string]string{
+				"MAX_DOWNLOAD_BYTES_PER_SEC": "-1",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "MIN_IMAGE_WIDTH and MIN_IMAGE_HEIGHT",
+			env: map[string]string{
+				"MIN_IMAGE_WIDTH":  "640",
+				"MIN_IMAGE_HEIGHT": "480",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.MinImageWidth != 640 || cfg.MinImageHeight != 480 {
+					t.Errorf("MinImageWidth, MinImageHeight = %d, %d, want 640, 480", cfg.MinImageWidth, cfg.MinImageHeight)
+				}
+			},
+		},
+		{
+			name: "negative MIN_IMAGE_WIDTH",
+			env: map[string]string{
+				"MIN_IMAGE_WIDTH": "-1",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid MIN_IMAGE_HEIGHT",
+			env: map[string]string{
+				"MIN_IMAGE_HEIGHT": "tall",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "REDIS_REPLICA_URL",
+			env: map[string]string{
+				"REDIS_REPLICA_URL": "redis://replica:6379",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.RedisReplicaURL != "redis://replica:6379" {
+					t.Errorf("RedisReplicaURL = %q, want redis://replica:6379", cfg.RedisReplicaURL)
+				}
+			},
+		},
+		{
+			name: "custom IMAGE_DIR_MODE and IMAGE_FILE_MODE",
+			env: map[string]string{
+				"IMAGE_DIR_MODE":  "0750",
+				"IMAGE_FILE_MODE": "640",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.ImageDirMode != 0750 || cfg.ImageFileMode != 0640 {
+					t.Errorf("ImageDirMode, ImageFileMode = %04o, %04o, want 0750, 0640", cfg.ImageDirMode, cfg.ImageFileMode)
+				}
+			},
+		},
+		{
+			name: "invalid IMAGE_DIR_MODE",
+			env: map[string]string{
+				"IMAGE_DIR_MODE": "0789",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "IMAGE_FILE_MODE out of range",
+			env: map[string]string{
+				"IMAGE_FILE_MODE": "01777",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "custom MIN_PHOTO_AGE",
+			env: map[string]string{
+				"MIN_PHOTO_AGE": "900",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.MinPhotoAge != 900 {
+					t.Errorf("MinPhotoAge = %d, want 900", cfg.MinPhotoAge)
+				}
+			},
+		},
+		{
+			name: "negative MIN_PHOTO_AGE",
+			env: map[string]string{
+				"MIN_PHOTO_AGE": "-1",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid MIN_PHOTO_AGE",
+			env: map[string]string{
+				"MIN_PHOTO_AGE": "15m",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "AUDIT_LOG defaults",
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.AuditLog != "" {
+					t.Errorf("AuditLog = %q, want empty", cfg.AuditLog)
+				}
+				if cfg.AuditLogMaxBytes != 100*1024*1024 {
+					t.Errorf("AuditLogMaxBytes = %d, want 100 MB", cfg.AuditLogMaxBytes)
+				}
+			},
+		},
+		{
+			name: "custom AUDIT_LOG",
+			env: map[string]string{
+				"AUDIT_LOG":        "/var/log/icloud-photo-sync/audit.log",
+				"AUDIT_LOG_MAX_MB": "0",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.AuditLog != "/var/log/icloud-photo-sync/audit.log" {
+					t.Errorf("AuditLog = %q, want /var/log/icloud-photo-sync/audit.log", cfg.AuditLog)
+				}
+				if cfg.AuditLogMaxBytes != 0 {
+					t.Errorf("AuditLogMaxBytes = %d, want 0", cfg.AuditLogMaxBytes)
+				}
+			},
+		},
+		{
+			name: "negative AUDIT_LOG_MAX_MB",
+			env: map[string]string{
+				"AUDIT_LOG_MAX_MB": "-1",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid AUDIT_LOG_MAX_MB",
+			env: map[string]string{
+				"AUDIT_LOG_MAX_MB": "1GB",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "custom SCRAPER_RETRIES",
+			env: map[string]string{
+				"SCRAPER_RETRIES": "0",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.ScraperRetries != 0 {
+					t.Errorf("ScraperRetries = %d, want 0", cfg.ScraperRetries)
+				}
+			},
+		},
+		{
+			name: "negative SCRAPER_RETRIES",
+			env: map[string]string{
+				"SCRAPER_RETRIES": "-1",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid SCRAPER_RETRIES",
+			env: map[string]string{
+				"SCRAPER_RETRIES": "many",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "custom SCRAPE_CACHE_TTL",
+			env: map[string]string{
+				"SCRAPE_CACHE_TTL": "600",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.ScrapeCacheTTL != 600 {
+					t.Errorf("ScrapeCacheTTL = %d, want 600", cfg.ScrapeCacheTTL)
+				}
+			},
+		},
+		{
+			name: "negative SCRAPE_CACHE_TTL",
+			env: map[string]string{
+				"SCRAPE_CACHE_TTL": "-1",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid SCRAPE_CACHE_TTL",
+			env: map[string]string{
+				"SCRAPE_CACHE_TTL": "10m",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "ICLOUD_COOKIE and ICLOUD_HEADERS",
+			env: map[string]string{
+				"ICLOUD_COOKIE":  "X-APPLE-WEBAUTH-TOKEN=abc; X-APPLE-WEBAUTH-USER=def",
+				"ICLOUD_HEADERS": "X-Apple-Custom: 1\nuser-agent:  Mozilla/5.0 (KHTML, like Gecko)\n",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if got := cfg.ICloudHeader.Get("Cookie"); got != "X-APPLE-WEBAUTH-TOKEN=abc; X-APPLE-WEBAUTH-USER=def" {
+					t.Errorf("Cookie = %q, want ICLOUD_COOKIE", got)
+				}
+				if got := cfg.ICloudHeader.Get("X-Apple-Custom"); got != "1" {
+					t.Errorf("X-Apple-Custom = %q, want 1", got)
+				}
+				if got := cfg.ICloudHeader.Get("User-Agent"); got != "Mozilla/5.0 (KHTML, like Gecko)" {
+					t.Errorf("User-Agent = %q, want Mozilla/5.0 (KHTML, like Gecko)", got)
+				}
+			},
+		},
+		{
+			name: "ICLOUD_HEADERS without a colon",
+			env: map[string]string{
+				"ICLOUD_HEADERS": "X-Apple-Custom 1",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "ICLOUD_HEADERS with an empty name",
+			env: map[string]string{
+				"ICLOUD_HEADERS": ": value",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "WELCOME_EMAIL enabled",
+			env: map[string]string{
+				"WELCOME_EMAIL": "true",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if !cfg.WelcomeEmail {
+					t.Error("Expected WelcomeEmail to be true")
+				}
+			},
+		},
+		{
+			name: "invalid WELCOME_EMAIL",
+			env: map[string]string{
+				"WELCOME_EMAIL": "sometimes",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "RUN_PROGRESS_TTL set",
+			env: map[string]string{
+				"RUN_PROGRESS_TTL": "86400",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.RunProgressTTL != 86400 {
+					t.Errorf("Expected RunProgressTTL 86400, got %d", cfg.RunProgressTTL)
+				}
+			},
+		},
+		{
+			name: "negative RUN_PROGRESS_TTL",
+			env: map[string]string{
+				"RUN_PROGRESS_TTL": "-1",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid RUN_PROGRESS_TTL",
+			env: map[string]string{
+				"RUN_PROGRESS_TTL": "1d",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "SMTP_FROM with display name",
 			env: map[string]string{
-				"REDIS_URL":        "redis://localhost:6379",
-				"SMTP_SERVER":      "smtp.example.com",
-				"SMTP_PORT":        "587",
-				"SMTP_USERNAME":    "user@example.com",
-				"SMTP_PASSWORD":    "password",
-				"SMTP_DESTINATION": "dest@example.com",
-				"IMAGE_DIR":        tmpDir,
+				"SMTP_FROM": "iCloud Sync <sync@example.com>",
 			},
-			configJSON: `{"album_urls": ["https://example.com/album1", "https://example.com/album2"]}`,
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
 			wantErr:    false,
 			validate: func(t *testing.T, cfg *Config) {
-				if len(cfg.AlbumURLs) != 2 {
-					t.Errorf("AlbumURLs length = %v, want 2", len(cfg.AlbumURLs))
+				if cfg.SMTPConfig.From != "sync@example.com" || cfg.SMTPConfig.FromName != "iCloud Sync" {
+					t.Errorf("SMTPConfig.From = %q, FromName = %q, want sync@example.com and iCloud Sync", cfg.SMTPConfig.From, cfg.SMTPConfig.FromName)
 				}
-				if cfg.AlbumURLs[0] != "https://example.com/album1" {
-					t.Errorf("AlbumURLs[0] = %v, want https://example.com/album1", cfg.AlbumURLs[0])
+			},
+		},
+		{
+			name: "SMTP_FROM plain address",
+			env: map[string]string{
+				"SMTP_FROM": "sync@example.com",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.SMTPConfig.From != "sync@example.com" || cfg.SMTPConfig.FromName != "" {
+					t.Errorf("SMTPConfig.From = %q, FromName = %q, want sync@example.com without a name", cfg.SMTPConfig.From, cfg.SMTPConfig.FromName)
 				}
 			},
 		},
 		{
-			name: "missing config file",
+			name: "invalid SMTP_FROM",
 			env: map[string]string{
-				"REDIS_URL":        "redis://localhost:6379",
-				"SMTP_SERVER":      "smtp.example.com",
-				"SMTP_PORT":        "587",
-				"SMTP_USERNAME":    "user@example.com",
-				"SMTP_PASSWORD":    "password",
-				"SMTP_DESTINATION": "dest@example.com",
-				"IMAGE_DIR":        tmpDir,
+				"SMTP_FROM": "iCloud Sync",
 			},
-			configJSON: "",
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
 			wantErr:    true,
 		},
 		{
-			name: "empty album URLs",
+			name: "EMAIL_CONTACT_SHEET only",
 			env: map[string]string{
-				"REDIS_URL":        "redis://localhost:6379",
-				"SMTP_SERVER":      "smtp.example.com",
-				"SMTP_PORT":        "587",
-				"SMTP_USERNAME":    "user@example.com",
-				"SMTP_PASSWORD":    "password",
-				"SMTP_DESTINATION": "dest@example.com",
-				"IMAGE_DIR":        tmpDir,
+				"EMAIL_CONTACT_SHEET":      "only",
+				"CONTACT_SHEET_COLUMNS":    "6",
+				"CONTACT_SHEET_THUMB_SIZE": "200",
 			},
-			configJSON: `{"album_urls": []}`,
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.EmailContactSheet != "only" || cfg.ContactSheetColumns != 6 || cfg.ContactSheetThumbSize != 200 {
+					t.Errorf("Contact sheet = %s, %d columns, %dpx, want only, 6 columns, 200px", cfg.EmailContactSheet, cfg.ContactSheetColumns, cfg.ContactSheetThumbSize)
+				}
+			},
+		},
+		{
+			name: "invalid EMAIL_CONTACT_SHEET",
+			env: map[string]string{
+				"EMAIL_CONTACT_SHEET": "digest",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
 			wantErr:    true,
 		},
 		{
-			name: "with optional fields",
+			name: "EMAIL_CONTACT_SHEET only with EMAIL_ZIP",
+			env: map[string]string{
+				"EMAIL_CONTACT_SHEET": "only",
+				"EMAIL_ZIP":           "true",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "EMAIL_ATTACHMENT thumbnail with EMAIL_ZIP",
+			env: map[string]string{
+				"EMAIL_ATTACHMENT": "thumbnail",
+				"EMAIL_ZIP":        "true",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "EMAIL_ATTACHMENT thumbnail with EMAIL_CONTACT_SHEET only",
+			env: map[string]string{
+				"EMAIL_ATTACHMENT":    "thumbnail",
+				"EMAIL_CONTACT_SHEET": "only",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "zero CONTACT_SHEET_COLUMNS",
+			env: map[string]string{
+				"EMAIL_CONTACT_SHEET":   "also",
+				"CONTACT_SHEET_COLUMNS": "0",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid CONTACT_SHEET_THUMB_SIZE",
+			env: map[string]string{
+				"CONTACT_SHEET_THUMB_SIZE": "big",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "Redis timeouts",
 			env: map[string]string{
-				"REDIS_URL":        "redis://localhost:6379",
-				"SMTP_SERVER":      "smtp.example.com",
-				"SMTP_PORT":        "587",
-				"SMTP_USERNAME":    "user@example.com",
-				"SMTP_PASSWORD":    "password",
-				"SMTP_DESTINATION": "dest@example.com",
-				"RUN_INTERVAL":     "1800",
-				"MAX_ITEMS":        "10",
-				"IMAGE_DIR":        tmpDir,
+				"REDIS_DIAL_TIMEOUT":  "2",
+				"REDIS_READ_TIMEOUT":  "10",
+				"REDIS_WRITE_TIMEOUT": "5",
 			},
-			configJSON: `{"album_urls": ["https://example.com/album"]}`,
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
 			wantErr:    false,
 			validate: func(t *testing.T, cfg *Config) {
-				if cfg.RunInterval != 1800 {
-					t.Errorf("RunInterval = %v, want 1800", cfg.RunInterval)
+				if cfg.RedisDialTimeout != 2 || cfg.RedisReadTimeout != 10 || cfg.RedisWriteTimeout != 5 {
+					t.Errorf("Redis timeouts = %d/%d/%d, want 2/10/5", cfg.RedisDialTimeout, cfg.RedisReadTimeout, cfg.RedisWriteTimeout)
 				}
-				if cfg.MaxItems != 10 {
-					t.Errorf("MaxItems = %v, want 10", cfg.MaxItems)
+			},
+		},
+		{
+			name: "negative REDIS_READ_TIMEOUT",
+			env: map[string]string{
+				"REDIS_READ_TIMEOUT": "-1",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid REDIS_DIAL_TIMEOUT",
+			env: map[string]string{
+				"REDIS_DIAL_TIMEOUT": "5s",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "VERIFY_EXISTING_FILES quarantine",
+			env: map[string]string{
+				"VERIFY_EXISTING_FILES": "quarantine",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.VerifyExistingFiles != "quarantine" {
+					t.Errorf("VerifyExistingFiles = %v, want quarantine", cfg.VerifyExistingFiles)
 				}
 			},
 		},
 		{
-			name: "invalid SMTP_PORT",
+			name: "invalid VERIFY_EXISTING_FILES",
+			env: map[string]string{
+				"VERIFY_EXISTING_FILES": "true",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "VERIFY_EXISTING_FILES with NORMALIZE_ORIENTATION",
+			env: map[string]string{
+				"VERIFY_EXISTING_FILES": "log",
+				"NORMALIZE_ORIENTATION": "true",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "redownload missing files defaults to true",
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if !cfg.RedownloadMissingFiles {
+					t.Errorf("Expected RedownloadMissingFiles to default to true")
+				}
+			},
+		},
+		{
+			name: "redownload missing files disabled",
+			env: map[string]string{
+				"REDOWNLOAD_MISSING_FILES": "false",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.RedownloadMissingFiles {
+					t.Errorf("Expected RedownloadMissingFiles to be false")
+				}
+			},
+		},
+		{
+			name: "invalid REDOWNLOAD_MISSING_FILES",
+			env: map[string]string{
+				"REDOWNLOAD_MISSING_FILES": "sometimes",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "max albums per run",
+			env: map[string]string{
+				"MAX_ALBUMS_PER_RUN": "5",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.MaxAlbumsPerRun != 5 {
+					t.Errorf("Expected MaxAlbumsPerRun to be 5, got %d", cfg.MaxAlbumsPerRun)
+				}
+			},
+		},
+		{
+			name: "invalid MAX_ALBUMS_PER_RUN",
+			env: map[string]string{
+				"MAX_ALBUMS_PER_RUN": "many",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "negative MAX_ALBUMS_PER_RUN",
+			env: map[string]string{
+				"MAX_ALBUMS_PER_RUN": "-1",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "status address",
+			env: map[string]string{
+				"STATUS_ADDR": ":8080",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.StatusAddr != ":8080" {
+					t.Errorf("Expected StatusAddr to be :8080, got %q", cfg.StatusAddr)
+				}
+			},
+		},
+		{
+			name: "invalid STATUS_ADDR",
+			env: map[string]string{
+				"STATUS_ADDR": "8080",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "email zip partial send defaults to true",
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if !cfg.EmailZipPartialSend {
+					t.Errorf("Expected EmailZipPartialSend to default to true")
+				}
+			},
+		},
+		{
+			name: "email zip partial send disabled",
+			env: map[string]string{
+				"EMAIL_ZIP_PARTIAL_SEND": "false",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.EmailZipPartialSend {
+					t.Errorf("Expected EmailZipPartialSend to be false")
+				}
+			},
+		},
+		{
+			name: "invalid EMAIL_ZIP_PARTIAL_SEND",
+			env: map[string]string{
+				"EMAIL_ZIP_PARTIAL_SEND": "maybe",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "strip gps and all exif",
+			env: map[string]string{
+				"STRIP_GPS":      "true",
+				"STRIP_ALL_EXIF": "true",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    false,
+			validate: func(t *testing.T, cfg *Config) {
+				if !cfg.StripGPS || !cfg.StripAllEXIF {
+					t.Errorf("Expected StripGPS and StripAllEXIF to be true")
+				}
+			},
+		},
+		{
+			name: "invalid STRIP_GPS",
+			env: map[string]string{
+				"STRIP_GPS": "location",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "invalid STRIP_ALL_EXIF",
+			env: map[string]string{
+				"STRIP_ALL_EXIF": "everything",
+			},
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name: "verify existing files with strip gps",
 			env: map[string]string{
-				"REDIS_URL":        "redis://localhost:6379",
-				"SMTP_SERVER":      "smtp.example.com",
-				"SMTP_PORT":        "invalid",
-				"SMTP_USERNAME":    "user@example.com",
-				"SMTP_PASSWORD":    "password",
-				"SMTP_DESTINATION": "dest@example.com",
-				"IMAGE_DIR":        tmpDir,
+				"VERIFY_EXISTING_FILES": "log",
+				"STRIP_GPS":             "true",
 			},
-			configJSON: `{"album_urls": ["https://example.com/album"]}`,
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
 			wantErr:    true,
 		},
 		{
-			name: "custom IMAGE_DIR",
+			name: "invalid SMTP_PORT",
 			env: map[string]string{
-				"REDIS_URL":        "redis://localhost:6379",
-				"SMTP_SERVER":      "smtp.example.com",
-				"SMTP_PORT":        "587",
-				"SMTP_USERNAME":    "user@example.com",
-				"SMTP_PASSWORD":    "password",
-				"SMTP_DESTINATION": "dest@example.com",
-				"IMAGE_DIR":        tmpDir,
+				"SMTP_PORT": "invalid",
 			},
-			configJSON: `{"album_urls": ["https://example.com/album"]}`,
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "custom IMAGE_DIR",
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
 			wantErr:    false,
 			validate: func(t *testing.T, cfg *Config) {
 				if cfg.ImageDir != tmpDir {
 					t.Errorf("ImageDir = %v, want %v", cfg.ImageDir, tmpDir)
 				}
+				if cfg.ImageLayout != "flat" {
+					t.Errorf("ImageLayout = %v, want flat", cfg.ImageLayout)
+				}
+				if cfg.HashAlgorithm != "sha256" {
+					t.Errorf("HashAlgorithm = %v, want sha256", cfg.HashAlgorithm)
+				}
+				if cfg.HashContent != "file" {
+					t.Errorf("HashContent = %v, want file", cfg.HashContent)
+				}
 			},
 		},
 		{
 			name: "with Google Photos config",
 			env: map[string]string{
-				"REDIS_URL":                  "redis://localhost:6379",
-				"SMTP_SERVER":                 "smtp.example.com",
-				"SMTP_PORT":                   "587",
-				"SMTP_USERNAME":               "user@example.com",
-				"SMTP_PASSWORD":               "password",
-				"SMTP_DESTINATION":            "dest@example.com",
-				"IMAGE_DIR":                   tmpDir,
 				"GOOGLE_PHOTOS_CLIENT_ID":     "gphotos-client-id",
 				"GOOGLE_PHOTOS_CLIENT_SECRET": "gphotos-secret",
 				"GOOGLE_PHOTOS_REFRESH_TOKEN": "gphotos-refresh-token",
 				"GOOGLE_PHOTOS_ALBUM_NAME":    "My Album",
 			},
-			configJSON: `{"album_urls": ["https://example.com/album"]}`,
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
 			wantErr:    false,
 			validate: func(t *testing.T, cfg *Config) {
 				if cfg.GooglePhotosConfig == nil {
@@ -180,32 +2176,18 @@ func TestLoad(t *testing.T) {
 		{
 			name: "partial Google Photos config should fail",
 			env: map[string]string{
-				"REDIS_URL":                  "redis://localhost:6379",
-				"SMTP_SERVER":                 "smtp.example.com",
-				"SMTP_PORT":                   "587",
-				"SMTP_USERNAME":               "user@example.com",
-				"SMTP_PASSWORD":               "password",
-				"SMTP_DESTINATION":            "dest@example.com",
-				"IMAGE_DIR":                   tmpDir,
-				"GOOGLE_PHOTOS_CLIENT_ID":     "gphotos-client-id",
+				"GOOGLE_PHOTOS_CLIENT_ID": "gphotos-client-id",
 				// Missing other Google Photos env vars
 			},
-			configJSON: `{"album_urls": ["https://example.com/album"]}`,
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
 			wantErr:    true,
 		},
 		{
 			name: "without Google Photos config",
-			env: map[string]string{
-				"REDIS_URL":        "redis://localhost:6379",
-				"SMTP_SERVER":       "smtp.example.com",
-				"SMTP_PORT":        "587",
-				"SMTP_USERNAME":     "user@example.com",
-				"SMTP_PASSWORD":     "password",
-				"SMTP_DESTINATION": "dest@example.com",
-				"IMAGE_DIR":        tmpDir,
+			env:  map[string]string{
 				// No Google Photos env vars
 			},
-			configJSON: `{"album_urls": ["https://example.com/album"]}`,
+			configJSON: `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
 			wantErr:    false,
 			validate: func(t *testing.T, cfg *Config) {
 				if cfg.GooglePhotosConfig != nil {
@@ -218,13 +2200,24 @@ func TestLoad(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Set environment variables
+			env := make(map[string]string, len(baseEnv)+len(tt.env))
+			for key, value := range baseEnv {
+				env[key] = value
+			}
 			for key, value := range tt.env {
+				if value == "" {
+					delete(env, key)
+					continue
+				}
+				env[key] = value
+			}
+			for key, value := range env {
 				os.Setenv(key, value)
 			}
 
 			// Set up test directory and config file
 			testImageDir := tmpDir
-			if dir, ok := tt.env["IMAGE_DIR"]; ok && dir != "" {
+			if dir, ok := env["IMAGE_DIR"]; ok && dir != "" {
 				testImageDir = dir
 			}
 			err := os.MkdirAll(testImageDir, 0755)
@@ -233,7 +2226,7 @@ func TestLoad(t *testing.T) {
 			}
 
 			configPath := filepath.Join(testImageDir, "config.json")
-			
+
 			// Remove config file if it exists (for tests that expect it to be missing)
 			if tt.configJSON == "" {
 				os.Remove(configPath)
@@ -261,9 +2254,209 @@ func TestLoad(t *testing.T) {
 			}
 
 			// Clean up
-			for key := range tt.env {
+			for key := range env {
 				os.Unsetenv(key)
 			}
 		})
 	}
 }
+
+func TestLoadEnvFile(t *testing.T) {
+	// Register cleanups so variables set from the file don't leak into other tests
+	for _, key := range []string{"ENV_FILE", "SMTP_SERVER", "SMTP_PASSWORD", "SMTP_FROM", "EMAIL_SUBJECT_PREFIX", "RUN_INTERVAL", "MAX_ITEMS"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+
+	envPath := filepath.Join(t.TempDir(), "sync.env")
+	content := `# Local development settings
+SMTP_SERVER=smtp.example.com
+export SMTP_PASSWORD="p@ss word\n"
+SMTP_FROM='Photos <photos@example.com>'
+EMAIL_SUBJECT_PREFIX=[Photos] # shown in every subject
+RUN_INTERVAL=3600
+MAX_ITEMS=5
+`
+	if err := os.WriteFile(envPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write env file: %v", err)
+	}
+	os.Setenv("ENV_FILE", envPath)
+	os.Setenv("MAX_ITEMS", "10") // The real environment takes precedence
+
+	if err := loadEnvFile(); err != nil {
+		t.Fatalf("loadEnvFile() error = %v", err)
+	}
+	want := map[string]string{
+		"SMTP_SERVER":          "smtp.example.com",
+		"SMTP_PASSWORD":        "p@ss word\n",
+		"SMTP_FROM":            "Photos <photos@example.com>",
+		"EMAIL_SUBJECT_PREFIX": "[Photos]",
+		"RUN_INTERVAL":         "3600",
+		"MAX_ITEMS":            "10",
+	}
+	for key, value := range want {
+		if got := os.Getenv(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+
+	for _, line := range []string{"NO_EQUALS_SIGN", "SMTP SERVER=x", `SMTP_FROM="unterminated`, "SMTP_FROM='unterminated"} {
+		if err := os.WriteFile(envPath, []byte(line+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write env file: %v", err)
+		}
+		if err := loadEnvFile(); err == nil || !strings.Contains(err.Error(), "line 1") {
+			t.Errorf("loadEnvFile() with %q error = %v, want a line 1 error", line, err)
+		}
+	}
+
+	// Without ENV_FILE a missing .env in the working directory is fine
+	os.Unsetenv("ENV_FILE")
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd() error = %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Chdir() error = %v", err)
+	}
+	defer os.Chdir(wd)
+	if err := loadEnvFile(); err != nil {
+		t.Errorf("loadEnvFile() without .env error = %v, want nil", err)
+	}
+	if err := os.WriteFile(".env", []byte("RUN_INTERVAL=60\n"), 0644); err != nil {
+		t.Fatalf("Failed to write .env: %v", err)
+	}
+	os.Unsetenv("RUN_INTERVAL")
+	if err := loadEnvFile(); err != nil || os.Getenv("RUN_INTERVAL") != "60" {
+		t.Errorf("loadEnvFile() with .env error = %v, RUN_INTERVAL = %q, want 60", err, os.Getenv("RUN_INTERVAL"))
+	}
+}
+
+func TestLoadAlbumConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{
+			name:    "trailing comma",
+			json:    "{\n  \"album_urls\": [\n    \"https://www.icloud.com/sharedalbum/#ALBUM_TOKEN\",\n  ]\n}",
+			wantErr: "line 4, column 3",
+		},
+		{
+			name:    "unknown key",
+			json:    `{"album_url": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"]}`,
+			wantErr: `unknown field "album_url"`,
+		},
+		{
+			name:    "wrong type",
+			json:    `{"album_urls": "https://www.icloud.com/sharedalbum/#ALBUM_TOKEN"}`,
+			wantErr: `"album_urls" at line 1, column`,
+		},
+		{
+			name:    "invalid album URL",
+			json:    `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN", "https://www.icloud.com/sharedalbum/"]}`,
+			wantErr: `album_urls[1] "https://www.icloud.com/sharedalbum/"`,
+		},
+		{
+			name:    "unknown album key",
+			json:    "{\n  \"album_urls\": [\n    \"https://www.icloud.com/sharedalbum/#ALBUM_TOKEN\",\n    {\"url\": \"https://www.icloud.com/sharedalbum/#OTHER_TOKEN\", \"enable\": false}\n  ]\n}",
+			wantErr: `album_urls[1] at line 4, column 5: json: unknown field "enable" (the supported keys are "url", "enabled", "priority" and "destination")`,
+		},
+		{
+			name:    "wrong type in album object",
+			json:    "{\n  \"album_urls\": [\n    {\"url\": \"https://www.icloud.com/sharedalbum/#ALBUM_TOKEN\", \"priority\": \"high\"}\n  ]\n}",
+			wantErr: `album_urls[0]: "priority" at line 3, column 81 must be int, got string`,
+		},
+		{
+			name:    "album entry of the wrong type",
+			json:    `{"album_urls": ["https://www.icloud.com/sharedalbum/#ALBUM_TOKEN", 42]}`,
+			wantErr: `album_urls[1] at line 1, column 68 must be a URL or an object, got number`,
+		},
+		{
+			name:    "invalid album URL in object form",
+			json:    `{"album_urls": [{"url": "https://example.com/album", "enabled": true}]}`,
+			wantErr: `album_urls[0] "https://example.com/album"`,
+		},
+		{
+			name:    "empty file",
+			json:    "",
+			wantErr: "file is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.json), 0644); err != nil {
+				t.Fatalf("Failed to write config file: %v", err)
+			}
+			_, err := loadAlbumConfig(path)
+			if err == nil {
+				t.Fatal("loadAlbumConfig() error = nil, want error")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadAlbumConfig() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	overnight := &QuietHours{Start: 22 * 60, End: 7 * 60, Location: time.UTC}
+	daytime := &QuietHours{Start: 9 * 60, End: 17 * 60, Location: time.UTC}
+
+	tests := []struct {
+		name       string
+		quietHours *QuietHours
+		now        time.Time
+		wantActive bool
+		wantEnd    time.Time
+	}{
+		{
+			name:       "overnight before midnight",
+			quietHours: overnight,
+			now:        time.Date(2024, 6, 15, 23, 0, 0, 0, time.UTC),
+			wantActive: true,
+			wantEnd:    time.Date(2024, 6, 16, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "overnight after midnight",
+			quietHours: overnight,
+			now:        time.Date(2024, 6, 16, 3, 0, 0, 0, time.UTC),
+			wantActive: true,
+			wantEnd:    time.Date(2024, 6, 16, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "overnight at the end",
+			quietHours: overnight,
+			now:        time.Date(2024, 6, 16, 7, 0, 0, 0, time.UTC),
+			wantActive: false,
+			wantEnd:    time.Date(2024, 6, 17, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "daytime inside",
+			quietHours: daytime,
+			now:        time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC),
+			wantActive: true,
+			wantEnd:    time.Date(2024, 6, 15, 17, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "daytime outside",
+			quietHours: daytime,
+			now:        time.Date(2024, 6, 15, 20, 0, 0, 0, time.UTC),
+			wantActive: false,
+			wantEnd:    time.Date(2024, 6, 16, 17, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.quietHours.Active(tt.now); got != tt.wantActive {
+				t.Errorf("Active() = %v, want %v", got, tt.wantActive)
+			}
+			if got := tt.quietHours.NextEnd(tt.now); !got.Equal(tt.wantEnd) {
+				t.Errorf("NextEnd() = %v, want %v", got, tt.wantEnd)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Default API base URLs; SMTPConfig.APIURL overrides them (e.g. https://api.eu.mailgun.net for
+// domains in Mailgun's EU region)
+const (
+	sendGridURL = "https://api.sendgrid.com"
+	mailgunURL  = "https://api.mailgun.net"
+)
+
+// threadHeaders are the headers copied from a message into API requests, besides the
+// addresses and subject the APIs take as separate fields
+var threadHeaders = []string{"Message-ID", "In-Reply-To", "References"}
+
+// sendGridAddress is an email address in a SendGrid request
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+// newSendGridAddress splits a From or Reply-To header into the address and display name
+// SendGrid takes as separate fields
+func newSendGridAddress(value string) sendGridAddress {
+	address, err := mail.ParseAddress(value)
+	if err != nil {
+		return sendGridAddress{Email: value}
+	}
+	return sendGridAddress{Email: address.Address, Name: address.Name}
+}
+
+// sendGridPersonalization lists the recipients of a SendGrid request
+type sendGridPersonalization struct {
+	To []sendGridAddress `json:"to"`
+}
+
+// sendGridContent is a body part of a SendGrid request
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// sendGridAttachment is a base64-encoded attachment of a SendGrid request
+type sendGridAttachment struct {
+	Content     string `json:"content"`
+	Type        string `json:"type"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition"`
+}
+
+// sendGridRequest is the body of a SendGrid v3 mail send request
+type sendGridRequest struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	ReplyTo          *sendGridAddress          `json:"reply_to,omitempty"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+// sendSendGrid delivers a message through SendGrid's v3 mail send API, with the attachments
+// base64-encoded into the JSON request
+func (s *Sender) sendSendGrid(ctx context.Context, m *message) error {
+	request := sendGridRequest{
+		Personalizations: []sendGridPersonalization{{To: sendGridRecipients(m)}},
+		From:             newSendGridAddress(header(m, "From")),
+		Subject:          header(m, "Subject"),
+		Content:          []sendGridContent{{Type: "text/plain", Value: m.body}},
+	}
+	if replyTo := header(m, "Reply-To"); replyTo != "" {
+		replyToAddress := newSendGridAddress(replyTo)
+		request.ReplyTo = &replyToAddress
+	}
+	for _, name := range threadHeaders {
+		if value := header(m, name); value != "" {
+			if request.Headers == nil {
+				request.Headers = make(map[string]string)
+			}
+			request.Headers[name] = value
+		}
+	}
+	for _, a := range m.attachments {
+		data, err := os.ReadFile(a.path)
+		if err != nil {
+			return fmt.Errorf("failed to read attachment: %w", err)
+		}
+		request.Attachments = append(request.Attachments, sendGridAttachment{
+			Content:     base64.StdEncoding.EncodeToString(data),
+			Type:        attachmentType(a.name),
+			Filename:    a.name,
+			Disposition: "attachment",
+		})
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal SendGrid request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.apiURL(sendGridURL)+"/v3/mail/send", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.smtpConfig.APIKey)
+	return s.doAPIRequest(req)
+}
+
+// sendMailgun delivers a message through Mailgun's messages API as a multipart form, with the
+// attachments as file parts
+func (s *Sender) sendMailgun(ctx context.Context, m *message) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fields := [][2]string{
+		{"from", header(m, "From")},
+		{"to", strings.Join(m.GetHeader("To"), ", ")},
+		{"subject", header(m, "Subject")},
+		{"text", m.body},
+	}
+	if replyTo := header(m, "Reply-To"); replyTo != "" {
+		fields = append(fields, [2]string{"h:Reply-To", replyTo})
+	}
+	for _, name := range threadHeaders {
+		if value := header(m, name); value != "" {
+			fields = append(fields, [2]string{"h:" + name, value})
+		}
+	}
+	for _, field := range fields {
+		if err := form.WriteField(field[0], field[1]); err != nil {
+			return fmt.Errorf("failed to build Mailgun request: %w", err)
+		}
+	}
+	for _, a := range m.attachments {
+		if err := writeFormFile(form, a); err != nil {
+			return err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return fmt.Errorf("failed to build Mailgun request: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/v3/%s/messages", s.apiURL(mailgunURL), s.smtpConfig.MailgunDomain)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.SetBasicAuth("api", s.smtpConfig.APIKey)
+	return s.doAPIRequest(req)
+}
+
+// writeFormFile copies an attachment into a Mailgun form
+func writeFormFile(form *multipart.Writer, a attachment) error {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to read attachment: %w", err)
+	}
+	defer file.Close()
+
+	part, err := form.CreateFormFile("attachment", a.name)
+	if err != nil {
+		return fmt.Errorf("failed to build Mailgun request: %w", err)
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return fmt.Errorf("failed to read attachment: %w", err)
+	}
+	return nil
+}
+
+// doAPIRequest sends an API request and turns a non-2xx response into an error
+func (s *Sender) doAPIRequest(req *http.Request) error {
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send email via %s: %w", s.smtpConfig.Backend, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if resp.StatusCode == http.StatusRequestEntityTooLarge {
+			return fmt.Errorf("failed to send email via %s: %w: %s", s.smtpConfig.Backend, ErrMessageTooLarge, bytes.TrimSpace(bodyBytes))
+		}
+		return fmt.Errorf("failed to send email via %s: status %d: %s", s.smtpConfig.Backend, resp.StatusCode, bytes.TrimSpace(bodyBytes))
+	}
+	return nil
+}
+
+// sendGridRecipients returns every To address of a message
+func sendGridRecipients(m *message) []sendGridAddress {
+	var to []sendGridAddress
+	for _, address := range m.GetHeader("To") {
+		to = append(to, sendGridAddress{Email: address})
+	}
+	return to
+}
+
+// apiURL returns the configured API base URL, or defaultURL
+func (s *Sender) apiURL(defaultURL string) string {
+	if s.smtpConfig.APIURL != "" {
+		return s.smtpConfig.APIURL
+	}
+	return defaultURL
+}
+
+// header returns the first value of a message header, or "" if it isn't set
+func header(m *message, name string) string {
+	if values := m.GetHeader(name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// attachmentType returns the MIME type of an attachment from its name
+func attachmentType(name string) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
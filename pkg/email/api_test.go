@@ -0,0 +1,316 @@
+package email
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+)
+
+func writeTestImage(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "abc123.jpg")
+	if err := os.WriteFile(path, []byte("fake jpeg data"), 0644); err != nil {
+		t.Fatalf("Failed to write test image: %v", err)
+	}
+	return path
+}
+
+func TestSender_SendGrid(t *testing.T) {
+	var got sendGridRequest
+	var auth, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender, err := NewSender(&config.SMTPConfig{Backend: "sendgrid", APIKey: "sg-key", APIURL: server.URL, From: "photos@example.com"})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	imagePath := writeTestImage(t)
+	msg := PhotoMessage{
+		Photo:          Photo{Album: "Family", Caption: "Beach day"},
+		Destination:    "frame@example.com",
+		AttachmentPath: imagePath,
+		AttachmentName: "2024-06-15_beach.jpg",
+	}
+	if err := sender.SendPhoto(context.Background(), imagePath, msg); err != nil {
+		t.Fatalf("SendPhoto() error = %v", err)
+	}
+
+	if path != "/v3/mail/send" {
+		t.Errorf("request path = %q, want /v3/mail/send", path)
+	}
+	if auth != "Bearer sg-key" {
+		t.Errorf("Authorization = %q, want Bearer sg-key", auth)
+	}
+	if got.From.Email != "photos@example.com" || got.ReplyTo != nil {
+		t.Errorf("from = %v, reply_to = %v, want photos@example.com and none", got.From, got.ReplyTo)
+	}
+	if len(got.Personalizations) != 1 || len(got.Personalizations[0].To) != 1 || got.Personalizations[0].To[0].Email != "frame@example.com" {
+		t.Errorf("personalizations = %v, want frame@example.com", got.Personalizations)
+	}
+	if !strings.Contains(got.Subject, "Family") || !strings.Contains(got.Subject, "Beach day") {
+		t.Errorf("subject = %q, want album and caption", got.Subject)
+	}
+	if len(got.Content) != 1 || !strings.Contains(got.Content[0].Value, "Beach day") {
+		t.Errorf("content = %v, want caption in body", got.Content)
+	}
+	if got.Headers["References"] == "" || !strings.HasSuffix(got.Headers["Message-ID"], "@example.com>") {
+		t.Errorf("headers = %v, want thread headers", got.Headers)
+	}
+	if len(got.Attachments) != 1 {
+		t.Fatalf("attachments = %d, want 1", len(got.Attachments))
+	}
+	attachment := got.Attachments[0]
+	data, err := base64.StdEncoding.DecodeString(attachment.Content)
+	if err != nil || string(data) != "fake jpeg data" {
+		t.Errorf("attachment content = %q (%v), want the image base64-encoded", data, err)
+	}
+	if attachment.Filename != "2024-06-15_beach.jpg" || attachment.Type != "image/jpeg" {
+		t.Errorf("attachment = %s (%s), want 2024-06-15_beach.jpg (image/jpeg)", attachment.Filename, attachment.Type)
+	}
+}
+
+func TestSender_SendGrid_FromName(t *testing.T) {
+	var got sendGridRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender, err := NewSender(&config.SMTPConfig{Backend: "sendgrid", APIKey: "sg-key", APIURL: server.URL, From: "photos@example.com", FromName: "Família Sync"})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	if err := sender.SendAlert("Test", "Body", "frame@example.com"); err != nil {
+		t.Fatalf("SendAlert() error = %v", err)
+	}
+	if got.From.Email != "photos@example.com" || got.From.Name != "Família Sync" {
+		t.Errorf("from = %+v, want photos@example.com named Família Sync", got.From)
+	}
+}
+
+func TestSender_SendGrid_Recipients(t *testing.T) {
+	var got sendGridRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender, err := NewSender(&config.SMTPConfig{Backend: "sendgrid", APIKey: "sg-key", APIURL: server.URL, From: "photos@example.com"})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	if err := sender.SendAlert("Test", "Body", "grandma@example.com, frame@example.com"); err != nil {
+		t.Fatalf("SendAlert() error = %v", err)
+	}
+	if len(got.Personalizations) != 1 || len(got.Personalizations[0].To) != 2 ||
+		got.Personalizations[0].To[0].Email != "grandma@example.com" || got.Personalizations[0].To[1].Email != "frame@example.com" {
+		t.Errorf("personalizations = %v, want grandma@example.com and frame@example.com", got.Personalizations)
+	}
+}
+
+func TestSender_SetConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender, err := NewSender(&config.SMTPConfig{Backend: "sendgrid", APIKey: "sg-key", APIURL: server.URL, From: "photos@example.com"})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	sender.SetConcurrency(2)
+
+	// Different kinds of email share the limit
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if i%2 == 0 {
+				err = sender.SendAlert("Test", "Body", "frame@example.com")
+			} else {
+				imagePath := writeTestImage(t)
+				err = sender.SendPhoto(context.Background(), imagePath, PhotoMessage{Photo: Photo{Album: "Family"}, Destination: "frame@example.com", AttachmentPath: imagePath})
+			}
+			if err != nil {
+				t.Errorf("send %d error = %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if maxInFlight != 2 {
+		t.Errorf("%d emails sent at once, want 2", maxInFlight)
+	}
+}
+
+func TestSender_Mailgun(t *testing.T) {
+	fields := map[string]string{}
+	var attachmentName, attachmentData, path, user, pass string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		user, pass, _ = r.BasicAuth()
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("Failed to parse form: %v", err)
+			return
+		}
+		for key, values := range r.MultipartForm.Value {
+			fields[key] = values[0]
+		}
+		if files := r.MultipartForm.File["attachment"]; len(files) == 1 {
+			attachmentName = files[0].Filename
+			file, err := files[0].Open()
+			if err == nil {
+				data, _ := io.ReadAll(file)
+				attachmentData = string(data)
+				file.Close()
+			}
+		}
+		w.Write([]byte(`{"message": "Queued. Thank you."}`))
+	}))
+	defer server.Close()
+
+	sender, err := NewSender(&config.SMTPConfig{Backend: "mailgun", APIKey: "mg-key", APIURL: server.URL, MailgunDomain: "mg.example.com", From: "photos@example.com"})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	imagePath := writeTestImage(t)
+	if err := sender.SendPhoto(context.Background(), imagePath, PhotoMessage{Photo: Photo{Album: "Family"}, Destination: "frame@example.com", AttachmentPath: imagePath}); err != nil {
+		t.Fatalf("SendPhoto() error = %v", err)
+	}
+
+	if path != "/v3/mg.example.com/messages" {
+		t.Errorf("request path = %q, want /v3/mg.example.com/messages", path)
+	}
+	if user != "api" || pass != "mg-key" {
+		t.Errorf("basic auth = %s:%s, want api:mg-key", user, pass)
+	}
+	if fields["from"] != "photos@example.com" || fields["to"] != "frame@example.com" {
+		t.Errorf("from/to = %q/%q, want photos@example.com/frame@example.com", fields["from"], fields["to"])
+	}
+	if !strings.Contains(fields["subject"], "Family") || fields["text"] == "" {
+		t.Errorf("subject = %q, text = %q, want album subject and body", fields["subject"], fields["text"])
+	}
+	if fields["h:References"] == "" {
+		t.Errorf("fields = %v, want h:References thread header", fields)
+	}
+	if attachmentName != "abc123.jpg" || attachmentData != "fake jpeg data" {
+		t.Errorf("attachment = %s %q, want abc123.jpg with the image data", attachmentName, attachmentData)
+	}
+}
+
+func TestSender_SendWelcome(t *testing.T) {
+	var got sendGridRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender, err := NewSender(&config.SMTPConfig{Backend: "sendgrid", APIKey: "sg-key", APIURL: server.URL, From: "photos@example.com"})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	if err := sender.SendWelcome(writeTestImage(t), "frame@example.com", "Family", 42); err != nil {
+		t.Fatalf("SendWelcome() error = %v", err)
+	}
+	if !strings.Contains(got.Subject, "Family") {
+		t.Errorf("subject = %q, want the album name", got.Subject)
+	}
+	if len(got.Content) != 1 || !strings.Contains(got.Content[0].Value, "42 photos") {
+		t.Errorf("content = %v, want the photo count in the body", got.Content)
+	}
+	if got.Headers["References"] == "" {
+		t.Errorf("headers = %v, want the email threaded with the album", got.Headers)
+	}
+	if len(got.Attachments) != 1 || got.Attachments[0].Filename != "cover.jpg" {
+		t.Errorf("attachments = %v, want cover.jpg", got.Attachments)
+	}
+
+	// Without a cover the introduction is sent on its own
+	got = sendGridRequest{}
+	if err := sender.SendWelcome("", "frame@example.com", "Family", 0); err != nil {
+		t.Fatalf("SendWelcome() error = %v", err)
+	}
+	if len(got.Attachments) != 0 || strings.Contains(got.Content[0].Value, "attached") {
+		t.Errorf("attachments = %v, body = %q, want no cover", got.Attachments, got.Content[0].Value)
+	}
+}
+
+func TestSender_API_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"errors":[{"message":"The provided authorization grant is invalid"}]}`))
+	}))
+	defer server.Close()
+
+	sender, err := NewSender(&config.SMTPConfig{Backend: "sendgrid", APIKey: "bad", APIURL: server.URL, From: "photos@example.com"})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	err = sender.SendAlert("Test", "body", "ops@example.com")
+	if err == nil || !strings.Contains(err.Error(), "status 401") || !strings.Contains(err.Error(), "authorization grant") {
+		t.Errorf("SendAlert() error = %v, want status 401 with the API's message", err)
+	}
+}
+
+func TestSender_SendPhoto_Canceled(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender, err := NewSender(&config.SMTPConfig{Backend: "sendgrid", APIKey: "sg-key", APIURL: server.URL, From: "photos@example.com"})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	imagePath := writeTestImage(t)
+	if err := sender.SendPhoto(ctx, imagePath, PhotoMessage{Destination: "frame@example.com", AttachmentPath: imagePath}); !errors.Is(err, context.Canceled) {
+		t.Errorf("SendPhoto() error = %v, want context.Canceled", err)
+	}
+	if requests != 0 {
+		t.Errorf("%d requests sent after the context was canceled, want 0", requests)
+	}
+}
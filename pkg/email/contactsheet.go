@@ -0,0 +1,86 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+)
+
+// contactSheetGap is the space in pixels around each thumbnail of a contact sheet
+const contactSheetGap = 8
+
+// contactSheetBackground fills the space around the thumbnails
+var contactSheetBackground = color.RGBA{R: 32, G: 32, B: 32, A: 255}
+
+// ContactSheet draws the images into a grid with the given number of columns, each scaled to
+// fit a thumbSize pixel square, and writes it as a JPEG into dir. Images that can't be decoded
+// (e.g. HEIC or video) are left out, and fail with ErrUnsupportedImage if none can be drawn.
+// Returns the sheet's path and how many images it shows. The caller removes the sheet
+func ContactSheet(imagePaths []string, dir string, columns int, thumbSize int) (string, int, error) {
+	var thumbs []image.Image
+	for _, imagePath := range imagePaths {
+		thumb, err := thumbnail(imagePath, thumbSize)
+		if errors.Is(err, ErrUnsupportedImage) {
+			continue
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		thumbs = append(thumbs, thumb)
+	}
+	if len(thumbs) == 0 {
+		return "", 0, fmt.Errorf("%w: none of the %d images could be decoded", ErrUnsupportedImage, len(imagePaths))
+	}
+
+	columns = min(max(columns, 1), len(thumbs))
+	rows := (len(thumbs) + columns - 1) / columns
+	cell := thumbSize + contactSheetGap
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*cell+contactSheetGap, rows*cell+contactSheetGap))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(contactSheetBackground), image.Point{}, draw.Src)
+	for i, thumb := range thumbs {
+		// Centre each thumbnail in its cell
+		bounds := thumb.Bounds()
+		x := contactSheetGap + (i%columns)*cell + (thumbSize-bounds.Dx())/2
+		y := contactSheetGap + (i/columns)*cell + (thumbSize-bounds.Dy())/2
+		draw.Draw(sheet, image.Rect(x, y, x+bounds.Dx(), y+bounds.Dy()), thumb, bounds.Min, draw.Src)
+	}
+
+	out, err := os.CreateTemp(dir, "contact-sheet-*.jpg")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create contact sheet: %w", err)
+	}
+	if err := jpeg.Encode(out, sheet, &jpeg.Options{Quality: 85}); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", 0, fmt.Errorf("failed to encode contact sheet: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", 0, fmt.Errorf("failed to write contact sheet: %w", err)
+	}
+	return out.Name(), len(thumbs), nil
+}
+
+// thumbnail decodes an image and scales it down to fit a size pixel square
+func thumbnail(imagePath string, size int) (image.Image, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+	defer file.Close()
+
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	bounds := src.Bounds()
+	if bounds.Dx() <= size && bounds.Dy() <= size {
+		return src, nil
+	}
+	width, height := fitWithin(bounds.Dx(), bounds.Dy(), size)
+	return downscale(src, width, height), nil
+}
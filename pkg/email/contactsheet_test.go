@@ -0,0 +1,58 @@
+package email
+
+import (
+	"errors"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContactSheet(t *testing.T) {
+	dir := t.TempDir()
+	var imagePaths []string
+	for i, size := range [][2]int{{400, 200}, {100, 300}, {50, 50}} {
+		path := filepath.Join(dir, string(rune('a'+i))+".jpg")
+		writeTestJPEG(t, path, size[0], size[1])
+		imagePaths = append(imagePaths, path)
+	}
+	unsupported := filepath.Join(dir, "video.mov")
+	if err := os.WriteFile(unsupported, []byte("not an image"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	imagePaths = append(imagePaths, unsupported)
+
+	sheetPath, shown, err := ContactSheet(imagePaths, dir, 2, 100)
+	if err != nil {
+		t.Fatalf("ContactSheet() error = %v", err)
+	}
+	defer os.Remove(sheetPath)
+	if shown != 3 {
+		t.Errorf("ContactSheet() shown = %d, want 3 (the video left out)", shown)
+	}
+
+	file, err := os.Open(sheetPath)
+	if err != nil {
+		t.Fatalf("Failed to open contact sheet: %v", err)
+	}
+	defer file.Close()
+	config, err := jpeg.DecodeConfig(file)
+	if err != nil {
+		t.Fatalf("Contact sheet is not a JPEG: %v", err)
+	}
+	// Two columns and two rows of 100px cells with an 8px gap around each
+	if config.Width != 2*108+8 || config.Height != 2*108+8 {
+		t.Errorf("Contact sheet is %dx%d, want 224x224", config.Width, config.Height)
+	}
+}
+
+func TestContactSheet_Unsupported(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "video.mov")
+	if err := os.WriteFile(path, []byte("not an image"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, _, err := ContactSheet([]string{path}, dir, 4, 100); !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("ContactSheet() error = %v, want ErrUnsupportedImage", err)
+	}
+}
@@ -0,0 +1,105 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Registers the GIF decoder
+	"image/jpeg"
+	_ "image/png" // Registers the PNG decoder
+	"os"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/storage"
+)
+
+// ErrUnsupportedImage is returned by MediumCopy for files it can't decode (e.g. HEIC or video)
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// MediumCopy writes a JPEG copy of the image scaled down so its longest side is at most
+// maxSize pixels, into dir. Returns the copy's path, or imagePath itself if the image is already
+// small enough or is an animated GIF, which a JPEG copy would reduce to its first frame. The
+// caller removes the copy when it's no longer needed
+func MediumCopy(imagePath string, dir string, maxSize int) (string, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open image: %w", err)
+	}
+	defer file.Close()
+
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	if config.Width <= maxSize && config.Height <= maxSize {
+		return imagePath, nil
+	}
+	if format == "gif" && storage.IsAnimatedGIF(imagePath) {
+		return imagePath, nil
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		return "", fmt.Errorf("failed to seek image: %w", err)
+	}
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+
+	width, height := fitWithin(config.Width, config.Height, maxSize)
+	dst := downscale(src, width, height)
+
+	out, err := os.CreateTemp(dir, "medium-*.jpg")
+	if err != nil {
+		return "", fmt.Errorf("failed to create medium copy: %w", err)
+	}
+	if err := jpeg.Encode(out, dst, &jpeg.Options{Quality: 85}); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", fmt.Errorf("failed to encode medium copy: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("failed to write medium copy: %w", err)
+	}
+	return out.Name(), nil
+}
+
+// fitWithin returns the size of a width x height image scaled so its longest side is maxSize
+func fitWithin(width int, height int, maxSize int) (int, int) {
+	if width >= height {
+		return maxSize, max(1, height*maxSize/width)
+	}
+	return max(1, width*maxSize/height), maxSize
+}
+
+// downscale resizes src to width x height by averaging the source pixels that fall in each
+// destination pixel (a box filter), which avoids the aliasing of nearest-neighbour sampling
+func downscale(src image.Image, width int, height int) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,106 @@
+package email
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestJPEG(t *testing.T, path string, width int, height int) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	defer file.Close()
+	if err := jpeg.Encode(file, img, nil); err != nil {
+		t.Fatalf("Failed to encode image: %v", err)
+	}
+}
+
+func TestMediumCopy(t *testing.T) {
+	dir := t.TempDir()
+	imagePath := filepath.Join(dir, "large.jpg")
+	writeTestJPEG(t, imagePath, 400, 200)
+
+	mediumPath, err := MediumCopy(imagePath, dir, 100)
+	if err != nil {
+		t.Fatalf("MediumCopy() error = %v", err)
+	}
+	if mediumPath == imagePath {
+		t.Fatal("MediumCopy() returned the original for an image larger than maxSize")
+	}
+	defer os.Remove(mediumPath)
+
+	file, err := os.Open(mediumPath)
+	if err != nil {
+		t.Fatalf("Failed to open medium copy: %v", err)
+	}
+	defer file.Close()
+	config, err := jpeg.DecodeConfig(file)
+	if err != nil {
+		t.Fatalf("Medium copy is not a JPEG: %v", err)
+	}
+	if config.Width != 100 || config.Height != 50 {
+		t.Errorf("medium copy is %dx%d, want 100x50", config.Width, config.Height)
+	}
+
+	// Already small enough: the original is used as is
+	smallPath, err := MediumCopy(imagePath, dir, 400)
+	if err != nil {
+		t.Fatalf("MediumCopy() error = %v", err)
+	}
+	if smallPath != imagePath {
+		t.Errorf("MediumCopy() = %s, want the original %s", smallPath, imagePath)
+	}
+}
+
+func TestMediumCopy_Unsupported(t *testing.T) {
+	dir := t.TempDir()
+	videoPath := filepath.Join(dir, "clip.mov")
+	if err := os.WriteFile(videoPath, []byte("not an image"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if _, err := MediumCopy(videoPath, dir, 100); !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("MediumCopy() error = %v, want ErrUnsupportedImage", err)
+	}
+}
+
+func TestMediumCopy_AnimatedGIF(t *testing.T) {
+	dir := t.TempDir()
+	imagePath := filepath.Join(dir, "animated.gif")
+	animation := &gif.GIF{}
+	for i := 0; i < 2; i++ {
+		animation.Image = append(animation.Image, image.NewPaletted(image.Rect(0, 0, 400, 200), color.Palette{color.Black, color.White}))
+		animation.Delay = append(animation.Delay, 10)
+	}
+	file, err := os.Create(imagePath)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	if err := gif.EncodeAll(file, animation); err != nil {
+		t.Fatalf("Failed to encode image: %v", err)
+	}
+	file.Close()
+
+	// Scaling would keep only the first frame, so the original is used as is
+	mediumPath, err := MediumCopy(imagePath, dir, 100)
+	if err != nil {
+		t.Fatalf("MediumCopy() error = %v", err)
+	}
+	if mediumPath != imagePath {
+		t.Errorf("MediumCopy() = %s, want the animated original %s", mediumPath, imagePath)
+	}
+}
@@ -1,75 +1,441 @@
 package email
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/textproto"
 	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
 
+	"github.com/jsteffee/icloud-photo-sync/pkg/certs"
 	"github.com/jsteffee/icloud-photo-sync/pkg/config"
 	"gopkg.in/mail.v2"
 )
 
+// ErrMessageTooLarge is returned when the mail server or API rejects a message for exceeding
+// its size limit
+var ErrMessageTooLarge = errors.New("message too large")
+
 // Sender handles sending emails with image attachments
 type Sender struct {
 	smtpConfig *config.SMTPConfig
+	tlsConfig  *tls.Config
+	httpClient *http.Client  // Used by the sendgrid and mailgun backends
+	sends      chan struct{} // Slots for messages being sent at once; nil for no limit
 }
 
 // NewSender creates a new email sender
 func NewSender(smtpConfig *config.SMTPConfig) (*Sender, error) {
+	tlsConfig, err := buildTLSConfig(smtpConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Sender{
 		smtpConfig: smtpConfig,
+		tlsConfig:  tlsConfig,
+		httpClient: &http.Client{Timeout: time.Duration(smtpConfig.Timeout) * time.Second},
 	}, nil
 }
 
-// SendImage sends an email with an image attachment
-func (s *Sender) SendImage(imagePath string, destination string) error {
-	m := mail.NewMessage()
-	
+// buildTLSConfig creates the TLS configuration for the SMTP connection
+// Certificates are verified unless InsecureSkipVerify is set; CACertPath adds trusted CAs
+// for internal mail servers with self-signed certificates
+func buildTLSConfig(smtpConfig *config.SMTPConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: smtpConfig.InsecureSkipVerify,
+		ServerName:         smtpConfig.Server,
+	}
+
+	if smtpConfig.CACertPath != "" {
+		pool, err := certs.LoadPool(smtpConfig.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("SMTP: %w", err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// SetConcurrency limits how many messages are sent at once, across everything sharing the
+// sender, so the pipeline's email stages together stay within the provider's connection limit
+// (EMAIL_CONCURRENCY). Sends beyond it wait for one to finish. Call before sending anything
+func (s *Sender) SetConcurrency(limit int) {
+	if limit > 0 {
+		s.sends = make(chan struct{}, limit)
+	}
+}
+
+// Photo describes the photo an email is about; fields left at their zero value are omitted
+type Photo struct {
+	Taken       time.Time // When the photo was taken
+	Contributor string    // Who added the photo to the shared album
+	Album       string    // Name of the shared album
+	Caption     string    // Caption from the shared album
+}
+
+// describe summarizes when, by whom, and where a photo was shared, such as
+// "Taken June 15, 2024 by Grandma in album "Summer"."; it returns "" if none of them are known
+func (p Photo) describe() string {
+	var parts []string
+	if !p.Taken.IsZero() {
+		parts = append(parts, "taken "+p.Taken.Format("January 2, 2006"))
+	}
+	if p.Contributor != "" {
+		if len(parts) == 0 {
+			parts = append(parts, "shared")
+		}
+		parts = append(parts, "by "+p.Contributor)
+	}
+	if p.Album != "" {
+		parts = append(parts, fmt.Sprintf("in album %q", p.Album))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	description := strings.Join(parts, " ")
+	return strings.ToUpper(description[:1]) + description[1:] + "."
+}
+
+// PhotoMessage is the email about one new photo
+type PhotoMessage struct {
+	Photo                 // Described in the body; its album names the subject and the thread
+	Destination    string // Recipient(s); empty for SMTP_DESTINATION
+	AttachmentPath string // File attached, e.g. a medium copy of the photo; empty for none
+	AttachmentName string // Name the attachment is shown as (see AttachmentName); empty for the file's name
+	OriginalURL    string // Link to the full-size original; empty for none
+}
+
+// SendPhoto emails the photo at imagePath, describing it in the body: when it was taken, who
+// shared it, its album, and its caption. Emails for the same album reference a common thread
+// so mail clients group them per album
+func (s *Sender) SendPhoto(ctx context.Context, imagePath string, msg PhotoMessage) error {
+	photo := msg.Photo
+	subject := "New Photo from " + albumLabel(photo.Album)
+	body := "A new photo has been added to the shared album."
+	if description := photo.describe(); description != "" {
+		body = fmt.Sprintf("%s\n\n%s", body, description)
+	}
+	if photo.Caption != "" {
+		subject = fmt.Sprintf("%s: %s", subject, strings.Join(strings.Fields(photo.Caption), " "))
+		body = fmt.Sprintf("%s\n\n%s", body, photo.Caption)
+	}
+	if msg.OriginalURL != "" {
+		body = fmt.Sprintf("%s\n\nDownload the full-size original: %s", body, msg.OriginalURL)
+	}
+
+	m := s.newMessage(msg.Destination, subject)
+	s.setThread(m.Message, photo.Album, strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath)))
+	m.setBody(body)
+
+	// Attach the image
+	if msg.AttachmentPath != "" {
+		filename := msg.AttachmentName
+		if filename == "" {
+			filename = filepath.Base(msg.AttachmentPath)
+		}
+		m.attach(msg.AttachmentPath, filename)
+	}
+
+	return s.send(ctx, m)
+}
+
+// AttachmentName builds a human-friendly attachment name such as 2024-06-15_beach.jpg from
+// the photo's capture date and caption. Without a caption, the start of the on-disk name
+// keeps names unique; without a capture date or caption, the on-disk name is used as is.
+func AttachmentName(imagePath string, taken time.Time, caption string) string {
+	base := filepath.Base(imagePath)
+	ext := filepath.Ext(base)
+	slug := slugify(caption)
+	if taken.IsZero() && slug == "" {
+		return base
+	}
+
+	if slug == "" {
+		slug = strings.TrimSuffix(base, ext)
+		if len(slug) > 8 {
+			slug = slug[:8]
+		}
+	}
+	if taken.IsZero() {
+		return slug + ext
+	}
+	return taken.Format("2006-01-02") + "_" + slug + ext
+}
+
+// maxSlugLength bounds the caption part of attachment names
+const maxSlugLength = 40
+
+// slugify lowercases text and replaces runs of anything but letters and digits with a dash
+func slugify(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+		if b.Len() >= maxSlugLength {
+			break
+		}
+	}
+	return b.String()
+}
+
+// SendZip sends an email with a zip archive of new photos attached
+// part and totalParts describe the archive's position when the photos were split across several zips.
+// album names the photos' album when they all come from one, and is empty otherwise
+func (s *Sender) SendZip(zipPath string, destination string, imageCount int, part int, totalParts int, album string) error {
+	subject := "New Photos from " + albumLabel(album)
+	filename := fmt.Sprintf("icloud-photos-%s.zip", time.Now().Format("2006-01-02"))
+	if totalParts > 1 {
+		subject = fmt.Sprintf("%s (part %d of %d)", subject, part, totalParts)
+		filename = fmt.Sprintf("icloud-photos-%s-part%d.zip", time.Now().Format("2006-01-02"), part)
+	}
+
+	m := s.newMessage(destination, subject)
+	s.setThread(m.Message, album, fmt.Sprintf("zip-part%d", part))
+	m.setBody(fmt.Sprintf("%d new photos have been added to the shared album. They are attached as a zip archive.", imageCount))
+	m.attach(zipPath, filename)
+
+	return s.send(context.Background(), m)
+}
+
+// SendContactSheet sends an email with a contact sheet (a grid of thumbnails) of new photos
+// attached, showing shown of the imageCount photos; sheetPath may be empty when none could be
+// shown. part, totalParts and album are as for SendZip
+func (s *Sender) SendContactSheet(sheetPath string, destination string, imageCount int, shown int, part int, totalParts int, album string) error {
+	subject := "Contact Sheet from " + albumLabel(album)
+	filename := fmt.Sprintf("icloud-photos-%s.jpg", time.Now().Format("2006-01-02"))
+	if totalParts > 1 {
+		subject = fmt.Sprintf("%s (part %d of %d)", subject, part, totalParts)
+		filename = fmt.Sprintf("icloud-photos-%s-part%d.jpg", time.Now().Format("2006-01-02"), part)
+	}
+	body := fmt.Sprintf("%d new photos have been added to the shared album.", imageCount)
+	switch {
+	case sheetPath == "":
+		body += " None of them could be previewed (e.g. videos)."
+	case shown < imageCount:
+		body += fmt.Sprintf(" %d of them are shown in the attached contact sheet; the others couldn't be previewed (e.g. videos).", shown)
+	default:
+		body += " They are shown in the attached contact sheet."
+	}
+
+	m := s.newMessage(destination, subject)
+	s.setThread(m.Message, album, fmt.Sprintf("contact-sheet-part%d", part))
+	m.setBody(body)
+	if sheetPath != "" {
+		m.attach(sheetPath, filename)
+	}
+
+	return s.send(context.Background(), m)
+}
+
+// SendWelcome introduces an album that has just started syncing (WELCOME_EMAIL), with a photo
+// from it attached as its cover. coverPath may be empty to send the introduction without one
+func (s *Sender) SendWelcome(coverPath string, destination string, album string, photoCount int) error {
+	subject := "Now Syncing " + albumLabel(album)
+	body := "Photos added to the shared album will be sent here from now on."
+	if photoCount > 0 {
+		body = fmt.Sprintf("%s The album currently has %d photos.", body, photoCount)
+	}
+	if coverPath != "" {
+		body = fmt.Sprintf("%s\n\nThe attached photo is from the album.", body)
+	}
+
+	m := s.newMessage(destination, subject)
+	s.setThread(m.Message, album, "welcome")
+	m.setBody(body)
+	if coverPath != "" {
+		m.attach(coverPath, "cover"+strings.ToLower(filepath.Ext(coverPath)))
+	}
+
+	return s.send(context.Background(), m)
+}
+
+// SendAlert sends a plain-text operator alert with no attachments
+func (s *Sender) SendAlert(subject string, body string, destination string) error {
+	m := s.newMessage(destination, subject)
+	m.setBody(body)
+	return s.send(context.Background(), m)
+}
+
+// message is an outgoing email. Headers live on the gomail message used for SMTP; the body and
+// attachments, which gomail doesn't expose again, are also kept for the HTTP API backends
+type message struct {
+	*mail.Message
+	body        string
+	attachments []attachment
+}
+
+// attachment is a file attached to a message under the given name
+type attachment struct {
+	path string
+	name string
+}
+
+// setBody sets the message's plain-text body
+func (m *message) setBody(body string) {
+	m.body = body
+	m.SetBody("text/plain", body)
+}
+
+// attach adds the file at path as an attachment shown as name
+func (m *message) attach(path string, name string) {
+	m.attachments = append(m.attachments, attachment{path: path, name: name})
+	m.Attach(path, mail.Rename(name))
+}
+
+// newMessage creates a message with the From, Reply-To, To, and Subject headers set
+func (s *Sender) newMessage(destination string, subject string) *message {
+	m := &message{Message: mail.NewMessage()}
+
 	// Some SMTP servers (like ProtonMail Bridge) require the From address to match
 	// the authenticated username. Use username as From, but set Reply-To if custom From is specified.
-	fromAddr := s.smtpConfig.Username
+	fromAddr := s.fromAddress()
 	replyToAddr := s.smtpConfig.From
 	if replyToAddr == "" {
 		replyToAddr = s.smtpConfig.Username
 	}
-	
+
 	// Set From header to authenticated username (required by some SMTP servers)
-	m.SetHeader("From", fromAddr)
+	m.SetHeader("From", s.formatAddress(m.Message, fromAddr))
 	// Set Reply-To to the desired sender address if different
 	if replyToAddr != fromAddr {
-		m.SetHeader("Reply-To", replyToAddr)
+		m.SetHeader("Reply-To", s.formatAddress(m.Message, replyToAddr))
+	}
+	m.SetHeader("To", recipients(destination)...)
+	if s.smtpConfig.SubjectPrefix != "" {
+		subject = s.smtpConfig.SubjectPrefix + " " + subject
 	}
-	m.SetHeader("To", destination)
-	m.SetHeader("Subject", "New Photo from iCloud Album")
-	m.SetBody("text/plain", "A new photo has been added to the shared album.")
+	m.SetHeader("Subject", subject)
+	return m
+}
 
-	// Attach the image
-	filename := filepath.Base(imagePath)
-	m.Attach(imagePath, mail.Rename(filename))
+// recipients splits a comma-separated destination into its addresses, so one email can go to
+// several recipients
+func recipients(destination string) []string {
+	var addresses []string
+	for _, address := range strings.Split(destination, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
 
-	// Create dialer
-	d := mail.NewDialer(s.smtpConfig.Server, s.smtpConfig.Port, s.smtpConfig.Username, s.smtpConfig.Password)
-	
-	// Skip certificate verification for self-signed or mismatched certificates
-	// This is common with local SMTP servers like ProtonMail Bridge
-	d.TLSConfig = &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         s.smtpConfig.Server,
+// formatAddress adds the display name from SMTP_FROM to an address, e.g.
+// "iCloud Sync" <sync@example.com>. Without a name, or for a username that isn't an email
+// address, the address is used as is
+func (s *Sender) formatAddress(m *mail.Message, address string) string {
+	if s.smtpConfig.FromName == "" || !strings.Contains(address, "@") {
+		return address
 	}
+	return m.FormatAddress(address, s.smtpConfig.FromName)
+}
 
-	// For port 25, ProtonMail Bridge typically requires STARTTLS for authentication
-	// Try MandatoryStartTLS first (required for authentication on port 25)
-	if s.smtpConfig.Port == 25 {
-		d.StartTLSPolicy = mail.MandatoryStartTLS
-	} else {
-		// For other ports, try opportunistic STARTTLS
-		d.StartTLSPolicy = mail.OpportunisticStartTLS
+// albumLabel names an album in subjects, with a short identifier derived from its name so
+// albums with similar names stay apart; an unknown album is described generically
+func albumLabel(album string) string {
+	if album == "" {
+		return "iCloud Album"
+	}
+	return fmt.Sprintf("%s [%s]", album, albumID(album)[:6])
+}
+
+// albumID returns a stable identifier for an album, used in subjects and thread headers
+func albumID(album string) string {
+	sum := sha256.Sum256([]byte(album))
+	return hex.EncodeToString(sum[:8])
+}
+
+// setThread gives the message a unique Message-ID and, when the album is known, makes it a
+// reply to a fixed per-album thread ID. The thread's root message is never sent; mail clients
+// still group every message referencing it into one conversation
+func (s *Sender) setThread(m *mail.Message, album string, id string) {
+	domain := s.messageDomain()
+	m.SetHeader("Message-ID", fmt.Sprintf("<%s.%d@%s>", id, time.Now().UnixNano(), domain))
+	if album == "" {
+		return
 	}
+	thread := fmt.Sprintf("<album.%s@%s>", albumID(album), domain)
+	m.SetHeader("In-Reply-To", thread)
+	m.SetHeader("References", thread)
+}
+
+// fromAddress returns the From address: the SMTP username, or the configured From address for
+// the API backends, which have no username
+func (s *Sender) fromAddress() string {
+	if s.usesAPI() {
+		return s.smtpConfig.From
+	}
+	return s.smtpConfig.Username
+}
+
+// usesAPI reports whether messages are sent through an HTTP API instead of SMTP
+func (s *Sender) usesAPI() bool {
+	return s.smtpConfig.Backend == "sendgrid" || s.smtpConfig.Backend == "mailgun"
+}
+
+// messageDomain returns the domain used in generated message IDs: the sender's domain, or a
+// fixed placeholder when the From address isn't an email address
+func (s *Sender) messageDomain() string {
+	from := s.fromAddress()
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		return from[at+1:]
+	}
+	return "icloud-photo-sync.local"
+}
+
+// send delivers a message through the configured backend, waiting for a free slot when the
+// concurrency is limited. Nothing is sent once ctx is done
+func (s *Sender) send(ctx context.Context, m *message) error {
+	if s.sends != nil {
+		select {
+		case s.sends <- struct{}{}:
+		case <-ctx.Done():
+			return fmt.Errorf("failed to send email: %w", ctx.Err())
+		}
+		defer func() { <-s.sends }()
+	}
+	switch s.smtpConfig.Backend {
+	case "sendgrid":
+		return s.sendSendGrid(ctx, m)
+	case "mailgun":
+		return s.sendMailgun(ctx, m)
+	}
+	return s.sendSMTP(ctx, m.Message)
+}
+
+// sendSMTP delivers a message through the configured SMTP server. The SMTP client can't be
+// interrupted, so ctx is only checked before connecting
+func (s *Sender) sendSMTP(ctx context.Context, m *mail.Message) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	d := s.newDialer()
 
 	// Send email
 	if err := d.DialAndSend(m); err != nil {
+		if tooLarge(err) {
+			return fmt.Errorf("failed to send email: %w: %v", ErrMessageTooLarge, err)
+		}
 		// If MandatoryStartTLS fails on port 25, try OpportunisticStartTLS as fallback
-		if s.smtpConfig.Port == 25 && d.StartTLSPolicy == mail.MandatoryStartTLS {
+		// (only when the policy came from the port-25 default, not an explicit mode)
+		if s.smtpConfig.TLSMode == "" && s.smtpConfig.Port == 25 && d.StartTLSPolicy == mail.MandatoryStartTLS {
 			d.StartTLSPolicy = mail.OpportunisticStartTLS
 			if err2 := d.DialAndSend(m); err2 != nil {
 				return fmt.Errorf("failed to send email on port 25 (tried MandatoryStartTLS and OpportunisticStartTLS): %w (original: %v)", err2, err)
@@ -82,3 +448,58 @@ func (s *Sender) SendImage(imagePath string, destination string) error {
 	return nil
 }
 
+// tooLarge reports whether an SMTP error is the server rejecting the message for its size:
+// reply code 552 (other than a full mailbox) or enhanced status 5.3.4
+func tooLarge(err error) bool {
+	var sendErr *mail.SendError
+	if errors.As(err, &sendErr) {
+		err = sendErr.Cause
+	}
+	var smtpErr *textproto.Error
+	if !errors.As(err, &smtpErr) {
+		return false
+	}
+	if strings.HasPrefix(smtpErr.Msg, "5.3.4") {
+		return true
+	}
+	return smtpErr.Code == 552 && !strings.HasPrefix(smtpErr.Msg, "5.2.2")
+}
+
+// newDialer creates a dialer with the configured TLS verification and TLS mode
+func (s *Sender) newDialer() *mail.Dialer {
+	d := mail.NewDialer(s.smtpConfig.Server, s.smtpConfig.Port, s.smtpConfig.Username, s.smtpConfig.Password)
+
+	// Certificate verification is configurable: local servers like ProtonMail Bridge use
+	// self-signed certificates and need SMTP_INSECURE_SKIP_VERIFY or SMTP_CA_CERT
+	d.TLSConfig = s.tlsConfig
+
+	// Bound the connection and each SMTP command so an unreachable server fails the send
+	// instead of stalling the run
+	d.Timeout = time.Duration(s.smtpConfig.Timeout) * time.Second
+
+	// An explicit SMTP_TLS_MODE overrides the port-based defaults below
+	switch s.smtpConfig.TLSMode {
+	case "starttls":
+		d.SSL = false
+		d.StartTLSPolicy = mail.OpportunisticStartTLS
+	case "mandatory-starttls":
+		d.SSL = false
+		d.StartTLSPolicy = mail.MandatoryStartTLS
+	case "implicit-tls":
+		d.SSL = true
+	case "none":
+		d.SSL = false
+		d.StartTLSPolicy = mail.NoStartTLS
+	default:
+		// For port 25, ProtonMail Bridge typically requires STARTTLS for authentication
+		// Try MandatoryStartTLS first (required for authentication on port 25)
+		if s.smtpConfig.Port == 25 {
+			d.StartTLSPolicy = mail.MandatoryStartTLS
+		} else {
+			// For other ports, try opportunistic STARTTLS
+			d.StartTLSPolicy = mail.OpportunisticStartTLS
+		}
+	}
+
+	return d
+}
@@ -1,9 +1,19 @@
 package email
 
 import (
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+	"gopkg.in/mail.v2"
 )
 
 func TestNewSender(t *testing.T) {
@@ -28,17 +38,291 @@ func TestNewSender(t *testing.T) {
 	}
 }
 
-// Note: Testing SendImage requires a real SMTP server or a mock
+func TestNewSender_TLSConfig(t *testing.T) {
+	// Verification is on by default
+	sender, err := NewSender(&config.SMTPConfig{Server: "smtp.example.com", Port: 587})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	if sender.tlsConfig.InsecureSkipVerify {
+		t.Error("NewSender() should verify certificates by default")
+	}
+	if sender.tlsConfig.ServerName != "smtp.example.com" {
+		t.Errorf("tlsConfig.ServerName = %v, want smtp.example.com", sender.tlsConfig.ServerName)
+	}
+
+	sender, err = NewSender(&config.SMTPConfig{Server: "127.0.0.1", Port: 25, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	if !sender.tlsConfig.InsecureSkipVerify {
+		t.Error("NewSender() did not honor InsecureSkipVerify")
+	}
+}
+
+func TestNewSender_CACert(t *testing.T) {
+	// Use the self-signed certificate of a test TLS server as the custom CA
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caPath, pemData, 0644); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	sender, err := NewSender(&config.SMTPConfig{Server: "smtp.internal", Port: 587, CACertPath: caPath})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	if sender.tlsConfig.RootCAs == nil {
+		t.Error("NewSender() did not load the custom CA certificate")
+	}
+
+	// Missing and invalid CA files are reported at startup
+	if _, err := NewSender(&config.SMTPConfig{Server: "smtp.internal", CACertPath: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("NewSender() expected error for missing CA file")
+	}
+	invalidPath := filepath.Join(t.TempDir(), "invalid.pem")
+	if err := os.WriteFile(invalidPath, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("Failed to write invalid CA file: %v", err)
+	}
+	if _, err := NewSender(&config.SMTPConfig{Server: "smtp.internal", CACertPath: invalidPath}); err == nil {
+		t.Error("NewSender() expected error for invalid CA file")
+	}
+}
+
+func TestSender_NewDialer_TLSMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		port       int
+		tlsMode    string
+		wantSSL    bool
+		wantPolicy mail.StartTLSPolicy
+	}{
+		{name: "default port 25", port: 25, wantPolicy: mail.MandatoryStartTLS},
+		{name: "default port 587", port: 587, wantPolicy: mail.OpportunisticStartTLS},
+		{name: "default port 465", port: 465, wantSSL: true, wantPolicy: mail.OpportunisticStartTLS},
+		{name: "starttls on port 25", port: 25, tlsMode: "starttls", wantPolicy: mail.OpportunisticStartTLS},
+		{name: "mandatory-starttls on 587", port: 587, tlsMode: "mandatory-starttls", wantPolicy: mail.MandatoryStartTLS},
+		{name: "implicit-tls on custom port", port: 2465, tlsMode: "implicit-tls", wantSSL: true},
+		{name: "none", port: 25, tlsMode: "none", wantPolicy: mail.NoStartTLS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender, err := NewSender(&config.SMTPConfig{Server: "smtp.example.com", Port: tt.port, TLSMode: tt.tlsMode})
+			if err != nil {
+				t.Fatalf("NewSender() error = %v", err)
+			}
+			d := sender.newDialer()
+			if d.SSL != tt.wantSSL {
+				t.Errorf("newDialer() SSL = %v, want %v", d.SSL, tt.wantSSL)
+			}
+			if !tt.wantSSL && d.StartTLSPolicy != tt.wantPolicy {
+				t.Errorf("newDialer() StartTLSPolicy = %v, want %v", d.StartTLSPolicy, tt.wantPolicy)
+			}
+		})
+	}
+}
+
+func TestSender_NewDialer_Timeout(t *testing.T) {
+	sender, err := NewSender(&config.SMTPConfig{Server: "smtp.example.com", Port: 587, Timeout: 15})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	if d := sender.newDialer(); d.Timeout != 15*time.Second {
+		t.Errorf("newDialer() Timeout = %v, want 15s", d.Timeout)
+	}
+}
+
+// Note: Testing SendPhoto requires a real SMTP server or a mock
 // For unit tests, we would typically use a mock SMTP server
 // This is a placeholder that can be expanded with actual SMTP mocking
-func TestSender_SendImage(t *testing.T) {
-	t.Skip("SendImage test requires SMTP server or mock - implement with test SMTP server")
-	
+func TestSender_SendPhoto(t *testing.T) {
+	t.Skip("SendPhoto test requires SMTP server or mock - implement with test SMTP server")
+
 	// Example test structure:
 	// 1. Set up mock SMTP server
 	// 2. Create sender with mock server config
 	// 3. Create test image file
-	// 4. Call SendImage
+	// 4. Call SendPhoto
 	// 5. Verify email was sent correctly
 }
 
+func TestSender_NewMessage_SubjectPrefix(t *testing.T) {
+	sender, err := NewSender(&config.SMTPConfig{Server: "smtp.example.com", Username: "test@example.com", SubjectPrefix: "[Photos]"})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+
+	m := sender.newMessage("dest@example.com", "New Photo from "+albumLabel("Family"))
+	want := "[Photos] New Photo from Family [" + albumID("Family")[:6] + "]"
+	if got := m.GetHeader("Subject"); len(got) != 1 || got[0] != want {
+		t.Errorf("Subject = %v, want %q", got, want)
+	}
+}
+
+func TestSender_NewMessage_FromName(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      config.SMTPConfig
+		wantFrom    string
+		wantReplyTo string
+	}{
+		{
+			name:        "name on both headers",
+			config:      config.SMTPConfig{Username: "bridge@example.com", From: "sync@example.com", FromName: "iCloud Sync"},
+			wantFrom:    `"iCloud Sync" <bridge@example.com>`,
+			wantReplyTo: `"iCloud Sync" <sync@example.com>`,
+		},
+		{
+			name:        "no name",
+			config:      config.SMTPConfig{Username: "bridge@example.com", From: "sync@example.com"},
+			wantFrom:    "bridge@example.com",
+			wantReplyTo: "sync@example.com",
+		},
+		{
+			name:        "username that isn't an address",
+			config:      config.SMTPConfig{Username: "bridge", From: "sync@example.com", FromName: "iCloud Sync"},
+			wantFrom:    "bridge",
+			wantReplyTo: `"iCloud Sync" <sync@example.com>`,
+		},
+		{
+			name:     "API backend",
+			config:   config.SMTPConfig{Backend: "sendgrid", From: "sync@example.com", FromName: "iCloud Sync"},
+			wantFrom: `"iCloud Sync" <sync@example.com>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender, err := NewSender(&tt.config)
+			if err != nil {
+				t.Fatalf("NewSender() error = %v", err)
+			}
+			m := sender.newMessage("dest@example.com", "Subject")
+			if got := m.GetHeader("From"); len(got) != 1 || got[0] != tt.wantFrom {
+				t.Errorf("From = %v, want %q", got, tt.wantFrom)
+			}
+			got := m.GetHeader("Reply-To")
+			if (tt.wantReplyTo == "" && len(got) != 0) || (tt.wantReplyTo != "" && (len(got) != 1 || got[0] != tt.wantReplyTo)) {
+				t.Errorf("Reply-To = %v, want %q", got, tt.wantReplyTo)
+			}
+		})
+	}
+}
+
+func TestSender_SetThread(t *testing.T) {
+	sender, err := NewSender(&config.SMTPConfig{Server: "smtp.example.com", Username: "test@example.com"})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+
+	first := mail.NewMessage()
+	sender.setThread(first, "Family", "abc")
+	second := mail.NewMessage()
+	sender.setThread(second, "Family", "def")
+	other := mail.NewMessage()
+	sender.setThread(other, "Vacation", "abc")
+
+	ref := first.GetHeader("References")
+	if len(ref) != 1 || !strings.HasSuffix(ref[0], "@example.com>") {
+		t.Fatalf("References = %v, want one ID in the sender's domain", ref)
+	}
+	if got := first.GetHeader("In-Reply-To"); len(got) != 1 || got[0] != ref[0] {
+		t.Errorf("In-Reply-To = %v, want %v", got, ref)
+	}
+	if got := second.GetHeader("References"); len(got) != 1 || got[0] != ref[0] {
+		t.Errorf("References for the same album = %v, want %v", got, ref)
+	}
+	if got := other.GetHeader("References"); len(got) != 1 || got[0] == ref[0] {
+		t.Errorf("References for another album = %v, want a different thread", got)
+	}
+	if first.GetHeader("Message-ID")[0] == second.GetHeader("Message-ID")[0] {
+		t.Error("Message-ID should be unique per message")
+	}
+
+	// Without an album there is no thread to join
+	unknown := mail.NewMessage()
+	sender.setThread(unknown, "", "abc")
+	if got := unknown.GetHeader("References"); len(got) != 0 {
+		t.Errorf("References without album = %v, want none", got)
+	}
+	if got := unknown.GetHeader("Message-ID"); len(got) != 1 {
+		t.Errorf("Message-ID without album = %v, want one", got)
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "size limit", err: &textproto.Error{Code: 552, Msg: "5.3.4 Message size exceeds fixed limit"}, want: true},
+		{name: "552 without enhanced code", err: &textproto.Error{Code: 552, Msg: "Message too big"}, want: true},
+		{name: "5.3.4 with another code", err: &textproto.Error{Code: 554, Msg: "5.3.4 Message too big for system"}, want: true},
+		{name: "mailbox full", err: &textproto.Error{Code: 552, Msg: "5.2.2 Mailbox full"}, want: false},
+		{name: "other rejection", err: &textproto.Error{Code: 550, Msg: "5.7.1 Relaying denied"}, want: false},
+		{name: "wrapped in send error", err: &mail.SendError{Cause: &textproto.Error{Code: 552, Msg: "5.3.4 Message size exceeds fixed limit"}}, want: true},
+		{name: "not an SMTP reply", err: errors.New("connection refused"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tooLarge(tt.err); got != tt.want {
+				t.Errorf("tooLarge(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttachmentName(t *testing.T) {
+	taken := time.Date(2024, 6, 15, 10, 30, 0, 0, time.UTC)
+	imagePath := "/images/ab/abcdef0123456789.jpg"
+
+	tests := []struct {
+		name    string
+		taken   time.Time
+		caption string
+		want    string
+	}{
+		{name: "date and caption", taken: taken, caption: "Beach day!", want: "2024-06-15_beach-day.jpg"},
+		{name: "date only", taken: taken, want: "2024-06-15_abcdef01.jpg"},
+		{name: "caption only", caption: "Sunset", want: "sunset.jpg"},
+		{name: "no metadata", want: "abcdef0123456789.jpg"},
+		{name: "caption without letters", taken: taken, caption: "!!!", want: "2024-06-15_abcdef01.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AttachmentName(imagePath, tt.taken, tt.caption); got != tt.want {
+				t.Errorf("AttachmentName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPhoto_Describe(t *testing.T) {
+	taken := time.Date(2024, 6, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		photo Photo
+		want  string
+	}{
+		{name: "everything", photo: Photo{Taken: taken, Contributor: "Grandma", Album: "Summer", Caption: "Beach day"}, want: `Taken June 15, 2024 by Grandma in album "Summer".`},
+		{name: "date and album", photo: Photo{Taken: taken, Album: "Summer"}, want: `Taken June 15, 2024 in album "Summer".`},
+		{name: "contributor only", photo: Photo{Contributor: "Grandma"}, want: "Shared by Grandma."},
+		{name: "album only", photo: Photo{Album: "Summer"}, want: `In album "Summer".`},
+		{name: "caption only", photo: Photo{Caption: "Beach day"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.photo.describe(); got != tt.want {
+				t.Errorf("describe() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package immich
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+)
+
+// DeviceID identifies this service to Immich as the device assets were uploaded from. Immich
+// deduplicates uploads by device and device asset ID
+const DeviceID = "icloud-photo-sync"
+
+// Client uploads assets to an Immich server through its REST API
+type Client struct {
+	config     *config.ImmichConfig
+	httpClient *http.Client
+}
+
+// NewClient creates a new Immich client
+func NewClient(cfg *config.ImmichConfig) (*Client, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("Immich config is required")
+	}
+	if cfg.URL == "" || cfg.APIKey == "" {
+		return nil, fmt.Errorf("Immich URL and API key are required")
+	}
+
+	return &Client{
+		config: cfg,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Minute, // Large videos can take a while
+		},
+	}, nil
+}
+
+// uploadResponse is the body Immich returns for an upload
+type uploadResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"` // created, or duplicate if Immich already had the asset
+}
+
+// UploadAsset uploads the file at filePath as deviceAssetID, dated taken (the file's
+// modification time if zero). Uploading an asset Immich already has is not an error
+func (c *Client) UploadAsset(filePath string, deviceAssetID string, taken time.Time) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+	if taken.IsZero() {
+		taken = info.ModTime()
+	}
+
+	// Stream the form so large videos aren't held in memory
+	body, form := io.Pipe()
+	writer := multipart.NewWriter(form)
+	go func() {
+		form.CloseWithError(writeUploadForm(writer, file, deviceAssetID, taken, info.ModTime()))
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, c.config.URL+"/api/assets", body)
+	if err != nil {
+		body.Close()
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("x-api-key", c.config.APIKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload asset: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("Immich upload failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(bodyBytes)))
+	}
+
+	var result uploadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode upload response: %w", err)
+	}
+	if result.Status == "duplicate" {
+		logging.Debugf("Immich already has %s as asset %s", filePath, result.ID)
+	}
+	return nil
+}
+
+// writeUploadForm writes the fields and file of an asset upload and closes the form
+func writeUploadForm(writer *multipart.Writer, file *os.File, deviceAssetID string, created time.Time, modified time.Time) error {
+	fields := [][2]string{
+		{"deviceAssetId", deviceAssetID},
+		{"deviceId", DeviceID},
+		{"fileCreatedAt", created.UTC().Format(time.RFC3339)},
+		{"fileModifiedAt", modified.UTC().Format(time.RFC3339)},
+	}
+	for _, field := range fields {
+		if err := writer.WriteField(field[0], field[1]); err != nil {
+			return fmt.Errorf("failed to write form field: %w", err)
+		}
+	}
+
+	part, err := writer.CreateFormFile("assetData", filepath.Base(file.Name()))
+	if err != nil {
+		return fmt.Errorf("failed to create file part: %w", err)
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return writer.Close()
+}
@@ -0,0 +1,118 @@
+package immich
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+)
+
+func TestNewClient_RequiresConfig(t *testing.T) {
+	if _, err := NewClient(nil); err == nil {
+		t.Error("NewClient(nil) error = nil, want error")
+	}
+	if _, err := NewClient(&config.ImmichConfig{URL: "https://immich.example.com"}); err == nil {
+		t.Error("NewClient() without API key error = nil, want error")
+	}
+}
+
+func TestClient_UploadAsset(t *testing.T) {
+	fields := map[string]string{}
+	var path, apiKey, fileName, fileData string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		apiKey = r.Header.Get("x-api-key")
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("Failed to parse form: %v", err)
+			return
+		}
+		for key, values := range r.MultipartForm.Value {
+			fields[key] = values[0]
+		}
+		if files := r.MultipartForm.File["assetData"]; len(files) == 1 {
+			fileName = files[0].Filename
+			file, err := files[0].Open()
+			if err == nil {
+				data, _ := io.ReadAll(file)
+				fileData = string(data)
+				file.Close()
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": "asset-1", "status": "created"}`))
+	}))
+	defer server.Close()
+
+	imagePath := filepath.Join(t.TempDir(), "abc123.jpg")
+	if err := os.WriteFile(imagePath, []byte("fake jpeg data"), 0644); err != nil {
+		t.Fatalf("Failed to write test image: %v", err)
+	}
+
+	client, err := NewClient(&config.ImmichConfig{URL: server.URL, APIKey: "immich-key"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	taken := time.Date(2024, 6, 15, 10, 30, 0, 0, time.UTC)
+	if err := client.UploadAsset(imagePath, "abc123", taken); err != nil {
+		t.Fatalf("UploadAsset() error = %v", err)
+	}
+
+	if path != "/api/assets" || apiKey != "immich-key" {
+		t.Errorf("request = %s with key %q, want /api/assets with immich-key", path, apiKey)
+	}
+	if fields["deviceAssetId"] != "abc123" || fields["deviceId"] != DeviceID {
+		t.Errorf("device fields = %q/%q, want abc123/%s", fields["deviceAssetId"], fields["deviceId"], DeviceID)
+	}
+	if fields["fileCreatedAt"] != "2024-06-15T10:30:00Z" || fields["fileModifiedAt"] == "" {
+		t.Errorf("dates = %q/%q, want the capture date and a modification date", fields["fileCreatedAt"], fields["fileModifiedAt"])
+	}
+	if fileName != "abc123.jpg" || fileData != "fake jpeg data" {
+		t.Errorf("asset = %s %q, want abc123.jpg with the image data", fileName, fileData)
+	}
+}
+
+func TestClient_UploadAsset_Status(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{name: "duplicate", status: http.StatusOK, body: `{"id": "asset-1", "status": "duplicate"}`},
+		{name: "bad API key", status: http.StatusUnauthorized, body: `{"message": "Invalid API key"}`, wantErr: "status 401: {\"message\": \"Invalid API key\"}"},
+	}
+
+	imagePath := filepath.Join(t.TempDir(), "abc123.jpg")
+	if err := os.WriteFile(imagePath, []byte("fake jpeg data"), 0644); err != nil {
+		t.Fatalf("Failed to write test image: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client, err := NewClient(&config.ImmichConfig{URL: server.URL, APIKey: "immich-key"})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			err = client.UploadAsset(imagePath, "abc123", time.Time{})
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("UploadAsset() error = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("UploadAsset() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is the minimum severity of messages that are logged
+type Level int32
+
+const (
+	LevelDebug Level = iota // Per-photo detail such as derivatives, tracking checks, and skips
+	LevelInfo               // Normal progress: runs, albums, deliveries
+	LevelWarn               // Problems that don't fail the run, such as quarantined images
+	LevelError              // Failures
+)
+
+// level is the current minimum level; info unless SetLevel is called
+var level atomic.Int32
+
+func init() {
+	level.Store(int32(LevelInfo))
+}
+
+// ParseLevel parses a LOG_LEVEL value: debug, info, warn (or warning), or error
+func ParseLevel(value string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", value)
+}
+
+// String returns the level's name as used in LOG_LEVEL
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "info"
+}
+
+// SetLevel sets the minimum level of messages that are logged
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// Enabled reports whether messages at l are logged
+func Enabled(l Level) bool {
+	return l >= Level(level.Load())
+}
+
+// Debugf logs a message at debug level
+func Debugf(format string, args ...interface{}) {
+	logf(LevelDebug, "DEBUG", format, args...)
+}
+
+// Infof logs a message at info level
+func Infof(format string, args ...interface{}) {
+	logf(LevelInfo, "INFO", format, args...)
+}
+
+// Warnf logs a message at warn level
+func Warnf(format string, args ...interface{}) {
+	logf(LevelWarn, "WARN", format, args...)
+}
+
+// Errorf logs a message at error level
+func Errorf(format string, args ...interface{}) {
+	logf(LevelError, "ERROR", format, args...)
+}
+
+// logf writes the message through the standard logger, tagged with its level
+func logf(l Level, tag string, format string, args ...interface{}) {
+	if !Enabled(l) {
+		return
+	}
+	// Depth 3 attributes the message to the caller of Debugf etc. when Lshortfile is set
+	log.Output(3, tag+" "+fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Level
+		wantErr bool
+	}{
+		{value: "debug", want: LevelDebug},
+		{value: "INFO", want: LevelInfo},
+		{value: "warn", want: LevelWarn},
+		{value: "warning", want: LevelWarn},
+		{value: " error ", want: LevelError},
+		{value: "verbose", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseLevel(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseLevel(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLevelFiltering(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer SetLevel(LevelInfo)
+
+	SetLevel(LevelWarn)
+	Debugf("debug %d", 1)
+	Infof("info %d", 2)
+	Warnf("warn %d", 3)
+	Errorf("error %d", 4)
+
+	out := buf.String()
+	for _, unwanted := range []string{"debug 1", "info 2"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("output contains %q below the level:\n%s", unwanted, out)
+		}
+	}
+	for _, wanted := range []string{"WARN warn 3", "ERROR error 4"} {
+		if !strings.Contains(out, wanted) {
+			t.Errorf("output missing %q:\n%s", wanted, out)
+		}
+	}
+}
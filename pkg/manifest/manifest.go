@@ -0,0 +1,78 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// HashStore lists the hashes each service has delivered (implemented by redis.Client)
+type HashStore interface {
+	ListHashesFor(service string) (map[string]string, error)
+}
+
+// ImageLocator finds downloaded images by hash (implemented by storage.Manager)
+type ImageLocator interface {
+	GetImagePath(hash string) (string, error)
+}
+
+// Entry describes one synced image
+type Entry struct {
+	Hash     string   `json:"hash"`
+	ImageURL string   `json:"image_url"`
+	Path     string   `json:"path,omitempty"` // Empty if the file is no longer in the image directory
+	Services []string `json:"services"`
+}
+
+// Manifest is a portable record of sync state
+type Manifest struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Images      []Entry   `json:"images"`
+}
+
+// Build joins the hashes each service has delivered with the files present in the image directory
+// Images are sorted by hash; services are listed in the order given
+func Build(store HashStore, locator ImageLocator, services []string) (*Manifest, error) {
+	entries := make(map[string]*Entry)
+	for _, service := range services {
+		hashes, err := store.ListHashesFor(service)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s hashes: %w", service, err)
+		}
+		for hash, imageURL := range hashes {
+			entry, ok := entries[hash]
+			if !ok {
+				entry = &Entry{Hash: hash, ImageURL: imageURL}
+				entries[hash] = entry
+			}
+			entry.Services = append(entry.Services, service)
+		}
+	}
+
+	m := &Manifest{
+		GeneratedAt: time.Now().UTC(),
+		Images:      make([]Entry, 0, len(entries)),
+	}
+	for hash, entry := range entries {
+		if path, err := locator.GetImagePath(hash); err == nil {
+			entry.Path = path
+		}
+		m.Images = append(m.Images, *entry)
+	}
+	sort.Slice(m.Images, func(i, j int) bool {
+		return m.Images[i].Hash < m.Images[j].Hash
+	})
+	return m, nil
+}
+
+// Write encodes the manifest as indented JSON
+func Write(w io.Writer, m *Manifest) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(m); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
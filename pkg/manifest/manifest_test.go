@@ -0,0 +1,67 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type fakeStore map[string]map[string]string
+
+func (s fakeStore) ListHashesFor(service string) (map[string]string, error) {
+	return s[service], nil
+}
+
+type fakeLocator map[string]string
+
+func (l fakeLocator) GetImagePath(hash string) (string, error) {
+	if path, ok := l[hash]; ok {
+		return path, nil
+	}
+	return "", fmt.Errorf("image not found for hash: %s", hash)
+}
+
+func TestBuild(t *testing.T) {
+	store := fakeStore{
+		"email": {
+			"bbb": "https://example.com/b.jpg",
+			"aaa": "https://example.com/a.jpg",
+		},
+		"google_photos": {
+			"aaa": "https://example.com/a.jpg",
+		},
+	}
+	locator := fakeLocator{"aaa": "/images/aaa.jpg"}
+
+	m, err := Build(store, locator, []string{"email", "google_photos"})
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	want := []Entry{
+		{Hash: "aaa", ImageURL: "https://example.com/a.jpg", Path: "/images/aaa.jpg", Services: []string{"email", "google_photos"}},
+		{Hash: "bbb", ImageURL: "https://example.com/b.jpg", Services: []string{"email"}},
+	}
+	if !reflect.DeepEqual(m.Images, want) {
+		t.Errorf("Build() images = %+v, want %+v", m.Images, want)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	m := &Manifest{Images: []Entry{{Hash: "aaa", ImageURL: "https://example.com/a.jpg", Services: []string{"email"}}}}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, m); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var decoded Manifest
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Write() produced invalid JSON: %v", err)
+	}
+	if !reflect.DeepEqual(decoded.Images, m.Images) {
+		t.Errorf("decoded images = %+v, want %+v", decoded.Images, m.Images)
+	}
+}
@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveNotifier copies each new image into a separate directory (e.g. a NAS share)
+type ArchiveNotifier struct {
+	dir      string
+	baseURL  string        // Optional - URL the directory is served at, for OriginalURL
+	template *NameTemplate // Optional - names copies instead of <hash>.<ext>
+}
+
+// NewArchiveNotifier creates a notifier that copies images into dir, creating it if needed
+func NewArchiveNotifier(dir string) (*ArchiveNotifier, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	return &ArchiveNotifier{dir: dir}, nil
+}
+
+// SetBaseURL sets the URL at which the archive directory is served over HTTP
+func (n *ArchiveNotifier) SetBaseURL(baseURL string) {
+	n.baseURL = strings.TrimSuffix(baseURL, "/")
+}
+
+// SetNameTemplate names archived copies with template instead of after the stored file
+func (n *ArchiveNotifier) SetNameTemplate(template *NameTemplate) {
+	n.template = template
+}
+
+// OriginalURL returns the URL of the archived copy of an image
+func (n *ArchiveNotifier) OriginalURL(hash string, imagePath string, metadata Metadata) (string, error) {
+	if n.baseURL == "" {
+		return "", fmt.Errorf("no base URL configured for the archive directory")
+	}
+	name, err := n.archiveName(hash, imagePath, metadata)
+	if err != nil {
+		return "", err
+	}
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return n.baseURL + "/" + strings.Join(segments, "/"), nil
+}
+
+// archiveName returns the slash-separated name of an image's copy relative to the archive
+// directory. A templated name already taken by a different file gets a suffix from the hash
+func (n *ArchiveNotifier) archiveName(hash string, imagePath string, metadata Metadata) (string, error) {
+	if n.template == nil {
+		return filepath.Base(imagePath), nil
+	}
+	name, err := n.template.Name(hash, imagePath, metadata)
+	if err != nil {
+		return "", err
+	}
+	existing := filepath.Join(n.dir, filepath.FromSlash(name))
+	if _, err := os.Stat(existing); err == nil && !sameContent(existing, imagePath) {
+		name = uniqueName(name, hash)
+	}
+	return name, nil
+}
+
+// Name returns the tracking key for archive delivery
+func (n *ArchiveNotifier) Name() string {
+	return "archive"
+}
+
+// Process copies the image into the archive directory
+// The copy is written to a temp file and renamed so the archive never holds partial images
+func (n *ArchiveNotifier) Process(hash string, imagePath string, metadata Metadata) error {
+	src, err := os.Open(imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to open image: %w", err)
+	}
+	defer src.Close()
+
+	name, err := n.archiveName(hash, imagePath, metadata)
+	if err != nil {
+		return err
+	}
+	destPath := filepath.Join(n.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("failed to create archive subdirectory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(destPath), ".archive-*")
+	if err != nil {
+		return fmt.Errorf("failed to create archive file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to copy image to archive: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write archive file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), destPath); err != nil {
+		return fmt.Errorf("failed to move image into archive: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package notify
+
+// PhotoPoster posts a photo with an optional caption to a chat (implemented by
+// telegram.Client and slack.Client)
+type PhotoPoster interface {
+	PostPhoto(filePath string, caption string) error
+}
+
+// ChatNotifier posts each new image to a chat app, captioned with its iCloud caption
+type ChatNotifier struct {
+	name   string
+	poster PhotoPoster
+}
+
+// NewChatNotifier creates a notifier that posts images with poster, tracked under name
+// (e.g. "telegram" or "slack")
+func NewChatNotifier(name string, poster PhotoPoster) *ChatNotifier {
+	return &ChatNotifier{name: name, poster: poster}
+}
+
+// Name returns the tracking key for the chat
+func (n *ChatNotifier) Name() string {
+	return n.name
+}
+
+// Process posts the image
+func (n *ChatNotifier) Process(hash string, imagePath string, metadata Metadata) error {
+	return n.poster.PostPhoto(imagePath, metadata.Caption)
+}
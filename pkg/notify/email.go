@@ -0,0 +1,494 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/email"
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+	"github.com/jsteffee/icloud-photo-sync/pkg/storage"
+)
+
+// EmailNotifier emails each new image as an attachment
+type EmailNotifier struct {
+	sender      *email.Sender
+	destination string
+	linker      OriginalLinker // Optional - set by SetMediumCopy or SetThumbnail
+	mediumSize  int            // Longest side of the scaled copy attached; 0 attaches the image itself
+	thumbnail   bool           // The scaled copy is a preview, so the link to the original is optional
+	tempDir     string
+}
+
+// NewEmailNotifier creates a notifier that emails each image to destination
+func NewEmailNotifier(sender *email.Sender, destination string) *EmailNotifier {
+	return &EmailNotifier{
+		sender:      sender,
+		destination: destination,
+	}
+}
+
+// Name returns the tracking key for email delivery
+func (n *EmailNotifier) Name() string {
+	return "email"
+}
+
+// SetMediumCopy makes the notifier attach a copy of each image scaled to at most maxSize
+// pixels, written to tempDir, and link to the original at the URL linker returns for it.
+// Images that can't be scaled (e.g. videos) are sent with the link alone
+func (n *EmailNotifier) SetMediumCopy(linker OriginalLinker, maxSize int, tempDir string) {
+	n.linker = linker
+	n.mediumSize = maxSize
+	n.tempDir = tempDir
+}
+
+// SetThumbnail makes the notifier attach only a small preview of each image, scaled to at most
+// maxSize pixels and written to tempDir, for recipients who view the full quality elsewhere
+// (e.g. Google Photos). linker may be nil; otherwise the email also links to the original.
+// Images that can't be scaled (e.g. videos) are sent as a notification without an attachment
+func (n *EmailNotifier) SetThumbnail(linker OriginalLinker, maxSize int, tempDir string) {
+	n.linker = linker
+	n.mediumSize = maxSize
+	n.thumbnail = true
+	n.tempDir = tempDir
+}
+
+// Process emails the image described by its capture date, contributor, album, and caption,
+// naming the attachment after its capture date and caption, to the album's own destination if
+// it has one. A smaller derivative in metadata is attached in place of the original
+func (n *EmailNotifier) Process(hash string, imagePath string, metadata Metadata) error {
+	source := emailSource(imagePath, metadata)
+	attachmentName := email.AttachmentName(source, metadata.Taken, metadata.Caption)
+	photo := email.Photo{
+		Taken:       metadata.Taken,
+		Contributor: metadata.Contributor,
+		Album:       metadata.Album,
+		Caption:     metadata.Caption,
+	}
+	destination := emailDestination(metadata.Destination, n.destination)
+	if n.mediumSize == 0 || (n.linker == nil && !n.thumbnail) {
+		return n.sendPhoto(imagePath, source, destination, attachmentName, photo, "")
+	}
+
+	var originalURL string
+	if n.linker != nil {
+		var err error
+		originalURL, err = n.linker.OriginalURL(hash, imagePath, metadata)
+		switch {
+		case err != nil && n.thumbnail:
+			// The original is delivered elsewhere; the preview is still worth sending
+			logging.Warnf("Error getting link to original of %s, sending the thumbnail without it: %v", imagePath, err)
+			originalURL = ""
+		case err != nil:
+			// Without a link the recipient would never get the original, so attach it (or the
+			// derivative chosen for email) instead
+			logging.Warnf("Error getting link to original of %s, attaching it instead: %v", imagePath, err)
+			return n.sendPhoto(imagePath, source, destination, attachmentName, photo, "")
+		}
+	}
+
+	attachmentPath, err := email.MediumCopy(source, n.tempDir, n.mediumSize)
+	switch {
+	case errors.Is(err, email.ErrUnsupportedImage):
+		logging.Debugf("Sending %s without an attachment: %v", imagePath, err)
+		attachmentPath = ""
+	case err != nil && n.thumbnail:
+		return fmt.Errorf("failed to create thumbnail: %w", err)
+	case err != nil:
+		return fmt.Errorf("failed to create medium copy: %w", err)
+	case attachmentPath != source:
+		defer os.Remove(attachmentPath)
+		// The attachment is now a JPEG whatever the original's format
+		attachmentName = strings.TrimSuffix(attachmentName, filepath.Ext(attachmentName)) + ".jpg"
+	}
+	return n.sendPhoto(imagePath, attachmentPath, destination, attachmentName, photo, originalURL)
+}
+
+// reducedSizes are the longest sides, in pixels, of the smaller copies tried in turn when the
+// mail server rejects an image for its size
+var reducedSizes = []int{2048, 1280}
+
+// sendPhoto emails the photo, and if the mail server rejects it as too large, retries with
+// reduced JPEG copies of the attachment so the photo still gets through
+func (n *EmailNotifier) sendPhoto(imagePath string, attachmentPath string, destination string, attachmentName string, photo email.Photo, originalURL string) error {
+	msg := email.PhotoMessage{
+		Photo:          photo,
+		Destination:    destination,
+		AttachmentPath: attachmentPath,
+		AttachmentName: attachmentName,
+		OriginalURL:    originalURL,
+	}
+	err := n.sender.SendPhoto(context.Background(), imagePath, msg)
+	if !errors.Is(err, email.ErrMessageTooLarge) || attachmentPath == "" {
+		return err
+	}
+	reducedName := strings.TrimSuffix(attachmentName, filepath.Ext(attachmentName)) + ".jpg"
+	for _, size := range reducedSizes {
+		reduced, scaleErr := email.MediumCopy(attachmentPath, n.tempDir, size)
+		if errors.Is(scaleErr, email.ErrUnsupportedImage) {
+			return err
+		}
+		if scaleErr != nil {
+			return fmt.Errorf("%w (and creating a reduced copy failed: %v)", err, scaleErr)
+		}
+		if reduced == attachmentPath {
+			// Already no larger than this; only a smaller size can help
+			continue
+		}
+		msg.AttachmentPath, msg.AttachmentName = reduced, reducedName
+		sendErr := n.sender.SendPhoto(context.Background(), imagePath, msg)
+		os.Remove(reduced)
+		if sendErr == nil {
+			logging.Warnf("%s was too large to email, sent a copy reduced to %d pixels instead", imagePath, size)
+			return nil
+		}
+		if !errors.Is(sendErr, email.ErrMessageTooLarge) {
+			return sendErr
+		}
+		err = sendErr
+	}
+	return err
+}
+
+// Welcome emails an introduction to the album with its cover photo attached, scaled like the
+// images themselves when a medium copy or thumbnail is attached. A cover that can't be scaled
+// (e.g. a video) is left out
+func (n *EmailNotifier) Welcome(album string, destination string, coverPath string, photoCount int) error {
+	if coverPath != "" && n.mediumSize > 0 {
+		scaled, err := email.MediumCopy(coverPath, n.tempDir, n.mediumSize)
+		switch {
+		case errors.Is(err, email.ErrUnsupportedImage):
+			logging.Debugf("Sending welcome email for %s without a cover: %v", album, err)
+			scaled = ""
+		case err != nil:
+			return fmt.Errorf("failed to scale cover photo: %w", err)
+		case scaled != coverPath:
+			defer os.Remove(scaled)
+		}
+		coverPath = scaled
+	}
+	return n.sender.SendWelcome(coverPath, emailDestination(destination, n.destination), album, photoCount)
+}
+
+// emailSource returns the file to email for an image: its derivative if one was downloaded,
+// otherwise the original
+func emailSource(imagePath string, metadata Metadata) string {
+	if metadata.Derivative != "" {
+		return metadata.Derivative
+	}
+	return imagePath
+}
+
+// EmailZipNotifier queues new images and emails them as zip archive(s) at the end of the run
+// It shares the "email" tracking key with EmailNotifier
+type EmailZipNotifier struct {
+	sender         *email.Sender
+	storageManager *storage.Manager
+	destination    string
+	maxBytes       int64
+	partialSend    bool // Send the rest of a batch when one of its images can't be attached or sent
+	queue          []queuedImage
+}
+
+// queuedImage is an image waiting to be included in a zip archive
+type queuedImage struct {
+	hash      string
+	imagePath string
+	metadata  Metadata
+}
+
+// NewEmailZipNotifier creates a notifier that emails images as zips of at most maxBytes of image data
+func NewEmailZipNotifier(sender *email.Sender, storageManager *storage.Manager, destination string, maxBytes int64) *EmailZipNotifier {
+	return &EmailZipNotifier{
+		sender:         sender,
+		storageManager: storageManager,
+		destination:    destination,
+		maxBytes:       maxBytes,
+	}
+}
+
+// Name returns the tracking key for email delivery
+func (n *EmailZipNotifier) Name() string {
+	return "email"
+}
+
+// SetPartialSend makes Flush leave out images that can't be read, or that make a zip too large
+// for the mail server even on their own, and send the rest instead of failing their whole zip
+func (n *EmailZipNotifier) SetPartialSend(enabled bool) {
+	n.partialSend = enabled
+}
+
+// Process queues the image, or its smaller derivative if one was downloaded, for the
+// end-of-run zip
+func (n *EmailZipNotifier) Process(hash string, imagePath string, metadata Metadata) error {
+	n.queue = append(n.queue, queuedImage{hash: hash, imagePath: emailSource(imagePath, metadata), metadata: metadata})
+	return ErrQueued
+}
+
+// Welcome emails an introduction to the album right away, with its cover photo attached
+func (n *EmailZipNotifier) Welcome(album string, destination string, coverPath string, photoCount int) error {
+	return n.sender.SendWelcome(coverPath, emailDestination(destination, n.destination), album, photoCount)
+}
+
+// Flush bundles the queued images into zip archive(s) and emails them
+func (n *EmailZipNotifier) Flush() ([]Delivery, error) {
+	queue := n.queue
+	n.queue = nil
+
+	var delivered []Delivery
+	var dropped []string
+	failed, total := 0, 0
+	// Each destination gets its own zips, numbered separately
+	for _, batch := range byDestination(queue, n.destination) {
+		imagePaths := make([]string, 0, len(batch.images))
+		byPath := make(map[string]queuedImage, len(batch.images))
+		for _, image := range batch.images {
+			imagePaths = append(imagePaths, image.imagePath)
+			byPath[image.imagePath] = image
+		}
+
+		if n.partialSend {
+			var unreadable []string
+			imagePaths, unreadable = readableImages(imagePaths)
+			dropped = append(dropped, unreadable...)
+		}
+		archives, err := n.storageManager.CreateZipArchives(imagePaths, n.maxBytes)
+		if err != nil {
+			return delivered, fmt.Errorf("failed to create zip archive of %d images: %w", len(imagePaths), err)
+		}
+
+		total += len(archives)
+		for i, archive := range archives {
+			logging.Infof("Emailing zip archive %d/%d with %d images to %s", i+1, len(archives), len(archive.ImagePaths), batch.destination)
+			sent, tooLarge, err := n.sendArchive(archive, batch.destination, i+1, len(archives), byPath)
+			for _, imagePath := range sent {
+				image := byPath[imagePath]
+				delivered = append(delivered, Delivery{Hash: image.hash, Metadata: image.metadata})
+			}
+			dropped = append(dropped, tooLarge...)
+			if err != nil {
+				logging.Errorf("Error sending zip archive %d/%d to %s: %v", i+1, len(archives), batch.destination, err)
+				failed++
+			}
+		}
+	}
+	switch {
+	case failed > 0:
+		return delivered, fmt.Errorf("failed to send %d of %d zip archives", failed, total)
+	case len(dropped) > 0:
+		// Left undelivered, so the next run tries them again
+		return delivered, fmt.Errorf("left %d images out of the zip emails: %v", len(dropped), dropped)
+	}
+	return delivered, nil
+}
+
+// sendArchive emails a zip archive and returns the images it delivered. With partial sends, a
+// zip the mail server rejects as too large is split in half and each half sent on its own,
+// until the images too large to send even alone are isolated; those are returned as dropped
+func (n *EmailZipNotifier) sendArchive(archive storage.ZipArchive, destination string, part int, totalParts int, byPath map[string]queuedImage) ([]string, []string, error) {
+	err := n.sender.SendZip(archive.Path, destination, len(archive.ImagePaths), part, totalParts, commonAlbum(archive.ImagePaths, byPath))
+	os.Remove(archive.Path)
+	if err == nil {
+		return archive.ImagePaths, nil, nil
+	}
+	if !n.partialSend || !errors.Is(err, email.ErrMessageTooLarge) {
+		return nil, nil, err
+	}
+	if len(archive.ImagePaths) == 1 {
+		logging.Errorf("Leaving %s out of the zip email: too large to send even on its own: %v", archive.ImagePaths[0], err)
+		return nil, archive.ImagePaths, nil
+	}
+
+	logging.Warnf("Zip archive %d/%d with %d images is too large to send, splitting it", part, totalParts, len(archive.ImagePaths))
+	var sent, dropped []string
+	half := len(archive.ImagePaths) / 2
+	for _, imagePaths := range [][]string{archive.ImagePaths[:half], archive.ImagePaths[half:]} {
+		archives, err := n.storageManager.CreateZipArchives(imagePaths, 0)
+		if err != nil {
+			return sent, dropped, fmt.Errorf("failed to create zip archive of %d images: %w", len(imagePaths), err)
+		}
+		halfSent, halfDropped, err := n.sendArchive(archives[0], destination, part, totalParts, byPath)
+		sent = append(sent, halfSent...)
+		dropped = append(dropped, halfDropped...)
+		if err != nil {
+			return sent, dropped, err
+		}
+	}
+	return sent, dropped, nil
+}
+
+// readableImages splits the given images into those that can be read, to be zipped, and those
+// that can't, which are logged
+func readableImages(imagePaths []string) ([]string, []string) {
+	var readable, unreadable []string
+	for _, imagePath := range imagePaths {
+		file, err := os.Open(imagePath)
+		if err != nil {
+			logging.Errorf("Leaving %s out of the zip email: %v", imagePath, err)
+			unreadable = append(unreadable, imagePath)
+			continue
+		}
+		file.Close()
+		readable = append(readable, imagePath)
+	}
+	return readable, unreadable
+}
+
+// commonAlbum returns the album shared by all the given queued images, or "" if they come
+// from more than one
+func commonAlbum(imagePaths []string, byPath map[string]queuedImage) string {
+	album := ""
+	for i, imagePath := range imagePaths {
+		imageAlbum := byPath[imagePath].metadata.Album
+		if i > 0 && imageAlbum != album {
+			return ""
+		}
+		album = imageAlbum
+	}
+	return album
+}
+
+// destinationBatch is the queued images emailed to one destination
+type destinationBatch struct {
+	destination string
+	images      []queuedImage
+}
+
+// byDestination splits queued images by the recipient(s) they're emailed to, in the order each
+// destination first appears. Images whose album has no destination of its own go to
+// defaultDestination
+func byDestination(queue []queuedImage, defaultDestination string) []destinationBatch {
+	var batches []destinationBatch
+	index := make(map[string]int)
+	for _, image := range queue {
+		destination := emailDestination(image.metadata.Destination, defaultDestination)
+		i, ok := index[destination]
+		if !ok {
+			i = len(batches)
+			index[destination] = i
+			batches = append(batches, destinationBatch{destination: destination})
+		}
+		batches[i].images = append(batches[i].images, image)
+	}
+	return batches
+}
+
+// emailDestination returns the recipient(s) of an album's emails: its own destination if it
+// has one, otherwise defaultDestination (SMTP_DESTINATION)
+func emailDestination(albumDestination string, defaultDestination string) string {
+	if albumDestination != "" {
+		return albumDestination
+	}
+	return defaultDestination
+}
+
+// contactSheetMaxRows bounds the height of a contact sheet; larger batches are split across
+// several sheets
+const contactSheetMaxRows = 10
+
+// ContactSheetNotifier queues new images and emails them as contact sheets (grids of
+// thumbnails) at the end of the run. Sent alongside the per-image emails it is tracked as
+// "contact_sheet"; in place of them it shares the "email" tracking key with EmailNotifier
+type ContactSheetNotifier struct {
+	sender      *email.Sender
+	destination string
+	name        string
+	columns     int
+	thumbSize   int
+	tempDir     string
+	queue       []queuedImage
+}
+
+// NewContactSheetNotifier creates a notifier that emails images as contact sheets with the
+// given number of columns of thumbSize pixel thumbnails, written to tempDir. name is its
+// tracking key
+func NewContactSheetNotifier(sender *email.Sender, destination string, name string, columns int, thumbSize int, tempDir string) *ContactSheetNotifier {
+	return &ContactSheetNotifier{
+		sender:      sender,
+		destination: destination,
+		name:        name,
+		columns:     columns,
+		thumbSize:   thumbSize,
+		tempDir:     tempDir,
+	}
+}
+
+// Name returns the tracking key the notifier was created with
+func (n *ContactSheetNotifier) Name() string {
+	return n.name
+}
+
+// Process queues the image, or its smaller derivative if one was downloaded, for the
+// end-of-run contact sheet
+func (n *ContactSheetNotifier) Process(hash string, imagePath string, metadata Metadata) error {
+	n.queue = append(n.queue, queuedImage{hash: hash, imagePath: emailSource(imagePath, metadata), metadata: metadata})
+	return ErrQueued
+}
+
+// Welcome emails an introduction to the album when the contact sheets replace the per-image
+// emails; alongside them, EmailNotifier introduces it
+func (n *ContactSheetNotifier) Welcome(album string, destination string, coverPath string, photoCount int) error {
+	if n.name != "email" {
+		return nil
+	}
+	return n.sender.SendWelcome(coverPath, emailDestination(destination, n.destination), album, photoCount)
+}
+
+// Flush draws the queued images into contact sheet(s) and emails them
+func (n *ContactSheetNotifier) Flush() ([]Delivery, error) {
+	queue := n.queue
+	n.queue = nil
+
+	perSheet := n.columns * contactSheetMaxRows
+	byPath := make(map[string]queuedImage, len(queue))
+	for _, image := range queue {
+		byPath[image.imagePath] = image
+	}
+
+	var delivered []Delivery
+	failed, total := 0, 0
+	// Each destination gets its own sheets, numbered separately
+	for _, destinationQueue := range byDestination(queue, n.destination) {
+		images := destinationQueue.images
+		sheets := (len(images) + perSheet - 1) / perSheet
+		total += sheets
+		for i := 0; i < sheets; i++ {
+			batch := images[i*perSheet : min((i+1)*perSheet, len(images))]
+			imagePaths := make([]string, 0, len(batch))
+			for _, image := range batch {
+				imagePaths = append(imagePaths, image.imagePath)
+			}
+			if err := n.sendSheet(imagePaths, destinationQueue.destination, i+1, sheets, commonAlbum(imagePaths, byPath)); err != nil {
+				logging.Errorf("Error sending contact sheet %d/%d to %s: %v", i+1, sheets, destinationQueue.destination, err)
+				failed++
+				continue
+			}
+			for _, image := range batch {
+				delivered = append(delivered, Delivery{Hash: image.hash, Metadata: image.metadata})
+			}
+		}
+	}
+	if failed > 0 {
+		return delivered, fmt.Errorf("failed to send %d of %d contact sheets", failed, total)
+	}
+	return delivered, nil
+}
+
+// sendSheet draws one contact sheet and emails it. Images that can't be previewed are
+// still counted in the email, which goes out without a sheet if none of them can
+func (n *ContactSheetNotifier) sendSheet(imagePaths []string, destination string, part int, totalParts int, album string) error {
+	sheetPath, shown, err := email.ContactSheet(imagePaths, n.tempDir, n.columns, n.thumbSize)
+	switch {
+	case errors.Is(err, email.ErrUnsupportedImage):
+		logging.Debugf("Sending contact sheet %d/%d without a sheet: %v", part, totalParts, err)
+	case err != nil:
+		return fmt.Errorf("failed to create contact sheet: %w", err)
+	default:
+		defer os.Remove(sheetPath)
+	}
+	logging.Infof("Emailing contact sheet %d/%d of %d images", part, totalParts, len(imagePaths))
+	return n.sender.SendContactSheet(sheetPath, destination, len(imagePaths), shown, part, totalParts, album)
+}
@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+	"github.com/jsteffee/icloud-photo-sync/pkg/photos"
+)
+
+// GooglePhotosNotifier uploads new images to Google Photos
+// Process is safe for concurrent use
+type GooglePhotosNotifier struct {
+	client    *photos.Client
+	albumName string
+	albumID   string // Resolved by Prepare each run; empty uploads to the library only
+	rotated   bool   // Resolve each photo's album from its capture date instead of using albumID
+	// onUnavailable is called when uploads stop for a condition retrying won't fix (revoked token,
+	// full album, storage quota) until it returns nil (e.g. an alert was sent); it is re-armed by
+	// the next successful upload
+	onUnavailable func(err error) error
+	alertMutex    sync.Mutex // Guards alerted
+	alerted       bool
+}
+
+// NewGooglePhotosNotifier creates a notifier that uploads to the named album (or the library
+// only if albumName is empty). onUnavailable may be nil.
+func NewGooglePhotosNotifier(client *photos.Client, albumName string, onUnavailable func(err error) error) *GooglePhotosNotifier {
+	return &GooglePhotosNotifier{
+		client:        client,
+		albumName:     albumName,
+		onUnavailable: onUnavailable,
+	}
+}
+
+// SetAlbumRotation files each photo in the album for its capture month or year (see
+// photos.Client.AlbumNameFor) instead of the single named album, when rotation isn't "none"
+func (n *GooglePhotosNotifier) SetAlbumRotation(rotation string) {
+	n.rotated = n.albumName != "" && rotation != "" && rotation != "none"
+}
+
+// Name returns the tracking key for Google Photos delivery
+func (n *GooglePhotosNotifier) Name() string {
+	return "google_photos"
+}
+
+// Prepare gets or creates the Google Photos album for this run
+// If AlbumName is not set, photos will be uploaded to library only (for partner sharing)
+func (n *GooglePhotosNotifier) Prepare() error {
+	if n.albumName == "" {
+		// No album name specified - upload to library only (for partner sharing)
+		logging.Infof("No album name specified - photos will be uploaded to library only (partner sharing will work if enabled)")
+		return nil
+	}
+
+	if n.rotated {
+		// Albums are resolved per photo, and only created when a photo needs one
+		logging.Infof("Google Photos albums rotate by capture date: %s <period>", n.albumName)
+		n.client.ResetAlbumContents()
+		return nil
+	}
+
+	// Album name is specified - get or create the album
+	albumID, err := n.client.GetOrCreateAlbumID()
+	if err != nil {
+		n.handleError(err)
+		return fmt.Errorf("failed to get/create Google Photos album: %w", err)
+	}
+	n.albumID = albumID
+	logging.Infof("Using Google Photos album ID: %s", n.albumID)
+	// Photos may have been added to or removed from the album since the last run
+	n.client.ResetAlbumContents()
+	return nil
+}
+
+// Process uploads the image to Google Photos
+func (n *GooglePhotosNotifier) Process(hash string, imagePath string, metadata Metadata) error {
+	albumID := n.albumID
+	if n.rotated {
+		albumName := n.client.AlbumNameFor(metadata.Taken)
+		var err error
+		if albumID, err = n.client.GetOrCreateAlbumIDFor(albumName); err != nil {
+			if n.handleError(err) {
+				return fmt.Errorf("%w: %w", ErrUnavailable, err)
+			}
+			return fmt.Errorf("failed to get/create Google Photos album %q: %w", albumName, err)
+		}
+	}
+
+	if albumID != "" {
+		logging.Debugf("Uploading high-quality image to Google Photos album: %s (hash: %s)", imagePath, hash)
+	} else {
+		logging.Debugf("Uploading high-quality image to Google Photos library (for partner sharing): %s (hash: %s)", imagePath, hash)
+	}
+
+	if err := n.client.UploadPhoto(imagePath, albumID, photos.UploadOptions{
+		Hash:        hash,
+		Description: metadata.Caption,
+		FileName:    uploadName(hash, imagePath, metadata),
+	}); err != nil {
+		if n.handleError(err) {
+			// Every further upload would fail the same way
+			return fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+		return err
+	}
+	n.alertMutex.Lock()
+	n.alerted = false
+	n.alertMutex.Unlock()
+	return nil
+}
+
+// uploadName returns the name to upload an image under: the name iCloud served it as with a
+// short hash suffix (e.g. IMG_1234-0123456789ab.jpg), or "" for the stored file's name if
+// iCloud didn't send one. Camera file names repeat (counters wrap, and every device starts at
+// IMG_0001), so the suffix keeps different photos apart in Google Photos and for
+// SKIP_IF_IN_ALBUM, which matches photos by name
+func uploadName(hash string, imagePath string, metadata Metadata) string {
+	base := sanitizeSegment(strings.TrimSuffix(metadata.FileName, path.Ext(metadata.FileName)))
+	if base == "" {
+		return ""
+	}
+	return uniqueName(base+strings.ToLower(filepath.Ext(imagePath)), hash)
+}
+
+// handleError reports errors that retrying won't fix and returns true if err was one
+func (n *GooglePhotosNotifier) handleError(err error) bool {
+	var title, cause, action string
+	switch {
+	case errors.Is(err, photos.ErrTokenRevoked):
+		title = "GOOGLE PHOTOS AUTHORIZATION FAILED - RE-AUTHORIZATION REQUIRED"
+		cause = "The refresh token has been revoked or has expired"
+		action = "Generate a new GOOGLE_PHOTOS_REFRESH_TOKEN and restart the service."
+	case errors.Is(err, photos.ErrAlbumFull):
+		title = "GOOGLE PHOTOS ALBUM IS FULL"
+		cause = "The album has reached the 20,000 item limit"
+		action = "Set GOOGLE_PHOTOS_ALBUM_NAME to a new album and restart the service."
+	case errors.Is(err, photos.ErrStorageQuotaExceeded):
+		title = "GOOGLE PHOTOS STORAGE IS FULL"
+		cause = "The Google account has run out of storage"
+		action = "Free up space or upgrade the account's storage plan."
+	default:
+		return false
+	}
+
+	logging.Errorf("==================================================================")
+	logging.Errorf("%s", title)
+	logging.Errorf("%s: %v", cause, err)
+	logging.Errorf("%s", action)
+	logging.Errorf("==================================================================")
+
+	n.alertMutex.Lock()
+	defer n.alertMutex.Unlock()
+	if n.onUnavailable != nil && !n.alerted {
+		if alertErr := n.onUnavailable(err); alertErr != nil {
+			logging.Errorf("Error reporting Google Photos failure: %v", alertErr)
+		} else {
+			n.alerted = true
+		}
+	}
+	return true
+}
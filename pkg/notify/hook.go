@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+)
+
+// HookNotifier runs an external command for each new image (e.g. to push to S3 or run a tagger)
+type HookNotifier struct {
+	command string
+	timeout time.Duration
+}
+
+// NewHookNotifier creates a notifier that runs command with the image path, hash, and source URL
+// as arguments. A zero timeout lets the command run indefinitely.
+func NewHookNotifier(command string, timeout time.Duration) *HookNotifier {
+	return &HookNotifier{
+		command: command,
+		timeout: timeout,
+	}
+}
+
+// Name returns the tracking key for the post-processing hook
+func (n *HookNotifier) Name() string {
+	return "hook"
+}
+
+// Process runs the hook as: <command> <image path> <hash> <image URL>
+// The same values (plus the album name) are also passed as ICLOUD_SYNC_* environment variables
+// Output is logged; a nonzero exit or timeout is returned as an error so the hook is retried next run
+func (n *HookNotifier) Process(hash string, imagePath string, metadata Metadata) error {
+	ctx := context.Background()
+	if n.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, n.command, imagePath, hash, metadata.ImageURL)
+	cmd.Env = append(os.Environ(),
+		"ICLOUD_SYNC_IMAGE_PATH="+imagePath,
+		"ICLOUD_SYNC_HASH="+hash,
+		"ICLOUD_SYNC_IMAGE_URL="+metadata.ImageURL,
+		"ICLOUD_SYNC_ALBUM="+metadata.Album,
+	)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// Don't wait forever for output from child processes that outlive a killed hook
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if out := strings.TrimSpace(output.String()); out != "" {
+		logging.Debugf("Post hook output for %s:\n%s", hash, out)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("post hook timed out after %v", n.timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("post hook failed: %w", err)
+	}
+	return nil
+}
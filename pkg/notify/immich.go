@@ -0,0 +1,30 @@
+package notify
+
+import "time"
+
+// AssetUploader uploads a file to a photo server under a device asset ID (implemented by
+// immich.Client)
+type AssetUploader interface {
+	UploadAsset(filePath string, deviceAssetID string, taken time.Time) error
+}
+
+// ImmichNotifier uploads each new image to an Immich server
+type ImmichNotifier struct {
+	uploader AssetUploader
+}
+
+// NewImmichNotifier creates a notifier that uploads images with uploader
+func NewImmichNotifier(uploader AssetUploader) *ImmichNotifier {
+	return &ImmichNotifier{uploader: uploader}
+}
+
+// Name returns the tracking key for Immich delivery
+func (n *ImmichNotifier) Name() string {
+	return "immich"
+}
+
+// Process uploads the image, using its hash as the device asset ID so a retried upload is
+// recognized as the same asset
+func (n *ImmichNotifier) Process(hash string, imagePath string, metadata Metadata) error {
+	return n.uploader.UploadAsset(imagePath, hash, metadata.Taken)
+}
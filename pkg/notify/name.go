@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// NameFields are the values an ARCHIVE_NAME_TEMPLATE is executed with
+type NameFields struct {
+	Hash         string    // Content hash of the image
+	Date         time.Time // Capture time in UTC, or when the image was downloaded if unknown
+	Album        string    // iCloud album the image came from
+	OriginalName string    // Name iCloud served the file as without its extension, or the hash if unknown
+	Ext          string    // Lowercase extension including the dot, e.g. ".jpg"
+}
+
+// NameTemplate names archived files and S3 objects from a text/template, e.g.
+// {{.Date.Format "2006/01"}}/{{.Album}}/{{.OriginalName}}{{.Ext}}
+type NameTemplate struct {
+	tmpl *template.Template
+}
+
+// ParseNameTemplate parses text and checks that it can be executed
+func ParseNameTemplate(text string) (*NameTemplate, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	t := &NameTemplate{tmpl: tmpl}
+	if _, err := t.execute(NameFields{Hash: "hash", Date: time.Now().UTC(), Album: "album", OriginalName: "name", Ext: ".jpg"}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Name returns the slash-separated relative name for an image, sanitized so every path segment
+// is safe as a file name and an object key. An empty result falls back to <hash><ext>
+func (t *NameTemplate) Name(hash string, imagePath string, metadata Metadata) (string, error) {
+	fields := NameFields{
+		Hash:         hash,
+		Date:         metadata.Taken.UTC(),
+		Album:        metadata.Album,
+		OriginalName: originalName(metadata),
+		Ext:          strings.ToLower(filepath.Ext(imagePath)),
+	}
+	if fields.Date.IsZero() {
+		if info, err := os.Stat(imagePath); err == nil {
+			fields.Date = info.ModTime().UTC()
+		}
+	}
+	if fields.OriginalName == "" {
+		fields.OriginalName = hash
+	}
+
+	name, err := t.execute(fields)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute name template: %w", err)
+	}
+	if name = sanitizeName(name); name == "" {
+		name = hash + fields.Ext
+	}
+	return name, nil
+}
+
+// execute runs the template with fields
+func (t *NameTemplate) execute(fields NameFields) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, fields); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// uniqueName returns name with a suffix from hash before its extension, for an image whose
+// templated name is already taken by a different image
+func uniqueName(name string, hash string) string {
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + hash + ext
+}
+
+// originalName returns the name iCloud served an image as, or else the file name in its
+// download URL, without the extension
+func originalName(metadata Metadata) string {
+	base := metadata.FileName
+	if base == "" {
+		u, err := url.Parse(metadata.ImageURL)
+		if err != nil {
+			return ""
+		}
+		base = path.Base(u.Path)
+	}
+	if base == "." || base == "/" {
+		return ""
+	}
+	return sanitizeSegment(strings.TrimSuffix(base, path.Ext(base)))
+}
+
+// sanitizeName sanitizes each segment of a slash-separated name, dropping empty, "." and ".."
+// segments so the name can't escape the archive directory or bucket prefix
+func sanitizeName(name string) string {
+	var segments []string
+	for _, segment := range strings.Split(strings.ReplaceAll(name, "\\", "/"), "/") {
+		if segment = sanitizeSegment(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// sanitizeSegment replaces characters that aren't allowed in file names on common filesystems
+// and trims leading and trailing spaces and dots
+func sanitizeSegment(segment string) string {
+	segment = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, segment)
+	return strings.Trim(segment, " .")
+}
+
+// sameContent reports whether two files have identical contents, comparing them a chunk at a
+// time so large videos aren't read into memory
+func sameContent(a string, b string) bool {
+	fileA, err := os.Open(a)
+	if err != nil {
+		return false
+	}
+	defer fileA.Close()
+	fileB, err := os.Open(b)
+	if err != nil {
+		return false
+	}
+	defer fileB.Close()
+	infoA, errA := fileA.Stat()
+	infoB, errB := fileB.Stat()
+	if errA != nil || errB != nil || infoA.Size() != infoB.Size() {
+		return false
+	}
+
+	bufA, bufB := make([]byte, 64*1024), make([]byte, 64*1024)
+	for {
+		n, errA := io.ReadFull(fileA, bufA)
+		m, errB := io.ReadFull(fileB, bufB)
+		if n != m || !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == errA
+		}
+		if errA != nil || errB != nil {
+			return false
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package notify
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrQueued is returned by Process when delivery was queued and will happen on Flush
+var ErrQueued = errors.New("delivery queued until the end of the run")
+
+// ErrUnavailable is returned (wrapped) by Process when a notifier cannot deliver anything
+// else this run, e.g. because its credentials were revoked. The notifier is skipped for the
+// rest of the run.
+var ErrUnavailable = errors.New("notifier unavailable for the rest of this run")
+
+// Metadata describes the image being delivered
+type Metadata struct {
+	ImageURL    string    // Original iCloud URL of the image
+	Album       string    // Name of the iCloud album the image came from
+	Taken       time.Time // When the photo was created (zero if unknown)
+	Caption     string    // Caption from the shared album (may be empty)
+	GUID        string    // iCloud asset GUID (may be empty)
+	Contributor string    // Who added the photo to the shared album (may be empty)
+	Derivative  string    // Path of a smaller size of the image for email (empty to use the original)
+	FileName    string    // Name iCloud served the file as, e.g. IMG_1234.HEIC (may be empty)
+	Destination string    // Email recipient(s) of the image's album (empty for SMTP_DESTINATION)
+}
+
+// OriginalLinker is implemented by notifiers that store images where they can be downloaded
+// (S3, or an archive directory served over HTTP), so emails can link to the original
+type OriginalLinker interface {
+	OriginalURL(hash string, imagePath string, metadata Metadata) (string, error)
+}
+
+// Notifier delivers new images to one destination (email, Google Photos, webhook, ...)
+// Each notifier is tracked independently in the store under its Name
+type Notifier interface {
+	// Name identifies the notifier in logs and is the tracking key in the store (e.g. "email")
+	Name() string
+	// Process delivers a single image
+	Process(hash string, imagePath string, metadata Metadata) error
+}
+
+// Preparer is implemented by notifiers that need setup at the start of each run
+// A notifier whose Prepare fails is skipped for that run
+type Preparer interface {
+	Prepare() error
+}
+
+// Welcomer is implemented by notifiers that can introduce an album the first time it syncs
+// (WELCOME_EMAIL). destination is the album's own recipient(s), or empty for the notifier's
+// default. coverPath is a photo from the album, or empty if none could be downloaded
+type Welcomer interface {
+	Welcome(album string, destination string, coverPath string, photoCount int) error
+}
+
+// Delivery is an image delivered by a Flusher
+type Delivery struct {
+	Hash     string
+	Metadata Metadata
+}
+
+// Flusher is implemented by notifiers that batch deliveries (returning ErrQueued from Process)
+// Flush delivers everything queued and returns the images that were delivered; an error
+// means some queued images could not be delivered
+type Flusher interface {
+	Flush() ([]Delivery, error)
+}
+
+// Registry holds the enabled notifiers in the order they run
+type Registry struct {
+	notifiers   []Notifier
+	concurrency map[Notifier]int
+}
+
+// NewRegistry creates an empty notifier registry
+func NewRegistry() *Registry {
+	return &Registry{concurrency: make(map[Notifier]int)}
+}
+
+// Register adds a notifier that processes one image at a time
+func (r *Registry) Register(notifier Notifier) {
+	r.RegisterWithConcurrency(notifier, 1)
+}
+
+// RegisterWithConcurrency adds a notifier that may process up to workers images at once
+// The notifier must then be safe for concurrent use
+func (r *Registry) RegisterWithConcurrency(notifier Notifier, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	r.notifiers = append(r.notifiers, notifier)
+	r.concurrency[notifier] = workers
+}
+
+// Concurrency returns how many images a registered notifier may process at once
+func (r *Registry) Concurrency(notifier Notifier) int {
+	if workers, ok := r.concurrency[notifier]; ok {
+		return workers
+	}
+	return 1
+}
+
+// Notifiers returns the registered notifiers in registration order
+func (r *Registry) Notifiers() []Notifier {
+	return r.notifiers
+}
+
+// Names returns the names of the registered notifiers
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.notifiers))
+	for _, notifier := range r.notifiers {
+		names = append(names, notifier.Name())
+	}
+	return names
+}
@@ -0,0 +1,729 @@
+package notify
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+	"github.com/jsteffee/icloud-photo-sync/pkg/email"
+	"github.com/jsteffee/icloud-photo-sync/pkg/storage"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	if len(registry.Notifiers()) != 0 {
+		t.Fatalf("NewRegistry() has %d notifiers, want 0", len(registry.Notifiers()))
+	}
+
+	webhook := NewWebhookNotifier("http://example.com/hook")
+	registry.Register(webhook)
+	archive, err := NewArchiveNotifier(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewArchiveNotifier() error = %v", err)
+	}
+	registry.RegisterWithConcurrency(archive, 3)
+
+	want := []string{"webhook", "archive"}
+	if got := registry.Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+	if got := registry.Concurrency(webhook); got != 1 {
+		t.Errorf("Concurrency(webhook) = %d, want 1", got)
+	}
+	if got := registry.Concurrency(archive); got != 3 {
+		t.Errorf("Concurrency(archive) = %d, want 3", got)
+	}
+}
+
+func TestWebhookNotifier_Process(t *testing.T) {
+	var got webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Method = %s, want POST", r.Method)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %s, want application/json", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	notifier := NewWebhookNotifier(server.URL)
+	metadata := Metadata{ImageURL: "https://icloud.example.com/photo.jpg", Album: "Family"}
+	if err := notifier.Process("abc123", "/images/abc123.jpg", metadata); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	want := webhookPayload{
+		Hash:     "abc123",
+		ImageURL: "https://icloud.example.com/photo.jpg",
+		Album:    "Family",
+		Filename: "abc123.jpg",
+	}
+	if got != want {
+		t.Errorf("payload = %+v, want %+v", got, want)
+	}
+}
+
+func TestWebhookNotifier_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	notifier := NewWebhookNotifier(server.URL)
+	if err := notifier.Process("abc123", "/images/abc123.jpg", Metadata{}); err == nil {
+		t.Error("Process() expected error for 500 response, got nil")
+	}
+}
+
+func TestArchiveNotifier_Process(t *testing.T) {
+	srcDir := t.TempDir()
+	archiveDir := filepath.Join(t.TempDir(), "nested", "archive")
+
+	imagePath := filepath.Join(srcDir, "abc123.jpg")
+	data := []byte("fake image data")
+	if err := os.WriteFile(imagePath, data, 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+
+	notifier, err := NewArchiveNotifier(archiveDir)
+	if err != nil {
+		t.Fatalf("NewArchiveNotifier() error = %v", err)
+	}
+	if err := notifier.Process("abc123", imagePath, Metadata{}); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	copied, err := os.ReadFile(filepath.Join(archiveDir, "abc123.jpg"))
+	if err != nil {
+		t.Fatalf("Failed to read archived image: %v", err)
+	}
+	if string(copied) != string(data) {
+		t.Errorf("archived image = %q, want %q", copied, data)
+	}
+
+	// No temp files should be left behind
+	entries, err := os.ReadDir(archiveDir)
+	if err != nil {
+		t.Fatalf("Failed to read archive dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("archive dir has %d entries, want 1", len(entries))
+	}
+}
+
+func TestNameTemplate_Name(t *testing.T) {
+	taken := time.Date(2024, 6, 15, 10, 30, 0, 0, time.UTC)
+	metadata := Metadata{
+		ImageURL: "https://cvws.icloud-content.com/B/abc/IMG_1234.JPG?o=xyz",
+		Album:    "Summer",
+		Taken:    taken,
+	}
+	tests := []struct {
+		name     string
+		template string
+		metadata Metadata
+		want     string
+	}{
+		{name: "date, album, and original name", template: `{{.Date.Format "2006/01"}}/{{.Album}}/{{.OriginalName}}{{.Ext}}`, metadata: metadata, want: "2024/06/Summer/IMG_1234.jpg"},
+		{name: "hash", template: "{{.Hash}}{{.Ext}}", metadata: metadata, want: "abc123.jpg"},
+		{name: "unsafe album name", template: "{{.Album}}/{{.Hash}}{{.Ext}}", metadata: Metadata{Album: `../Mom: "Best" <2024>`}, want: "Mom_ _Best_ _2024_/abc123.jpg"},
+		{name: "unknown original name", template: "{{.OriginalName}}{{.Ext}}", want: "abc123.jpg"},
+		{name: "served file name", template: "{{.OriginalName}}{{.Ext}}", metadata: Metadata{ImageURL: "https://cvws.icloud-content.com/B/AbCdEf", FileName: "IMG_5678.HEIC"}, want: "IMG_5678.jpg"},
+		{name: "empty result", template: "{{.Album}}", want: "abc123.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := ParseNameTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("ParseNameTemplate() error = %v", err)
+			}
+			got, err := template.Name("abc123", "/images/abc123.JPG", tt.metadata)
+			if err != nil {
+				t.Fatalf("Name() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Name() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseNameTemplate("{{.Camera}}"); err == nil {
+		t.Error("ParseNameTemplate() expected error for an unknown field")
+	}
+}
+
+func TestUploadName(t *testing.T) {
+	hash := "0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name      string
+		imagePath string
+		metadata  Metadata
+		want      string
+	}{
+		{name: "served file name", imagePath: "/images/" + hash + ".jpg", metadata: Metadata{FileName: "IMG_0001.JPG"}, want: "IMG_0001-0123456789ab.jpg"},
+		{name: "stored extension", imagePath: "/images/" + hash + ".heic", metadata: Metadata{FileName: "IMG_0001.HEIC"}, want: "IMG_0001-0123456789ab.heic"},
+		{name: "unsafe characters", imagePath: "/images/" + hash + ".jpg", metadata: Metadata{FileName: `../Beach: "day".jpg`}, want: "_Beach_ _day_-0123456789ab.jpg"},
+		{name: "unknown file name", imagePath: "/images/" + hash + ".jpg", metadata: Metadata{ImageURL: "https://cvws.icloud-content.com/B/abc/IMG_1234.JPG"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := uploadName(hash, tt.imagePath, tt.metadata); got != tt.want {
+				t.Errorf("uploadName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArchiveNotifier_NameTemplate(t *testing.T) {
+	srcDir, archiveDir := t.TempDir(), t.TempDir()
+	metadata := Metadata{ImageURL: "https://example.com/IMG_1234.JPG", Album: "Summer"}
+	template, err := ParseNameTemplate("{{.Album}}/{{.OriginalName}}{{.Ext}}")
+	if err != nil {
+		t.Fatalf("ParseNameTemplate() error = %v", err)
+	}
+	notifier, err := NewArchiveNotifier(archiveDir)
+	if err != nil {
+		t.Fatalf("NewArchiveNotifier() error = %v", err)
+	}
+	notifier.SetNameTemplate(template)
+	notifier.SetBaseURL("https://nas.example.com/photos")
+
+	first := filepath.Join(srcDir, "aaa111.jpg")
+	second := filepath.Join(srcDir, "bbb222.jpg")
+	os.WriteFile(first, []byte("first image"), 0644)
+	os.WriteFile(second, []byte("second image"), 0644)
+
+	if err := notifier.Process("aaa111", first, metadata); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	// Archiving the same image again keeps its name
+	if err := notifier.Process("aaa111", first, metadata); err != nil {
+		t.Fatalf("Process() again error = %v", err)
+	}
+	// A different image with the same name gets a hash suffix
+	if err := notifier.Process("bbb222", second, metadata); err != nil {
+		t.Fatalf("Process() of colliding image error = %v", err)
+	}
+
+	for name, want := range map[string]string{"IMG_1234.jpg": "first image", "IMG_1234-bbb222.jpg": "second image"} {
+		data, err := os.ReadFile(filepath.Join(archiveDir, "Summer", name))
+		if err != nil || string(data) != want {
+			t.Errorf("archived %s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+	entries, _ := os.ReadDir(filepath.Join(archiveDir, "Summer"))
+	if len(entries) != 2 {
+		t.Errorf("archive dir has %d entries, want 2", len(entries))
+	}
+
+	got, err := notifier.OriginalURL("bbb222", second, metadata)
+	if want := "https://nas.example.com/photos/Summer/IMG_1234-bbb222.jpg"; err != nil || got != want {
+		t.Errorf("OriginalURL() = %s, %v, want %s", got, err, want)
+	}
+}
+
+func TestArchiveNotifier_MissingImage(t *testing.T) {
+	notifier, err := NewArchiveNotifier(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewArchiveNotifier() error = %v", err)
+	}
+	if err := notifier.Process("abc123", "/nonexistent/abc123.jpg", Metadata{}); err == nil {
+		t.Error("Process() expected error for missing image, got nil")
+	}
+}
+
+func TestHookNotifier_Process(t *testing.T) {
+	dir := t.TempDir()
+	outPath := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "hook.sh")
+	content := "#!/bin/sh\necho \"$1 $2 $3 $ICLOUD_SYNC_ALBUM\" > " + outPath + "\necho hook ran\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write hook script: %v", err)
+	}
+
+	notifier := NewHookNotifier(script, 10*time.Second)
+	metadata := Metadata{ImageURL: "https://example.com/a.jpg", Album: "Family"}
+	if err := notifier.Process("abc123", "/images/abc123.jpg", metadata); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	got, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("Failed to read hook output: %v", err)
+	}
+	want := "/images/abc123.jpg abc123 https://example.com/a.jpg Family\n"
+	if string(got) != want {
+		t.Errorf("hook arguments = %q, want %q", got, want)
+	}
+}
+
+func TestHookNotifier_Failure(t *testing.T) {
+	dir := t.TempDir()
+	failing := filepath.Join(dir, "fail.sh")
+	if err := os.WriteFile(failing, []byte("#!/bin/sh\necho boom >&2\nexit 3\n"), 0755); err != nil {
+		t.Fatalf("Failed to write hook script: %v", err)
+	}
+	if err := NewHookNotifier(failing, 10*time.Second).Process("abc123", "/images/abc123.jpg", Metadata{}); err == nil {
+		t.Error("Process() expected error for nonzero exit, got nil")
+	}
+
+	slow := filepath.Join(dir, "slow.sh")
+	if err := os.WriteFile(slow, []byte("#!/bin/sh\nsleep 5\n"), 0755); err != nil {
+		t.Fatalf("Failed to write hook script: %v", err)
+	}
+	if err := NewHookNotifier(slow, 100*time.Millisecond).Process("abc123", "/images/abc123.jpg", Metadata{}); err == nil {
+		t.Error("Process() expected error for timeout, got nil")
+	}
+}
+
+func TestArchiveNotifier_OriginalURL(t *testing.T) {
+	notifier, err := NewArchiveNotifier(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewArchiveNotifier() error = %v", err)
+	}
+	if _, err := notifier.OriginalURL("abc123", "/images/abc123.jpg", Metadata{}); err == nil {
+		t.Error("OriginalURL() expected error without a base URL")
+	}
+
+	notifier.SetBaseURL("https://photos.example.com/archive/")
+	got, err := notifier.OriginalURL("abc123", "/images/ab/abc 123.jpg", Metadata{})
+	if err != nil {
+		t.Fatalf("OriginalURL() error = %v", err)
+	}
+	if want := "https://photos.example.com/archive/abc%20123.jpg"; got != want {
+		t.Errorf("OriginalURL() = %s, want %s", got, want)
+	}
+}
+
+// fakeUploader records the objects it is asked to store
+type fakeUploader struct {
+	keys         []string
+	contentTypes []string
+}
+
+func (u *fakeUploader) PutObject(key string, filePath string, contentType string) error {
+	u.keys = append(u.keys, key)
+	u.contentTypes = append(u.contentTypes, contentType)
+	return nil
+}
+
+func TestS3Notifier_Process(t *testing.T) {
+	taken := time.Date(2024, 6, 15, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		prefix    string
+		keyFormat string
+		metadata  Metadata
+		wantKey   string
+	}{
+		{name: "hash", prefix: "photos", keyFormat: "hash", wantKey: "photos/abc123.jpg"},
+		{name: "date", keyFormat: "date", metadata: Metadata{Taken: taken}, wantKey: "2024/06/15/abc123.jpg"},
+		{name: "date without capture date", prefix: "photos/", keyFormat: "date", wantKey: "photos/undated/abc123.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploader := &fakeUploader{}
+			notifier := NewS3Notifier(uploader, tt.prefix, tt.keyFormat)
+			if err := notifier.Process("abc123", "/images/abc123.JPG", tt.metadata); err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if len(uploader.keys) != 1 || uploader.keys[0] != tt.wantKey {
+				t.Errorf("uploaded keys = %v, want [%s]", uploader.keys, tt.wantKey)
+			}
+			if uploader.contentTypes[0] != "image/jpeg" {
+				t.Errorf("content type = %s, want image/jpeg", uploader.contentTypes[0])
+			}
+		})
+	}
+}
+
+// fakeInspector is a fakeUploader that reports the ETags of existing objects
+type fakeInspector struct {
+	fakeUploader
+	etags map[string]string
+}
+
+func (u *fakeInspector) ObjectETag(key string) (string, bool, error) {
+	etag, ok := u.etags[key]
+	return etag, ok, nil
+}
+
+func TestS3Notifier_NameTemplate(t *testing.T) {
+	imagePath := filepath.Join(t.TempDir(), "abc123.jpg")
+	os.WriteFile(imagePath, []byte("hello"), 0644)
+	template, err := ParseNameTemplate("{{.Album}}/{{.OriginalName}}{{.Ext}}")
+	if err != nil {
+		t.Fatalf("ParseNameTemplate() error = %v", err)
+	}
+	metadata := Metadata{ImageURL: "https://example.com/IMG_1234.JPG", Album: "Summer"}
+
+	tests := []struct {
+		name    string
+		etags   map[string]string
+		wantKey string
+	}{
+		{name: "new key", wantKey: "photos/Summer/IMG_1234.jpg"},
+		// The MD5 of "hello": the same image was uploaded before
+		{name: "same image", etags: map[string]string{"photos/Summer/IMG_1234.jpg": "5d41402abc4b2a76b9719d911017c592"}, wantKey: "photos/Summer/IMG_1234.jpg"},
+		{name: "different image", etags: map[string]string{"photos/Summer/IMG_1234.jpg": "0123456789abcdef0123456789abcdef"}, wantKey: "photos/Summer/IMG_1234-abc123.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploader := &fakeInspector{etags: tt.etags}
+			notifier := NewS3Notifier(uploader, "photos", "date")
+			notifier.SetNameTemplate(template)
+			if err := notifier.Process("abc123", imagePath, metadata); err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if len(uploader.keys) != 1 || uploader.keys[0] != tt.wantKey {
+				t.Errorf("uploaded keys = %v, want [%s]", uploader.keys, tt.wantKey)
+			}
+		})
+	}
+}
+
+// fakePresigner is a fakeUploader that also creates download links
+type fakePresigner struct {
+	fakeUploader
+	expires time.Duration
+}
+
+func (u *fakePresigner) PresignGetObject(key string, expires time.Duration) (string, error) {
+	u.expires = expires
+	return "https://bucket.example.com/" + key + "?signed", nil
+}
+
+func TestS3Notifier_OriginalURL(t *testing.T) {
+	taken := time.Date(2024, 6, 15, 10, 30, 0, 0, time.UTC)
+	presigner := &fakePresigner{}
+	notifier := NewS3Notifier(presigner, "photos", "date")
+	notifier.SetLinkExpiry(time.Hour)
+
+	got, err := notifier.OriginalURL("abc123", "/images/abc123.JPG", Metadata{Taken: taken})
+	if err != nil {
+		t.Fatalf("OriginalURL() error = %v", err)
+	}
+	// Links to the key the image is uploaded under
+	if want := "https://bucket.example.com/photos/2024/06/15/abc123.jpg?signed"; got != want {
+		t.Errorf("OriginalURL() = %s, want %s", got, want)
+	}
+	if presigner.expires != time.Hour {
+		t.Errorf("link expiry = %v, want 1h", presigner.expires)
+	}
+
+	// An uploader that can't presign has no links
+	if _, err := NewS3Notifier(&fakeUploader{}, "", "hash").OriginalURL("abc123", "/images/abc123.jpg", Metadata{}); err == nil {
+		t.Error("OriginalURL() expected error for an uploader without presigning")
+	}
+}
+
+// fakeAssetUploader records the assets it is asked to upload
+type fakeAssetUploader struct {
+	filePath      string
+	deviceAssetID string
+	taken         time.Time
+}
+
+func (u *fakeAssetUploader) UploadAsset(filePath string, deviceAssetID string, taken time.Time) error {
+	u.filePath, u.deviceAssetID, u.taken = filePath, deviceAssetID, taken
+	return nil
+}
+
+func TestImmichNotifier_Process(t *testing.T) {
+	taken := time.Date(2024, 6, 15, 10, 30, 0, 0, time.UTC)
+	uploader := &fakeAssetUploader{}
+	notifier := NewImmichNotifier(uploader)
+	if notifier.Name() != "immich" {
+		t.Errorf("Name() = %s, want immich", notifier.Name())
+	}
+	if err := notifier.Process("abc123", "/images/abc123.jpg", Metadata{Taken: taken}); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if uploader.filePath != "/images/abc123.jpg" || uploader.deviceAssetID != "abc123" || !uploader.taken.Equal(taken) {
+		t.Errorf("uploaded %s as %s taken %v, want /images/abc123.jpg as abc123 taken %v", uploader.filePath, uploader.deviceAssetID, uploader.taken, taken)
+	}
+}
+
+// fakePhotoPoster records the photos it is asked to post
+type fakePhotoPoster struct {
+	filePath string
+	caption  string
+}
+
+func (p *fakePhotoPoster) PostPhoto(filePath string, caption string) error {
+	p.filePath, p.caption = filePath, caption
+	return nil
+}
+
+func TestChatNotifier_Process(t *testing.T) {
+	poster := &fakePhotoPoster{}
+	notifier := NewChatNotifier("telegram", poster)
+	if notifier.Name() != "telegram" {
+		t.Errorf("Name() = %s, want telegram", notifier.Name())
+	}
+	if err := notifier.Process("abc123", "/images/abc123.jpg", Metadata{Caption: "Beach day", Album: "Summer"}); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if poster.filePath != "/images/abc123.jpg" || poster.caption != "Beach day" {
+		t.Errorf("posted %s with caption %q, want /images/abc123.jpg with the iCloud caption", poster.filePath, poster.caption)
+	}
+}
+
+func TestEmailNotifier_Thumbnail(t *testing.T) {
+	var got struct {
+		Attachments []struct {
+			Content  string `json:"content"`
+			Filename string `json:"filename"`
+		} `json:"attachments"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender, err := email.NewSender(&config.SMTPConfig{Backend: "sendgrid", APIKey: "key", APIURL: server.URL, From: "photos@example.com"})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	imagePath := filepath.Join(t.TempDir(), "abc123.jpg")
+	file, err := os.Create(imagePath)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	if err := jpeg.Encode(file, image.NewRGBA(image.Rect(0, 0, 1000, 500)), nil); err != nil {
+		t.Fatalf("Failed to encode image: %v", err)
+	}
+	file.Close()
+
+	// No linker: the thumbnail alone is sent
+	notifier := NewEmailNotifier(sender, "frame@example.com")
+	notifier.SetThumbnail(nil, 100, t.TempDir())
+	if err := notifier.Process("abc123", imagePath, Metadata{}); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	if len(got.Attachments) != 1 {
+		t.Fatalf("attachments = %d, want 1", len(got.Attachments))
+	}
+	if got.Attachments[0].Filename != "abc123.jpg" {
+		t.Errorf("attachment name = %q, want abc123.jpg", got.Attachments[0].Filename)
+	}
+	data, err := base64.StdEncoding.DecodeString(got.Attachments[0].Content)
+	if err != nil {
+		t.Fatalf("Failed to decode attachment: %v", err)
+	}
+	thumbnail, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("attachment is not a JPEG: %v", err)
+	}
+	if thumbnail.Width != 100 || thumbnail.Height != 50 {
+		t.Errorf("thumbnail is %dx%d, want 100x50", thumbnail.Width, thumbnail.Height)
+	}
+}
+
+func TestEmailNotifier_TooLarge(t *testing.T) {
+	var requests int
+	var got struct {
+		Attachments []struct {
+			Content  string `json:"content"`
+			Filename string `json:"filename"`
+		} `json:"attachments"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender, err := email.NewSender(&config.SMTPConfig{Backend: "sendgrid", APIKey: "key", APIURL: server.URL, From: "photos@example.com"})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	imagePath := filepath.Join(t.TempDir(), "abc123.png")
+	file, err := os.Create(imagePath)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	if err := png.Encode(file, image.NewRGBA(image.Rect(0, 0, 3000, 1500))); err != nil {
+		t.Fatalf("Failed to encode image: %v", err)
+	}
+	file.Close()
+
+	notifier := NewEmailNotifier(sender, "frame@example.com")
+	if err := notifier.Process("abc123", imagePath, Metadata{}); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	if requests != 2 || len(got.Attachments) != 1 {
+		t.Fatalf("requests = %d, attachments = %d, want a retry with 1 attachment", requests, len(got.Attachments))
+	}
+	if got.Attachments[0].Filename != "abc123.jpg" {
+		t.Errorf("attachment name = %q, want abc123.jpg", got.Attachments[0].Filename)
+	}
+	data, err := base64.StdEncoding.DecodeString(got.Attachments[0].Content)
+	if err != nil {
+		t.Fatalf("Failed to decode attachment: %v", err)
+	}
+	reduced, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("attachment is not a JPEG: %v", err)
+	}
+	if reduced.Width != 2048 || reduced.Height != 1024 {
+		t.Errorf("reduced copy is %dx%d, want 2048x1024", reduced.Width, reduced.Height)
+	}
+}
+
+func TestEmailZipNotifier_PartialSend(t *testing.T) {
+	var sent int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if len(body) > 100*1024 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		sent++
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender, err := email.NewSender(&config.SMTPConfig{Backend: "sendgrid", APIKey: "key", APIURL: server.URL, From: "photos@example.com"})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	dir := t.TempDir()
+	storageManager, err := storage.NewManager(dir)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	large := make([]byte, 200*1024)
+	rand.New(rand.NewSource(1)).Read(large)
+	files := map[string][]byte{"a.jpg": []byte("small a"), "b.jpg": large, "c.jpg": []byte("small c")}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatalf("Failed to write image: %v", err)
+		}
+	}
+
+	notifier := NewEmailZipNotifier(sender, storageManager, "frame@example.com", 0)
+	notifier.SetPartialSend(true)
+	for _, name := range []string{"a.jpg", "b.jpg", "missing.jpg", "c.jpg"} {
+		notifier.Process(strings.TrimSuffix(name, ".jpg"), filepath.Join(dir, name), Metadata{})
+	}
+	delivered, err := notifier.Flush()
+	if err == nil || !strings.Contains(err.Error(), "left 2 images out") {
+		t.Errorf("Flush() error = %v, want the missing and too large images reported", err)
+	}
+	var hashes []string
+	for _, delivery := range delivered {
+		hashes = append(hashes, delivery.Hash)
+	}
+	sort.Strings(hashes)
+	if !reflect.DeepEqual(hashes, []string{"a", "c"}) {
+		t.Errorf("delivered %v, want a and c", hashes)
+	}
+	if sent != 2 {
+		t.Errorf("sent %d emails, want the two halves without the large image", sent)
+	}
+
+	// Without partial sends the whole zip fails
+	notifier.SetPartialSend(false)
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		notifier.Process(strings.TrimSuffix(name, ".jpg"), filepath.Join(dir, name), Metadata{})
+	}
+	if delivered, err := notifier.Flush(); err == nil || len(delivered) != 0 {
+		t.Errorf("Flush() = %v, %v, want the whole zip to fail", delivered, err)
+	}
+}
+
+func TestEmailZipNotifier_Destinations(t *testing.T) {
+	images := map[string]int{} // Images zipped per recipient
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Personalizations []struct {
+				To []struct {
+					Email string `json:"email"`
+				} `json:"to"`
+			} `json:"personalizations"`
+			Attachments []struct {
+				Content string `json:"content"`
+			} `json:"attachments"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Attachments) != 1 {
+			t.Errorf("Failed to decode request: %v", err)
+			return
+		}
+		data, _ := base64.StdEncoding.DecodeString(request.Attachments[0].Content)
+		archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Errorf("Failed to read zip: %v", err)
+			return
+		}
+		for _, to := range request.Personalizations[0].To {
+			images[to.Email] += len(archive.File)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender, err := email.NewSender(&config.SMTPConfig{Backend: "sendgrid", APIKey: "key", APIURL: server.URL, From: "photos@example.com"})
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
+	dir := t.TempDir()
+	storageManager, err := storage.NewManager(dir)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	notifier := NewEmailZipNotifier(sender, storageManager, "frame@example.com", 0)
+	destinations := map[string]string{"a": "grandma@example.com, aunt@example.com", "b": "", "c": "grandma@example.com, aunt@example.com"}
+	for _, hash := range []string{"a", "b", "c"} {
+		path := filepath.Join(dir, hash+".jpg")
+		if err := os.WriteFile(path, []byte("image "+hash), 0600); err != nil {
+			t.Fatalf("Failed to write image: %v", err)
+		}
+		notifier.Process(hash, path, Metadata{Destination: destinations[hash]})
+	}
+	delivered, err := notifier.Flush()
+	if err != nil || len(delivered) != 3 {
+		t.Fatalf("Flush() = %v, %v, want every image delivered", delivered, err)
+	}
+	want := map[string]int{"grandma@example.com": 2, "aunt@example.com": 2, "frame@example.com": 1}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("images per recipient = %v, want %v", images, want)
+	}
+}
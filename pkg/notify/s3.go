@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ObjectUploader stores a file under a key (implemented by s3.Client)
+type ObjectUploader interface {
+	PutObject(key string, filePath string, contentType string) error
+}
+
+// ObjectPresigner creates time-limited download URLs for keys (implemented by s3.Client)
+type ObjectPresigner interface {
+	PresignGetObject(key string, expires time.Duration) (string, error)
+}
+
+// ObjectInspector looks up the ETag of a key, reporting false if it doesn't exist
+// (implemented by s3.Client)
+type ObjectInspector interface {
+	ObjectETag(key string) (string, bool, error)
+}
+
+// S3Notifier uploads each new image to an S3-compatible bucket
+type S3Notifier struct {
+	uploader   ObjectUploader
+	prefix     string
+	keyFormat  string
+	linkExpiry time.Duration // How long OriginalURL links stay valid
+	template   *NameTemplate // Optional - names objects instead of keyFormat
+}
+
+// NewS3Notifier creates a notifier that uploads images under prefix, named by hash or, with
+// keyFormat "date", in YYYY/MM/DD folders by capture date
+func NewS3Notifier(uploader ObjectUploader, prefix string, keyFormat string) *S3Notifier {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3Notifier{
+		uploader:  uploader,
+		prefix:    prefix,
+		keyFormat: keyFormat,
+	}
+}
+
+// Name returns the tracking key for S3 delivery
+func (n *S3Notifier) Name() string {
+	return "s3"
+}
+
+// SetNameTemplate names objects (under the prefix) with template instead of the key format
+func (n *S3Notifier) SetNameTemplate(template *NameTemplate) {
+	n.template = template
+}
+
+// SetLinkExpiry sets how long the presigned links returned by OriginalURL stay valid
+func (n *S3Notifier) SetLinkExpiry(expiry time.Duration) {
+	n.linkExpiry = expiry
+}
+
+// OriginalURL returns a presigned URL for downloading the uploaded image
+// The URL is valid as soon as the upload (which may still be running) completes
+func (n *S3Notifier) OriginalURL(hash string, imagePath string, metadata Metadata) (string, error) {
+	presigner, ok := n.uploader.(ObjectPresigner)
+	if !ok {
+		return "", fmt.Errorf("S3 uploader cannot create download links")
+	}
+	key, err := n.objectKey(hash, imagePath, metadata)
+	if err != nil {
+		return "", err
+	}
+	return presigner.PresignGetObject(key, n.linkExpiry)
+}
+
+// Process uploads the image to the bucket
+func (n *S3Notifier) Process(hash string, imagePath string, metadata Metadata) error {
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(imagePath)))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	key, err := n.objectKey(hash, imagePath, metadata)
+	if err != nil {
+		return err
+	}
+	return n.uploader.PutObject(key, imagePath, contentType)
+}
+
+// objectKey returns the key an image is stored under
+// Photos without a capture date are filed under "undated" with the date format. A templated
+// key already holding a different image gets a suffix from the hash, if the uploader can tell
+func (n *S3Notifier) objectKey(hash string, imagePath string, metadata Metadata) (string, error) {
+	if n.template != nil {
+		name, err := n.template.Name(hash, imagePath, metadata)
+		if err != nil {
+			return "", err
+		}
+		inspector, ok := n.uploader.(ObjectInspector)
+		if !ok {
+			return n.prefix + name, nil
+		}
+		etag, exists, err := inspector.ObjectETag(n.prefix + name)
+		if err != nil {
+			return "", fmt.Errorf("failed to check for an existing object: %w", err)
+		}
+		if exists && etag != fileMD5(imagePath) {
+			name = uniqueName(name, hash)
+		}
+		return n.prefix + name, nil
+	}
+
+	ext := strings.ToLower(filepath.Ext(imagePath))
+	name := hash + ext
+	if n.keyFormat == "date" {
+		dir := "undated"
+		if !metadata.Taken.IsZero() {
+			dir = metadata.Taken.UTC().Format("2006/01/02")
+		}
+		name = path.Join(dir, name)
+	}
+	return n.prefix + name, nil
+}
+
+// fileMD5 returns the hex MD5 of a file, which S3 reports as the ETag of a single PUT, or ""
+// if it can't be read
+func fileMD5(filePath string) string {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// webhookTimeout bounds each webhook request so a slow endpoint cannot stall a sync run
+const webhookTimeout = 30 * time.Second
+
+// WebhookNotifier POSTs a JSON description of each new image to a URL
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// webhookPayload is the JSON body sent for each image
+type webhookPayload struct {
+	Hash     string `json:"hash"`
+	ImageURL string `json:"image_url"`
+	Album    string `json:"album"`
+	Filename string `json:"filename"`
+}
+
+// NewWebhookNotifier creates a notifier that POSTs to url
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// Name returns the tracking key for webhook delivery
+func (n *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+// Process POSTs the image metadata to the webhook; any non-2xx response is an error
+func (n *WebhookNotifier) Process(hash string, imagePath string, metadata Metadata) error {
+	body, err := json.Marshal(webhookPayload{
+		Hash:     hash,
+		ImageURL: metadata.ImageURL,
+		Album:    metadata.Album,
+		Filename: filepath.Base(imagePath),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
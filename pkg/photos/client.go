@@ -3,28 +3,67 @@ package photos
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
+	"unicode"
 
+	"github.com/jsteffee/icloud-photo-sync/pkg/certs"
 	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
 	"golang.org/x/oauth2"
 )
 
+// ErrTokenRevoked is returned when Google rejects the OAuth credentials (e.g. the refresh
+// token was revoked or expired). Uploads will keep failing until the user re-authorizes.
+var ErrTokenRevoked = errors.New("Google Photos refresh token revoked or expired, re-authorization required")
+
+// ErrAlbumFull is returned when the target album has reached Google Photos' 20,000 item limit
+var ErrAlbumFull = errors.New("Google Photos album is full")
+
+// ErrAlbumNotFound is returned by FindAlbumByName when no app-created album has the name
+var ErrAlbumNotFound = errors.New("album not found")
+
+// ErrStorageQuotaExceeded is returned when the Google account has run out of storage
+var ErrStorageQuotaExceeded = errors.New("Google Photos storage quota exceeded")
+
+// UploadTokenValidity is how long a stored upload token is reused. Google accepts upload
+// tokens for about a day; a margin is kept so a token never expires mid-request.
+const UploadTokenValidity = 23 * time.Hour
+
+// UploadTokenStore persists upload tokens so an interrupted upload can be resumed
+// without sending the file again
+type UploadTokenStore interface {
+	GetUploadToken(hash string) (string, time.Time, error)
+	SetUploadToken(hash string, token string, ttl time.Duration) error
+	DeleteUploadToken(hash string) error
+}
+
 // Client handles Google Photos API interactions
 type Client struct {
 	config      *config.GooglePhotosConfig
 	oauthConfig *oauth2.Config
 	httpClient  *http.Client
 	ctx         context.Context
-	albumID     string
+	albumIDs    map[string]string // Album IDs found or created, by title
 	albumMutex  sync.RWMutex
+	createMutex sync.Mutex       // Serializes finding and creating albums so each is created once
+	tokenStore  UploadTokenStore // Optional - nil disables upload resumption
+	// albumItems holds the file names and media item IDs in each album, by album ID, loaded on
+	// the first upload to it after ResetAlbumContents when SkipIfInAlbum is set
+	albumItemsMutex sync.Mutex
+	albumItems      map[string]map[string]bool
 }
 
 // NewClient creates a new Google Photos client
@@ -47,12 +86,24 @@ func NewClient(cfg *config.GooglePhotosConfig) (*Client, error) {
 	}
 
 	ctx := context.Background()
-	
+
+	// Token refreshes and API calls go through the HTTP client in the context, so an extra CA
+	// (e.g. for a TLS-inspecting proxy) applies to both
+	if cfg.CACertPath != "" {
+		pool, err := certs.LoadPool(cfg.CACertPath)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: transport})
+	}
+
 	// Create a token with the refresh token - the HTTP client will use this to get access tokens
 	token := &oauth2.Token{
 		RefreshToken: cfg.RefreshToken,
 	}
-	
+
 	// Create a reusable token source that will automatically refresh when needed
 	tokenSource := oauthConfig.TokenSource(ctx, token)
 	httpClient := oauth2.NewClient(ctx, tokenSource)
@@ -76,7 +127,7 @@ func (c *Client) RefreshAccessToken() error {
 	tokenSource := c.oauthConfig.TokenSource(c.ctx, token)
 	newToken, err := tokenSource.Token()
 	if err != nil {
-		return fmt.Errorf("failed to refresh access token: %w", err)
+		return wrapAuthError(fmt.Errorf("failed to refresh access token: %w", err))
 	}
 
 	// Update the HTTP client with a new token source using the refreshed token
@@ -121,33 +172,22 @@ func (c *Client) CreateAlbum(albumName string) (string, error) {
 		return "", fmt.Errorf("failed to create album: status %d: %s", resp.StatusCode, string(bodyBytes))
 	}
 
-	var albumResponse struct {
-		ID    string `json:"id"`
-		Title string `json:"title"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&albumResponse); err != nil {
+	var album albumResponse
+	if err := json.NewDecoder(resp.Body).Decode(&album); err != nil {
 		return "", fmt.Errorf("failed to decode album response: %w", err)
 	}
 
-	// Cache the album ID
-	c.albumMutex.Lock()
-	c.albumID = albumResponse.ID
-	c.albumMutex.Unlock()
-
-	return albumResponse.ID, nil
+	c.cacheAlbumID(albumName, album.ID)
+	return album.ID, nil
 }
 
 // FindAlbumByName finds a Google Photos album by name (only app-created albums)
 // With the new API scopes, we can only access albums created by this app
 func (c *Client) FindAlbumByName(albumName string) (string, error) {
 	// Check cached album ID first
-	c.albumMutex.RLock()
-	if c.albumID != "" {
-		cachedID := c.albumID
-		c.albumMutex.RUnlock()
+	if cachedID := c.cachedAlbumID(albumName); cachedID != "" {
 		return cachedID, nil
 	}
-	c.albumMutex.RUnlock()
 
 	// The HTTP client will automatically refresh the token if needed
 	// With new scopes, we can only list app-created albums
@@ -187,10 +227,7 @@ func (c *Client) FindAlbumByName(albumName string) (string, error) {
 
 		for _, album := range albumsList.Albums {
 			if album.Title == albumName {
-				// Cache the album ID
-				c.albumMutex.Lock()
-				c.albumID = album.ID
-				c.albumMutex.Unlock()
+				c.cacheAlbumID(albumName, album.ID)
 				return album.ID, nil
 			}
 		}
@@ -202,34 +239,121 @@ func (c *Client) FindAlbumByName(albumName string) (string, error) {
 		nextPageToken = albumsList.NextPageToken
 	}
 
-	return "", fmt.Errorf("album not found: %s (note: with new API scopes, only app-created albums are accessible)", albumName)
+	return "", fmt.Errorf("%w: %s (note: with new API scopes, only app-created albums are accessible)", ErrAlbumNotFound, albumName)
 }
 
 // GetOrCreateAlbumID gets the album ID, creating it if it doesn't exist
 // Returns empty string if AlbumName is not configured (for library-only uploads/partner sharing)
 func (c *Client) GetOrCreateAlbumID() (string, error) {
+	return c.GetOrCreateAlbumIDFor(c.config.AlbumName)
+}
+
+// GetOrCreateAlbumIDFor gets the ID of the named album, creating it if it doesn't exist. The
+// name is first made into a title Google Photos accepts (see sanitizeAlbumTitle). IDs are
+// cached by name, so each album is only looked up once. Returns empty string if albumName is
+// empty (upload to library only). Safe for concurrent use
+func (c *Client) GetOrCreateAlbumIDFor(albumName string) (string, error) {
 	// If no album name is configured, return empty string (upload to library only)
-	if c.config.AlbumName == "" {
+	if albumName == "" {
 		return "", nil
 	}
-
-	c.albumMutex.RLock()
-	if c.albumID != "" {
-		cachedID := c.albumID
-		c.albumMutex.RUnlock()
+	title := sanitizeAlbumTitle(albumName)
+	if title == "" {
+		return "", fmt.Errorf("album name %q has no characters Google Photos accepts in a title", albumName)
+	}
+	if cachedID := c.cachedAlbumID(title); cachedID != "" {
 		return cachedID, nil
 	}
-	c.albumMutex.RUnlock()
+
+	// Concurrent uploads to a new album must not each create it
+	c.createMutex.Lock()
+	defer c.createMutex.Unlock()
+	if title != albumName {
+		logging.Infof("Using %q as the Google Photos album name for %q", title, albumName)
+		albumName = title
+	}
 
 	// Try to find the album first
-	albumID, err := c.FindAlbumByName(c.config.AlbumName)
+	albumID, err := c.FindAlbumByName(albumName)
 	if err == nil {
 		return albumID, nil
 	}
+	if err = wrapAuthError(err); errors.Is(err, ErrTokenRevoked) {
+		return "", err
+	}
 
 	// If not found, create it
-	log.Printf("Album '%s' not found, creating new album...", c.config.AlbumName)
-	return c.CreateAlbum(c.config.AlbumName)
+	logging.Infof("Album '%s' not found, creating new album...", albumName)
+	albumID, err = c.CreateAlbum(albumName)
+	if err != nil {
+		return "", wrapAuthError(err)
+	}
+	return albumID, nil
+}
+
+// AlbumNameFor returns the album a photo taken at taken belongs in: AlbumName, followed by the
+// photo's year or year and month with AlbumRotation. Photos without a capture date, and every
+// photo without rotation, go in AlbumName itself
+func (c *Client) AlbumNameFor(taken time.Time) string {
+	return RotatedAlbumName(c.config.AlbumName, c.config.AlbumRotation, taken)
+}
+
+// RotatedAlbumName returns base followed by the year ("yearly") or year and month ("monthly")
+// of taken, e.g. "iCloud Sync 2024-06", or base itself if rotation is "none" or taken is zero
+func RotatedAlbumName(base string, rotation string, taken time.Time) string {
+	if base == "" || taken.IsZero() {
+		return base
+	}
+	switch rotation {
+	case "monthly":
+		return base + " " + taken.UTC().Format("2006-01")
+	case "yearly":
+		return base + " " + taken.UTC().Format("2006")
+	}
+	return base
+}
+
+// maxAlbumTitleLength is the longest album title, in characters, Google Photos accepts
+const maxAlbumTitleLength = 500
+
+// sanitizeAlbumTitle normalizes an album name into a title Google Photos accepts: invalid
+// UTF-8 and invisible formatting characters (e.g. zero-width spaces) are removed, control
+// characters and runs of whitespace become a single space, and the result is trimmed and cut
+// to maxAlbumTitleLength characters. Returns "" if nothing usable is left
+func sanitizeAlbumTitle(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToValidUTF8(name, "") {
+		switch {
+		case unicode.IsControl(r) || unicode.IsSpace(r):
+			b.WriteRune(' ')
+		case unicode.Is(unicode.Cf, r):
+			// Dropped
+		default:
+			b.WriteRune(r)
+		}
+	}
+	title := strings.Join(strings.Fields(b.String()), " ")
+	if runes := []rune(title); len(runes) > maxAlbumTitleLength {
+		title = strings.TrimSpace(string(runes[:maxAlbumTitleLength]))
+	}
+	return title
+}
+
+// cachedAlbumID returns the cached ID of the named album, or "" if it hasn't been resolved
+func (c *Client) cachedAlbumID(albumName string) string {
+	c.albumMutex.RLock()
+	defer c.albumMutex.RUnlock()
+	return c.albumIDs[albumName]
+}
+
+// cacheAlbumID remembers the ID of the named album
+func (c *Client) cacheAlbumID(albumName string, albumID string) {
+	c.albumMutex.Lock()
+	defer c.albumMutex.Unlock()
+	if c.albumIDs == nil {
+		c.albumIDs = make(map[string]string)
+	}
+	c.albumIDs[albumName] = albumID
 }
 
 // BatchCreateMediaItemsRequest represents the request to create media items
@@ -239,9 +363,29 @@ type BatchCreateMediaItemsRequest struct {
 
 // NewMediaItem represents a new media item to create
 type NewMediaItem struct {
+	Description     string          `json:"description,omitempty"`
 	SimpleMediaItem SimpleMediaItem `json:"simpleMediaItem"`
 }
 
+// maxDescriptionLength is the longest description the Google Photos API accepts
+const maxDescriptionLength = 1000
+
+// UploadMetadata is the JSON metadata part of a media upload. Empty fields are omitted, so
+// an upload without metadata sends {}. Fields Google doesn't use at upload time are ignored;
+// the description is also set when the media item is created
+type UploadMetadata struct {
+	FileName    string `json:"filename,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// truncateDescription shortens description to the longest the API accepts
+func truncateDescription(description string) string {
+	if runes := []rune(description); len(runes) > maxDescriptionLength {
+		return string(runes[:maxDescriptionLength])
+	}
+	return description
+}
+
 // SimpleMediaItem represents a simple media item
 type SimpleMediaItem struct {
 	UploadToken string `json:"uploadToken"`
@@ -260,12 +404,26 @@ type NewMediaItemResult struct {
 
 // MediaItem represents a Google Photos media item
 type MediaItem struct {
-	ID string `json:"id"`
+	ID         string `json:"id"`
+	Processing bool   `json:"-"` // Video still being processed by Google; the item is final later
+	Duplicate  bool   `json:"-"` // The library already held identical media, which this item is
 }
 
+// statusAlreadyExists is the status code batchCreate reports for media the library already holds
+const statusAlreadyExists = 6
+
 // mediaItemResponse is used for JSON unmarshaling
 type mediaItemResponse struct {
-	ID string `json:"id"`
+	ID            string         `json:"id"`
+	BaseURL       string         `json:"baseUrl"`
+	MediaMetadata *mediaMetadata `json:"mediaMetadata"`
+}
+
+// mediaMetadata holds the parts of a media item's metadata we inspect
+type mediaMetadata struct {
+	Video *struct {
+		Status string `json:"status"` // PROCESSING, READY, or FAILED
+	} `json:"video"`
 }
 
 // Status represents an API status
@@ -279,34 +437,176 @@ type BatchAddMediaItemsRequest struct {
 	MediaItemIds []string `json:"mediaItemIds"`
 }
 
+// searchMediaItemsRequest lists a page of an album's media items
+type searchMediaItemsRequest struct {
+	AlbumID   string `json:"albumId"`
+	PageSize  int    `json:"pageSize"`
+	PageToken string `json:"pageToken,omitempty"`
+}
+
+// searchMediaItemsResponse is a page of media items from mediaItems:search
+type searchMediaItemsResponse struct {
+	MediaItems []struct {
+		ID       string `json:"id"`
+		Filename string `json:"filename"`
+	} `json:"mediaItems"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// SetUploadTokenStore enables resuming uploads with tokens persisted in store
+func (c *Client) SetUploadTokenStore(store UploadTokenStore) {
+	c.tokenStore = store
+}
+
+// UploadOptions are the optional details of an upload; the zero value uploads the file as is
+type UploadOptions struct {
+	// Hash identifies the photo's upload token when an upload token store is configured, so a
+	// retry can skip re-uploading the file if creating the media item fails
+	Hash string
+	// Description is set on the media item (e.g. the photo's iCloud caption); empty leaves it unset
+	Description string
+	// FileName is uploaded as instead of the name of the file at imagePath; empty uses the file's
+	// name. Names should be unique to the photo, since SkipIfInAlbum matches photos by name
+	FileName string
+}
+
 // UploadPhoto uploads a photo to Google Photos and optionally adds it to an album
 // If albumID is empty, the photo is uploaded to the library only (useful for partner sharing)
-func (c *Client) UploadPhoto(imagePath string, albumID string) error {
+func (c *Client) UploadPhoto(imagePath string, albumID string, opts UploadOptions) error {
+	// Images are uploaded under their file name, so one the album already holds (e.g. from before
+	// the local copy was deleted) doesn't need uploading again
+	storedName := filepath.Base(imagePath)
+	fileName := opts.FileName
+	if fileName == "" {
+		fileName = storedName
+	}
+	skipIfInAlbum := c.config.SkipIfInAlbum && albumID != ""
+	metadata := UploadMetadata{FileName: fileName, Description: truncateDescription(opts.Description)}
+	if skipIfInAlbum {
+		inAlbum, err := c.albumHas(albumID, fileName)
+		if err == nil && !inAlbum && fileName != storedName {
+			// Uploaded before photos were named after the name iCloud served them as
+			inAlbum, err = c.albumHas(albumID, storedName)
+		}
+		if err != nil {
+			return wrapAuthError(fmt.Errorf("failed to check album contents: %w", err))
+		}
+		if inAlbum {
+			logging.Infof("%s is already in the Google Photos album, skipping upload", imagePath)
+			return nil
+		}
+	}
+
 	// The HTTP client will automatically refresh the token if needed
-	// Step 1: Upload the media file
-	uploadToken, err := c.uploadMedia(imagePath)
-	if err != nil {
-		return fmt.Errorf("failed to upload media: %w", err)
+	// Step 1: Upload the media file (or reuse a fresh token from an interrupted attempt)
+	resumed := false
+	uploadToken := c.storedUploadToken(opts.Hash)
+	if uploadToken != "" {
+		logging.Infof("Resuming upload of %s with stored upload token", imagePath)
+		resumed = true
+	} else {
+		var err error
+		uploadToken, err = c.uploadMedia(imagePath, metadata)
+		if err != nil {
+			return wrapAuthError(fmt.Errorf("failed to upload media: %w", err))
+		}
+		c.saveUploadToken(opts.Hash, uploadToken)
 	}
 
 	// Step 2: Create media item
-	mediaItem, err := c.createMediaItem(uploadToken)
+	mediaItem, err := c.createMediaItem(uploadToken, opts.Description)
+	if err != nil && resumed && !errors.Is(wrapAuthError(err), ErrTokenRevoked) &&
+		!errors.Is(err, ErrAlbumFull) && !errors.Is(err, ErrStorageQuotaExceeded) {
+		// The stored token may have been rejected; fall back to a full upload
+		logging.Warnf("Stored upload token for %s was not accepted (%v), uploading again", imagePath, err)
+		c.deleteUploadToken(opts.Hash)
+		uploadToken, err = c.uploadMedia(imagePath, metadata)
+		if err != nil {
+			return wrapAuthError(fmt.Errorf("failed to upload media: %w", err))
+		}
+		c.saveUploadToken(opts.Hash, uploadToken)
+		mediaItem, err = c.createMediaItem(uploadToken, opts.Description)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create media item: %w", err)
+		return wrapAuthError(fmt.Errorf("failed to create media item: %w", err))
+	}
+	c.deleteUploadToken(opts.Hash)
+	if c.config.VerifyUploads && mediaItem.ID != "" {
+		processing, err := c.verifyMediaItem(mediaItem.ID)
+		if err != nil {
+			return wrapAuthError(fmt.Errorf("failed to verify media item: %w", err))
+		}
+		mediaItem.Processing = mediaItem.Processing || processing
+	}
+	if mediaItem.Processing {
+		logging.Infof("Google Photos is still processing %s; it will appear once processing finishes", imagePath)
+	}
+	if mediaItem.Duplicate {
+		logging.Infof("Google Photos already has %s in the library (uploaded as %s), using the existing media item", imagePath, fileName)
 	}
 
 	// Step 3: Add media item to album (if album ID is provided)
-	if albumID != "" {
-		if err := c.addMediaItemToAlbum(albumID, mediaItem.ID); err != nil {
-			return fmt.Errorf("failed to add media item to album: %w", err)
+	if albumID != "" && mediaItem.ID == "" {
+		// Accepted for processing without an item ID yet, so it can only land in the library
+		logging.Warnf("Google Photos returned no media item ID for %s, so it could not be added to the album", imagePath)
+	} else if albumID != "" {
+		// Google returns the existing media item for content already in the library
+		inAlbum := false
+		if skipIfInAlbum {
+			if inAlbum, err = c.albumHas(albumID, mediaItem.ID); err != nil {
+				return wrapAuthError(fmt.Errorf("failed to check album contents: %w", err))
+			}
 		}
+		if inAlbum {
+			logging.Infof("%s is already in the Google Photos album, not adding it again", imagePath)
+		} else if err := c.addMediaItemToAlbum(albumID, mediaItem.ID); err != nil {
+			return wrapAuthError(fmt.Errorf("failed to add media item to album: %w", err))
+		}
+		c.rememberAlbumItem(albumID, fileName, mediaItem.ID)
 	}
 
 	return nil
 }
 
-// uploadMedia uploads the media file and returns an upload token
-func (c *Client) uploadMedia(imagePath string) (string, error) {
+// storedUploadToken returns a stored upload token for hash that is still within its validity window
+func (c *Client) storedUploadToken(hash string) string {
+	if c.tokenStore == nil || hash == "" {
+		return ""
+	}
+	token, createdAt, err := c.tokenStore.GetUploadToken(hash)
+	if err != nil {
+		logging.Errorf("Error reading stored upload token for hash %s: %v", hash, err)
+		return ""
+	}
+	if token == "" || time.Since(createdAt) >= UploadTokenValidity {
+		return ""
+	}
+	return token
+}
+
+// saveUploadToken stores an upload token for hash so a failed attempt can be resumed
+func (c *Client) saveUploadToken(hash string, token string) {
+	if c.tokenStore == nil || hash == "" {
+		return
+	}
+	if err := c.tokenStore.SetUploadToken(hash, token, UploadTokenValidity); err != nil {
+		logging.Errorf("Error storing upload token for hash %s: %v", hash, err)
+	}
+}
+
+// deleteUploadToken removes the stored upload token for hash once it has been used
+func (c *Client) deleteUploadToken(hash string) {
+	if c.tokenStore == nil || hash == "" {
+		return
+	}
+	if err := c.tokenStore.DeleteUploadToken(hash); err != nil {
+		logging.Errorf("Error deleting upload token for hash %s: %v", hash, err)
+	}
+}
+
+// uploadMedia uploads the media file with metadata and returns an upload token. An empty
+// metadata.FileName is filled in from the file
+func (c *Client) uploadMedia(imagePath string, metadata UploadMetadata) (string, error) {
 	file, err := os.Open(imagePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to open file: %w", err)
@@ -319,6 +619,13 @@ func (c *Client) uploadMedia(imagePath string) (string, error) {
 		return "", fmt.Errorf("failed to get file info: %w", err)
 	}
 	fileName := fileInfo.Name()
+	if metadata.FileName == "" {
+		metadata.FileName = fileName
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal metadata: %w", err)
+	}
 
 	// Create multipart form with metadata and file parts
 	// Google Photos API requires 2 parts: metadata (JSON) and file data
@@ -332,20 +639,20 @@ func (c *Client) uploadMedia(imagePath string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to create metadata part: %w", err)
 	}
-	_, err = metadataPart.Write([]byte("{}"))
+	_, err = metadataPart.Write(metadataJSON)
 	if err != nil {
 		return "", fmt.Errorf("failed to write metadata: %w", err)
 	}
 
 	// Part 2: File data (binary with Content-Type header)
-	// Reset file position to beginning
-	_, err = file.Seek(0, 0)
+
+	contentType, err := mediaContentType(file, fileName)
 	if err != nil {
-		return "", fmt.Errorf("failed to seek file: %w", err)
+		return "", err
 	}
 
 	fileHeader := make(textproto.MIMEHeader)
-	fileHeader.Set("Content-Type", "application/octet-stream")
+	fileHeader.Set("Content-Type", contentType)
 	filePart, err := writer.CreatePart(fileHeader)
 	if err != nil {
 		return "", fmt.Errorf("failed to create file part: %w", err)
@@ -369,7 +676,8 @@ func (c *Client) uploadMedia(imagePath string) (string, error) {
 
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	req.Header.Set("X-Goog-Upload-Protocol", "multipart")
-	req.Header.Set("X-Goog-Upload-File-Name", fileName)
+	req.Header.Set("X-Goog-Upload-File-Name", metadata.FileName)
+	req.Header.Set("X-Goog-Upload-Content-Type", contentType)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -377,9 +685,13 @@ func (c *Client) uploadMedia(imagePath string) (string, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("%w: upload failed with status %d: %s", ErrTokenRevoked, resp.StatusCode, string(bodyBytes))
+	}
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return "", classifyQuotaError(fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(bodyBytes)), string(bodyBytes))
 	}
 
 	uploadTokenBytes, err := io.ReadAll(resp.Body)
@@ -391,10 +703,12 @@ func (c *Client) uploadMedia(imagePath string) (string, error) {
 }
 
 // createMediaItem creates a media item from an upload token
-func (c *Client) createMediaItem(uploadToken string) (*MediaItem, error) {
+func (c *Client) createMediaItem(uploadToken string, description string) (*MediaItem, error) {
+	description = truncateDescription(description)
 	requestBody := BatchCreateMediaItemsRequest{
 		NewMediaItems: []NewMediaItem{
 			{
+				Description: description,
 				SimpleMediaItem: SimpleMediaItem{
 					UploadToken: uploadToken,
 				},
@@ -420,9 +734,13 @@ func (c *Client) createMediaItem(uploadToken string) (*MediaItem, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%w: failed to create media item: status %d: %s", ErrTokenRevoked, resp.StatusCode, string(bodyBytes))
+	}
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to create media item: status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, classifyQuotaError(fmt.Errorf("failed to create media item: status %d: %s", resp.StatusCode, string(bodyBytes)), string(bodyBytes))
 	}
 
 	var response BatchCreateMediaItemsResponse
@@ -435,15 +753,102 @@ func (c *Client) createMediaItem(uploadToken string) (*MediaItem, error) {
 	}
 
 	result := response.NewMediaItemResults[0]
+	if isDuplicateStatus(result.Status) {
+		// Identical media was uploaded before (e.g. under another name); it's already in the
+		// library, so this isn't a failure. The existing item is returned when Google sends it
+		item := &MediaItem{Duplicate: true}
+		if result.MediaItem != nil {
+			item.ID = result.MediaItem.ID
+		}
+		return item, nil
+	}
 	if result.Status != nil && result.Status.Code != 0 {
-		return nil, fmt.Errorf("media item creation failed: %s", result.Status.Message)
+		return nil, classifyQuotaError(fmt.Errorf("media item creation failed: %s", result.Status.Message), result.Status.Message)
 	}
 
 	if result.MediaItem == nil {
+		// Videos are processed asynchronously and may be accepted before an item is returned
+		if result.Status != nil && strings.Contains(strings.ToLower(result.Status.Message), "processing") {
+			return &MediaItem{Processing: true}, nil
+		}
 		return nil, fmt.Errorf("media item is nil in response")
 	}
 
-	return &MediaItem{ID: result.MediaItem.ID}, nil
+	item := &MediaItem{ID: result.MediaItem.ID}
+	if metadata := result.MediaItem.MediaMetadata; metadata != nil && metadata.Video != nil {
+		switch metadata.Video.Status {
+		case "FAILED":
+			return nil, fmt.Errorf("video processing failed")
+		case "PROCESSING":
+			item.Processing = true
+		}
+	}
+	return item, nil
+}
+
+// isDuplicateStatus reports whether a batchCreate result status says the library already
+// holds identical media
+func isDuplicateStatus(status *Status) bool {
+	if status == nil {
+		return false
+	}
+	message := strings.ToLower(status.Message)
+	return status.Code == statusAlreadyExists || strings.Contains(message, "already exists")
+}
+
+// verifyMediaItem looks up a newly created media item to confirm Google kept it. It reports
+// whether the item is still being processed, and fails if the item is missing, failed
+// processing, or has no baseUrl.
+func (c *Client) verifyMediaItem(mediaItemID string) (bool, error) {
+	url := fmt.Sprintf("https://photoslibrary.googleapis.com/v1/mediaItems/%s", mediaItemID)
+	req, err := http.NewRequestWithContext(c.ctx, "GET", url, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to get media item: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("%w: failed to get media item: status %d: %s", ErrTokenRevoked, resp.StatusCode, string(bodyBytes))
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return false, fmt.Errorf("media item %s not found after upload", mediaItemID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("failed to get media item: status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var item mediaItemResponse
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		return false, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return checkMediaItem(mediaItemID, &item)
+}
+
+// checkMediaItem validates a mediaItems.get response for verifyMediaItem
+func checkMediaItem(mediaItemID string, item *mediaItemResponse) (bool, error) {
+	if item.ID != mediaItemID {
+		return false, fmt.Errorf("media item %s not found after upload", mediaItemID)
+	}
+	processing := false
+	if metadata := item.MediaMetadata; metadata != nil && metadata.Video != nil {
+		switch metadata.Video.Status {
+		case "FAILED":
+			return false, fmt.Errorf("media item %s failed processing", mediaItemID)
+		case "PROCESSING":
+			processing = true
+		}
+	}
+	if item.BaseURL == "" && !processing {
+		return false, fmt.Errorf("media item %s has no baseUrl", mediaItemID)
+	}
+	return processing, nil
 }
 
 // addMediaItemToAlbum adds a media item to an album
@@ -471,14 +876,180 @@ func (c *Client) addMediaItemToAlbum(albumID string, mediaItemID string) error {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%w: failed to add media item to album: status %d: %s", ErrTokenRevoked, resp.StatusCode, string(bodyBytes))
+	}
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to add media item to album: status %d: %s", resp.StatusCode, string(bodyBytes))
+		return classifyQuotaError(fmt.Errorf("failed to add media item to album: status %d: %s", resp.StatusCode, string(bodyBytes)), string(bodyBytes))
 	}
 
 	return nil
 }
 
+// ResetAlbumContents forgets the loaded album contents so the next upload with SkipIfInAlbum
+// lists the album again, picking up items added or removed outside this client
+func (c *Client) ResetAlbumContents() {
+	c.albumItemsMutex.Lock()
+	defer c.albumItemsMutex.Unlock()
+	c.albumItems = nil
+}
+
+// albumHas reports whether the album holds a media item with the given file name or ID,
+// listing the album first if its contents aren't loaded
+func (c *Client) albumHas(albumID string, key string) (bool, error) {
+	c.albumItemsMutex.Lock()
+	defer c.albumItemsMutex.Unlock()
+	if c.albumItems[albumID] == nil {
+		items, count, err := c.listAlbumItems(albumID)
+		if err != nil {
+			return false, err
+		}
+		logging.Infof("Loaded %d items in the Google Photos album to skip photos it already has", count)
+		if c.albumItems == nil {
+			c.albumItems = make(map[string]map[string]bool)
+		}
+		c.albumItems[albumID] = items
+	}
+	return c.albumItems[albumID][key], nil
+}
+
+// rememberAlbumItem records that the album now holds a media item, if its contents are loaded
+func (c *Client) rememberAlbumItem(albumID string, fileName string, mediaItemID string) {
+	c.albumItemsMutex.Lock()
+	defer c.albumItemsMutex.Unlock()
+	if items := c.albumItems[albumID]; items != nil {
+		items[fileName] = true
+		items[mediaItemID] = true
+	}
+}
+
+// listAlbumItems returns the file names and IDs of every media item in an album, and the
+// number of items
+func (c *Client) listAlbumItems(albumID string) (map[string]bool, int, error) {
+	items := make(map[string]bool)
+	count := 0
+	var pageToken string
+	for {
+		jsonData, err := json.Marshal(searchMediaItemsRequest{AlbumID: albumID, PageSize: 100, PageToken: pageToken})
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to marshal request: %w", err)
+		}
+
+		req, err := http.NewRequestWithContext(c.ctx, "POST", "https://photoslibrary.googleapis.com/v1/mediaItems:search", bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		page, err := c.searchMediaItems(req)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, item := range page.MediaItems {
+			items[item.ID] = true
+			items[item.Filename] = true
+		}
+		count += len(page.MediaItems)
+
+		if page.NextPageToken == "" {
+			return items, count, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// searchMediaItems sends a mediaItems:search request and decodes the page it returns
+func (c *Client) searchMediaItems(req *http.Request) (*searchMediaItemsResponse, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list album items: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%w: failed to list album items: status %d: %s", ErrTokenRevoked, resp.StatusCode, string(bodyBytes))
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to list album items: status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var page searchMediaItemsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("failed to decode album items: %w", err)
+	}
+	return &page, nil
+}
+
+// wrapAuthError wraps err with ErrTokenRevoked if it was caused by the token endpoint
+// rejecting the refresh token (invalid_grant) or the client credentials (401)
+func wrapAuthError(err error) error {
+	if err == nil || errors.Is(err, ErrTokenRevoked) {
+		return err
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		if retrieveErr.ErrorCode == "invalid_grant" ||
+			(retrieveErr.Response != nil && retrieveErr.Response.StatusCode == http.StatusUnauthorized) {
+			return fmt.Errorf("%w: %v", ErrTokenRevoked, err)
+		}
+	}
+	return err
+}
+
+// mediaTypes maps extensions of photo and video formats iCloud serves to MIME types, since
+// the standard library's table doesn't include most video formats
+var mediaTypes = map[string]string{
+	".heic": "image/heic",
+	".mov":  "video/quicktime",
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+}
+
+// mediaContentType returns the MIME type to upload a file as, based on its extension or,
+// failing that, its first bytes. The file is left positioned at the start.
+func mediaContentType(file *os.File, fileName string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if contentType, ok := mediaTypes[ext]; ok {
+		return contentType, nil
+	}
+	if contentType := mime.TypeByExtension(ext); strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "video/") {
+		return contentType, nil
+	}
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to seek file: %w", err)
+	}
+	contentType := http.DetectContentType(header[:n])
+	if strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "video/") {
+		return contentType, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// classifyQuotaError wraps err with ErrAlbumFull or ErrStorageQuotaExceeded if the API message
+// describes a limit that retrying won't fix; otherwise err is returned unchanged
+func classifyQuotaError(err error, message string) error {
+	message = strings.ToLower(message)
+	switch {
+	case strings.Contains(message, "storage") &&
+		(strings.Contains(message, "quota") || strings.Contains(message, "full") || strings.Contains(message, "insufficient")):
+		return fmt.Errorf("%w: %v", ErrStorageQuotaExceeded, err)
+	case strings.Contains(message, "album") &&
+		(strings.Contains(message, "full") || strings.Contains(message, "20000") || strings.Contains(message, "20,000")):
+		return fmt.Errorf("%w: %v", ErrAlbumFull, err)
+	}
+	return err
+}
+
 // GetOrFindAlbumID gets the cached album ID or finds it by name
 // Deprecated: Use GetOrCreateAlbumID instead for better compatibility with new API scopes
 func (c *Client) GetOrFindAlbumID() (string, error) {
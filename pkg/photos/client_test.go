@@ -2,15 +2,22 @@ package photos
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+	"golang.org/x/oauth2"
 )
 
 func TestNewClient(t *testing.T) {
@@ -228,7 +235,7 @@ func TestClient_UploadPhoto(t *testing.T) {
 
 	// Note: This test requires proper OAuth2 setup and Google Photos API mocking
 	// The actual implementation uses google.golang.org/api which is harder to mock
-	err = client.UploadPhoto(testImagePath, "test-album-id")
+	err = client.UploadPhoto(testImagePath, "test-album-id", UploadOptions{})
 	if err != nil {
 		// Expected in test environment without proper OAuth and API setup
 		t.Logf("UploadPhoto() failed as expected in test: %v", err)
@@ -262,9 +269,7 @@ func TestClient_GetOrFindAlbumID(t *testing.T) {
 
 	// Test that album ID is cached after first successful call
 	// This would require a successful FindAlbumByName call first
-	client.albumMutex.Lock()
-	client.albumID = "cached-album-id"
-	client.albumMutex.Unlock()
+	client.cacheAlbumID("Test Album", "cached-album-id")
 
 	albumID, err := client.GetOrFindAlbumID()
 	if err != nil {
@@ -349,3 +354,544 @@ func TestClient_ErrorHandling_AlbumNotFound(t *testing.T) {
 	}
 }
 
+func TestWrapAuthError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantRevoked bool
+	}{
+		{
+			name:        "invalid_grant",
+			err:         fmt.Errorf("failed to upload: %w", &oauth2.RetrieveError{ErrorCode: "invalid_grant"}),
+			wantRevoked: true,
+		},
+		{
+			name:        "401 from token endpoint",
+			err:         &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusUnauthorized}},
+			wantRevoked: true,
+		},
+		{
+			name:        "other token error",
+			err:         &oauth2.RetrieveError{ErrorCode: "temporarily_unavailable"},
+			wantRevoked: false,
+		},
+		{
+			name:        "non-auth error",
+			err:         errors.New("connection reset"),
+			wantRevoked: false,
+		},
+		{
+			name:        "already wrapped",
+			err:         fmt.Errorf("%w: status 401", ErrTokenRevoked),
+			wantRevoked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := wrapAuthError(tt.err)
+			if errors.Is(got, ErrTokenRevoked) != tt.wantRevoked {
+				t.Errorf("wrapAuthError() = %v, want revoked = %v", got, tt.wantRevoked)
+			}
+		})
+	}
+}
+
+// memoryTokenStore is an in-memory UploadTokenStore for tests
+type memoryTokenStore struct {
+	tokens    map[string]string
+	createdAt map[string]time.Time
+}
+
+func newMemoryTokenStore() *memoryTokenStore {
+	return &memoryTokenStore{tokens: map[string]string{}, createdAt: map[string]time.Time{}}
+}
+
+func (s *memoryTokenStore) GetUploadToken(hash string) (string, time.Time, error) {
+	return s.tokens[hash], s.createdAt[hash], nil
+}
+
+func (s *memoryTokenStore) SetUploadToken(hash string, token string, ttl time.Duration) error {
+	s.tokens[hash] = token
+	s.createdAt[hash] = time.Now()
+	return nil
+}
+
+func (s *memoryTokenStore) DeleteUploadToken(hash string) error {
+	delete(s.tokens, hash)
+	delete(s.createdAt, hash)
+	return nil
+}
+
+func TestClient_StoredUploadToken(t *testing.T) {
+	client, err := NewClient(&config.GooglePhotosConfig{
+		ClientID:     "test-client-id",
+		ClientSecret: "test-client-secret",
+		RefreshToken: "test-refresh-token",
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	// Without a store nothing is saved or reused
+	client.saveUploadToken("hash-1", "token-1")
+	if got := client.storedUploadToken("hash-1"); got != "" {
+		t.Errorf("storedUploadToken() without store = %q, want empty", got)
+	}
+
+	store := newMemoryTokenStore()
+	client.SetUploadTokenStore(store)
+
+	client.saveUploadToken("hash-1", "token-1")
+	if got := client.storedUploadToken("hash-1"); got != "token-1" {
+		t.Errorf("storedUploadToken() = %q, want token-1", got)
+	}
+
+	// Tokens past the validity window are not reused
+	store.createdAt["hash-1"] = time.Now().Add(-UploadTokenValidity - time.Minute)
+	if got := client.storedUploadToken("hash-1"); got != "" {
+		t.Errorf("storedUploadToken() for expired token = %q, want empty", got)
+	}
+
+	// An empty hash never uses the store
+	client.saveUploadToken("", "token-2")
+	if len(store.tokens) != 1 {
+		t.Errorf("saveUploadToken() with empty hash stored a token")
+	}
+
+	client.deleteUploadToken("hash-1")
+	if _, ok := store.tokens["hash-1"]; ok {
+		t.Error("deleteUploadToken() did not remove the token")
+	}
+}
+
+func TestClassifyQuotaError(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    error
+	}{
+		{
+			name:    "storage full",
+			message: `{"error": {"code": 400, "message": "Insufficient storage quota", "status": "FAILED_PRECONDITION"}}`,
+			want:    ErrStorageQuotaExceeded,
+		},
+		{
+			name:    "album full",
+			message: "Request failed: album is full (20000 items)",
+			want:    ErrAlbumFull,
+		},
+		{
+			name:    "other error",
+			message: "Internal error",
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := errors.New("failed")
+			got := classifyQuotaError(base, tt.message)
+			if tt.want == nil {
+				if got != base {
+					t.Errorf("classifyQuotaError() = %v, want unchanged error", got)
+				}
+				return
+			}
+			if !errors.Is(got, tt.want) {
+				t.Errorf("classifyQuotaError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMediaContentType(t *testing.T) {
+	dir := t.TempDir()
+	pngHeader := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	tests := []struct {
+		name     string
+		fileName string
+		content  []byte
+		want     string
+	}{
+		{name: "jpeg by extension", fileName: "photo.jpg", content: []byte("data"), want: "image/jpeg"},
+		{name: "video by extension", fileName: "clip.MOV", content: []byte("data"), want: "video/quicktime"},
+		{name: "mp4 by extension", fileName: "clip.mp4", content: []byte("data"), want: "video/mp4"},
+		{name: "sniffed from content", fileName: "unknown", content: pngHeader, want: "image/png"},
+		{name: "unknown content", fileName: "unknown.bin", content: []byte("plain text"), want: "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.fileName)
+			if err := os.WriteFile(path, tt.content, 0644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("Failed to open file: %v", err)
+			}
+			defer file.Close()
+
+			got, err := mediaContentType(file, tt.fileName)
+			if err != nil {
+				t.Fatalf("mediaContentType() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("mediaContentType() = %q, want %q", got, tt.want)
+			}
+
+			// The file must be readable from the start afterwards
+			data, err := io.ReadAll(file)
+			if err != nil {
+				t.Fatalf("Failed to read file: %v", err)
+			}
+			if string(data) != string(tt.content) {
+				t.Errorf("file content after sniffing = %q, want %q", data, tt.content)
+			}
+		})
+	}
+}
+
+func TestRotatedAlbumName(t *testing.T) {
+	taken := time.Date(2024, 6, 30, 23, 30, 0, 0, time.FixedZone("PDT", -7*60*60))
+	tests := []struct {
+		name     string
+		base     string
+		rotation string
+		taken    time.Time
+		want     string
+	}{
+		{name: "none", base: "iCloud Sync", rotation: "none", taken: taken, want: "iCloud Sync"},
+		{name: "monthly uses UTC", base: "iCloud Sync", rotation: "monthly", taken: taken, want: "iCloud Sync 2024-07"},
+		{name: "yearly", base: "iCloud Sync", rotation: "yearly", taken: taken, want: "iCloud Sync 2024"},
+		{name: "undated", base: "iCloud Sync", rotation: "monthly", want: "iCloud Sync"},
+		{name: "library only", rotation: "monthly", taken: taken, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RotatedAlbumName(tt.base, tt.rotation, tt.taken); got != tt.want {
+				t.Errorf("RotatedAlbumName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_GetOrCreateAlbumIDFor_CachesByName(t *testing.T) {
+	client, err := NewClient(&config.GooglePhotosConfig{
+		ClientID:      "test-client-id",
+		ClientSecret:  "test-client-secret",
+		RefreshToken:  "test-refresh-token",
+		AlbumName:     "iCloud Sync",
+		AlbumRotation: "monthly",
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	client.cacheAlbumID("iCloud Sync 2024-06", "june-id")
+	client.cacheAlbumID("iCloud Sync 2024-07", "july-id")
+
+	for name, want := range map[string]string{"iCloud Sync 2024-06": "june-id", "iCloud Sync 2024-07": "july-id"} {
+		got, err := client.GetOrCreateAlbumIDFor(name)
+		if err != nil {
+			t.Fatalf("GetOrCreateAlbumIDFor(%q) error = %v", name, err)
+		}
+		if got != want {
+			t.Errorf("GetOrCreateAlbumIDFor(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if got, err := client.GetOrCreateAlbumIDFor(""); err != nil || got != "" {
+		t.Errorf("GetOrCreateAlbumIDFor(\"\") = %q, %v, want library only", got, err)
+	}
+
+	// Names are looked up by their sanitized title
+	if got, err := client.GetOrCreateAlbumIDFor(" iCloud\tSync  2024-06\n"); err != nil || got != "june-id" {
+		t.Errorf("GetOrCreateAlbumIDFor() = %q, %v, want the album of the sanitized name", got, err)
+	}
+	if _, err := client.GetOrCreateAlbumIDFor("\u200b\x00"); err == nil {
+		t.Error("GetOrCreateAlbumIDFor() error = nil, want an error for a name with nothing usable")
+	}
+}
+
+func TestSanitizeAlbumTitle(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "unchanged", input: "iCloud Sync 2024-06", want: "iCloud Sync 2024-06"},
+		{name: "whitespace collapsed and trimmed", input: "  Family\t\tPhotos \n", want: "Family Photos"},
+		{name: "control characters", input: "Family\x00Photos\x7f", want: "Family Photos"},
+		{name: "invisible formatting removed", input: "Fam\u200bily\ufeff", want: "Family"},
+		{name: "invalid UTF-8 removed", input: "Caf\xe9 Photos", want: "Caf Photos"},
+		{name: "non-ASCII kept", input: "Família 📷", want: "Família 📷"},
+		{name: "nothing usable", input: " \u200b ", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeAlbumTitle(tt.input); got != tt.want {
+				t.Errorf("sanitizeAlbumTitle(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+
+	// Cut to the limit in characters, not bytes, without a trailing space
+	long := sanitizeAlbumTitle(strings.Repeat("é", maxAlbumTitleLength-1) + " and more")
+	if len([]rune(long)) != maxAlbumTitleLength-1 || strings.HasSuffix(long, " ") {
+		t.Errorf("sanitizeAlbumTitle() of a long name = %d characters, want %d", len([]rune(long)), maxAlbumTitleLength-1)
+	}
+}
+
+func TestNewMediaItem_Description(t *testing.T) {
+	withCaption, err := json.Marshal(NewMediaItem{Description: "Beach day", SimpleMediaItem: SimpleMediaItem{UploadToken: "token"}})
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if !strings.Contains(string(withCaption), `"description":"Beach day"`) {
+		t.Errorf("NewMediaItem JSON = %s, want description", withCaption)
+	}
+
+	withoutCaption, err := json.Marshal(NewMediaItem{SimpleMediaItem: SimpleMediaItem{UploadToken: "token"}})
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if strings.Contains(string(withoutCaption), "description") {
+		t.Errorf("NewMediaItem JSON = %s, want no description for empty caption", withoutCaption)
+	}
+}
+
+func TestCheckMediaItem(t *testing.T) {
+	video := func(status string) *mediaMetadata {
+		metadata := &mediaMetadata{}
+		metadata.Video = &struct {
+			Status string `json:"status"`
+		}{Status: status}
+		return metadata
+	}
+
+	tests := []struct {
+		name           string
+		item           mediaItemResponse
+		wantProcessing bool
+		wantErr        bool
+	}{
+		{"ready photo", mediaItemResponse{ID: "item", BaseURL: "https://lh3.googleusercontent.com/item"}, false, false},
+		{"missing baseUrl", mediaItemResponse{ID: "item"}, false, true},
+		{"different item", mediaItemResponse{ID: "other", BaseURL: "https://lh3.googleusercontent.com/other"}, false, true},
+		{"video processing", mediaItemResponse{ID: "item", MediaMetadata: video("PROCESSING")}, true, false},
+		{"video failed", mediaItemResponse{ID: "item", BaseURL: "https://lh3.googleusercontent.com/item", MediaMetadata: video("FAILED")}, false, true},
+		{"video ready", mediaItemResponse{ID: "item", BaseURL: "https://lh3.googleusercontent.com/item", MediaMetadata: video("READY")}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processing, err := checkMediaItem("item", &tt.item)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkMediaItem() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if processing != tt.wantProcessing {
+				t.Errorf("checkMediaItem() processing = %v, want %v", processing, tt.wantProcessing)
+			}
+		})
+	}
+}
+
+// roundTripFunc serves a client's requests without a network
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestClient_SkipIfInAlbum(t *testing.T) {
+	searches := 0
+	var paths []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		paths = append(paths, req.URL.Path)
+		body := `{}`
+		if req.URL.Path == "/v1/mediaItems:search" {
+			searches++
+			var search searchMediaItemsRequest
+			if err := json.NewDecoder(req.Body).Decode(&search); err != nil || search.AlbumID != "album-id" {
+				t.Errorf("search request = %+v (%v), want album-id", search, err)
+			}
+			// Two pages, to check the listing follows nextPageToken
+			if search.PageToken == "" {
+				body = `{"mediaItems": [{"id": "item-1", "filename": "abc123.jpg"}], "nextPageToken": "page-2"}`
+			} else {
+				body = `{"mediaItems": [{"id": "item-2", "filename": "def456.jpg"}]}`
+			}
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+	})
+
+	client, err := NewClient(&config.GooglePhotosConfig{SkipIfInAlbum: true})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	client.httpClient = &http.Client{Transport: transport}
+
+	dir := t.TempDir()
+	for _, name := range []string{"abc123.jpg", "def456.jpg"} {
+		imagePath := filepath.Join(dir, name)
+		if err := os.WriteFile(imagePath, []byte("fake jpeg data"), 0644); err != nil {
+			t.Fatalf("Failed to write test image: %v", err)
+		}
+		if err := client.UploadPhoto(imagePath, "album-id", UploadOptions{}); err != nil {
+			t.Errorf("UploadPhoto(%s) error = %v, want skipped", name, err)
+		}
+	}
+	if searches != 2 {
+		t.Errorf("search requests = %d, want 2 (one listing over two pages)", searches)
+	}
+	for _, path := range paths {
+		if path != "/v1/mediaItems:search" {
+			t.Errorf("request to %s, want only album listing for photos already in the album", path)
+		}
+	}
+
+	// Google returns the existing media item for content already in the library, which must
+	// not be added to the album again
+	if inAlbum, err := client.albumHas("album-id", "item-2"); err != nil || !inAlbum {
+		t.Errorf("albumHas(item-2) = %v, %v, want true", inAlbum, err)
+	}
+	client.rememberAlbumItem("album-id", "ghi789.jpg", "item-3")
+	if inAlbum, _ := client.albumHas("album-id", "ghi789.jpg"); !inAlbum {
+		t.Error("albumHas(ghi789.jpg) = false after rememberAlbumItem, want true")
+	}
+
+	// Resetting lists the album again on the next check
+	client.ResetAlbumContents()
+	if inAlbum, _ := client.albumHas("album-id", "ghi789.jpg"); inAlbum {
+		t.Error("albumHas(ghi789.jpg) = true after ResetAlbumContents, want the album listed again")
+	}
+	if searches != 4 {
+		t.Errorf("search requests = %d, want 4 after ResetAlbumContents", searches)
+	}
+}
+
+func TestClient_UploadMedia_Metadata(t *testing.T) {
+	var got []map[string]string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if err != nil {
+			return nil, err
+		}
+		part, err := multipart.NewReader(req.Body, params["boundary"]).NextPart()
+		if err != nil {
+			return nil, err
+		}
+		metadata := map[string]string{}
+		if err := json.NewDecoder(part).Decode(&metadata); err != nil {
+			t.Errorf("metadata part is not JSON: %v", err)
+		}
+		got = append(got, metadata)
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("upload-token")), Header: make(http.Header)}, nil
+	})
+
+	client, err := NewClient(&config.GooglePhotosConfig{})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	client.httpClient = &http.Client{Transport: transport}
+	imagePath := filepath.Join(t.TempDir(), "abc123.jpg")
+	if err := os.WriteFile(imagePath, []byte("fake jpeg data"), 0644); err != nil {
+		t.Fatalf("Failed to write test image: %v", err)
+	}
+
+	if _, err := client.uploadMedia(imagePath, UploadMetadata{Description: "Beach day"}); err != nil {
+		t.Fatalf("uploadMedia() error = %v", err)
+	}
+	if _, err := client.uploadMedia(imagePath, UploadMetadata{FileName: "IMG_0001.jpg"}); err != nil {
+		t.Fatalf("uploadMedia() error = %v", err)
+	}
+	want := []map[string]string{
+		{"filename": "abc123.jpg", "description": "Beach day"},
+		{"filename": "IMG_0001.jpg"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata parts = %v, want %v", got, want)
+	}
+
+	if empty, err := json.Marshal(UploadMetadata{}); err != nil || string(empty) != "{}" {
+		t.Errorf("json.Marshal(UploadMetadata{}) = %s, %v, want {}", empty, err)
+	}
+}
+
+func TestClient_UploadPhoto_FileName(t *testing.T) {
+	var uploadNames, paths []string
+	createStatus := `{"code": 0, "message": "Success"}`
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		paths = append(paths, req.URL.Path)
+		body := `{}`
+		switch req.URL.Path {
+		case "/v1/uploads":
+			uploadNames = append(uploadNames, req.Header.Get("X-Goog-Upload-File-Name"))
+			body = "upload-token"
+		case "/v1/mediaItems:batchCreate":
+			body = `{"newMediaItemResults": [{"mediaItem": {"id": "item-1"}, "status": ` + createStatus + `}]}`
+		case "/v1/mediaItems:search":
+			body = `{"mediaItems": [{"id": "item-0", "filename": "0123456789ab.jpg"}]}`
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+	})
+
+	client, err := NewClient(&config.GooglePhotosConfig{})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	client.httpClient = &http.Client{Transport: transport}
+	imagePath := filepath.Join(t.TempDir(), "0123456789ab.jpg")
+	if err := os.WriteFile(imagePath, []byte("fake jpeg data"), 0644); err != nil {
+		t.Fatalf("Failed to write test image: %v", err)
+	}
+
+	if err := client.UploadPhoto(imagePath, "", UploadOptions{FileName: "IMG_0001-0123456789ab.jpg"}); err != nil {
+		t.Fatalf("UploadPhoto() error = %v", err)
+	}
+	if err := client.UploadPhoto(imagePath, "", UploadOptions{}); err != nil {
+		t.Fatalf("UploadPhoto() error = %v", err)
+	}
+	if want := []string{"IMG_0001-0123456789ab.jpg", "0123456789ab.jpg"}; !reflect.DeepEqual(uploadNames, want) {
+		t.Errorf("uploaded as %v, want %v", uploadNames, want)
+	}
+
+	// Identical media already in the library isn't a failure
+	createStatus = `{"code": 6, "message": "Failed: There was an error while trying to create this media item. (Identical media item already exists)"}`
+	if err := client.UploadPhoto(imagePath, "", UploadOptions{FileName: "IMG_0001-0123456789ab.jpg"}); err != nil {
+		t.Errorf("UploadPhoto() error = %v for a duplicate, want the existing item used", err)
+	}
+
+	// A photo uploaded under its stored name before names were used is still found in the album
+	skipping, err := NewClient(&config.GooglePhotosConfig{SkipIfInAlbum: true})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	skipping.httpClient = &http.Client{Transport: transport}
+	paths = nil
+	if err := skipping.UploadPhoto(imagePath, "album-id", UploadOptions{FileName: "IMG_0001-0123456789ab.jpg"}); err != nil {
+		t.Fatalf("UploadPhoto() error = %v", err)
+	}
+	if want := []string{"/v1/mediaItems:search"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("requests = %v, want %v for a photo already in the album", paths, want)
+	}
+}
+
+func TestIsDuplicateStatus(t *testing.T) {
+	tests := []struct {
+		status *Status
+		want   bool
+	}{
+		{status: nil, want: false},
+		{status: &Status{Code: 0, Message: "Success"}, want: false},
+		{status: &Status{Code: 6, Message: "ALREADY_EXISTS"}, want: true},
+		{status: &Status{Code: 3, Message: "Identical media item already exists"}, want: true},
+		{status: &Status{Code: 3, Message: "Invalid upload token"}, want: false},
+	}
+	for _, tt := range tests {
+		if got := isDuplicateStatus(tt.status); got != tt.want {
+			t.Errorf("isDuplicateStatus(%+v) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
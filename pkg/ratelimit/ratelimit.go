@@ -0,0 +1,16 @@
+package ratelimit
+
+import "time"
+
+// MaxRetryAfter caps how long a rate-limited request waits before its single retry
+const MaxRetryAfter = time.Minute
+
+// RetryAfter returns how long to wait before retrying a request the API asked to be retried in
+// the given number of seconds, at most MaxRetryAfter
+func RetryAfter(seconds int) time.Duration {
+	wait := time.Duration(seconds) * time.Second
+	if wait > MaxRetryAfter {
+		wait = MaxRetryAfter
+	}
+	return wait
+}
@@ -0,0 +1,23 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		seconds int
+		want    time.Duration
+	}{
+		{seconds: 0, want: 0},
+		{seconds: 5, want: 5 * time.Second},
+		{seconds: 60, want: time.Minute},
+		{seconds: 3600, want: MaxRetryAfter},
+	}
+	for _, tt := range tests {
+		if got := RetryAfter(tt.seconds); got != tt.want {
+			t.Errorf("RetryAfter(%d) = %v, want %v", tt.seconds, got, tt.want)
+		}
+	}
+}
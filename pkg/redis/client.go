@@ -3,23 +3,99 @@ package redis
 import (
 	"context"
 	"fmt"
-	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/redis/go-redis/v9"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
 )
 
 // Client wraps a Redis client for hash tracking
 type Client struct {
-	client *redis.Client
-	ctx    context.Context
+	client    *redis.Client
+	replica   *redis.Client // Optional - serves existence checks, falling back to client
+	ctx       context.Context
+	hashCache *hashCache // Optional - nil always checks Redis
+	keyPrefix string     // Namespace every key starts with
+	// replicaDown is set while the replica is failing, so the fallback is logged once per outage
+	replicaDown atomic.Bool
+}
+
+// DefaultKeyPrefix is the namespace keys are stored under unless Options.KeyPrefix is set
+const DefaultKeyPrefix = "image:hash"
+
+// Options configures the Redis driver
+// Zero values keep the driver defaults (or values given as query parameters in the URL)
+type Options struct {
+	MaxRetries int    // Retries per command on network errors, including a dropped connection (-1 disables)
+	PoolSize   int    // Maximum number of pooled connections
+	Password   string // Overrides any password in the URL
+	// DialTimeout, ReadTimeout and WriteTimeout bound connecting and each command's round-trip,
+	// so a slow or unreachable Redis fails commands instead of hanging the run
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// HashCacheSize enables an in-process LRU cache of up to this many delivered hashes in front
+	// of HashExistsFor, saving a Redis round-trip for repeated checks (0 disables)
+	HashCacheSize int
+	// KeyPrefix namespaces every key so several deployments can share one Redis instance
+	// (defaults to DefaultKeyPrefix, the prefix used before namespacing was configurable)
+	KeyPrefix string
+	// ReplicaURL is an optional read replica that serves the delivery checks (HashExistsFor,
+	// GUIDExistsFor, GetHash, and the dead-letter checks) while writes go to the primary.
+	// Checks fall back to the primary whenever the replica fails
+	ReplicaURL string
 }
 
 // NewClient creates a new Redis client
 func NewClient(redisURL string) (*Client, error) {
+	return NewClientWithOptions(redisURL, Options{})
+}
+
+// NewClientWithOptions creates a new Redis client with custom driver options
+// go-redis replaces broken pooled connections and retries the failed command, so a
+// Redis restart mid-run only fails commands once MaxRetries is exhausted
+func NewClientWithOptions(redisURL string, options Options) (*Client, error) {
 	opts, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
 	}
+	if options.MaxRetries != 0 {
+		opts.MaxRetries = options.MaxRetries
+	}
+	if options.PoolSize != 0 {
+		opts.PoolSize = options.PoolSize
+	}
+	if options.DialTimeout != 0 {
+		opts.DialTimeout = options.DialTimeout
+	}
+	if options.ReadTimeout != 0 {
+		opts.ReadTimeout = options.ReadTimeout
+	}
+	if options.WriteTimeout != 0 {
+		opts.WriteTimeout = options.WriteTimeout
+	}
+	if options.Password != "" {
+		opts.Password = options.Password
+	}
+	var replicaOpts *redis.Options
+	if options.ReplicaURL != "" {
+		if replicaOpts, err = redis.ParseURL(options.ReplicaURL); err != nil {
+			return nil, fmt.Errorf("failed to parse Redis replica URL: %w", err)
+		}
+		// Fall back to the primary straight away rather than retrying the replica
+		replicaOpts.MaxRetries = -1
+		replicaOpts.PoolSize = opts.PoolSize
+		replicaOpts.DialTimeout = opts.DialTimeout
+		replicaOpts.ReadTimeout = opts.ReadTimeout
+		replicaOpts.WriteTimeout = opts.WriteTimeout
+		if options.Password != "" {
+			replicaOpts.Password = options.Password
+		}
+	}
 
 	client := redis.NewClient(opts)
 	ctx := context.Background()
@@ -29,11 +105,68 @@ func NewClient(redisURL string) (*Client, error) {
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
-	log.Printf("Redis client initialized successfully")
-	return &Client{
-		client: client,
-		ctx:    ctx,
-	}, nil
+	logging.Infof("Redis client initialized successfully")
+	c := &Client{
+		client:    client,
+		ctx:       ctx,
+		keyPrefix: options.KeyPrefix,
+	}
+	if c.keyPrefix == "" {
+		c.keyPrefix = DefaultKeyPrefix
+	}
+	if options.HashCacheSize > 0 {
+		c.hashCache = newHashCache(options.HashCacheSize)
+	}
+	if replicaOpts != nil {
+		// An unreachable replica isn't fatal; checks use the primary until it's back
+		c.replica = redis.NewClient(replicaOpts)
+		if err := c.replica.Ping(ctx).Err(); err != nil {
+			c.replicaFailed(err)
+		} else {
+			logging.Infof("Redis read replica initialized successfully")
+		}
+	}
+	return c, nil
+}
+
+// exists runs EXISTS for key on the replica if one is configured, or on the primary
+func (c *Client) exists(key string) (int64, error) {
+	if c.replica != nil {
+		exists, err := c.replica.Exists(c.ctx, key).Result()
+		if err == nil {
+			c.replicaOK()
+			return exists, nil
+		}
+		c.replicaFailed(err)
+	}
+	return c.client.Exists(c.ctx, key).Result()
+}
+
+// get runs GET for key on the replica if one is configured, or on the primary
+func (c *Client) get(key string) (string, error) {
+	if c.replica != nil {
+		val, err := c.replica.Get(c.ctx, key).Result()
+		if err == nil || err == redis.Nil {
+			c.replicaOK()
+			return val, err
+		}
+		c.replicaFailed(err)
+	}
+	return c.client.Get(c.ctx, key).Result()
+}
+
+// replicaFailed logs the first replica failure of an outage
+func (c *Client) replicaFailed(err error) {
+	if !c.replicaDown.Swap(true) {
+		logging.Warnf("Redis read replica unavailable, checking the primary instead: %v", err)
+	}
+}
+
+// replicaOK logs the replica's recovery after an outage
+func (c *Client) replicaOK() {
+	if c.replicaDown.Swap(false) {
+		logging.Infof("Redis read replica is available again")
+	}
 }
 
 // HashExists checks if a hash exists in Redis (for email - kept for backward compatibility)
@@ -49,7 +182,7 @@ func (c *Client) SetHash(hash string, imageURL string) error {
 // GetHash retrieves the image URL associated with a hash
 func (c *Client) GetHash(hash string) (string, error) {
 	key := c.hashKey("email", hash)
-	val, err := c.client.Get(c.ctx, key).Result()
+	val, err := c.get(key)
 	if err == redis.Nil {
 		return "", nil
 	}
@@ -61,46 +194,693 @@ func (c *Client) GetHash(hash string) (string, error) {
 
 // HashExistsForEmail checks if a hash exists in Redis for email tracking
 func (c *Client) HashExistsForEmail(hash string) (bool, error) {
-	key := c.hashKey("email", hash)
-	exists, err := c.client.Exists(c.ctx, key).Result()
+	return c.HashExistsFor("email", hash)
+}
+
+// SetHashForEmail stores a hash in Redis with the associated image URL for email tracking
+func (c *Client) SetHashForEmail(hash string, imageURL string) error {
+	return c.SetHashFor("email", hash, imageURL)
+}
+
+// HashExistsForGooglePhotos checks if a hash exists in Redis for Google Photos tracking
+func (c *Client) HashExistsForGooglePhotos(hash string) (bool, error) {
+	return c.HashExistsFor("google_photos", hash)
+}
+
+// SetHashForGooglePhotos stores a hash in Redis with the associated image URL for Google Photos tracking
+func (c *Client) SetHashForGooglePhotos(hash string, imageURL string) error {
+	return c.SetHashFor("google_photos", hash, imageURL)
+}
+
+// HashExistsFor checks if a hash has been delivered by the named service (e.g. "email", "webhook")
+func (c *Client) HashExistsFor(service string, hash string) (bool, error) {
+	key := c.hashKey(service, hash)
+	if c.hashCache != nil && c.hashCache.Contains(key) {
+		return true, nil
+	}
+	exists, err := c.exists(key)
 	if err != nil {
 		return false, fmt.Errorf("failed to check hash existence: %w", err)
 	}
+	if exists > 0 && c.hashCache != nil {
+		c.hashCache.Add(key)
+	}
 	return exists > 0, nil
 }
 
-// SetHashForEmail stores a hash in Redis with the associated image URL for email tracking
-func (c *Client) SetHashForEmail(hash string, imageURL string) error {
-	key := c.hashKey("email", hash)
+// SetHashFor records that a hash has been delivered by the named service, storing the associated image URL
+func (c *Client) SetHashFor(service string, hash string, imageURL string) error {
+	key := c.hashKey(service, hash)
 	err := c.client.Set(c.ctx, key, imageURL, 0).Err()
 	if err != nil {
 		return fmt.Errorf("failed to set hash: %w", err)
 	}
+	if c.hashCache != nil {
+		c.hashCache.Add(key)
+	}
 	return nil
 }
 
-// HashExistsForGooglePhotos checks if a hash exists in Redis for Google Photos tracking
-func (c *Client) HashExistsForGooglePhotos(hash string) (bool, error) {
-	key := c.hashKey("google_photos", hash)
-	exists, err := c.client.Exists(c.ctx, key).Result()
+// GUIDExistsFor checks if an iCloud asset GUID has been delivered by the named service
+func (c *Client) GUIDExistsFor(service string, guid string) (bool, error) {
+	key := c.guidKey(service, guid)
+	if c.hashCache != nil && c.hashCache.Contains(key) {
+		return true, nil
+	}
+	exists, err := c.exists(key)
 	if err != nil {
-		return false, fmt.Errorf("failed to check hash existence: %w", err)
+		return false, fmt.Errorf("failed to check GUID existence: %w", err)
+	}
+	if exists > 0 && c.hashCache != nil {
+		c.hashCache.Add(key)
 	}
 	return exists > 0, nil
 }
 
-// SetHashForGooglePhotos stores a hash in Redis with the associated image URL for Google Photos tracking
-func (c *Client) SetHashForGooglePhotos(hash string, imageURL string) error {
-	key := c.hashKey("google_photos", hash)
+// SetGUIDFor records that an iCloud asset GUID has been delivered by the named service,
+// storing the hash of the content that was delivered
+func (c *Client) SetGUIDFor(service string, guid string, hash string) error {
+	key := c.guidKey(service, guid)
+	if err := c.client.Set(c.ctx, key, hash, 0).Err(); err != nil {
+		return fmt.Errorf("failed to set GUID: %w", err)
+	}
+	if c.hashCache != nil {
+		c.hashCache.Add(key)
+	}
+	return nil
+}
+
+// ListHashesFor returns every hash delivered by the named service, mapped to its image URL
+func (c *Client) ListHashesFor(service string) (map[string]string, error) {
+	prefix := c.hashKey(service, "")
+	hashes := make(map[string]string)
+	iter := c.client.Scan(c.ctx, 0, scanPattern(prefix), 0).Iterator()
+	for iter.Next(c.ctx) {
+		key := iter.Val()
+		imageURL, err := c.client.Get(c.ctx, key).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s hash: %w", service, err)
+		}
+		hashes[strings.TrimPrefix(key, prefix)] = imageURL
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan %s hashes: %w", service, err)
+	}
+	return hashes, nil
+}
+
+// ListEmailHashes returns every emailed hash mapped to its image URL
+func (c *Client) ListEmailHashes() (map[string]string, error) {
+	return c.ListHashesFor("email")
+}
+
+// ListGooglePhotosHashes returns every hash uploaded to Google Photos mapped to its image URL
+func (c *Client) ListGooglePhotosHashes() (map[string]string, error) {
+	return c.ListHashesFor("google_photos")
+}
+
+// IncrementFailureCount increments the consecutive failure count for a hash and returns the new count
+func (c *Client) IncrementFailureCount(hash string) (int64, error) {
+	key := c.hashKey("failures", hash)
+	count, err := c.client.Incr(c.ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment failure count: %w", err)
+	}
+	return count, nil
+}
+
+// GetFailureCount returns the consecutive failure count for a hash (0 if none recorded)
+func (c *Client) GetFailureCount(hash string) (int64, error) {
+	key := c.hashKey("failures", hash)
+	count, err := c.client.Get(c.ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get failure count: %w", err)
+	}
+	return count, nil
+}
+
+// GetFailureCounts returns the failure counts of all hashes that currently have failures recorded
+func (c *Client) GetFailureCounts() (map[string]int64, error) {
+	prefix := c.hashKey("failures", "")
+	counts := make(map[string]int64)
+	iter := c.client.Scan(c.ctx, 0, scanPattern(prefix), 0).Iterator()
+	for iter.Next(c.ctx) {
+		key := iter.Val()
+		count, err := c.client.Get(c.ctx, key).Int64()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get failure count: %w", err)
+		}
+		counts[strings.TrimPrefix(key, prefix)] = count
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan failure counts: %w", err)
+	}
+	return counts, nil
+}
+
+// IsDeadLettered checks if a hash has been dead-lettered (quarantined after repeated failures)
+func (c *Client) IsDeadLettered(hash string) (bool, error) {
+	key := c.hashKey("dead_letter", hash)
+	exists, err := c.exists(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to check dead letter existence: %w", err)
+	}
+	return exists > 0, nil
+}
+
+// SetDeadLettered marks a hash as dead-lettered so it is skipped on future runs
+func (c *Client) SetDeadLettered(hash string, imageURL string) error {
+	key := c.hashKey("dead_letter", hash)
 	err := c.client.Set(c.ctx, key, imageURL, 0).Err()
 	if err != nil {
-		return fmt.Errorf("failed to set hash: %w", err)
+		return fmt.Errorf("failed to set dead letter: %w", err)
+	}
+	return nil
+}
+
+// IncrementAttemptsFor increments the failed delivery attempts of a hash for the named service
+// and returns the new count
+func (c *Client) IncrementAttemptsFor(service string, hash string) (int64, error) {
+	count, err := c.client.Incr(c.ctx, c.hashKey("attempts:"+service, hash)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment %s attempts: %w", service, err)
+	}
+	return count, nil
+}
+
+// IsDeadLetteredFor checks if the named service has given up delivering a hash
+func (c *Client) IsDeadLetteredFor(service string, hash string) (bool, error) {
+	exists, err := c.exists(c.hashKey("dead_letter:"+service, hash))
+	if err != nil {
+		return false, fmt.Errorf("failed to check %s dead letter existence: %w", service, err)
+	}
+	return exists > 0, nil
+}
+
+// SetDeadLetteredFor marks a hash as dead-lettered for the named service so it stops retrying
+// the delivery; other services are unaffected
+func (c *Client) SetDeadLetteredFor(service string, hash string, imageURL string) error {
+	if err := c.client.Set(c.ctx, c.hashKey("dead_letter:"+service, hash), imageURL, 0).Err(); err != nil {
+		return fmt.Errorf("failed to set %s dead letter: %w", service, err)
+	}
+	return nil
+}
+
+// ResetAttemptsFor clears the failed attempts and dead-letter mark of a hash for the named service
+func (c *Client) ResetAttemptsFor(service string, hash string) error {
+	err := c.client.Del(c.ctx, c.hashKey("attempts:"+service, hash), c.hashKey("dead_letter:"+service, hash)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to reset %s attempts: %w", service, err)
+	}
+	return nil
+}
+
+// ResetFailures clears the failure count and dead-letter mark for a hash
+func (c *Client) ResetFailures(hash string) error {
+	err := c.client.Del(c.ctx, c.hashKey("failures", hash), c.hashKey("dead_letter", hash)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to reset failures: %w", err)
+	}
+	return nil
+}
+
+// ResetAllFailures clears all failure counts, per-service attempt counts, and dead-letter marks
+// Returns the number of keys removed
+func (c *Client) ResetAllFailures() (int, error) {
+	removed := 0
+	for _, prefix := range []string{"failures", "attempts", "dead_letter"} {
+		iter := c.client.Scan(c.ctx, 0, scanPattern(c.hashKey(prefix, "")), 0).Iterator()
+		for iter.Next(c.ctx) {
+			if err := c.client.Del(c.ctx, iter.Val()).Err(); err != nil {
+				return removed, fmt.Errorf("failed to delete %s: %w", iter.Val(), err)
+			}
+			removed++
+		}
+		if err := iter.Err(); err != nil {
+			return removed, fmt.Errorf("failed to scan %s keys: %w", prefix, err)
+		}
+	}
+	return removed, nil
+}
+
+// SetUploadToken stores a Google Photos upload token for a hash along with its creation time
+// The key expires after ttl so stale tokens are cleaned up automatically
+func (c *Client) SetUploadToken(hash string, token string, ttl time.Duration) error {
+	key := c.hashKey("upload_token", hash)
+	pipe := c.client.TxPipeline()
+	pipe.HSet(c.ctx, key, "token", token, "created_at", time.Now().Unix())
+	pipe.Expire(c.ctx, key, ttl)
+	if _, err := pipe.Exec(c.ctx); err != nil {
+		return fmt.Errorf("failed to set upload token: %w", err)
+	}
+	return nil
+}
+
+// GetUploadToken returns the stored upload token for a hash and when it was created
+// Returns an empty token if none is stored
+func (c *Client) GetUploadToken(hash string) (string, time.Time, error) {
+	key := c.hashKey("upload_token", hash)
+	values, err := c.client.HGetAll(c.ctx, key).Result()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to get upload token: %w", err)
+	}
+	token := values["token"]
+	if token == "" {
+		return "", time.Time{}, nil
+	}
+	createdAt, err := strconv.ParseInt(values["created_at"], 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid upload token timestamp: %w", err)
+	}
+	return token, time.Unix(createdAt, 0), nil
+}
+
+// DeleteUploadToken removes the stored upload token for a hash
+func (c *Client) DeleteUploadToken(hash string) error {
+	key := c.hashKey("upload_token", hash)
+	if err := c.client.Del(c.ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to delete upload token: %w", err)
+	}
+	return nil
+}
+
+// URLHashTTL is how long a URL→hash mapping is remembered; iCloud URLs rotate, so stale ones expire
+const URLHashTTL = 30 * 24 * time.Hour
+
+// SetURLHash remembers the hash of the content at imageURL and the ETag it was served with
+func (c *Client) SetURLHash(imageURL string, hash string, etag string) error {
+	key := c.hashKey("url", imageURL)
+	pipe := c.client.TxPipeline()
+	pipe.HSet(c.ctx, key, "hash", hash, "etag", etag)
+	pipe.Expire(c.ctx, key, URLHashTTL)
+	if _, err := pipe.Exec(c.ctx); err != nil {
+		return fmt.Errorf("failed to set URL hash: %w", err)
+	}
+	return nil
+}
+
+// GetURLHash returns the remembered hash and ETag for imageURL
+// Returns an empty hash if the URL hasn't been seen
+func (c *Client) GetURLHash(imageURL string) (string, string, error) {
+	key := c.hashKey("url", imageURL)
+	values, err := c.client.HGetAll(c.ctx, key).Result()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get URL hash: %w", err)
+	}
+	return values["hash"], values["etag"], nil
+}
+
+// AddAlbumHash records that an image from the album (identified by its URL) has been synced
+func (c *Client) AddAlbumHash(albumURL string, hash string) error {
+	key := c.hashKey("album_hashes", albumURL)
+	if err := c.client.SAdd(c.ctx, key, hash).Err(); err != nil {
+		return fmt.Errorf("failed to add album hash: %w", err)
+	}
+	return nil
+}
+
+// AlbumHashCount returns how many synced images have been recorded for the album
+// Zero means the album has never been synced
+func (c *Client) AlbumHashCount(albumURL string) (int64, error) {
+	key := c.hashKey("album_hashes", albumURL)
+	count, err := c.client.SCard(c.ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count album hashes: %w", err)
+	}
+	return count, nil
+}
+
+// AlbumWelcomed reports whether the album (identified by its URL) has been introduced with a
+// welcome email (WELCOME_EMAIL)
+func (c *Client) AlbumWelcomed(albumURL string) (bool, error) {
+	key := c.hashKey("album_welcomed", albumURL)
+	exists, err := c.exists(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to check album welcome: %w", err)
+	}
+	return exists > 0, nil
+}
+
+// SetAlbumWelcomed records that the album has been introduced, so it is never welcomed again
+func (c *Client) SetAlbumWelcomed(albumURL string) error {
+	key := c.hashKey("album_welcomed", albumURL)
+	if err := c.client.Set(c.ctx, key, time.Now().UTC().Format(time.RFC3339), 0).Err(); err != nil {
+		return fmt.Errorf("failed to set album welcome: %w", err)
+	}
+	return nil
+}
+
+// AlbumCursor returns the position in the album list the next run starts scraping from
+// (MAX_ALBUMS_PER_RUN), or 0 if none has been recorded
+func (c *Client) AlbumCursor() (int, error) {
+	val, err := c.get(c.hashKey("cursor", "albums"))
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get album cursor: %w", err)
+	}
+	cursor, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid album cursor %q: %w", val, err)
+	}
+	return cursor, nil
+}
+
+// SetAlbumCursor records the position in the album list the next run starts scraping from
+func (c *Client) SetAlbumCursor(cursor int) error {
+	if err := c.client.Set(c.ctx, c.hashKey("cursor", "albums"), cursor, 0).Err(); err != nil {
+		return fmt.Errorf("failed to set album cursor: %w", err)
+	}
+	return nil
+}
+
+// AddRunProgress records that the current run has finished an image from the album (identified
+// by its URL), keeping the album's progress for ttl after the last image recorded
+func (c *Client) AddRunProgress(albumURL string, image string, ttl time.Duration) error {
+	key := c.hashKey("run_progress", albumURL)
+	pipe := c.client.TxPipeline()
+	pipe.SAdd(c.ctx, key, image)
+	pipe.Expire(c.ctx, key, ttl)
+	if _, err := pipe.Exec(c.ctx); err != nil {
+		return fmt.Errorf("failed to add run progress: %w", err)
+	}
+	return nil
+}
+
+// RunProgress returns the images an interrupted run finished from the album
+func (c *Client) RunProgress(albumURL string) ([]string, error) {
+	key := c.hashKey("run_progress", albumURL)
+	images, err := c.client.SMembers(c.ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get run progress: %w", err)
+	}
+	return images, nil
+}
+
+// ClearRunProgress forgets the album's progress once a run has gone through all of its images
+func (c *Client) ClearRunProgress(albumURL string) error {
+	key := c.hashKey("run_progress", albumURL)
+	if err := c.client.Del(c.ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to clear run progress: %w", err)
+	}
+	return nil
+}
+
+// AddDeferred queues an image whose delivery to a service was deferred (e.g. by quiet hours),
+// with what the service needs to deliver it later (entry, e.g. encoded metadata)
+func (c *Client) AddDeferred(service string, hash string, entry string) error {
+	key := c.hashKey("deferred", service)
+	if err := c.client.HSet(c.ctx, key, hash, entry).Err(); err != nil {
+		return fmt.Errorf("failed to add deferred hash: %w", err)
 	}
 	return nil
 }
 
-// Close closes the Redis connection
+// Deferred returns the entries of the images queued for a service, by hash
+func (c *Client) Deferred(service string) (map[string]string, error) {
+	key := c.hashKey("deferred", service)
+	entries, err := c.client.HGetAll(c.ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deferred hashes: %w", err)
+	}
+	return entries, nil
+}
+
+// DeferredCount returns how many images are queued for a service
+func (c *Client) DeferredCount(service string) (int64, error) {
+	key := c.hashKey("deferred", service)
+	count, err := c.client.HLen(c.ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deferred hashes: %w", err)
+	}
+	return count, nil
+}
+
+// RemoveDeferred takes an image off a service's queue once it has been delivered (or can't be)
+func (c *Client) RemoveDeferred(service string, hash string) error {
+	key := c.hashKey("deferred", service)
+	if err := c.client.HDel(c.ctx, key, hash).Err(); err != nil {
+		return fmt.Errorf("failed to remove deferred hash: %w", err)
+	}
+	return nil
+}
+
+// refreshLockScript extends a lock's expiry only while the caller still holds it
+var refreshLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseLockScript deletes a lock only while the caller still holds it
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// AcquireLock takes the named lock for owner unless another owner holds it. The lock expires
+// after ttl unless refreshed, so a crashed holder can't block others for long. When the lock
+// is taken, it returns false and the current holder
+func (c *Client) AcquireLock(name string, owner string, ttl time.Duration) (bool, string, error) {
+	key := c.hashKey("lock", name)
+	acquired, err := c.client.SetNX(c.ctx, key, owner, ttl).Result()
+	if err != nil {
+		return false, "", fmt.Errorf("failed to acquire %s lock: %w", name, err)
+	}
+	if acquired {
+		return true, owner, nil
+	}
+	holder, err := c.client.Get(c.ctx, key).Result()
+	if err == redis.Nil {
+		return false, "", nil // Released in between; the caller tries again next time
+	}
+	if err != nil {
+		return false, "", fmt.Errorf("failed to read %s lock: %w", name, err)
+	}
+	return false, holder, nil
+}
+
+// RefreshLock extends the named lock's expiry to ttl from now
+// Returns false if owner no longer holds the lock (it expired and may have been taken)
+func (c *Client) RefreshLock(name string, owner string, ttl time.Duration) (bool, error) {
+	key := c.hashKey("lock", name)
+	refreshed, err := refreshLockScript.Run(c.ctx, c.client, []string{key}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to refresh %s lock: %w", name, err)
+	}
+	return refreshed == 1, nil
+}
+
+// ReleaseLock releases the named lock if owner still holds it
+func (c *Client) ReleaseLock(name string, owner string) error {
+	key := c.hashKey("lock", name)
+	if err := releaseLockScript.Run(c.ctx, c.client, []string{key}, owner).Err(); err != nil {
+		return fmt.Errorf("failed to release %s lock: %w", name, err)
+	}
+	return nil
+}
+
+// RekeyHashes moves everything recorded under an old content hash to its new hash, for a
+// change of hash scheme (HASH_ALGO, HASH_CONTENT). hashes maps each old hash to its new one.
+// Delivery, failure, attempt, dead-letter and upload token keys are renamed, keeping a key
+// already recorded under the new hash; the hashes remembered for URLs, GUIDs, albums and
+// deferred deliveries are rewritten. Running it again after an interruption is safe.
+// Returns the number of keys changed
+func (c *Client) RekeyHashes(hashes map[string]string) (int, error) {
+	if len(hashes) == 0 {
+		return 0, nil
+	}
+	// Keys are listed up front so renamed keys aren't visited again by the scan
+	prefix := c.keyPrefix + ":"
+	keys, err := c.scanKeys(prefix)
+	if err != nil {
+		return 0, err
+	}
+	guidKeys, err := c.scanKeys(c.guidNamespace() + ":")
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, key := range keys {
+		rest := strings.TrimPrefix(key, prefix)
+		kind, _, _ := strings.Cut(rest, ":")
+		var n int
+		switch kind {
+		case "url":
+			n, err = c.rekeyField(key, "hash", hashes)
+		case "album_hashes":
+			n, err = c.rekeyMembers(key, hashes)
+		case "deferred":
+			n, err = c.rekeyFieldNames(key, hashes)
+		case "guid", "lock":
+			// GUID keys are handled below; locks aren't tracked by hash
+			continue
+		default:
+			i := strings.LastIndex(rest, ":")
+			newHash, ok := hashes[rest[i+1:]]
+			if i < 0 || !ok {
+				continue
+			}
+			n, err = c.renameKey(key, prefix+rest[:i+1]+newHash)
+		}
+		if err != nil {
+			return changed, err
+		}
+		changed += n
+	}
+	for _, key := range guidKeys {
+		n, err := c.rekeyValue(key, hashes)
+		if err != nil {
+			return changed, err
+		}
+		changed += n
+	}
+	return changed, nil
+}
+
+// scanKeys returns every key starting with prefix
+func (c *Client) scanKeys(prefix string) ([]string, error) {
+	var keys []string
+	iter := c.client.Scan(c.ctx, 0, scanPattern(prefix), 0).Iterator()
+	for iter.Next(c.ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan keys: %w", err)
+	}
+	return keys, nil
+}
+
+// renameKey renames key to newKey, or deletes it if newKey already exists
+func (c *Client) renameKey(key string, newKey string) (int, error) {
+	renamed, err := c.client.RenameNX(c.ctx, key, newKey).Result()
+	if err != nil && strings.Contains(err.Error(), "no such key") {
+		// Gone since it was listed
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to rename %s: %w", key, err)
+	}
+	if !renamed {
+		if err := c.client.Del(c.ctx, key).Err(); err != nil {
+			return 0, fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+	}
+	return 1, nil
+}
+
+// rekeyValue rewrites a string key holding an old hash
+func (c *Client) rekeyValue(key string, hashes map[string]string) (int, error) {
+	hash, err := c.client.Get(c.ctx, key).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	newHash, ok := hashes[hash]
+	if !ok {
+		return 0, nil
+	}
+	if err := c.client.Set(c.ctx, key, newHash, redis.KeepTTL).Err(); err != nil {
+		return 0, fmt.Errorf("failed to set %s: %w", key, err)
+	}
+	return 1, nil
+}
+
+// rekeyField rewrites a hash key's field holding an old hash
+func (c *Client) rekeyField(key string, field string, hashes map[string]string) (int, error) {
+	hash, err := c.client.HGet(c.ctx, key, field).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	newHash, ok := hashes[hash]
+	if !ok {
+		return 0, nil
+	}
+	if err := c.client.HSet(c.ctx, key, field, newHash).Err(); err != nil {
+		return 0, fmt.Errorf("failed to set %s: %w", key, err)
+	}
+	return 1, nil
+}
+
+// rekeyFieldNames renames the fields of a hash named after old hashes to their new hashes,
+// keeping a field already recorded under the new hash
+func (c *Client) rekeyFieldNames(key string, hashes map[string]string) (int, error) {
+	fields, err := c.client.HGetAll(c.ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	pipe := c.client.TxPipeline()
+	changed := 0
+	for hash, value := range fields {
+		if newHash, ok := hashes[hash]; ok {
+			pipe.HDel(c.ctx, key, hash)
+			pipe.HSetNX(c.ctx, key, newHash, value)
+			changed = 1
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+	if _, err := pipe.Exec(c.ctx); err != nil {
+		return 0, fmt.Errorf("failed to update %s: %w", key, err)
+	}
+	return changed, nil
+}
+
+// rekeyMembers replaces the old hashes in a set with their new hashes
+func (c *Client) rekeyMembers(key string, hashes map[string]string) (int, error) {
+	members, err := c.client.SMembers(c.ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	pipe := c.client.TxPipeline()
+	changed := 0
+	for _, hash := range members {
+		if newHash, ok := hashes[hash]; ok {
+			pipe.SRem(c.ctx, key, hash)
+			pipe.SAdd(c.ctx, key, newHash)
+			changed = 1
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+	if _, err := pipe.Exec(c.ctx); err != nil {
+		return 0, fmt.Errorf("failed to update %s: %w", key, err)
+	}
+	return changed, nil
+}
+
+// Ping checks that Redis is reachable
+func (c *Client) Ping() error {
+	if err := c.client.Ping(c.ctx).Err(); err != nil {
+		return fmt.Errorf("failed to ping Redis: %w", err)
+	}
+	return nil
+}
+
+// Close closes the Redis connections
 func (c *Client) Close() error {
+	if c.replica != nil {
+		c.replica.Close()
+	}
 	if c.client != nil {
 		return c.client.Close()
 	}
@@ -109,6 +889,35 @@ func (c *Client) Close() error {
 
 // hashKey returns the Redis key for a hash with a prefix
 func (c *Client) hashKey(prefix, hash string) string {
-	return fmt.Sprintf("image:hash:%s:%s", prefix, hash)
+	return fmt.Sprintf("%s:%s:%s", c.keyPrefix, prefix, hash)
+}
+
+// guidKey returns the key tracking an asset GUID for a service: image:guid:<service>:<guid>,
+// or <prefix>:guid:<service>:<guid> with a custom key prefix. GUIDs live apart from the
+// content hashes so both keyspaces can be used side by side
+func (c *Client) guidKey(service, guid string) string {
+	return fmt.Sprintf("%s:%s:%s", c.guidNamespace(), service, guid)
+}
+
+// guidNamespace returns the prefix of every GUID key (see guidKey)
+func (c *Client) guidNamespace() string {
+	if c.keyPrefix != DefaultKeyPrefix {
+		return c.keyPrefix + ":guid"
+	}
+	return "image:guid"
 }
 
+// scanPattern returns a SCAN pattern matching every key starting with prefix
+// Glob characters in the prefix (e.g. from a custom key prefix) are escaped
+func scanPattern(prefix string) string {
+	var b strings.Builder
+	for _, r := range prefix {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteString("*")
+	return b.String()
+}
@@ -1,7 +1,9 @@
 package redis
 
 import (
+	"strings"
 	"testing"
+	"time"
 )
 
 func setupTestRedis(t *testing.T) *Client {
@@ -103,7 +105,7 @@ func TestClient_GetHash(t *testing.T) {
 
 func TestClient_Close(t *testing.T) {
 	client := setupTestRedis(t)
-	
+
 	err := client.Close()
 	if err != nil {
 		t.Fatalf("Close() error = %v", err)
@@ -132,6 +134,58 @@ func TestHashKey(t *testing.T) {
 	}
 }
 
+func TestClient_KeyPrefix(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyPrefix string
+		want      string
+	}{
+		{name: "default", keyPrefix: DefaultKeyPrefix, want: "image:hash:email:abc123"},
+		{name: "custom", keyPrefix: "family-albums", want: "family-albums:email:abc123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{keyPrefix: tt.keyPrefix}
+			if got := client.hashKey("email", "abc123"); got != tt.want {
+				t.Errorf("hashKey() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanPattern(t *testing.T) {
+	if got, want := scanPattern("deploy[1]*:email:"), `deploy\[1\]\*:email:*`; got != want {
+		t.Errorf("scanPattern() = %s, want %s", got, want)
+	}
+}
+
+func TestClient_KeyPrefixIsolation(t *testing.T) {
+	setupTestRedis(t).Close()
+	first, err := NewClientWithOptions("redis://localhost:6379", Options{KeyPrefix: "test-deploy-a"})
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() error = %v", err)
+	}
+	defer first.Close()
+	second, err := NewClientWithOptions("redis://localhost:6379", Options{KeyPrefix: "test-deploy-b"})
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() error = %v", err)
+	}
+	defer second.Close()
+
+	hash := "isolation-test-hash"
+	defer first.client.Del(first.ctx, first.hashKey("email", hash))
+	if err := first.SetHashFor("email", hash, "https://example.com/a.jpg"); err != nil {
+		t.Fatalf("SetHashFor() error = %v", err)
+	}
+	exists, err := second.HashExistsFor("email", hash)
+	if err != nil {
+		t.Fatalf("HashExistsFor() error = %v", err)
+	}
+	if exists {
+		t.Error("HashExistsFor() = true, want keys isolated between prefixes")
+	}
+}
+
 // Test with a mock Redis for unit tests without requiring Redis
 func TestClient_WithMock(t *testing.T) {
 	// This would use a mock Redis client for true unit testing
@@ -225,3 +279,514 @@ func TestClient_BackwardCompatibility(t *testing.T) {
 	}
 }
 
+func TestClient_FailureTracking(t *testing.T) {
+	client := setupTestRedis(t)
+	defer client.Close()
+
+	hash := "test-hash-failures"
+	imageURL := "https://example.com/broken.jpg"
+	defer client.ResetFailures(hash)
+
+	for want := int64(1); want <= 3; want++ {
+		count, err := client.IncrementFailureCount(hash)
+		if err != nil {
+			t.Fatalf("IncrementFailureCount() error = %v", err)
+		}
+		if count != want {
+			t.Errorf("IncrementFailureCount() = %v, want %v", count, want)
+		}
+	}
+
+	counts, err := client.GetFailureCounts()
+	if err != nil {
+		t.Fatalf("GetFailureCounts() error = %v", err)
+	}
+	if counts[hash] != 3 {
+		t.Errorf("GetFailureCounts()[%s] = %v, want 3", hash, counts[hash])
+	}
+
+	if err := client.SetDeadLettered(hash, imageURL); err != nil {
+		t.Fatalf("SetDeadLettered() error = %v", err)
+	}
+	deadLettered, err := client.IsDeadLettered(hash)
+	if err != nil {
+		t.Fatalf("IsDeadLettered() error = %v", err)
+	}
+	if !deadLettered {
+		t.Error("IsDeadLettered() = false, want true")
+	}
+
+	if err := client.ResetFailures(hash); err != nil {
+		t.Fatalf("ResetFailures() error = %v", err)
+	}
+	count, err := client.GetFailureCount(hash)
+	if err != nil {
+		t.Fatalf("GetFailureCount() error = %v", err)
+	}
+	if count != 0 {
+		t.Errorf("GetFailureCount() after reset = %v, want 0", count)
+	}
+	deadLettered, err = client.IsDeadLettered(hash)
+	if err != nil {
+		t.Fatalf("IsDeadLettered() error = %v", err)
+	}
+	if deadLettered {
+		t.Error("IsDeadLettered() after reset = true, want false")
+	}
+}
+
+func TestClient_AttemptTracking(t *testing.T) {
+	client := setupTestRedis(t)
+	defer client.Close()
+
+	hash := "test-hash-attempts"
+	defer client.ResetAttemptsFor("email", hash)
+
+	for want := int64(1); want <= 2; want++ {
+		count, err := client.IncrementAttemptsFor("email", hash)
+		if err != nil {
+			t.Fatalf("IncrementAttemptsFor() error = %v", err)
+		}
+		if count != want {
+			t.Errorf("IncrementAttemptsFor() = %v, want %v", count, want)
+		}
+	}
+
+	if err := client.SetDeadLetteredFor("email", hash, "https://example.com/huge.jpg"); err != nil {
+		t.Fatalf("SetDeadLetteredFor() error = %v", err)
+	}
+	if deadLettered, err := client.IsDeadLetteredFor("email", hash); err != nil || !deadLettered {
+		t.Errorf("IsDeadLetteredFor(email) = %v, %v, want true", deadLettered, err)
+	}
+	// Only the email delivery is given up on
+	if deadLettered, err := client.IsDeadLetteredFor("google_photos", hash); err != nil || deadLettered {
+		t.Errorf("IsDeadLetteredFor(google_photos) = %v, %v, want false", deadLettered, err)
+	}
+	if deadLettered, err := client.IsDeadLettered(hash); err != nil || deadLettered {
+		t.Errorf("IsDeadLettered() = %v, %v, want false", deadLettered, err)
+	}
+
+	if err := client.ResetAttemptsFor("email", hash); err != nil {
+		t.Fatalf("ResetAttemptsFor() error = %v", err)
+	}
+	if deadLettered, err := client.IsDeadLetteredFor("email", hash); err != nil || deadLettered {
+		t.Errorf("IsDeadLetteredFor(email) after reset = %v, %v, want false", deadLettered, err)
+	}
+	if count, err := client.IncrementAttemptsFor("email", hash); err != nil || count != 1 {
+		t.Errorf("IncrementAttemptsFor() after reset = %v, %v, want 1", count, err)
+	}
+}
+
+func TestClient_UploadToken(t *testing.T) {
+	client := setupTestRedis(t)
+	defer client.Close()
+
+	hash := "test-hash-upload-token"
+	defer client.DeleteUploadToken(hash)
+
+	token, _, err := client.GetUploadToken(hash)
+	if err != nil {
+		t.Fatalf("GetUploadToken() error = %v", err)
+	}
+	if token != "" {
+		t.Errorf("GetUploadToken() = %q, want empty for missing token", token)
+	}
+
+	before := time.Now().Add(-time.Second)
+	if err := client.SetUploadToken(hash, "upload-token", time.Hour); err != nil {
+		t.Fatalf("SetUploadToken() error = %v", err)
+	}
+
+	token, createdAt, err := client.GetUploadToken(hash)
+	if err != nil {
+		t.Fatalf("GetUploadToken() error = %v", err)
+	}
+	if token != "upload-token" {
+		t.Errorf("GetUploadToken() = %q, want upload-token", token)
+	}
+	if createdAt.Before(before) {
+		t.Errorf("GetUploadToken() createdAt = %v, want after %v", createdAt, before)
+	}
+
+	if err := client.DeleteUploadToken(hash); err != nil {
+		t.Fatalf("DeleteUploadToken() error = %v", err)
+	}
+	token, _, err = client.GetUploadToken(hash)
+	if err != nil {
+		t.Fatalf("GetUploadToken() error = %v", err)
+	}
+	if token != "" {
+		t.Errorf("GetUploadToken() after delete = %q, want empty", token)
+	}
+}
+
+func TestClient_ListHashes(t *testing.T) {
+	client := setupTestRedis(t)
+	defer client.Close()
+
+	hash := "test-hash-list"
+	imageURL := "https://example.com/list.jpg"
+	defer client.client.Del(client.ctx, client.hashKey("email", hash), client.hashKey("google_photos", hash))
+
+	if err := client.SetHashForEmail(hash, imageURL); err != nil {
+		t.Fatalf("SetHashForEmail() error = %v", err)
+	}
+
+	emailHashes, err := client.ListEmailHashes()
+	if err != nil {
+		t.Fatalf("ListEmailHashes() error = %v", err)
+	}
+	if emailHashes[hash] != imageURL {
+		t.Errorf("ListEmailHashes()[%s] = %q, want %q", hash, emailHashes[hash], imageURL)
+	}
+
+	gphotosHashes, err := client.ListGooglePhotosHashes()
+	if err != nil {
+		t.Fatalf("ListGooglePhotosHashes() error = %v", err)
+	}
+	if _, ok := gphotosHashes[hash]; ok {
+		t.Errorf("ListGooglePhotosHashes() contains %s, want it absent", hash)
+	}
+}
+
+func TestClient_URLHash(t *testing.T) {
+	client := setupTestRedis(t)
+	defer client.Close()
+
+	imageURL := "https://example.com/url-hash.jpg"
+	defer client.client.Del(client.ctx, client.hashKey("url", imageURL))
+
+	hash, etag, err := client.GetURLHash(imageURL)
+	if err != nil {
+		t.Fatalf("GetURLHash() error = %v", err)
+	}
+	if hash != "" || etag != "" {
+		t.Errorf("GetURLHash() = (%q, %q), want empty for unseen URL", hash, etag)
+	}
+
+	if err := client.SetURLHash(imageURL, "abc123", `"etag-1"`); err != nil {
+		t.Fatalf("SetURLHash() error = %v", err)
+	}
+	hash, etag, err = client.GetURLHash(imageURL)
+	if err != nil {
+		t.Fatalf("GetURLHash() error = %v", err)
+	}
+	if hash != "abc123" || etag != `"etag-1"` {
+		t.Errorf("GetURLHash() = (%q, %q), want (abc123, \"etag-1\")", hash, etag)
+	}
+}
+
+func TestClient_AlbumHashes(t *testing.T) {
+	client := setupTestRedis(t)
+	defer client.Close()
+
+	albumURL := "https://www.icloud.com/sharedalbum/#ALBUM_HASHES_TEST"
+	defer client.client.Del(client.ctx, client.hashKey("album_hashes", albumURL))
+
+	count, err := client.AlbumHashCount(albumURL)
+	if err != nil {
+		t.Fatalf("AlbumHashCount() error = %v", err)
+	}
+	if count != 0 {
+		t.Errorf("AlbumHashCount() = %d, want 0 for unsynced album", count)
+	}
+
+	for _, hash := range []string{"abc", "def", "abc"} {
+		if err := client.AddAlbumHash(albumURL, hash); err != nil {
+			t.Fatalf("AddAlbumHash() error = %v", err)
+		}
+	}
+	count, err = client.AlbumHashCount(albumURL)
+	if err != nil {
+		t.Fatalf("AlbumHashCount() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("AlbumHashCount() = %d, want 2", count)
+	}
+}
+
+func TestClient_AlbumWelcomed(t *testing.T) {
+	client := setupTestRedis(t)
+	defer client.Close()
+
+	albumURL := "https://www.icloud.com/sharedalbum/#ALBUM_WELCOMED_TEST"
+	defer client.client.Del(client.ctx, client.hashKey("album_welcomed", albumURL))
+
+	welcomed, err := client.AlbumWelcomed(albumURL)
+	if err != nil {
+		t.Fatalf("AlbumWelcomed() error = %v", err)
+	}
+	if welcomed {
+		t.Error("AlbumWelcomed() = true, want false before SetAlbumWelcomed")
+	}
+	if err := client.SetAlbumWelcomed(albumURL); err != nil {
+		t.Fatalf("SetAlbumWelcomed() error = %v", err)
+	}
+	welcomed, err = client.AlbumWelcomed(albumURL)
+	if err != nil {
+		t.Fatalf("AlbumWelcomed() error = %v", err)
+	}
+	if !welcomed {
+		t.Error("AlbumWelcomed() = false, want true after SetAlbumWelcomed")
+	}
+}
+
+func TestClient_RunProgress(t *testing.T) {
+	client := setupTestRedis(t)
+	defer client.Close()
+
+	albumURL := "https://www.icloud.com/sharedalbum/#RUN_PROGRESS_TEST"
+	key := client.hashKey("run_progress", albumURL)
+	defer client.client.Del(client.ctx, key)
+
+	for _, image := range []string{"guid-1", "guid-2", "guid-1"} {
+		if err := client.AddRunProgress(albumURL, image, time.Hour); err != nil {
+			t.Fatalf("AddRunProgress() error = %v", err)
+		}
+	}
+	images, err := client.RunProgress(albumURL)
+	if err != nil {
+		t.Fatalf("RunProgress() error = %v", err)
+	}
+	if len(images) != 2 {
+		t.Errorf("RunProgress() = %v, want guid-1 and guid-2", images)
+	}
+	if ttl := client.client.TTL(client.ctx, key).Val(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("TTL = %v, want at most an hour", ttl)
+	}
+
+	if err := client.ClearRunProgress(albumURL); err != nil {
+		t.Fatalf("ClearRunProgress() error = %v", err)
+	}
+	if images, err := client.RunProgress(albumURL); err != nil || len(images) != 0 {
+		t.Errorf("RunProgress() = %v, %v after ClearRunProgress, want none", images, err)
+	}
+}
+
+func TestClient_AlbumCursor(t *testing.T) {
+	client := setupTestRedis(t)
+	defer client.Close()
+
+	key := client.hashKey("cursor", "albums")
+	client.client.Del(client.ctx, key)
+	defer client.client.Del(client.ctx, key)
+
+	if cursor, err := client.AlbumCursor(); err != nil || cursor != 0 {
+		t.Errorf("AlbumCursor() = %d, %v before any run, want 0", cursor, err)
+	}
+	if err := client.SetAlbumCursor(7); err != nil {
+		t.Fatalf("SetAlbumCursor() error = %v", err)
+	}
+	if cursor, err := client.AlbumCursor(); err != nil || cursor != 7 {
+		t.Errorf("AlbumCursor() = %d, %v, want 7", cursor, err)
+	}
+}
+
+func TestClient_GUIDs(t *testing.T) {
+	client := setupTestRedis(t)
+	defer client.Close()
+
+	guid := "GUID_TEST_ASSET"
+	defer client.client.Del(client.ctx, client.guidKey("email", guid))
+
+	if got := client.guidKey("email", guid); got != "image:guid:email:"+guid {
+		t.Errorf("guidKey() = %s, want image:guid:email:%s", got, guid)
+	}
+
+	exists, err := client.GUIDExistsFor("email", guid)
+	if err != nil {
+		t.Fatalf("GUIDExistsFor() error = %v", err)
+	}
+	if exists {
+		t.Error("GUIDExistsFor() = true before SetGUIDFor")
+	}
+	if err := client.SetGUIDFor("email", guid, "abc123"); err != nil {
+		t.Fatalf("SetGUIDFor() error = %v", err)
+	}
+	exists, err = client.GUIDExistsFor("email", guid)
+	if err != nil {
+		t.Fatalf("GUIDExistsFor() error = %v", err)
+	}
+	if !exists {
+		t.Error("GUIDExistsFor() = false after SetGUIDFor")
+	}
+	// Tracked per service, separately from content hashes
+	if exists, _ := client.GUIDExistsFor("google_photos", guid); exists {
+		t.Error("GUIDExistsFor(google_photos) = true, want false")
+	}
+	if exists, _ := client.HashExistsFor("email", guid); exists {
+		t.Error("HashExistsFor() = true for a GUID, want false")
+	}
+}
+
+func TestClient_RekeyHashes(t *testing.T) {
+	setupTestRedis(t).Close()
+	client, err := NewClientWithOptions("redis://localhost:6379", Options{KeyPrefix: "test-rekey"})
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() error = %v", err)
+	}
+	defer client.Close()
+	defer func() {
+		keys, _ := client.scanKeys("test-rekey:")
+		if len(keys) > 0 {
+			client.client.Del(client.ctx, keys...)
+		}
+	}()
+
+	imageURL := "https://example.com/a.jpg"
+	client.SetHashFor("email", "old1", imageURL)
+	client.SetHashFor("google_photos", "old1", imageURL)
+	client.SetHashFor("google_photos", "new1", imageURL) // Already recorded under the new hash
+	client.SetDeadLetteredFor("webhook", "old1", imageURL)
+	client.SetHashFor("email", "other", imageURL)
+	client.SetGUIDFor("email", "GUID1", "old1")
+	client.SetURLHash(imageURL, "old1", `"etag"`)
+	client.AddAlbumHash("https://www.icloud.com/sharedalbum/#B0", "old1")
+	client.AddDeferred("email", "old1", `{"Album":"Family"}`)
+
+	if _, err := client.RekeyHashes(map[string]string{"old1": "new1"}); err != nil {
+		t.Fatalf("RekeyHashes() error = %v", err)
+	}
+
+	for _, service := range []string{"email", "google_photos"} {
+		if exists, _ := client.HashExistsFor(service, "new1"); !exists {
+			t.Errorf("HashExistsFor(%s, new1) = false after RekeyHashes()", service)
+		}
+		if exists, _ := client.HashExistsFor(service, "old1"); exists {
+			t.Errorf("HashExistsFor(%s, old1) = true after RekeyHashes()", service)
+		}
+	}
+	if deadLettered, _ := client.IsDeadLetteredFor("webhook", "new1"); !deadLettered {
+		t.Error("IsDeadLetteredFor(webhook, new1) = false after RekeyHashes()")
+	}
+	if exists, _ := client.HashExistsFor("email", "other"); !exists {
+		t.Error("HashExistsFor(email, other) = false, want unmapped hashes kept")
+	}
+	if hash, _ := client.client.Get(client.ctx, client.guidKey("email", "GUID1")).Result(); hash != "new1" {
+		t.Errorf("GUID hash = %q, want new1", hash)
+	}
+	if hash, _, _ := client.GetURLHash(imageURL); hash != "new1" {
+		t.Errorf("GetURLHash() = %q, want new1", hash)
+	}
+	if entries, _ := client.Deferred("email"); len(entries) != 1 || entries["new1"] != `{"Album":"Family"}` {
+		t.Errorf("Deferred() = %v, want the entry under new1", entries)
+	}
+	if count, _ := client.AlbumHashCount("https://www.icloud.com/sharedalbum/#B0"); count != 1 {
+		t.Errorf("AlbumHashCount() = %d, want 1", count)
+	}
+
+	// Running it again changes nothing
+	if changed, err := client.RekeyHashes(map[string]string{"old1": "new1"}); err != nil || changed != 0 {
+		t.Errorf("RekeyHashes() again = %d, %v, want 0, nil", changed, err)
+	}
+}
+
+func TestClient_Deferred(t *testing.T) {
+	client := setupTestRedis(t)
+	defer client.Close()
+
+	service := "deferred_test"
+	defer client.client.Del(client.ctx, client.hashKey("deferred", service))
+
+	for _, hash := range []string{"abc", "def", "abc"} {
+		if err := client.AddDeferred(service, hash, "entry "+hash); err != nil {
+			t.Fatalf("AddDeferred() error = %v", err)
+		}
+	}
+	count, err := client.DeferredCount(service)
+	if err != nil {
+		t.Fatalf("DeferredCount() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("DeferredCount() = %d, want 2", count)
+	}
+	entries, err := client.Deferred(service)
+	if err != nil {
+		t.Fatalf("Deferred() error = %v", err)
+	}
+	if entries["abc"] != "entry abc" || entries["def"] != "entry def" {
+		t.Errorf("Deferred() = %v, want the entries by hash", entries)
+	}
+
+	if err := client.RemoveDeferred(service, "abc"); err != nil {
+		t.Fatalf("RemoveDeferred() error = %v", err)
+	}
+	entries, err = client.Deferred(service)
+	if err != nil {
+		t.Fatalf("Deferred() error = %v", err)
+	}
+	if len(entries) != 1 || entries["def"] != "entry def" {
+		t.Errorf("Deferred() after RemoveDeferred = %v, want only def", entries)
+	}
+}
+
+func TestClient_Lock(t *testing.T) {
+	client := setupTestRedis(t)
+	defer client.Close()
+
+	name := "lock_test"
+	defer client.client.Del(client.ctx, client.hashKey("lock", name))
+
+	acquired, _, err := client.AcquireLock(name, "first", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("AcquireLock(first) = %v, %v, want acquired", acquired, err)
+	}
+	acquired, holder, err := client.AcquireLock(name, "second", time.Minute)
+	if err != nil || acquired || holder != "first" {
+		t.Fatalf("AcquireLock(second) = %v, %q, %v, want not acquired, held by first", acquired, holder, err)
+	}
+
+	if refreshed, err := client.RefreshLock(name, "second", time.Minute); err != nil || refreshed {
+		t.Errorf("RefreshLock(second) = %v, %v, want false", refreshed, err)
+	}
+	if refreshed, err := client.RefreshLock(name, "first", time.Minute); err != nil || !refreshed {
+		t.Errorf("RefreshLock(first) = %v, %v, want true", refreshed, err)
+	}
+
+	// Only the holder can release the lock
+	if err := client.ReleaseLock(name, "second"); err != nil {
+		t.Fatalf("ReleaseLock(second) error = %v", err)
+	}
+	if acquired, _, _ := client.AcquireLock(name, "second", time.Minute); acquired {
+		t.Error("AcquireLock(second) succeeded after a release by a non-holder")
+	}
+	if err := client.ReleaseLock(name, "first"); err != nil {
+		t.Fatalf("ReleaseLock(first) error = %v", err)
+	}
+	if acquired, _, err := client.AcquireLock(name, "second", time.Minute); err != nil || !acquired {
+		t.Errorf("AcquireLock(second) after release = %v, %v, want acquired", acquired, err)
+	}
+}
+
+func TestNewClientWithOptions_InvalidReplicaURL(t *testing.T) {
+	_, err := NewClientWithOptions("redis://localhost:6379", Options{ReplicaURL: "http://localhost:6379"})
+	if err == nil || !strings.Contains(err.Error(), "replica") {
+		t.Errorf("NewClientWithOptions() error = %v, want invalid replica URL error", err)
+	}
+}
+
+func TestClient_ReplicaFallback(t *testing.T) {
+	// Nothing listens on port 1, so every replica read must fall back to the primary
+	client, err := NewClientWithOptions("redis://localhost:6379", Options{ReplicaURL: "redis://localhost:1"})
+	if err != nil {
+		t.Skipf("Skipping test: Redis not available: %v", err)
+	}
+	defer client.Close()
+
+	hash := "test-replica-fallback"
+	defer client.client.Del(client.ctx, client.hashKey("email", hash))
+	if err := client.SetHashFor("email", hash, "https://example.com/replica.jpg"); err != nil {
+		t.Fatalf("SetHashFor() error = %v", err)
+	}
+	exists, err := client.HashExistsFor("email", hash)
+	if err != nil || !exists {
+		t.Errorf("HashExistsFor() = %v, %v, want true from the primary", exists, err)
+	}
+	imageURL, err := client.GetHash(hash)
+	if err != nil || imageURL != "https://example.com/replica.jpg" {
+		t.Errorf("GetHash() = %q, %v, want the URL from the primary", imageURL, err)
+	}
+	if !client.replicaDown.Load() {
+		t.Error("replicaDown = false, want true while the replica is unreachable")
+	}
+}
@@ -0,0 +1,59 @@
+package redis
+
+import (
+	"container/list"
+	"sync"
+)
+
+// hashCache is a bounded, concurrency-safe LRU set of Redis keys known to exist
+// Only positive results are cached: a key that exists stays delivered, while a missing key
+// may be set at any moment by another writer
+type hashCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List               // Front is most recently used
+	entries  map[string]*list.Element // Key -> element holding the key
+}
+
+// newHashCache creates a cache holding at most capacity keys
+func newHashCache(capacity int) *hashCache {
+	return &hashCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element, capacity),
+	}
+}
+
+// Contains reports whether key is cached, marking it as recently used
+func (c *hashCache) Contains(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if ok {
+		c.order.MoveToFront(element)
+	}
+	return ok
+}
+
+// Add caches key, evicting the least recently used key if the cache is full
+func (c *hashCache) Add(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(key)
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(string))
+	}
+}
+
+// Len returns the number of cached keys
+func (c *hashCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
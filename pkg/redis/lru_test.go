@@ -0,0 +1,51 @@
+package redis
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestHashCache_Eviction(t *testing.T) {
+	cache := newHashCache(2)
+
+	cache.Add("a")
+	cache.Add("b")
+	if !cache.Contains("a") {
+		t.Fatal("Contains(a) = false, want true")
+	}
+
+	// "b" is now least recently used and is evicted by "c"
+	cache.Add("c")
+	if cache.Contains("b") {
+		t.Error("Contains(b) = true, want false after eviction")
+	}
+	if !cache.Contains("a") || !cache.Contains("c") {
+		t.Error("Contains(a) and Contains(c) should be true")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", cache.Len())
+	}
+}
+
+func TestHashCache_Concurrent(t *testing.T) {
+	cache := newHashCache(50)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("key-%d-%d", worker, j)
+				cache.Add(key)
+				cache.Contains(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if cache.Len() != 50 {
+		t.Errorf("Len() = %d, want 50", cache.Len())
+	}
+}
@@ -0,0 +1,268 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+)
+
+// Client uploads objects to an S3-compatible bucket (AWS S3, MinIO, etc.)
+// Requests are signed with AWS Signature Version 4
+type Client struct {
+	config     *config.S3Config
+	endpoint   *url.URL
+	pathStyle  bool // Address the bucket in the path rather than the host name
+	httpClient *http.Client
+	now        func() time.Time // Replaceable in tests
+}
+
+// NewClient creates a new S3 client
+// With no endpoint configured, AWS S3 in the configured region is used with virtual-hosted
+// bucket addressing; a custom endpoint (e.g. MinIO) uses path-style addressing
+func NewClient(cfg *config.S3Config) (*Client, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("S3 config is required")
+	}
+
+	endpoint := cfg.Endpoint
+	pathStyle := endpoint != ""
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3 endpoint: %s", endpoint)
+	}
+
+	return &Client{
+		config:    cfg,
+		endpoint:  u,
+		pathStyle: pathStyle,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Minute, // Large videos can take a while
+		},
+		now: time.Now,
+	}, nil
+}
+
+// PutObject uploads the file at filePath to key in the bucket
+func (c *Client) PutObject(key string, filePath string, contentType string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	// SigV4 signs the payload hash, so the file is read once to hash it and again to upload it
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return fmt.Errorf("failed to hash file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek file: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, c.objectURL(key), file)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	c.sign(req, hex.EncodeToString(hasher.Sum(nil)))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("S3 upload failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// ObjectETag returns the ETag of key in the bucket without its quotes, or false if there is no
+// such object. For objects uploaded in a single PUT, the ETag is the hex MD5 of the content
+func (c *Client) ObjectETag(key string) (string, bool, error) {
+	req, err := http.NewRequest(http.MethodHead, c.objectURL(key), nil)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create request: %w", err)
+	}
+	c.sign(req, emptyPayloadHash)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to check object: %w", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return strings.Trim(resp.Header.Get("ETag"), `"`), true, nil
+	case http.StatusNotFound:
+		return "", false, nil
+	default:
+		return "", false, fmt.Errorf("S3 object check failed with status %d", resp.StatusCode)
+	}
+}
+
+// PresignGetObject returns a URL from which anyone can download key until it expires
+// (at most 7 days, the SigV4 limit)
+func (c *Client) PresignGetObject(key string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > 7*24*time.Hour {
+		return "", fmt.Errorf("presigned URL expiry must be between 1 second and 7 days: got %v", expires)
+	}
+	u, err := url.Parse(c.objectURL(key))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse object URL: %w", err)
+	}
+	c.presign(u, expires)
+	return u.String(), nil
+}
+
+// presign adds AWS Signature Version 4 query parameters for a GET of u
+// Only the host is signed and the payload is unsigned, as browsers send no other headers
+func (c *Client) presign(u *url.URL, expires time.Duration) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + c.config.Region + "/s3/aws4_request"
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", c.config.AccessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+	// Encode escapes spaces as "+"; SigV4 requires "%20"
+	canonicalQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		canonicalQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	u.RawQuery = canonicalQuery + "&X-Amz-Signature=" + hex.EncodeToString(hmacSHA256(c.signingKey(date), stringToSign))
+}
+
+// objectURL returns the URL of key in the bucket
+func (c *Client) objectURL(key string) string {
+	u := *c.endpoint
+	path, rawPath := "/"+key, "/"+escapePath(key)
+	if c.pathStyle {
+		path, rawPath = "/"+c.config.Bucket+path, "/"+escapePath(c.config.Bucket)+rawPath
+	} else {
+		u.Host = c.config.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(c.endpoint.Path, "/") + path
+	u.RawPath = strings.TrimSuffix(c.endpoint.EscapedPath(), "/") + rawPath
+	return u.String()
+}
+
+// sign adds AWS Signature Version 4 headers to req
+// The host, Content-Type, Range, and all x-amz-* headers are signed
+func (c *Client) sign(req *http.Request, payloadHash string) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Canonical headers: lowercase names, sorted, with trimmed values
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" || lower == "range" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.config.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signature := hex.EncodeToString(hmacSHA256(c.signingKey(date), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// signingKey derives the SigV4 signing key for date (YYYYMMDD)
+func (c *Client) signingKey(date string) []byte {
+	key := hmacSHA256([]byte("AWS4"+c.config.SecretAccessKey), date)
+	key = hmacSHA256(key, c.config.Region)
+	key = hmacSHA256(key, "s3")
+	return hmacSHA256(key, "aws4_request")
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapePath URI-encodes each segment of an object key as SigV4 requires: every byte except
+// unreserved characters (A-Z a-z 0-9 - . _ ~) is percent-encoded, keeping the slashes
+func escapePath(key string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		ch := key[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9',
+			ch == '-', ch == '.', ch == '_', ch == '~', ch == '/':
+			b.WriteByte(ch)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[ch>>4])
+			b.WriteByte(hexDigits[ch&0x0F])
+		}
+	}
+	return b.String()
+}
@@ -1,31 +1,116 @@
 package scraper
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
 )
 
+// ErrInvalidAlbumURL is returned when no usable album token can be extracted from an album URL
+var ErrInvalidAlbumURL = errors.New("invalid album URL")
+
+// defaultRetryDelay is the wait before the first retry of a failed scrape; it doubles for each
+// further retry
+const defaultRetryDelay = 5 * time.Second
+
+// Options holds optional scraper settings
+type Options struct {
+	Timeout time.Duration // Maximum time to wait for the iCloud API per scrape attempt (0 = no timeout)
+	Retries int           // Times a scrape that failed with a transient error is retried (0 = no retries)
+	// CacheTTL is how long a successful scrape's photos are returned instead of fetching the
+	// album again (0 = always fetch)
+	CacheTTL time.Duration
+	// Header is sent with every request to iCloud, e.g. the Cookie of a signed-in iCloud web
+	// session for albums that aren't served anonymously. Replaces the default header of the
+	// same name
+	Header http.Header
+}
+
 // Scraper scrapes iCloud shared albums for image URLs
 type Scraper struct {
 	albumURL string
 	token    string
-	client   *icloudalbum.Client
+	timeout  time.Duration
+	retries  int
+	// retryDelay is the wait before the first retry (replaceable in tests)
+	retryDelay time.Duration
+	// getImages fetches the album from iCloud (replaceable in tests)
+	getImages func(token string) (*icloudalbum.Response, error)
+
+	// nameMu guards albumName, which a refresh of expired URLs can set while the pipeline's
+	// workers read it
+	nameMu    sync.RWMutex
+	albumName string // Album (stream) name reported by iCloud on the last successful scrape
+
+	cacheTTL time.Duration
+	cacheMu  sync.Mutex // Guards the fields below
+	cached   []Photo    // Photos from the last successful scrape
+	cachedAt time.Time  // When they were fetched (zero if nothing is cached)
 }
 
 // NewScraper creates a new scraper instance
 func NewScraper(albumURL string) *Scraper {
+	return NewScraperWithOptions(albumURL, Options{})
+}
+
+// NewScraperWithOptions creates a new scraper instance with the given options
+func NewScraperWithOptions(albumURL string, opts Options) *Scraper {
 	// Extract token from URL (part after #)
 	token := extractTokenFromURL(albumURL)
-	
-	return &Scraper{
-		albumURL: albumURL,
-		token:    token,
-		client:   icloudalbum.NewClient(),
+	s := &Scraper{
+		albumURL:   albumURL,
+		token:      token,
+		timeout:    opts.Timeout,
+		retries:    opts.Retries,
+		retryDelay: defaultRetryDelay,
+		getImages:  icloudalbum.NewClient().GetImages,
+		cacheTTL:   opts.CacheTTL,
+	}
+	if len(opts.Header) > 0 {
+		// The library's HTTP client can't be configured, so the album is fetched with a client
+		// of our own that sends the header
+		s.getImages = newWebClient(opts.Header).GetImages
 	}
+	return s
+}
+
+// headerTransport sets header on requests to iCloud before passing them to base (or
+// http.DefaultTransport if nil). Requests to other hosts are passed on unchanged, so a session
+// cookie is never sent elsewhere
+type headerTransport struct {
+	base   http.RoundTripper
+	header http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	host := strings.ToLower(req.URL.Hostname())
+	if host != "icloud.com" && !strings.HasSuffix(host, ".icloud.com") {
+		return base.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		req.Header[name] = values
+	}
+	return base.RoundTrip(req)
 }
 
 // extractTokenFromURL extracts the album token from an iCloud shared album URL
@@ -44,19 +129,303 @@ func extractTokenFromURL(url string) string {
 	return token
 }
 
+// AlbumName returns the album name reported by iCloud, falling back to the album token
+// if the album has not been scraped successfully yet
+func (s *Scraper) AlbumName() string {
+	s.nameMu.RLock()
+	defer s.nameMu.RUnlock()
+	if s.albumName != "" {
+		return s.albumName
+	}
+	return s.token
+}
+
+// setAlbumName records the album name iCloud reported, keeping the previous one if it's empty
+func (s *Scraper) setAlbumName(name string) {
+	if name == "" {
+		return
+	}
+	s.nameMu.Lock()
+	defer s.nameMu.Unlock()
+	s.albumName = name
+}
+
+// Photo is a high-quality image found in an album
+type Photo struct {
+	URL         string       // Download URL of the best derivative
+	GUID        string       // iCloud asset GUID, shared by the same photo across albums
+	Taken       time.Time    // When the photo was created (zero if unknown)
+	Added       time.Time    // When the photo was added to the shared album (zero if unknown)
+	Caption     string       // Caption added in the shared album (may be empty)
+	Contributor string       // Name of the person who added the photo to the album (may be empty)
+	Derivatives []Derivative // Every size iCloud offers with a URL, smallest first
+}
+
+// Derivative is one of the sizes iCloud offers a photo in
+type Derivative struct {
+	Name   string // iCloud's key for the size, e.g. "original" or a pixel width
+	Width  int    // 0 if unknown
+	Height int    // 0 if unknown
+	URL    string
+}
+
+// longestSide returns the length of the derivative's longest side in pixels (0 if unknown)
+func (d Derivative) longestSide() int {
+	return max(d.Width, d.Height)
+}
+
+// SmallestAtLeast returns the smallest derivative, other than the one at URL, whose longest
+// side is at least size pixels and shorter than that of the one at URL, for destinations that
+// don't need the full image. Reports false if there is none
+func (p Photo) SmallestAtLeast(size int) (Derivative, bool) {
+	best := 0
+	for _, derivative := range p.Derivatives {
+		if derivative.URL == p.URL {
+			best = derivative.longestSide()
+		}
+	}
+	for _, derivative := range p.Derivatives {
+		longest := derivative.longestSide()
+		if derivative.URL == p.URL || longest < size || (best > 0 && longest >= best) {
+			continue
+		}
+		return derivative, true
+	}
+	return Derivative{}, false
+}
+
+// photoDerivatives returns the derivatives of a photo that have a URL, smallest first
+func photoDerivatives(photo icloudalbum.Image) []Derivative {
+	derivatives := make([]Derivative, 0, len(photo.Derivatives))
+	for name, derivative := range photo.Derivatives {
+		if derivative.URL == nil || *derivative.URL == "" {
+			continue
+		}
+		derivatives = append(derivatives, Derivative{
+			Name:   name,
+			Width:  derivative.Width,
+			Height: derivative.Height,
+			URL:    *derivative.URL,
+		})
+	}
+	sort.Slice(derivatives, func(i, j int) bool {
+		if derivatives[i].longestSide() != derivatives[j].longestSide() {
+			return derivatives[i].longestSide() < derivatives[j].longestSide()
+		}
+		return derivatives[i].Name < derivatives[j].Name
+	})
+	return derivatives
+}
+
+// contributorName returns the name of the person who added a photo, from the full name iCloud
+// reports or else its first and last names
+func contributorName(photo icloudalbum.Image) string {
+	if name := strings.TrimSpace(photo.ContributorFullName); name != "" {
+		return name
+	}
+	return strings.TrimSpace(photo.ContributorFirstName + " " + photo.ContributorLastName)
+}
+
+// checkToken verifies that a plausible album token was extracted from the URL
+func (s *Scraper) checkToken() error {
+	return checkToken(s.albumURL, s.token)
+}
+
+// checkToken verifies that token, extracted from albumURL, is a plausible album token
+func checkToken(albumURL, token string) error {
+	if token == "" {
+		return fmt.Errorf("%w: could not extract token from %s", ErrInvalidAlbumURL, albumURL)
+	}
+	// The iCloud library derives the server partition from the first characters of the token
+	if len(token) < 3 {
+		return fmt.Errorf("%w: token %q in %s is too short", ErrInvalidAlbumURL, token, albumURL)
+	}
+	return nil
+}
+
+// ValidateAlbumURL checks that albumURL is a well-formed iCloud shared album URL
+// (https://www.icloud.com/sharedalbum/#TOKEN) with a usable album token, without contacting iCloud
+func ValidateAlbumURL(albumURL string) error {
+	u, err := url.Parse(albumURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidAlbumURL, err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("%w: %s must start with https://", ErrInvalidAlbumURL, albumURL)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host != "icloud.com" && !strings.HasSuffix(host, ".icloud.com") {
+		return fmt.Errorf("%w: %s is not an icloud.com URL", ErrInvalidAlbumURL, albumURL)
+	}
+	if !strings.Contains(u.Path, "sharedalbum") {
+		return fmt.Errorf("%w: %s is not a shared album URL (expected https://www.icloud.com/sharedalbum/#TOKEN)", ErrInvalidAlbumURL, albumURL)
+	}
+	return checkToken(albumURL, extractTokenFromURL(albumURL))
+}
+
+// Validate checks that the album token was extracted and that the album is reachable
+// The iCloud library has no metadata-only call, so this performs a full (timeout-bounded) fetch
+// and also records the album name
+func (s *Scraper) Validate(ctx context.Context) error {
+	if err := s.checkToken(); err != nil {
+		return err
+	}
+
+	response, err := s.fetchImages(ctx)
+	if err != nil {
+		return fmt.Errorf("album %s is not reachable: %w", s.albumURL, err)
+	}
+	if response == nil {
+		return fmt.Errorf("album %s returned an empty response", s.albumURL)
+	}
+	s.setAlbumName(response.Metadata.StreamName)
+	return nil
+}
+
+// fetchImages calls the iCloud library, bounded by ctx and the configured timeout
+// The library has no context support, so the call runs in a goroutine; a hung call is
+// abandoned (and its goroutine exits whenever the library eventually returns)
+func (s *Scraper) fetchImages(ctx context.Context) (*icloudalbum.Response, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		response *icloudalbum.Response
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		response, err := s.getImages(s.token)
+		done <- result{response: response, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.response, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("gave up waiting for album %s: %w", s.albumURL, ctx.Err())
+	}
+}
+
+// fetchImagesWithRetry is like fetchImages but retries transient failures up to the configured
+// number of times, waiting longer before each retry, so a momentary iCloud outage doesn't skip
+// the album until the next run
+func (s *Scraper) fetchImagesWithRetry(ctx context.Context) (*icloudalbum.Response, error) {
+	delay := s.retryDelay
+	for attempt := 0; ; attempt++ {
+		response, err := s.fetchImages(ctx)
+		if err == nil || attempt >= s.retries || ctx.Err() != nil || !retryable(err) {
+			return response, err
+		}
+		logging.Warnf("Error fetching album %s (attempt %d of %d), retrying in %v: %v", s.albumURL, attempt+1, s.retries+1, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		}
+		delay *= 2
+	}
+}
+
+// retryable reports whether a failed fetch may succeed if tried again: network errors, a
+// fetch that timed out, and a response that isn't JSON at all, which is what iCloud sends
+// with a 5xx. The iCloud library doesn't expose status codes, so an album that is gone or
+// whose token was revoked is recognized by iCloud answering with JSON that isn't an album
+func retryable(err error) bool {
+	var urlErr *url.Error
+	var netErr net.Error
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.As(err, &urlErr), errors.As(err, &netErr):
+		return true
+	case errors.As(err, &syntaxErr):
+		return true
+	}
+	return false
+}
+
 // GetImageURLs extracts image URLs from the iCloud shared album using the API
 func (s *Scraper) GetImageURLs() ([]string, error) {
-	if s.token == "" {
-		return nil, fmt.Errorf("invalid album URL: could not extract token from %s", s.albumURL)
+	photos, err := s.GetPhotos()
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0, len(photos))
+	for _, photo := range photos {
+		urls = append(urls, photo.URL)
+	}
+	return urls, nil
+}
+
+// GetPhotos extracts the best image URL and asset GUID of each photo in the iCloud shared album
+func (s *Scraper) GetPhotos() ([]Photo, error) {
+	return s.GetPhotosContext(context.Background())
+}
+
+// GetPhotosContext is like GetPhotos but gives up when ctx is done or the configured timeout elapses
+// With a cache TTL, photos from a scrape within the TTL are returned without contacting iCloud
+func (s *Scraper) GetPhotosContext(ctx context.Context) ([]Photo, error) {
+	if err := s.checkToken(); err != nil {
+		return nil, err
+	}
+	if photos, age, ok := s.cachedPhotos(); ok {
+		logging.Infof("Using album %s as fetched %v ago (SCRAPE_CACHE_TTL)", s.AlbumName(), age.Round(time.Second))
+		return photos, nil
+	}
+
+	photos, err := s.scrapePhotos(ctx)
+	if err != nil {
+		return nil, err
 	}
+	if s.cacheTTL > 0 {
+		s.cacheMu.Lock()
+		s.cached = slices.Clone(photos)
+		s.cachedAt = time.Now()
+		s.cacheMu.Unlock()
+	}
+	return photos, nil
+}
+
+// cachedPhotos returns a copy of the cached photos and their age, if they're within the TTL
+func (s *Scraper) cachedPhotos() ([]Photo, time.Duration, bool) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if s.cachedAt.IsZero() {
+		return nil, 0, false
+	}
+	age := time.Since(s.cachedAt)
+	if age >= s.cacheTTL {
+		return nil, 0, false
+	}
+	return slices.Clone(s.cached), age, true
+}
+
+// Invalidate drops the cached photos, so the next scrape fetches the album from iCloud
+// (e.g. because a cached download URL has expired)
+func (s *Scraper) Invalidate() {
+	s.cacheMu.Lock()
+	s.cached, s.cachedAt = nil, time.Time{}
+	s.cacheMu.Unlock()
+}
+
+// scrapePhotos fetches the album from iCloud and picks each photo's best image URL
+func (s *Scraper) scrapePhotos(ctx context.Context) ([]Photo, error) {
 
 	// Use the iCloud shared album library to get images
-	response, err := s.client.GetImages(s.token)
+	response, err := s.fetchImagesWithRetry(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get images from iCloud API: %w", err)
 	}
+	s.setAlbumName(response.Metadata.StreamName)
 
-	var urls []string
+	var photos []Photo
 	skippedCount := 0
 	for i, photo := range response.Photos {
 		// Log available derivatives for debugging
@@ -65,18 +434,18 @@ func (s *Scraper) GetImageURLs() ([]string, error) {
 			availableDerivatives = append(availableDerivatives, name)
 		}
 		if len(availableDerivatives) > 0 {
-			log.Printf("Photo %d has derivatives: %v", i+1, availableDerivatives)
+			logging.Debugf("Photo %d has derivatives: %v", i+1, availableDerivatives)
 		} else {
-			log.Printf("Photo %d has no derivatives", i+1)
+			logging.Debugf("Photo %d has no derivatives", i+1)
 		}
-		
+
 		// Get the highest quality derivative available
 		// Priority: named "original" > named "medium" > highest numeric key (width) > other named keys
 		// Skip "thumbnail" and small numeric keys (< 1000 pixels) - not high quality enough
 		var bestURL *string
 		var qualityUsed string
 		var bestWidth int
-		
+
 		// Helper function to find derivative by name (case-insensitive)
 		findDerivative := func(name string) (*icloudalbum.Derivative, bool) {
 			// Try exact match first
@@ -91,17 +460,17 @@ func (s *Scraper) GetImageURLs() ([]string, error) {
 			}
 			return nil, false
 		}
-		
+
 		// Try named "original" first (highest quality)
 		if derivative, ok := findDerivative("original"); ok && derivative.URL != nil {
 			bestURL = derivative.URL
 			qualityUsed = "original"
-			log.Printf("Photo %d: Using 'original' quality", i+1)
+			logging.Debugf("Photo %d: Using 'original' quality", i+1)
 		} else if derivative, ok := findDerivative("medium"); ok && derivative.URL != nil {
 			// Fall back to named "medium" if original not available
 			bestURL = derivative.URL
 			qualityUsed = "medium"
-			log.Printf("Photo %d: Using 'medium' quality (original not available)", i+1)
+			logging.Debugf("Photo %d: Using 'medium' quality (original not available)", i+1)
 		} else {
 			// No named derivatives found, look for numeric keys (pixel widths)
 			// Find the highest numeric key (largest width = highest quality)
@@ -110,7 +479,7 @@ func (s *Scraper) GetImageURLs() ([]string, error) {
 				if strings.EqualFold(key, "thumbnail") {
 					continue
 				}
-				
+
 				// Try to parse as numeric (pixel width)
 				if width, err := strconv.Atoi(key); err == nil {
 					// Only consider high-quality derivatives (>= 1000 pixels wide)
@@ -140,12 +509,12 @@ func (s *Scraper) GetImageURLs() ([]string, error) {
 					}
 				}
 			}
-			
+
 			if bestURL != nil {
-				log.Printf("Photo %d: Using numeric derivative with quality '%s'", i+1, qualityUsed)
+				logging.Debugf("Photo %d: Using numeric derivative with quality '%s'", i+1, qualityUsed)
 			}
 		}
-		
+
 		// Skip if no high-quality derivative found
 		if bestURL == nil {
 			// Check if only thumbnail or small derivatives are available
@@ -165,26 +534,32 @@ func (s *Scraper) GetImageURLs() ([]string, error) {
 					break
 				}
 			}
-			
+
 			if hasOnlySmall {
-				log.Printf("Photo %d: Skipping - only thumbnail or small derivatives available (< 1000px). Available: %v", i+1, availableDerivatives)
+				logging.Debugf("Photo %d: Skipping - only thumbnail or small derivatives available (< 1000px). Available: %v", i+1, availableDerivatives)
 			} else {
-				log.Printf("Photo %d: Skipping - no usable derivative found. Available: %v", i+1, availableDerivatives)
+				logging.Debugf("Photo %d: Skipping - no usable derivative found. Available: %v", i+1, availableDerivatives)
 			}
 			skippedCount++
 			continue
 		}
-		
-		urls = append(urls, *bestURL)
-		log.Printf("Photo %d: Added URL with quality '%s'", i+1, qualityUsed)
+
+		photos = append(photos, Photo{
+			URL:         *bestURL,
+			GUID:        photo.PhotoGUID,
+			Taken:       photo.DateCreated,
+			Added:       photo.BatchDateCreated,
+			Caption:     strings.TrimSpace(photo.Caption),
+			Contributor: contributorName(photo),
+			Derivatives: photoDerivatives(photo),
+		})
+		logging.Debugf("Photo %d: Added URL with quality '%s'", i+1, qualityUsed)
 	}
-	
+
 	if skippedCount > 0 {
-		log.Printf("Skipped %d photos due to insufficient quality (only thumbnail or no original/medium available)", skippedCount)
+		logging.Warnf("Skipped %d photos due to insufficient quality (only thumbnail or no original/medium available)", skippedCount)
 	}
-	log.Printf("Total photos processed: %d, URLs extracted: %d", len(response.Photos), len(urls))
+	logging.Infof("Total photos processed: %d, URLs extracted: %d", len(response.Photos), len(photos))
 
-	return urls, nil
+	return photos, nil
 }
-
-
@@ -1,28 +1,40 @@
 package scraper
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
 )
 
 func TestExtractTokenFromURL(t *testing.T) {
 	tests := []struct {
-		name     string
-		url      string
+		name      string
+		url       string
 		wantToken string
 	}{
 		{
-			name:     "standard URL",
-			url:      "https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN",
+			name:      "standard URL",
+			url:       "https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN",
 			wantToken: "EXAMPLE_TOKEN",
 		},
 		{
-			name:     "URL with semicolon",
-			url:      "https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN;param",
+			name:      "URL with semicolon",
+			url:       "https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN;param",
 			wantToken: "EXAMPLE_TOKEN",
 		},
 		{
-			name:     "URL without hash",
-			url:      "https://www.icloud.com/sharedalbum/",
+			name:      "URL without hash",
+			url:       "https://www.icloud.com/sharedalbum/",
 			wantToken: "",
 		},
 	}
@@ -37,6 +49,34 @@ func TestExtractTokenFromURL(t *testing.T) {
 	}
 }
 
+func TestValidateAlbumURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{name: "standard URL", url: "https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN"},
+		{name: "localized URL", url: "https://www.icloud.com/sharedalbum/en-us/#EXAMPLE_TOKEN"},
+		{name: "missing token", url: "https://www.icloud.com/sharedalbum/", wantErr: true},
+		{name: "short token", url: "https://www.icloud.com/sharedalbum/#AB", wantErr: true},
+		{name: "other host", url: "https://example.com/sharedalbum/#EXAMPLE_TOKEN", wantErr: true},
+		{name: "not a shared album", url: "https://www.icloud.com/photos/#EXAMPLE_TOKEN", wantErr: true},
+		{name: "no scheme", url: "www.icloud.com/sharedalbum/#EXAMPLE_TOKEN", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAlbumURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateAlbumURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidAlbumURL) {
+				t.Errorf("ValidateAlbumURL(%q) error = %v, want ErrInvalidAlbumURL", tt.url, err)
+			}
+		})
+	}
+}
+
 func TestScraper_GetImageURLs_InvalidToken(t *testing.T) {
 	// Test with invalid URL (no token)
 	scraper := NewScraper("https://www.icloud.com/sharedalbum/")
@@ -46,12 +86,336 @@ func TestScraper_GetImageURLs_InvalidToken(t *testing.T) {
 	}
 }
 
+func TestScraper_GetPhotos_Timeout(t *testing.T) {
+	scraper := NewScraperWithOptions("https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN", Options{Timeout: 50 * time.Millisecond})
+	release := make(chan struct{})
+	defer close(release)
+	scraper.getImages = func(token string) (*icloudalbum.Response, error) {
+		<-release // Simulate a hung iCloud request
+		return &icloudalbum.Response{}, nil
+	}
+
+	start := time.Now()
+	_, err := scraper.GetPhotos()
+	if err == nil {
+		t.Fatal("GetPhotos() expected timeout error")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetPhotos() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetPhotos() took %v, expected to give up after the timeout", elapsed)
+	}
+}
+
+func TestScraper_GetPhotos_Retry(t *testing.T) {
+	// A 5xx from iCloud comes back from the library as a body that isn't JSON
+	var serverErr error
+	if err := json.Unmarshal([]byte("<html>503 Service Unavailable</html>"), &struct{}{}); err != nil {
+		serverErr = fmt.Errorf("getting API response: unmarshaling response: %w", err)
+	}
+	networkErr := fmt.Errorf("getting API response: HTTP request failed: %w",
+		&url.Error{Op: "Post", URL: "https://p01-sharedstreams.icloud.com", Err: errors.New("connection reset by peer")})
+	var albumGoneErr error
+	if err := json.Unmarshal([]byte(`{"photos": "gone"}`), &struct{ Photos []string }{}); err != nil {
+		albumGoneErr = fmt.Errorf("getting API response: unmarshaling response: %w", err)
+	}
+
+	tests := []struct {
+		name      string
+		retries   int
+		failures  []error // Returned by the first attempts
+		wantErr   bool
+		wantCalls int
+	}{
+		{name: "server error then success", retries: 2, failures: []error{serverErr}, wantCalls: 2},
+		{name: "network errors then success", retries: 2, failures: []error{networkErr, networkErr}, wantCalls: 3},
+		{name: "retries exhausted", retries: 2, failures: []error{serverErr, serverErr, serverErr}, wantErr: true, wantCalls: 3},
+		{name: "retries disabled", retries: 0, failures: []error{serverErr}, wantErr: true, wantCalls: 1},
+		{name: "album gone is not retried", retries: 2, failures: []error{albumGoneErr}, wantErr: true, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scraper := NewScraperWithOptions("https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN", Options{Retries: tt.retries})
+			scraper.retryDelay = time.Millisecond
+			calls := 0
+			scraper.getImages = func(token string) (*icloudalbum.Response, error) {
+				calls++
+				if calls <= len(tt.failures) {
+					return nil, tt.failures[calls-1]
+				}
+				return &icloudalbum.Response{Metadata: icloudalbum.Metadata{StreamName: "Family"}}, nil
+			}
+
+			_, err := scraper.GetPhotos()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetPhotos() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("getImages called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestScraper_GetPhotos_RetryCanceled(t *testing.T) {
+	scraper := NewScraperWithOptions("https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN", Options{Retries: 3})
+	scraper.retryDelay = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	scraper.getImages = func(token string) (*icloudalbum.Response, error) {
+		calls++
+		cancel() // The run stops while waiting to retry
+		return nil, &url.Error{Op: "Post", URL: "https://p01-sharedstreams.icloud.com", Err: errors.New("i/o timeout")}
+	}
+
+	start := time.Now()
+	if _, err := scraper.GetPhotosContext(ctx); err == nil {
+		t.Fatal("GetPhotosContext() expected error")
+	}
+	if calls != 1 {
+		t.Errorf("getImages called %d times, want 1", calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetPhotosContext() took %v, expected to stop waiting once canceled", elapsed)
+	}
+}
+
+func TestScraper_GetPhotos_Cache(t *testing.T) {
+	scraper := NewScraperWithOptions("https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN", Options{CacheTTL: time.Hour})
+	calls := 0
+	scraper.getImages = func(token string) (*icloudalbum.Response, error) {
+		calls++
+		url := fmt.Sprintf("https://example.com/photo%d.jpg", calls)
+		return &icloudalbum.Response{
+			Photos: []icloudalbum.Image{{
+				PhotoGUID:   "guid-1",
+				Derivatives: map[string]icloudalbum.Derivative{"original": {URL: &url}},
+			}},
+		}, nil
+	}
+
+	first, err := scraper.GetPhotos()
+	if err != nil {
+		t.Fatalf("GetPhotos() error = %v", err)
+	}
+	first[0].URL = "modified by the caller"
+	second, err := scraper.GetPhotos()
+	if err != nil {
+		t.Fatalf("GetPhotos() error = %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("getImages called %d times within the TTL, want 1", calls)
+	}
+	if len(second) != 1 || second[0].URL != "https://example.com/photo1.jpg" {
+		t.Errorf("GetPhotos() = %+v, want the cached photo unchanged", second)
+	}
+
+	scraper.Invalidate()
+	third, err := scraper.GetPhotos()
+	if err != nil {
+		t.Fatalf("GetPhotos() error = %v", err)
+	}
+	if calls != 2 || len(third) != 1 || third[0].URL != "https://example.com/photo2.jpg" {
+		t.Errorf("GetPhotos() after Invalidate() = %+v (%d calls), want a fresh fetch", third, calls)
+	}
+
+	// Once the TTL has passed the album is fetched again
+	scraper.cacheMu.Lock()
+	scraper.cachedAt = time.Now().Add(-2 * time.Hour)
+	scraper.cacheMu.Unlock()
+	if _, err := scraper.GetPhotos(); err != nil {
+		t.Fatalf("GetPhotos() error = %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("getImages called %d times after the TTL, want 3", calls)
+	}
+
+	// Without a TTL every call fetches
+	uncached := NewScraper("https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN")
+	uncachedCalls := 0
+	uncached.getImages = func(token string) (*icloudalbum.Response, error) {
+		uncachedCalls++
+		return &icloudalbum.Response{}, nil
+	}
+	uncached.GetPhotos()
+	uncached.GetPhotos()
+	if uncachedCalls != 2 {
+		t.Errorf("getImages called %d times without a TTL, want 2", uncachedCalls)
+	}
+}
+
+func TestScraper_GetPhotos_AlbumName(t *testing.T) {
+	scraper := NewScraper("https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN")
+	if scraper.AlbumName() != "EXAMPLE_TOKEN" {
+		t.Errorf("AlbumName() before scrape = %v, want EXAMPLE_TOKEN", scraper.AlbumName())
+	}
+
+	url := "https://example.com/photo.jpg"
+	taken := time.Date(2024, 6, 15, 10, 30, 0, 0, time.UTC)
+	added := time.Date(2024, 6, 16, 8, 0, 0, 0, time.UTC)
+	scraper.getImages = func(token string) (*icloudalbum.Response, error) {
+		return &icloudalbum.Response{
+			Metadata: icloudalbum.Metadata{StreamName: "Family"},
+			Photos: []icloudalbum.Image{
+				{
+					PhotoGUID:        "guid-1",
+					DateCreated:      taken,
+					BatchDateCreated: added,
+					Caption:          " Beach day ",
+					Derivatives:      map[string]icloudalbum.Derivative{"original": {URL: &url}},
+					// Without a full name the first and last names are used
+					ContributorFirstName: "Grandma",
+					ContributorLastName:  "Jones",
+				},
+			},
+		}, nil
+	}
+
+	photos, err := scraper.GetPhotos()
+	if err != nil {
+		t.Fatalf("GetPhotos() error = %v", err)
+	}
+	if len(photos) != 1 || photos[0].URL != url || photos[0].GUID != "guid-1" {
+		t.Fatalf("GetPhotos() = %+v, want one photo with URL %s and GUID guid-1", photos, url)
+	}
+	if !photos[0].Taken.Equal(taken) || photos[0].Caption != "Beach day" {
+		t.Errorf("GetPhotos() metadata = (%v, %q), want (%v, \"Beach day\")", photos[0].Taken, photos[0].Caption, taken)
+	}
+	if !photos[0].Added.Equal(added) {
+		t.Errorf("GetPhotos() added = %v, want %v", photos[0].Added, added)
+	}
+	if photos[0].Contributor != "Grandma Jones" {
+		t.Errorf("GetPhotos() contributor = %q, want \"Grandma Jones\"", photos[0].Contributor)
+	}
+	if scraper.AlbumName() != "Family" {
+		t.Errorf("AlbumName() = %v, want Family", scraper.AlbumName())
+	}
+}
+
+// The pipeline reads the album name from its workers while expired URLs are refreshed by
+// scraping the album again; run with -race
+func TestScraper_AlbumName_ConcurrentRefresh(t *testing.T) {
+	scraper := NewScraper("https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN")
+	scraper.getImages = func(token string) (*icloudalbum.Response, error) {
+		return &icloudalbum.Response{Metadata: icloudalbum.Metadata{StreamName: "Family"}}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := scraper.GetPhotos(); err != nil {
+				t.Errorf("GetPhotos() error = %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if name := scraper.AlbumName(); name != "EXAMPLE_TOKEN" && name != "Family" {
+				t.Errorf("AlbumName() = %q, want the token or Family", name)
+			}
+		}()
+	}
+	wg.Wait()
+	if scraper.AlbumName() != "Family" {
+		t.Errorf("AlbumName() = %v, want Family", scraper.AlbumName())
+	}
+}
+
+func TestScraper_GetPhotos_Derivatives(t *testing.T) {
+	original, medium, thumbnail := "https://example.com/original.jpg", "https://example.com/medium.jpg", "https://example.com/thumb.jpg"
+	scraper := NewScraper("https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN")
+	scraper.getImages = func(token string) (*icloudalbum.Response, error) {
+		return &icloudalbum.Response{
+			Photos: []icloudalbum.Image{
+				{
+					PhotoGUID: "guid-1",
+					Derivatives: map[string]icloudalbum.Derivative{
+						"original":  {URL: &original, Width: 4032, Height: 3024},
+						"medium":    {URL: &medium, Width: 1600, Height: 1200},
+						"thumbnail": {URL: &thumbnail, Width: 342, Height: 256},
+						"2048":      {Width: 2048, Height: 1536}, // No URL
+					},
+				},
+			},
+		}, nil
+	}
+
+	photos, err := scraper.GetPhotos()
+	if err != nil {
+		t.Fatalf("GetPhotos() error = %v", err)
+	}
+	if len(photos) != 1 || photos[0].URL != original {
+		t.Fatalf("GetPhotos() = %+v, want one photo with URL %s", photos, original)
+	}
+	var names []string
+	for _, derivative := range photos[0].Derivatives {
+		names = append(names, derivative.Name)
+	}
+	if strings.Join(names, ",") != "thumbnail,medium,original" {
+		t.Errorf("Derivatives = %v, want thumbnail, medium, original", names)
+	}
+
+	tests := []struct {
+		size    int
+		wantURL string
+	}{
+		{size: 200, wantURL: thumbnail},
+		{size: 1280, wantURL: medium},
+		{size: 1600, wantURL: medium},
+		{size: 2000, wantURL: ""}, // Only the original is large enough
+	}
+	for _, tt := range tests {
+		derivative, ok := photos[0].SmallestAtLeast(tt.size)
+		if ok != (tt.wantURL != "") || derivative.URL != tt.wantURL {
+			t.Errorf("SmallestAtLeast(%d) = %v, %v, want %q", tt.size, derivative.URL, ok, tt.wantURL)
+		}
+	}
+}
+
+func TestScraper_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		fetchErr    error
+		wantErr     bool
+		wantInvalid bool
+	}{
+		{name: "valid album", url: "https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN"},
+		{name: "missing token", url: "https://www.icloud.com/sharedalbum/", wantErr: true, wantInvalid: true},
+		{name: "token too short", url: "https://www.icloud.com/sharedalbum/#AB", wantErr: true, wantInvalid: true},
+		{name: "unreachable album", url: "https://www.icloud.com/sharedalbum/#EXAMPLE_TOKEN", fetchErr: errors.New("404"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scraper := NewScraper(tt.url)
+			scraper.getImages = func(token string) (*icloudalbum.Response, error) {
+				if tt.fetchErr != nil {
+					return nil, tt.fetchErr
+				}
+				return &icloudalbum.Response{Metadata: icloudalbum.Metadata{StreamName: "Family"}}, nil
+			}
+
+			err := scraper.Validate(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrInvalidAlbumURL) != tt.wantInvalid {
+				t.Errorf("Validate() error = %v, want ErrInvalidAlbumURL = %v", err, tt.wantInvalid)
+			}
+		})
+	}
+}
+
 // Note: Testing GetImageURLs with a real token would require network access
 // and a valid iCloud shared album. These integration tests are skipped
 // in unit test runs but can be enabled for manual testing.
 func TestScraper_GetImageURLs_Integration(t *testing.T) {
 	t.Skip("Integration test - requires valid iCloud shared album token")
-	
+
 	// Uncomment and provide a valid token for integration testing:
 	// scraper := NewScraper("https://www.icloud.com/sharedalbum/#YOUR_TOKEN_HERE")
 	// urls, err := scraper.GetImageURLs()
@@ -63,3 +427,89 @@ func TestScraper_GetImageURLs_Integration(t *testing.T) {
 	// }
 }
 
+func TestWebClient_Header(t *testing.T) {
+	header := make(http.Header)
+	header.Set("Cookie", "X-APPLE-WEBAUTH-TOKEN=secret")
+	header.Set("User-Agent", "custom-agent")
+	client := newWebClient(header)
+	if client.httpClient.CheckRedirect == nil {
+		t.Error("CheckRedirect is not set, so the partition redirect would be followed")
+	}
+	transport, ok := client.httpClient.Transport.(*headerTransport)
+	if !ok {
+		t.Fatalf("Transport = %T, want *headerTransport", client.httpClient.Transport)
+	}
+
+	// iCloud moves the album to another host (330) before listing it
+	var got []*http.Request
+	transport.base = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		got = append(got, req)
+		body := "{}"
+		status := http.StatusOK
+		switch {
+		case req.URL.Host == "p42-sharedstreams.icloud.com" && strings.HasSuffix(req.URL.Path, "/webstream"):
+			status, body = 330, `{"X-Apple-MMe-Host": "p99-sharedstreams.icloud.com"}`
+		case strings.HasSuffix(req.URL.Path, "/webstream"):
+			body = `{"streamName": "Family", "photos": [{"photoGuid": "GUID1", "caption": "Beach", "width": "4032", "height": "3024",
+				"derivatives": {"original": {"checksum": "abc", "width": "4032", "height": "3024", "fileSize": "1234"}}}]}`
+		case strings.HasSuffix(req.URL.Path, "/webasseturls"):
+			body = `{"items": {"abc": {"url_location": "cvws.icloud-content.com", "url_path": "/photo.jpg"}}}`
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+	})
+
+	response, err := client.GetImages("B0gEXAMPLE_TOKEN")
+	if err != nil {
+		t.Fatalf("GetImages() error = %v", err)
+	}
+	if response.Metadata.StreamName != "Family" || len(response.Photos) != 1 {
+		t.Fatalf("GetImages() = %+v, want the Family album with one photo", response)
+	}
+	photo := response.Photos[0]
+	original := photo.Derivatives["original"]
+	if photo.PhotoGUID != "GUID1" || photo.Width != 4032 || original.URL == nil || *original.URL != "https://cvws.icloud-content.com/photo.jpg" {
+		t.Errorf("photo = %+v (original %+v), want GUID1 with its download URL", photo, original)
+	}
+	if len(got) != 4 || got[3].URL.Host != "p99-sharedstreams.icloud.com" {
+		t.Fatalf("Got %d requests, want the URLs requested from the host iCloud moved the album to", len(got))
+	}
+	for _, req := range got {
+		if req.Header.Get("Cookie") != "X-APPLE-WEBAUTH-TOKEN=secret" || req.Header.Get("User-Agent") != "custom-agent" {
+			t.Errorf("iCloud request headers = %v, want the configured Cookie and User-Agent", req.Header)
+		}
+	}
+
+	// Requests to other hosts are passed on unchanged
+	got = nil
+	req, err := http.NewRequest(http.MethodGet, "https://example.com/elsewhere", nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	req.Header.Set("User-Agent", "library-agent")
+	if _, err := client.httpClient.Do(req); err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Header.Get("Cookie") != "" || got[0].Header.Get("User-Agent") != "library-agent" {
+		t.Errorf("Request to another host has headers %v, want them unchanged", got[0].Header)
+	}
+}
+
+func TestWebBaseURL(t *testing.T) {
+	tests := map[string]string{
+		"A0z5qAGN1JIFd3y":   "https://p00-sharedstreams.icloud.com/A0z5qAGN1JIFd3y/sharedstreams",
+		"B0gEXAMPLE_TOKEN":  "https://p42-sharedstreams.icloud.com/B0gEXAMPLE_TOKEN/sharedstreams",
+		"B0gEXAMPLE;suffix": "https://p42-sharedstreams.icloud.com/B0gEXAMPLE/sharedstreams",
+	}
+	for token, want := range tests {
+		if got := webBaseURL(token); got != want {
+			t.Errorf("webBaseURL(%q) = %q, want %q", token, got, want)
+		}
+	}
+}
+
+// roundTripFunc serves a client's requests without a network
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,262 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	icloudalbum "github.com/Shogoki/icloud-shared-album-go"
+)
+
+// webAssetChunkSize is the number of photos whose URLs are requested at once, as the shared
+// album web page does
+const webAssetChunkSize = 25
+
+// webHeaders are sent with the shared album API requests, like the library's defaults. Header
+// in Options replaces any of the same name
+var webHeaders = map[string]string{
+	"Origin":          "https://www.icloud.com",
+	"Accept-Language": "en-US,en;q=0.8",
+	"User-Agent":      "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_4) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/56.0.2924.87 Safari/537.36",
+	"Content-Type":    "text/plain",
+	"Accept":          "*/*",
+	"Referer":         "https://www.icloud.com/sharedalbum/",
+}
+
+// webClient fetches shared albums from the same web API as the iCloud library, through an HTTP
+// client of our own. The library doesn't let its client be configured, so albums that need
+// extra headers (Options.Header) are fetched with this instead
+type webClient struct {
+	httpClient *http.Client
+}
+
+// newWebClient creates a client that sends header with every request to iCloud
+func newWebClient(header http.Header) *webClient {
+	return &webClient{httpClient: &http.Client{
+		Transport: &headerTransport{header: header.Clone()},
+		// Redirects are read to find the album's partition, not followed
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// webStream is the album listing returned by the webstream endpoint
+type webStream struct {
+	StreamName    string           `json:"streamName"`
+	UserFirstName string           `json:"userFirstName"`
+	UserLastName  string           `json:"userLastName"`
+	StreamCtag    string           `json:"streamCtag"`
+	ItemsReturned string           `json:"itemsReturned"`
+	Locations     interface{}      `json:"locations"`
+	Photos        []webStreamPhoto `json:"photos"`
+}
+
+// webStreamPhoto is one photo in the album listing
+type webStreamPhoto struct {
+	BatchGUID            string                         `json:"batchGuid"`
+	Derivatives          map[string]webStreamDerivative `json:"derivatives"`
+	ContributorLastName  string                         `json:"contributorLastName"`
+	BatchDateCreated     string                         `json:"batchDateCreated"`
+	DateCreated          string                         `json:"dateCreated"`
+	ContributorFirstName string                         `json:"contributorFirstName"`
+	PhotoGUID            string                         `json:"photoGuid"`
+	ContributorFullName  string                         `json:"contributorFullName"`
+	Caption              string                         `json:"caption"`
+	Height               string                         `json:"height"`
+	Width                string                         `json:"width"`
+	MediaAssetType       *string                        `json:"mediaAssetType,omitempty"`
+}
+
+// webStreamDerivative is one size of a photo in the album listing
+type webStreamDerivative struct {
+	Checksum string `json:"checksum"`
+	FileSize string `json:"fileSize"`
+	Width    string `json:"width"`
+	Height   string `json:"height"`
+}
+
+// webAssetURLs maps derivative checksums to their download locations
+type webAssetURLs struct {
+	Items map[string]struct {
+		URLLocation string `json:"url_location"`
+		URLPath     string `json:"url_path"`
+	} `json:"items"`
+}
+
+// GetImages fetches the album with the given token, in the library's response format
+func (c *webClient) GetImages(token string) (*icloudalbum.Response, error) {
+	baseURL, err := c.redirectedBaseURL(webBaseURL(token))
+	if err != nil {
+		return nil, fmt.Errorf("getting redirected base URL: %w", err)
+	}
+
+	var stream webStream
+	baseURL, err = c.post(baseURL, "webstream", map[string]interface{}{"streamCtag": nil}, &stream)
+	if err != nil {
+		return nil, fmt.Errorf("getting API response: %w", err)
+	}
+
+	urls := make(map[string]string)
+	for start := 0; start < len(stream.Photos); start += webAssetChunkSize {
+		var guids []string
+		for _, photo := range stream.Photos[start:min(start+webAssetChunkSize, len(stream.Photos))] {
+			guids = append(guids, photo.PhotoGUID)
+		}
+		var assets webAssetURLs
+		if baseURL, err = c.post(baseURL, "webasseturls", map[string]interface{}{"photoGuids": guids}, &assets); err != nil {
+			return nil, fmt.Errorf("getting URLs for chunk: %w", err)
+		}
+		for checksum, item := range assets.Items {
+			urls[checksum] = "https://" + item.URLLocation + item.URLPath
+		}
+	}
+
+	itemsReturned, _ := strconv.Atoi(stream.ItemsReturned)
+	response := &icloudalbum.Response{
+		Metadata: icloudalbum.Metadata{
+			StreamName:    stream.StreamName,
+			UserFirstName: stream.UserFirstName,
+			UserLastName:  stream.UserLastName,
+			StreamCtag:    stream.StreamCtag,
+			ItemsReturned: itemsReturned,
+			Locations:     stream.Locations,
+		},
+	}
+	for _, photo := range stream.Photos {
+		response.Photos = append(response.Photos, photo.image(urls))
+	}
+	return response, nil
+}
+
+// image converts a listed photo to the library's format, with the URLs of its derivatives
+func (p webStreamPhoto) image(urls map[string]string) icloudalbum.Image {
+	derivatives := make(map[string]icloudalbum.Derivative, len(p.Derivatives))
+	for key, d := range p.Derivatives {
+		derivative := icloudalbum.Derivative{Checksum: d.Checksum}
+		derivative.FileSize, _ = strconv.ParseInt(d.FileSize, 10, 64)
+		derivative.Width, _ = strconv.Atoi(d.Width)
+		derivative.Height, _ = strconv.Atoi(d.Height)
+		if url, ok := urls[d.Checksum]; ok {
+			derivative.URL = &url
+		}
+		derivatives[key] = derivative
+	}
+	image := icloudalbum.Image{
+		BatchGUID:            p.BatchGUID,
+		Derivatives:          derivatives,
+		ContributorLastName:  p.ContributorLastName,
+		BatchDateCreated:     parseWebDate(p.BatchDateCreated),
+		DateCreated:          parseWebDate(p.DateCreated),
+		ContributorFirstName: p.ContributorFirstName,
+		PhotoGUID:            p.PhotoGUID,
+		ContributorFullName:  p.ContributorFullName,
+		Caption:              p.Caption,
+		MediaAssetType:       p.MediaAssetType,
+	}
+	image.Height, _ = strconv.Atoi(p.Height)
+	image.Width, _ = strconv.Atoi(p.Width)
+	return image
+}
+
+// parseWebDate parses a date from the album listing, or returns the zero time
+func parseWebDate(date string) time.Time {
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// webBaseURL returns the shared streams URL of the partition the album token names
+func webBaseURL(token string) string {
+	if i := strings.Index(token, ";"); i >= 0 {
+		token = token[:i]
+	}
+	var partition int
+	if len(token) > 1 && token[0] == 'A' {
+		partition = base62(token[1:2])
+	} else if len(token) > 2 {
+		partition = base62(token[1:3])
+	}
+	return fmt.Sprintf("https://p%02d-sharedstreams.icloud.com/%s/sharedstreams", partition, token)
+}
+
+// base62 decodes a number in the base 62 alphabet album tokens use
+func base62(s string) int {
+	const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	n := 0
+	for i := 0; i < len(s); i++ {
+		n = n*62 + strings.IndexByte(alphabet, s[i])
+	}
+	return n
+}
+
+// redirectedBaseURL returns the base URL iCloud redirects the album's partition to, if any
+func (c *webClient) redirectedBaseURL(baseURL string) (string, error) {
+	resp, err := c.httpClient.Get(baseURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPermanentRedirect || resp.StatusCode == http.StatusTemporaryRedirect {
+		if location := resp.Header.Get("Location"); location != "" {
+			return strings.TrimSuffix(location, "/"), nil
+		}
+	}
+	return baseURL, nil
+}
+
+// post sends payload to an endpoint under baseURL and decodes the response into out. iCloud
+// answers 330 with the host actually serving the album; the request is repeated there, and the
+// base URL it succeeded at is returned for the following requests
+func (c *webClient) post(baseURL string, endpoint string, payload interface{}, out interface{}) (string, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("marshaling payload: %w", err)
+	}
+	for redirects := 0; redirects <= 2; redirects++ {
+		req, err := http.NewRequest(http.MethodPost, baseURL+"/"+endpoint, bytes.NewReader(body))
+		if err != nil {
+			return "", fmt.Errorf("creating request: %w", err)
+		}
+		for name, value := range webHeaders {
+			req.Header.Set(name, value)
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("HTTP request failed: %w", err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("reading response body: %w", err)
+		}
+
+		if resp.StatusCode == 330 {
+			var redirect struct {
+				Host string `json:"X-Apple-MMe-Host"`
+			}
+			if err := json.Unmarshal(data, &redirect); err != nil || redirect.Host == "" {
+				return "", fmt.Errorf("redirect response missing X-Apple-MMe-Host")
+			}
+			parts := strings.Split(baseURL, "/")
+			if len(parts) < 4 {
+				return "", fmt.Errorf("invalid baseURL format")
+			}
+			baseURL = fmt.Sprintf("https://%s/%s/sharedstreams", redirect.Host, parts[3])
+			continue
+		}
+		// Like the library, other statuses are recognized by their body (see retryable)
+		if err := json.Unmarshal(data, out); err != nil {
+			return "", fmt.Errorf("unmarshaling response: %w", err)
+		}
+		return baseURL, nil
+	}
+	return "", fmt.Errorf("too many redirects")
+}
@@ -0,0 +1,165 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+	"github.com/jsteffee/icloud-photo-sync/pkg/ratelimit"
+)
+
+// DefaultAPIURL is the Slack Web API base URL
+const DefaultAPIURL = "https://slack.com/api"
+
+// Client posts photos to a Slack channel through the Web API. Incoming webhooks can't carry
+// files, so a bot token is required
+type Client struct {
+	config     *config.SlackConfig
+	apiURL     string
+	httpClient *http.Client
+}
+
+// NewClient creates a new Slack client
+func NewClient(cfg *config.SlackConfig) (*Client, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("Slack config is required")
+	}
+	if cfg.BotToken == "" || cfg.ChannelID == "" {
+		return nil, fmt.Errorf("Slack bot token and channel ID are required")
+	}
+
+	return &Client{
+		config: cfg,
+		apiURL: DefaultAPIURL,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Minute, // Large files can take a while
+		},
+	}, nil
+}
+
+// apiResponse holds the fields of Web API responses used here
+type apiResponse struct {
+	OK        bool   `json:"ok"`
+	Error     string `json:"error"`
+	UploadURL string `json:"upload_url"`
+	FileID    string `json:"file_id"`
+}
+
+// PostPhoto uploads the file at filePath to the channel with an optional caption as the
+// message text: it reserves an upload URL, uploads the file, then shares it in the channel
+func (c *Client) PostPhoto(filePath string, caption string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	fileName := filepath.Base(filePath)
+	reserved, err := c.call("files.getUploadURLExternal", "application/x-www-form-urlencoded", func() io.Reader {
+		return strings.NewReader(url.Values{
+			"filename": {fileName},
+			"length":   {strconv.FormatInt(info.Size(), 10)},
+		}.Encode())
+	})
+	if err != nil {
+		return err
+	}
+
+	// The upload URL is pre-authorized; it takes the raw file contents
+	req, err := http.NewRequest(http.MethodPost, reserved.UploadURL, file)
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %w", err)
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload file to Slack: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Slack file upload failed with status %d", resp.StatusCode)
+	}
+
+	type sharedFile struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+	}
+	complete, err := json.Marshal(struct {
+		Files          []sharedFile `json:"files"`
+		ChannelID      string       `json:"channel_id"`
+		InitialComment string       `json:"initial_comment,omitempty"`
+	}{
+		Files:          []sharedFile{{ID: reserved.FileID, Title: fileName}},
+		ChannelID:      c.config.ChannelID,
+		InitialComment: caption,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode upload completion: %w", err)
+	}
+	_, err = c.call("files.completeUploadExternal", "application/json; charset=utf-8", func() io.Reader {
+		return bytes.NewReader(complete)
+	})
+	return err
+}
+
+// call makes a Web API call, retrying once after the wait Slack asks for if rate limited.
+// body is called for each attempt. A response that isn't ok is an error
+func (c *Client) call(method string, contentType string, body func() io.Reader) (*apiResponse, error) {
+	resp, err := c.post(method, contentType, body())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		resp.Body.Close()
+		seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil {
+			seconds = 1
+		}
+		wait := ratelimit.RetryAfter(seconds)
+		logging.Debugf("Slack rate limit reached for %s, retrying in %s", method, wait)
+		time.Sleep(wait)
+		if resp, err = c.post(method, contentType, body()); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+
+	var result apiResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("Slack %s failed with status %d", method, resp.StatusCode)
+	}
+	if !result.OK {
+		return nil, fmt.Errorf("Slack %s failed: %s", method, result.Error)
+	}
+	return &result, nil
+}
+
+// post sends one authorized Web API request
+func (c *Client) post(method string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, c.apiURL+"/"+method, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+c.config.BotToken)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Slack %s: %w", method, err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,117 @@
+package slack
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+)
+
+func TestNewClient_RequiresConfig(t *testing.T) {
+	if _, err := NewClient(nil); err == nil {
+		t.Error("NewClient(nil) error = nil, want error")
+	}
+	if _, err := NewClient(&config.SlackConfig{BotToken: "xoxb-token"}); err == nil {
+		t.Error("NewClient() without channel ID error = nil, want error")
+	}
+}
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client, err := NewClient(&config.SlackConfig{BotToken: "xoxb-token", ChannelID: "C0123456789"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	client.apiURL = server.URL + "/api"
+	return client
+}
+
+func writeImage(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "abc123.jpg")
+	if err := os.WriteFile(path, []byte("fake jpeg data"), 0644); err != nil {
+		t.Fatalf("Failed to write test image: %v", err)
+	}
+	return path
+}
+
+func TestClient_PostPhoto(t *testing.T) {
+	var uploaded string
+	var completion struct {
+		Files []struct {
+			ID    string `json:"id"`
+			Title string `json:"title"`
+		} `json:"files"`
+		ChannelID      string `json:"channel_id"`
+		InitialComment string `json:"initial_comment"`
+	}
+	var serverURL string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/upload" && r.Header.Get("Authorization") != "Bearer xoxb-token" {
+			t.Errorf("%s Authorization = %q, want the bot token", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		switch r.URL.Path {
+		case "/api/files.getUploadURLExternal":
+			if r.FormValue("filename") != "abc123.jpg" || r.FormValue("length") != "14" {
+				t.Errorf("reserved %q of length %q, want abc123.jpg of 14 bytes", r.FormValue("filename"), r.FormValue("length"))
+			}
+			w.Write([]byte(`{"ok": true, "upload_url": "` + serverURL + `/upload", "file_id": "F123"}`))
+		case "/upload":
+			data, _ := io.ReadAll(r.Body)
+			uploaded = string(data)
+		case "/api/files.completeUploadExternal":
+			if err := json.NewDecoder(r.Body).Decode(&completion); err != nil {
+				t.Errorf("Failed to decode completion: %v", err)
+			}
+			w.Write([]byte(`{"ok": true}`))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	})
+	serverURL = strings.TrimSuffix(client.apiURL, "/api")
+
+	if err := client.PostPhoto(writeImage(t), "Beach day"); err != nil {
+		t.Fatalf("PostPhoto() error = %v", err)
+	}
+	if uploaded != "fake jpeg data" {
+		t.Errorf("uploaded %q, want the image data", uploaded)
+	}
+	if len(completion.Files) != 1 || completion.Files[0].ID != "F123" || completion.Files[0].Title != "abc123.jpg" {
+		t.Errorf("completed files = %+v, want F123 titled abc123.jpg", completion.Files)
+	}
+	if completion.ChannelID != "C0123456789" || completion.InitialComment != "Beach day" {
+		t.Errorf("shared to %q with comment %q, want C0123456789 with the caption", completion.ChannelID, completion.InitialComment)
+	}
+}
+
+func TestClient_PostPhoto_Errors(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": false, "error": "invalid_auth"}`))
+	})
+	err := client.PostPhoto(writeImage(t), "")
+	if err == nil || !strings.Contains(err.Error(), "invalid_auth") {
+		t.Errorf("PostPhoto() error = %v, want invalid_auth", err)
+	}
+
+	// A rate-limited call is retried once
+	calls := 0
+	client = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"ok": false, "error": "channel_not_found"}`))
+	})
+	err = client.PostPhoto(writeImage(t), "")
+	if err == nil || !strings.Contains(err.Error(), "channel_not_found") || calls != 2 {
+		t.Errorf("PostPhoto() error = %v after %d calls, want channel_not_found after 2", err, calls)
+	}
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// IsAnimatedGIF reports whether the file at path is a GIF with more than one frame. Animated
+// GIFs are passed through as downloaded: they keep the file hash, and are never scaled or
+// re-encoded, which would keep only the first frame. Files that can't be read or aren't GIFs
+// report false
+func IsAnimatedGIF(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	return animatedGIF(bufio.NewReader(file))
+}
+
+// animatedGIF walks the blocks of a GIF stream without decoding the frames, stopping at the
+// second image descriptor
+func animatedGIF(r *bufio.Reader) bool {
+	// Header and logical screen descriptor, with the global colour table's flag and size
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return false
+	}
+	if string(header[:6]) != "GIF87a" && string(header[:6]) != "GIF89a" {
+		return false
+	}
+	if !skipColorTable(r, header[10]) {
+		return false
+	}
+
+	frames := 0
+	for {
+		separator, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		switch separator {
+		case 0x21: // Extension: a label, then data sub-blocks
+			if _, err := r.ReadByte(); err != nil || !skipSubBlocks(r) {
+				return false
+			}
+		case 0x2C: // Image descriptor, an optional local colour table, then the LZW data
+			frames++
+			if frames > 1 {
+				return true
+			}
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(r, descriptor); err != nil || !skipColorTable(r, descriptor[8]) {
+				return false
+			}
+			if _, err := r.ReadByte(); err != nil || !skipSubBlocks(r) {
+				return false
+			}
+		default: // Trailer (0x3B) or anything unexpected
+			return false
+		}
+	}
+}
+
+// skipColorTable skips the colour table a GIF descriptor's packed flags say follows it, if any
+func skipColorTable(r *bufio.Reader, flags byte) bool {
+	if flags&0x80 == 0 {
+		return true
+	}
+	_, err := r.Discard(3 << (flags&0x07 + 1))
+	return err == nil
+}
+
+// skipSubBlocks skips GIF data sub-blocks up to and including the empty block ending them
+func skipSubBlocks(r *bufio.Reader) bool {
+	for {
+		size, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		if size == 0 {
+			return true
+		}
+		if _, err := r.Discard(int(size)); err != nil {
+			return false
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestGIF encodes a GIF with the given number of frames, each with its own palette so the
+// later frames carry local colour tables
+func writeTestGIF(t *testing.T, path string, frames int) {
+	t.Helper()
+	animation := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		palette := color.Palette{color.Black, color.RGBA{R: uint8(i * 50), A: 255}}
+		frame := image.NewPaletted(image.Rect(0, 0, 32, 32), palette)
+		frame.SetColorIndex(i, i, 1)
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, 10)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	defer file.Close()
+	if err := gif.EncodeAll(file, animation); err != nil {
+		t.Fatalf("Failed to encode image: %v", err)
+	}
+}
+
+func TestIsAnimatedGIF(t *testing.T) {
+	dir := t.TempDir()
+	animated := filepath.Join(dir, "animated.gif")
+	writeTestGIF(t, animated, 3)
+	still := filepath.Join(dir, "still.gif")
+	writeTestGIF(t, still, 1)
+	truncated := filepath.Join(dir, "truncated.gif")
+	data, err := os.ReadFile(animated)
+	if err != nil {
+		t.Fatalf("Failed to read image: %v", err)
+	}
+	if err := os.WriteFile(truncated, data[:30], 0600); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+	notGIF := filepath.Join(dir, "photo.jpg")
+	if err := os.WriteFile(notGIF, []byte("not an image"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{animated, true},
+		{still, false},
+		{truncated, false},
+		{notGIF, false},
+		{filepath.Join(dir, "missing.gif"), false},
+	}
+	for _, tt := range tests {
+		if got := IsAnimatedGIF(tt.path); got != tt.want {
+			t.Errorf("IsAnimatedGIF(%s) = %v, want %v", filepath.Base(tt.path), got, tt.want)
+		}
+	}
+}
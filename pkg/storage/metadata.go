@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// exifGPSTag is the TIFF tag pointing from the first IFD to the GPS IFD
+const exifGPSTag = 0x8825
+
+// xmpNamespace starts the APP1 segments holding XMP rather than EXIF data
+var xmpNamespace = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// tiffTypeSizes are the sizes in bytes of the TIFF field types, by type
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// stripMetadata removes the location from the JPEG at path, or with all every EXIF, XMP and
+// IPTC segment and comment, without re-encoding the image. Colour profiles are kept. The GPS
+// data in EXIF is cleared in place, and XMP mentioning a location is dropped. Files that aren't
+// JPEGs or have nothing to strip are left untouched. Reports whether the file was rewritten
+func stripMetadata(path string, all bool) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read image: %w", err)
+	}
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return false, nil
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	pos := 2
+	changed := false
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break // Start of scan: no metadata follows
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		segment := data[pos:end]
+		pos = end
+
+		switch {
+		case all && (marker == 0xE1 || marker == 0xED || marker == 0xFE):
+			// APP1 (EXIF, XMP), APP13 (IPTC) and comments
+			changed = true
+		case marker == 0xE1 && bytes.HasPrefix(segment[4:], xmpNamespace):
+			if bytes.Contains(segment, []byte("GPS")) {
+				changed = true
+				continue
+			}
+			out = append(out, segment...)
+		case marker == 0xE1:
+			segment = append([]byte{}, segment...)
+			if clearGPS(segment) {
+				changed = true
+			}
+			out = append(out, segment...)
+		default:
+			out = append(out, segment...)
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	out = append(out, data[pos:]...)
+	if err := replaceFile(path, out); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// clearGPS empties the GPS IFD of an EXIF APP1 segment in place, zeroing its entries and the
+// values they point to. Reports whether there was any GPS data
+func clearGPS(segment []byte) bool {
+	order := exifByteOrder(segment)
+	if order == nil {
+		return false
+	}
+	tiff := segment[exifHeaderLength:]
+	gps := 0
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return false
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == exifGPSTag {
+			gps = int(order.Uint32(tiff[entry+8:]))
+			break
+		}
+	}
+	if gps < 8 || gps+2 > len(tiff) {
+		return false
+	}
+
+	count = int(order.Uint16(tiff[gps:]))
+	if count == 0 {
+		return false
+	}
+	for i := 0; i < count; i++ {
+		entry := gps + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		// Values longer than 4 bytes are stored elsewhere, at the offset in the entry
+		size := tiffTypeSizes[order.Uint16(tiff[entry+2:])] * int(order.Uint32(tiff[entry+4:]))
+		if size > 4 {
+			offset := int(order.Uint32(tiff[entry+8:]))
+			if offset >= 8 && offset+size <= len(tiff) {
+				clear(tiff[offset : offset+size])
+			}
+		}
+		clear(tiff[entry : entry+12])
+	}
+	order.PutUint16(tiff[gps:], 0)
+	return true
+}
+
+// replaceFile overwrites the file at path with data once it is fully written elsewhere,
+// keeping the file's permissions
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat image: %w", err)
+	}
+	tmpPath := path + ".rewrite"
+	if err := os.WriteFile(tmpPath, data, info.Mode().Perm()); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write image: %w", classifyWriteError(err))
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write image: %w", classifyWriteError(err))
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace image: %w", classifyWriteError(err))
+	}
+	return nil
+}
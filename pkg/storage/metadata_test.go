@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// gpsLatitude is the value of the GPS latitude in the test EXIF data, stored outside its entry
+var gpsLatitude = bytes.Repeat([]byte{0x2A}, 24)
+
+// gpsJPEG encodes a small image with an EXIF segment holding an orientation and a GPS IFD with
+// a latitude, an XMP segment mentioning the location, and a comment
+func gpsJPEG(t *testing.T) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 16, 16)), nil); err != nil {
+		t.Fatalf("jpeg.Encode() error = %v", err)
+	}
+
+	// TIFF header; the first IFD at 8 with the orientation and the GPS pointer; the GPS IFD
+	// at 38 with the latitude, three RATIONALs stored at 56
+	order := binary.BigEndian
+	tiff := make([]byte, 56)
+	copy(tiff, "MM")
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 2)
+	order.PutUint16(tiff[10:], exifOrientationTag)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], 1)
+	order.PutUint16(tiff[22:], exifGPSTag)
+	order.PutUint16(tiff[24:], 4)
+	order.PutUint32(tiff[26:], 1)
+	order.PutUint32(tiff[30:], 38)
+	order.PutUint16(tiff[38:], 1)
+	order.PutUint16(tiff[40:], 2) // GPSLatitude
+	order.PutUint16(tiff[42:], 5)
+	order.PutUint32(tiff[44:], 3)
+	order.PutUint32(tiff[48:], 56)
+	tiff = append(tiff, gpsLatitude...)
+
+	segment := func(marker byte, payload []byte) []byte {
+		out := []byte{0xFF, marker, 0, 0}
+		binary.BigEndian.PutUint16(out[2:], uint16(len(payload)+2))
+		return append(out, payload...)
+	}
+	data := append([]byte{}, encoded.Bytes()[:2]...)
+	data = append(data, segment(0xE1, append([]byte("Exif\x00\x00"), tiff...))...)
+	data = append(data, segment(0xE1, append(append([]byte{}, xmpNamespace...), `<exif:GPSLatitude>51,30N</exif:GPSLatitude>`...))...)
+	data = append(data, segment(0xFE, []byte("holiday"))...)
+	return append(data, encoded.Bytes()[2:]...)
+}
+
+func TestStripMetadata(t *testing.T) {
+	t.Run("gps", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "photo.jpg")
+		if err := os.WriteFile(path, gpsJPEG(t), 0600); err != nil {
+			t.Fatalf("Failed to write image: %v", err)
+		}
+		changed, err := stripMetadata(path, false)
+		if err != nil || !changed {
+			t.Fatalf("stripMetadata() = %v, %v, want the file rewritten", changed, err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read image: %v", err)
+		}
+		if bytes.Contains(data, gpsLatitude) || bytes.Contains(data, []byte("GPSLatitude")) {
+			t.Error("the location is still in the image")
+		}
+		if orientation, _, _ := exifOrientation(data); orientation != 1 {
+			t.Errorf("orientation = %d, want the rest of the EXIF data kept", orientation)
+		}
+		if !bytes.Contains(data, []byte("holiday")) {
+			t.Error("the comment was removed along with the location")
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+			t.Errorf("stripped image doesn't decode: %v", err)
+		}
+
+		// Nothing is left to strip the second time
+		if changed, err := stripMetadata(path, false); err != nil || changed {
+			t.Errorf("stripMetadata() again = %v, %v, want the file left alone", changed, err)
+		}
+	})
+
+	t.Run("all", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "photo.jpg")
+		if err := os.WriteFile(path, gpsJPEG(t), 0600); err != nil {
+			t.Fatalf("Failed to write image: %v", err)
+		}
+		if changed, err := stripMetadata(path, true); err != nil || !changed {
+			t.Fatalf("stripMetadata() = %v, %v, want the file rewritten", changed, err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read image: %v", err)
+		}
+		if bytes.Contains(data, []byte("Exif\x00\x00")) || bytes.Contains(data, xmpNamespace) || bytes.Contains(data, []byte("holiday")) {
+			t.Error("metadata is still in the image")
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+			t.Errorf("stripped image doesn't decode: %v", err)
+		}
+	})
+
+	t.Run("not a JPEG", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "video.mov")
+		if err := os.WriteFile(path, []byte("not an image"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if changed, err := stripMetadata(path, true); err != nil || changed {
+			t.Errorf("stripMetadata() = %v, %v, want the file left alone", changed, err)
+		}
+	})
+}
+
+func TestManager_StripGPS(t *testing.T) {
+	original := gpsJPEG(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(original)
+	}))
+	defer server.Close()
+
+	manager, err := NewManagerWithOptions(t.TempDir(), Options{StripGPS: true})
+	if err != nil {
+		t.Fatalf("NewManagerWithOptions() error = %v", err)
+	}
+	imagePath, hash, err := manager.DownloadAndHash(server.URL + "/photo.jpg")
+	if err != nil {
+		t.Fatalf("DownloadAndHash() error = %v", err)
+	}
+
+	// The hash is still that of the download, so stripping doesn't resend anything
+	sum := sha256.Sum256(original)
+	if hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("hash = %s, want the hash of the downloaded file", hash)
+	}
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		t.Fatalf("Failed to read image: %v", err)
+	}
+	if bytes.Contains(data, gpsLatitude) {
+		t.Error("the stored image still has its location")
+	}
+}
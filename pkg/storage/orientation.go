@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"os"
+)
+
+// normalizedJPEGQuality is the quality rotated JPEGs are re-encoded with
+const normalizedJPEGQuality = 92
+
+// exifOrientationTag is the TIFF tag holding the EXIF orientation
+const exifOrientationTag = 0x0112
+
+// normalizeOrientation rotates the JPEG at path upright according to its EXIF orientation and
+// resets the tag to 1 (normal), keeping the rest of the EXIF data such as the capture date.
+// Files that aren't JPEGs or are already upright are left untouched. Reports whether the file
+// was rewritten
+func normalizeOrientation(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read image: %w", err)
+	}
+	orientation, exif, valueOffset := exifOrientation(data)
+	if orientation < 2 || orientation > 8 {
+		return false, nil
+	}
+
+	src, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("failed to decode JPEG: %w", err)
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, orient(src, orientation), &jpeg.Options{Quality: normalizedJPEGQuality}); err != nil {
+		return false, fmt.Errorf("failed to encode JPEG: %w", err)
+	}
+
+	// The encoder writes no metadata, so put the original EXIF segment back after the SOI
+	// marker with the orientation reset
+	segment := append([]byte{}, exif...)
+	exifByteOrder(segment).PutUint16(segment[valueOffset:], 1)
+	out := make([]byte, 0, encoded.Len()+len(segment))
+	out = append(out, encoded.Bytes()[:2]...)
+	out = append(out, segment...)
+	out = append(out, encoded.Bytes()[2:]...)
+
+	if err := replaceFile(path, out); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// exifOrientation finds the EXIF orientation of JPEG data. Returns the orientation (0 if
+// there is none), the APP1 segment holding it including its marker, and the offset of the
+// orientation value within that segment
+func exifOrientation(data []byte) (int, []byte, int) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, nil, 0
+	}
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break // Start of scan: no metadata follows
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		segment := data[pos:end]
+		if marker == 0xE1 {
+			if orientation, offset := tiffOrientation(segment); orientation != 0 {
+				return orientation, segment, offset
+			}
+		}
+		pos = end
+	}
+	return 0, nil, 0
+}
+
+// exifHeaderLength is the length of the marker, segment length, and "Exif\0\0" preceding the
+// TIFF data in an EXIF APP1 segment
+const exifHeaderLength = 10
+
+// exifByteOrder returns the byte order of an EXIF APP1 segment's TIFF data, or nil if the
+// segment isn't EXIF
+func exifByteOrder(segment []byte) binary.ByteOrder {
+	if len(segment) < exifHeaderLength+8 || string(segment[4:10]) != "Exif\x00\x00" {
+		return nil
+	}
+	switch string(segment[exifHeaderLength : exifHeaderLength+2]) {
+	case "II":
+		return binary.LittleEndian
+	case "MM":
+		return binary.BigEndian
+	}
+	return nil
+}
+
+// tiffOrientation reads the orientation from the first IFD of an EXIF APP1 segment and
+// returns it with the offset of its value in the segment, or 0 if it has none
+func tiffOrientation(segment []byte) (int, int) {
+	order := exifByteOrder(segment)
+	if order == nil {
+		return 0, 0
+	}
+	tiff := segment[exifHeaderLength:]
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0, 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		// The orientation is a single SHORT stored in the entry's value field
+		if order.Uint16(tiff[entry:]) == exifOrientationTag && order.Uint16(tiff[entry+2:]) == 3 {
+			return int(order.Uint16(tiff[entry+8:])), exifHeaderLength + entry + 8
+		}
+	}
+	return 0, 0
+}
+
+// orient returns src transformed so that an image with the given EXIF orientation displays
+// upright without it
+func orient(src image.Image, orientation int) image.Image {
+	bounds := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w // Orientations 5-8 swap width and height
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // Rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // Mirrored along the top-left diagonal
+				dx, dy = y, x
+			case 6: // Needs rotating 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // Mirrored along the top-right diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // Needs rotating 90° counterclockwise
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], rgba.Pix[rgba.PixOffset(x, y):rgba.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testRed  = color.RGBA{R: 220, G: 20, B: 20, A: 255}
+	testBlue = color.RGBA{R: 20, G: 20, B: 220, A: 255}
+)
+
+// orientedJPEG encodes a 32x16 image, red on the left and blue on the right, with an EXIF
+// segment holding the orientation in the given byte order
+func orientedJPEG(t *testing.T, orientation uint16, order binary.ByteOrder) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			if x < 16 {
+				img.Set(x, y, testRed)
+			} else {
+				img.Set(x, y, testBlue)
+			}
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("jpeg.Encode() error = %v", err)
+	}
+
+	// TIFF header, then an IFD with the orientation entry
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], exifOrientationTag)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	data := append([]byte{}, encoded.Bytes()[:2]...)
+	data = append(data, segment...)
+	return append(data, encoded.Bytes()[2:]...)
+}
+
+// isColor reports whether c is close to want, allowing for JPEG artifacts
+func isColor(c color.Color, want color.RGBA) bool {
+	r, g, b, _ := c.RGBA()
+	near := func(got uint32, want uint8) bool {
+		diff := int(got>>8) - int(want)
+		return diff > -40 && diff < 40
+	}
+	return near(r, want.R) && near(g, want.G) && near(b, want.B)
+}
+
+func TestNormalizeOrientation(t *testing.T) {
+	tests := []struct {
+		name        string
+		orientation uint16
+		order       binary.ByteOrder
+		wantW       int
+		wantH       int
+		topLeft     color.RGBA // Color expected at the top-left of the upright image
+		bottomRight color.RGBA
+	}{
+		{"rotate 90 big-endian", 6, binary.BigEndian, 16, 32, testRed, testBlue},
+		{"rotate 90 little-endian", 6, binary.LittleEndian, 16, 32, testRed, testBlue},
+		{"rotate 270", 8, binary.BigEndian, 16, 32, testBlue, testRed},
+		{"rotate 180", 3, binary.BigEndian, 32, 16, testBlue, testRed},
+		{"mirror", 2, binary.BigEndian, 32, 16, testBlue, testRed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "photo.jpg")
+			if err := os.WriteFile(path, orientedJPEG(t, tt.orientation, tt.order), 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			rewritten, err := normalizeOrientation(path)
+			if err != nil || !rewritten {
+				t.Fatalf("normalizeOrientation() = %v, %v, want rewritten", rewritten, err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			if orientation, _, _ := exifOrientation(data); orientation != 1 {
+				t.Errorf("orientation after normalizing = %d, want 1 (EXIF kept, tag reset)", orientation)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("jpeg.Decode() error = %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+				t.Fatalf("size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+			}
+			if c := img.At(1, 1); !isColor(c, tt.topLeft) {
+				t.Errorf("top-left = %v, want %v", c, tt.topLeft)
+			}
+			if c := img.At(tt.wantW-2, tt.wantH-2); !isColor(c, tt.bottomRight) {
+				t.Errorf("bottom-right = %v, want %v", c, tt.bottomRight)
+			}
+		})
+	}
+}
+
+func TestNormalizeOrientation_Untouched(t *testing.T) {
+	files := map[string][]byte{
+		"upright.jpg": orientedJPEG(t, 1, binary.BigEndian),
+		"image.png":   []byte("\x89PNG\r\n\x1a\nnot really"),
+	}
+	for name, data := range files {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		rewritten, err := normalizeOrientation(path)
+		if err != nil || rewritten {
+			t.Errorf("normalizeOrientation(%s) = %v, %v, want untouched", name, rewritten, err)
+		}
+		if got, _ := os.ReadFile(path); !bytes.Equal(got, data) {
+			t.Errorf("normalizeOrientation(%s) changed the file", name)
+		}
+	}
+}
+
+func TestManager_NormalizeOrientation(t *testing.T) {
+	data := orientedJPEG(t, 6, binary.BigEndian)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	manager, err := NewManagerWithOptions(t.TempDir(), Options{NormalizeOrientation: true})
+	if err != nil {
+		t.Fatalf("NewManagerWithOptions() error = %v", err)
+	}
+	path, hash, err := manager.DownloadAndHash(server.URL + "/photo.jpg")
+	if err != nil {
+		t.Fatalf("DownloadAndHash() error = %v", err)
+	}
+
+	// The hash identifies the download, not the rotated file
+	sum := sha256.Sum256(data)
+	if hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("DownloadAndHash() hash = %v, want hash of the downloaded bytes", hash)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer file.Close()
+	config, err := jpeg.DecodeConfig(file)
+	if err != nil {
+		t.Fatalf("DecodeConfig() error = %v", err)
+	}
+	if config.Width != 16 || config.Height != 32 {
+		t.Errorf("stored image size = %dx%d, want 16x32 (rotated upright)", config.Width, config.Height)
+	}
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket of bytes shared by every download, so concurrent downloads
+// together stay under the rate. Readers reserve the bytes they've read and sleep off any
+// deficit, which keeps waiting downloads in the order they read
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second
+	burst  int     // Largest read allowed at once: one second's worth
+	tokens float64 // May go negative while readers wait for their reservation
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter for bytesPerSec, or nil (no limit) if it isn't positive
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	burst := bytesPerSec
+	if burst > 1<<30 {
+		burst = 1 << 30
+	}
+	return &rateLimiter{
+		rate:   float64(bytesPerSec),
+		burst:  int(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n bytes fit under the rate, or ctx is done
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(deficit / l.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reader returns r limited to the rate, or r itself without a limiter
+func (l *rateLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, limiter: l}
+}
+
+// limitedReader reads from r no faster than its limiter allows
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > lr.limiter.burst {
+		p = p[:lr.limiter.burst]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if waitErr := lr.limiter.wait(lr.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
@@ -1,103 +1,819 @@
 package storage
 
 import (
+	"archive/zip"
+	"context"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"image"
+	"image/draw"
+	_ "image/jpeg" // Registers the JPEG decoder for pixel hashing
+	_ "image/png"  // Registers the PNG decoder for pixel hashing
 	"io"
+	"io/fs"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"lukechampine.com/blake3"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/certs"
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+)
+
+// QuarantineDirName is the subdirectory of the image directory holding images that repeatedly failed to process
+const QuarantineDirName = "quarantine"
+
+// DerivativesDirName is the subdirectory of the image directory holding smaller sizes of images
+// downloaded for destinations that don't need the original
+const DerivativesDirName = "derivatives"
+
+// ErrImageDirUnwritable is returned when files can't be written to the image directory
+// (read-only mount, full disk, or missing permissions); every further download would fail too
+var ErrImageDirUnwritable = errors.New("image directory is not writable")
+
+// ErrImageNotFound is returned by GetImagePath when no file is stored for a hash
+var ErrImageNotFound = errors.New("image not found")
+
+// ErrImageTooSmall is returned when a downloaded image is smaller than the minimum resolution
+var ErrImageTooSmall = errors.New("image below minimum resolution")
+
+// ErrURLExpired is returned when the CDN refuses a download URL with 403, 404, or 410. iCloud
+// download URLs expire, so retrying the same URL won't help; the album has to be fetched
+// again for a fresh one
+var ErrURLExpired = errors.New("download URL expired")
+
+// ErrTypeNotAllowed is returned when a download's media type is excluded by the allow/block lists
+var ErrTypeNotAllowed = errors.New("media type not allowed")
+
+// imageExtensions lists the extensions images may be stored with
+var imageExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+// Layout controls how downloaded files are arranged under the image directory
+type Layout string
+
+const (
+	// LayoutFlat stores every file directly in the image directory: <hash>.<ext>
+	LayoutFlat Layout = "flat"
+	// LayoutHash shards files by the first two hash characters: ab/<hash>.<ext>
+	LayoutHash Layout = "hash"
+	// LayoutAlbum groups files by album: <album>/<hash>.<ext>
+	LayoutAlbum Layout = "album"
+	// LayoutAlbumHash groups files by album, then shards by hash prefix: <album>/ab/<hash>.<ext>
+	LayoutAlbumHash Layout = "album-hash"
+)
+
+// HashAlgorithm selects the hash used to identify downloaded images
+// Changing the algorithm changes every hash, so existing Redis tracking keys no longer match
+type HashAlgorithm string
+
+const (
+	HashSHA256 HashAlgorithm = "sha256"
+	HashSHA1   HashAlgorithm = "sha1"
+	HashBLAKE3 HashAlgorithm = "blake3"
+	HashXXHash HashAlgorithm = "xxhash"
 )
 
+// HashContent selects what the hash is calculated over
+// Changing it changes the hash of every decodable image, like changing the algorithm
+type HashContent string
+
+const (
+	// HashContentFile hashes the downloaded bytes as-is
+	HashContentFile HashContent = "file"
+	// HashContentPixels hashes the decoded pixels of JPEG and PNG images, so copies that differ
+	// only in metadata (EXIF orientation, location, ...) get the same hash. Other files, including
+	// animated GIFs, HEIC, and videos, fall back to the file hash
+	HashContentPixels HashContent = "pixels"
+)
+
+// Options holds optional storage manager settings
+type Options struct {
+	Layout        Layout        // Defaults to LayoutFlat
+	HashAlgorithm HashAlgorithm // Defaults to HashSHA256
+	HashContent   HashContent   // Defaults to HashContentFile
+	// AllowedTypes and BlockedTypes filter downloads by MIME type ("image/gif") or type family
+	// ("video/*"); see ParseMediaType. When AllowedTypes is set, anything not in it is skipped
+	AllowedTypes []string
+	BlockedTypes []string
+	// DownloadTimeout bounds each download (default 60s; negative disables). DownloadTimeoutPerMB
+	// extends it by this much per megabyte of the response's Content-Length, so large videos get
+	// longer while small images still fail fast
+	DownloadTimeout      time.Duration
+	DownloadTimeoutPerMB time.Duration
+	// AllowDeletedFiles lets an unchanged URL (same ETag) be skipped even though its file was
+	// deleted after delivery; DownloadAndHashForAlbum then returns an empty path
+	AllowDeletedFiles bool
+	// MaxConnsPerHost caps the connections open to each CDN host (default 8; negative disables
+	// the cap). Downloads beyond it wait for a free connection, within their timeout.
+	// MaxIdleConnsPerHost is how many are kept open for reuse between downloads (default 8)
+	MaxConnsPerHost     int
+	MaxIdleConnsPerHost int
+	// MaxDownloadsPerHost caps the downloads in flight to each hostname (0 = no limit). Unlike
+	// MaxConnsPerHost it also holds when many requests share one HTTP/2 connection
+	MaxDownloadsPerHost int
+	// MaxDownloadBytesPerSec caps the combined rate of all downloads in bytes per second
+	// (0 = no limit)
+	MaxDownloadBytesPerSec int64
+	// MinWidth and MinHeight skip downloaded images narrower or shorter than this many pixels
+	// (0 = no minimum). Files whose dimensions can't be read, such as videos, are kept
+	MinWidth  int
+	MinHeight int
+	// NormalizeOrientation rotates downloaded JPEGs upright according to their EXIF orientation
+	// and resets the tag, re-encoding only images that need it. The hash is still that of the
+	// downloaded file, so enabling it doesn't change which photos count as delivered
+	NormalizeOrientation bool
+	// StripGPS clears the location from downloaded JPEGs, and StripAllEXIF removes their EXIF,
+	// XMP and IPTC metadata altogether, before any destination gets them. Like
+	// NormalizeOrientation, the hash is still that of the downloaded file
+	StripGPS     bool
+	StripAllEXIF bool
+	// CACertPath is an optional PEM file of CA certificates trusted for downloads in addition to
+	// the system roots, e.g. the root CA of a TLS-inspecting proxy
+	CACertPath string
+	// DirMode and FileMode are the permissions directories and image files are created with,
+	// applied as given rather than narrowed by the umask. Zero keeps the defaults: 0755 less the
+	// umask for directories, and 0600 for images
+	DirMode  os.FileMode
+	FileMode os.FileMode
+}
+
+// URLCache remembers the hash of each downloaded URL and the ETag it was served with
+// (implemented by redis.Client) so unchanged URLs can be skipped with a HEAD request
+type URLCache interface {
+	GetURLHash(imageURL string) (string, string, error)
+	SetURLHash(imageURL string, hash string, etag string) error
+}
+
 // Manager handles image downloads and hash calculation
 type Manager struct {
-	imageDir string
-	client   *http.Client
+	imageDir      string
+	layout        Layout
+	hashAlgorithm HashAlgorithm
+	hashContent   HashContent
+	client        *http.Client
+	urlCache      URLCache // Optional - nil always downloads
+	allowedTypes  []string
+	blockedTypes  []string
+	// Timeouts are applied per request (see downloadTimeout) rather than on the http.Client
+	timeout      time.Duration
+	timeoutPerMB time.Duration
+	allowDeleted bool
+	// hostSlots holds a semaphore per download hostname when maxPerHost is set
+	maxPerHost int
+	hostMu     sync.Mutex
+	hostSlots  map[string]chan struct{}
+	limiter    *rateLimiter // Shared by all downloads; nil when unlimited
+	minWidth   int
+	minHeight  int
+	normalize  bool
+	stripGPS   bool
+	stripAll   bool
+	dirMode    os.FileMode // Zero: 0755 less the umask
+	fileMode   os.FileMode // Zero: left as created
+	// names holds the file name each hash was last served as (Content-Disposition)
+	namesMu sync.Mutex
+	names   map[string]string
 }
 
-// NewManager creates a new storage manager
+// NewManager creates a new storage manager with the default options
 func NewManager(imageDir string) (*Manager, error) {
+	return NewManagerWithOptions(imageDir, Options{})
+}
+
+// NewManagerWithOptions creates a new storage manager with the given options
+func NewManagerWithOptions(imageDir string, opts Options) (*Manager, error) {
+	layout := opts.Layout
+	switch layout {
+	case "":
+		layout = LayoutFlat
+	case LayoutFlat, LayoutHash, LayoutAlbum, LayoutAlbumHash:
+	default:
+		return nil, fmt.Errorf("unknown image layout: %s", layout)
+	}
+
+	hashAlgorithm := opts.HashAlgorithm
+	switch hashAlgorithm {
+	case "":
+		hashAlgorithm = HashSHA256
+	case HashSHA256, HashSHA1, HashBLAKE3, HashXXHash:
+	default:
+		return nil, fmt.Errorf("unknown hash algorithm: %s", hashAlgorithm)
+	}
+
+	hashContent := opts.HashContent
+	switch hashContent {
+	case "":
+		hashContent = HashContentFile
+	case HashContentFile, HashContentPixels:
+	default:
+		return nil, fmt.Errorf("unknown hash content: %s", hashContent)
+	}
+
+	timeout := opts.DownloadTimeout
+	if timeout == 0 {
+		timeout = 60 * time.Second
+	}
+
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if ok {
+		transport = transport.Clone() // Keep the default proxy, dial, and TLS settings
+	} else {
+		transport = &http.Transport{}
+	}
+	transport.MaxConnsPerHost = connLimit(opts.MaxConnsPerHost)
+	transport.MaxIdleConnsPerHost = connLimit(opts.MaxIdleConnsPerHost)
+	if opts.CACertPath != "" {
+		pool, err := certs.LoadPool(opts.CACertPath)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	m := &Manager{
+		imageDir:      imageDir,
+		layout:        layout,
+		hashAlgorithm: hashAlgorithm,
+		hashContent:   hashContent,
+		client:        &http.Client{Transport: transport},
+		allowedTypes:  opts.AllowedTypes,
+		blockedTypes:  opts.BlockedTypes,
+		timeout:       timeout,
+		timeoutPerMB:  opts.DownloadTimeoutPerMB,
+		allowDeleted:  opts.AllowDeletedFiles,
+		maxPerHost:    opts.MaxDownloadsPerHost,
+		hostSlots:     make(map[string]chan struct{}),
+		limiter:       newRateLimiter(opts.MaxDownloadBytesPerSec),
+		minWidth:      opts.MinWidth,
+		minHeight:     opts.MinHeight,
+		normalize:     opts.NormalizeOrientation,
+		stripGPS:      opts.StripGPS,
+		stripAll:      opts.StripAllEXIF,
+		dirMode:       opts.DirMode,
+		fileMode:      opts.FileMode,
+	}
+
 	// Create directory if it doesn't exist
-	if err := os.MkdirAll(imageDir, 0755); err != nil {
+	if err := m.mkdirAll(imageDir); err != nil {
 		return nil, fmt.Errorf("failed to create image directory: %w", err)
 	}
+	return m, nil
+}
+
+// connLimit returns the connection limit for a configured value: the default for 0, or no
+// limit when negative
+func connLimit(n int) int {
+	switch {
+	case n == 0:
+		return 8
+	case n < 0:
+		return 0
+	}
+	return n
+}
+
+// acquireHost blocks until a download to imageURL's host may start and returns the function
+// that releases its slot. Without a per-host limit it returns immediately
+func (m *Manager) acquireHost(imageURL string) func() {
+	if m.maxPerHost <= 0 {
+		return func() {}
+	}
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return func() {} // The request itself will fail on the same URL
+	}
+
+	m.hostMu.Lock()
+	slots, ok := m.hostSlots[u.Hostname()]
+	if !ok {
+		slots = make(chan struct{}, m.maxPerHost)
+		m.hostSlots[u.Hostname()] = slots
+	}
+	m.hostMu.Unlock()
+
+	slots <- struct{}{}
+	return func() { <-slots }
+}
 
-	return &Manager{
-		imageDir: imageDir,
-		client: &http.Client{
-			Timeout: 60 * time.Second,
-		},
-	}, nil
+// SetURLCache enables the HEAD pre-check that skips downloading URLs whose ETag is unchanged
+func (m *Manager) SetURLCache(cache URLCache) {
+	m.urlCache = cache
 }
 
-// DownloadAndHash downloads an image and calculates its SHA-256 hash
+// DownloadAndHash downloads an image and calculates its hash (SHA-256 unless configured otherwise)
 // Returns the local file path and the hash
 func (m *Manager) DownloadAndHash(imageURL string) (string, string, error) {
-	// Download the image
-	resp, err := m.client.Get(imageURL)
+	return m.DownloadAndHashForAlbum(imageURL, "")
+}
+
+// DownloadAndHashForAlbum downloads an image belonging to the named album and calculates its hash
+// The album is only used to place the file when an album layout is configured
+// Returns the local file path and the hash. With AllowDeletedFiles, the path is empty if the URL
+// is unchanged but its file was deleted; use RedownloadForAlbum if the file is needed again
+func (m *Manager) DownloadAndHashForAlbum(imageURL string, album string) (string, string, error) {
+	// Skip the download entirely if the URL still serves content we already have
+	if path, hash, ok := m.checkUnchanged(imageURL); ok {
+		// The lists may have changed since the file was downloaded
+		if path != "" {
+			if err := m.checkType(mediaType(path, "")); err != nil {
+				return "", "", err
+			}
+			if err := m.checkResolution(path); err != nil {
+				return "", "", err
+			}
+		}
+		return path, hash, nil
+	}
+	return m.RedownloadForAlbum(imageURL, album)
+}
+
+// RedownloadForAlbum downloads an image and calculates its hash without consulting the URL cache
+func (m *Manager) RedownloadForAlbum(imageURL string, album string) (string, string, error) {
+	// Wait for a slot on the image's host before the download timeout starts
+	defer m.acquireHost(imageURL)()
+
+	// Download the image; the deadline starts at the base timeout and is extended once the
+	// response's size is known
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var timedOut atomic.Bool
+	timeout := m.downloadTimeout(-1)
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			timedOut.Store(true)
+			cancel()
+		})
+		defer timer.Stop()
+	}
+	downloadErr := func(err error) error {
+		if timedOut.Load() {
+			return fmt.Errorf("download timed out after %v: %w", timeout, err)
+		}
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to download image: %w", err)
+		return "", "", fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to download image: %w", downloadErr(err))
 	}
 	defer resp.Body.Close()
+	if timer != nil && resp.ContentLength > 0 && m.timeoutPerMB > 0 {
+		timeout = m.downloadTimeout(resp.ContentLength)
+		timer.Reset(timeout)
+	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return "", "", statusError(resp.StatusCode)
+	}
+
+	// CDN URLs often have no extension; the real file name may only be in Content-Disposition
+	filename := dispositionFilename(resp.Header.Get("Content-Disposition"))
+	location := imageURL
+	if filename != "" {
+		location = filename
+	}
+
+	// Skip excluded types before reading the body
+	if err := m.checkType(mediaType(location, resp.Header.Get("Content-Type"))); err != nil {
+		return "", "", err
 	}
 
 	// Create a tee reader to both hash and write the file
-	hasher := sha256.New()
-	tee := io.TeeReader(resp.Body, hasher)
+	hasher := m.newHasher()
+	tee := io.TeeReader(m.limiter.reader(ctx, resp.Body), hasher)
+
+	// Determine file extension from Content-Disposition, URL, or Content-Type
+	ext := m.downloadExtension(filename, imageURL, resp.Header.Get("Content-Type"))
 
-	// Determine file extension from URL or Content-Type
-	ext := m.getFileExtension(imageURL, resp.Header.Get("Content-Type"))
-	
 	// Create a temporary file first
 	tmpFile, err := os.CreateTemp(m.imageDir, "download-*"+ext)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create temp file: %w", err)
+		return "", "", fmt.Errorf("failed to create temp file: %w", classifyWriteError(err))
 	}
 	tmpPath := tmpFile.Name()
+	if err := m.chmodFile(tmpFile); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return "", "", err
+	}
 
 	// Write to temp file
 	_, err = io.Copy(tmpFile, tee)
 	tmpFile.Close()
 	if err != nil {
 		os.Remove(tmpPath)
-		return "", "", fmt.Errorf("failed to write image: %w", err)
+		return "", "", fmt.Errorf("failed to write image: %w", classifyWriteError(downloadErr(err)))
+	}
+
+	if err := m.checkResolution(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return "", "", err
 	}
 
 	// Calculate hash
 	hash := hex.EncodeToString(hasher.Sum(nil))
+	if m.hashContent == HashContentPixels {
+		if pixelHash, ok := m.pixelHash(tmpPath); ok {
+			hash = pixelHash
+		}
+	}
+	m.rememberURL(imageURL, hash, resp.Header.Get("ETag"))
+	m.rememberName(hash, filename)
+
+	if m.normalize {
+		if _, err := normalizeOrientation(tmpPath); err != nil {
+			logging.Warnf("Could not normalize the orientation of %s, keeping it as downloaded: %v", imageURL, err)
+		}
+	}
+	if err := m.stripMetadata(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return "", "", err
+	}
 
 	// Check if file with this hash already exists
-	hashPath := filepath.Join(m.imageDir, hash+ext)
+	hashDir := m.hashDir(hash, album)
+	hashPath := filepath.Join(hashDir, hash+ext)
 	if _, err := os.Stat(hashPath); err == nil {
 		// File already exists, remove temp file and return existing
 		os.Remove(tmpPath)
 		return hashPath, hash, nil
 	}
 
-	// Rename temp file to hash-based filename
+	if err := m.mkdirAll(hashDir); err != nil {
+		os.Remove(tmpPath)
+		return "", "", fmt.Errorf("failed to create image subdirectory: %w", classifyWriteError(err))
+	}
+
+	// Rename temp file to hash-based filename. A concurrent download of the same content may
+	// have created it since the Stat above: on POSIX the rename atomically replaces it with
+	// identical bytes, elsewhere it fails and the existing file is used
 	if err := os.Rename(tmpPath, hashPath); err != nil {
 		os.Remove(tmpPath)
-		return "", "", fmt.Errorf("failed to rename file: %w", err)
+		if _, statErr := os.Stat(hashPath); statErr == nil {
+			return hashPath, hash, nil
+		}
+		return "", "", fmt.Errorf("failed to rename file: %w", classifyWriteError(err))
 	}
 
 	return hashPath, hash, nil
 }
 
+// DownloadDerivative downloads a smaller size of the image with the given hash, labelled size
+// (e.g. "1280x960"), into the derivatives subdirectory. A size already downloaded for the hash
+// is reused without contacting the server. Returns the local file path
+func (m *Manager) DownloadDerivative(imageURL string, hash string, size string) (string, error) {
+	base := hash + "-" + sanitizeDirName(size)
+	dir := filepath.Join(m.imageDir, DerivativesDirName)
+	if path, ok := m.derivativePath(dir, base); ok {
+		return path, nil
+	}
+
+	defer m.acquireHost(imageURL)()
+	ctx := context.Background()
+	if timeout := m.downloadTimeout(-1); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download derivative: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError(resp.StatusCode)
+	}
+
+	if err := m.mkdirAll(dir); err != nil {
+		return "", fmt.Errorf("failed to create derivatives directory: %w", classifyWriteError(err))
+	}
+	filename := dispositionFilename(resp.Header.Get("Content-Disposition"))
+	path := filepath.Join(dir, base+m.downloadExtension(filename, imageURL, resp.Header.Get("Content-Type")))
+	tmpFile, err := os.CreateTemp(dir, "download-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", classifyWriteError(err))
+	}
+	tmpPath := tmpFile.Name()
+	if err := m.chmodFile(tmpFile); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return "", err
+	}
+	_, err = io.Copy(tmpFile, m.limiter.reader(ctx, resp.Body))
+	tmpFile.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to write derivative: %w", classifyWriteError(err))
+	}
+	if err := m.stripMetadata(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to rename file: %w", classifyWriteError(err))
+	}
+	return path, nil
+}
+
+// stripMetadata removes the metadata StripGPS or StripAllEXIF asks for from a downloaded file.
+// A file that can't be stripped fails the download rather than leaking its location
+func (m *Manager) stripMetadata(path string) error {
+	if !m.stripGPS && !m.stripAll {
+		return nil
+	}
+	if _, err := stripMetadata(path, m.stripAll); err != nil {
+		return fmt.Errorf("failed to strip metadata: %w", err)
+	}
+	return nil
+}
+
+// derivativePath returns the path of an already downloaded derivative named base in dir
+func (m *Manager) derivativePath(dir string, base string) (string, bool) {
+	for _, ext := range imageExtensions {
+		path := filepath.Join(dir, base+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// mkdirAll creates dir and any missing parents. With a configured directory mode, each
+// directory created gets exactly that mode
+func (m *Manager) mkdirAll(dir string) error {
+	if m.dirMode == 0 {
+		return os.MkdirAll(dir, 0755)
+	}
+	info, err := os.Stat(dir)
+	if err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := m.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, m.dirMode); err != nil {
+		if os.IsExist(err) {
+			// Created by a concurrent download
+			return nil
+		}
+		return err
+	}
+	return os.Chmod(dir, m.dirMode)
+}
+
+// chmodFile gives a newly created image file the configured file mode, if any
+func (m *Manager) chmodFile(file *os.File) error {
+	if m.fileMode == 0 {
+		return nil
+	}
+	if err := file.Chmod(m.fileMode); err != nil {
+		return fmt.Errorf("failed to set file mode: %w", classifyWriteError(err))
+	}
+	return nil
+}
+
+// CheckWritable verifies that files can be created and written in the image directory
+// Returns an error wrapping ErrImageDirUnwritable if not
+func (m *Manager) CheckWritable() error {
+	file, err := os.CreateTemp(m.imageDir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrImageDirUnwritable, err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write([]byte("write check"))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrImageDirUnwritable, err)
+	}
+	return nil
+}
+
+// classifyWriteError wraps err with ErrImageDirUnwritable if it was caused by the file system
+// refusing writes, as opposed to e.g. a dropped connection while copying a download
+func classifyWriteError(err error) error {
+	switch {
+	case errors.Is(err, syscall.EROFS), errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT),
+		errors.Is(err, os.ErrPermission):
+		return fmt.Errorf("%w: %w", ErrImageDirUnwritable, err)
+	default:
+		return err
+	}
+}
+
+// checkUnchanged issues a HEAD request for a URL that was downloaded before and reports whether
+// it still has the same ETag (and Content-Length) and the file is still on disk
+// Any error, missing ETag, or mismatch returns false so the caller falls back to a full GET
+func (m *Manager) checkUnchanged(imageURL string) (string, string, bool) {
+	if m.urlCache == nil {
+		return "", "", false
+	}
+	hash, etag, err := m.urlCache.GetURLHash(imageURL)
+	if err != nil || hash == "" || etag == "" {
+		return "", "", false
+	}
+
+	path, err := m.GetImagePath(hash)
+	if err != nil && !(m.allowDeleted && errors.Is(err, ErrImageNotFound)) {
+		return "", "", false
+	}
+
+	ctx := context.Background()
+	if timeout := m.downloadTimeout(-1); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, imageURL, nil)
+	if err != nil {
+		return "", "", false
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", "", false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != etag {
+		return "", "", false
+	}
+	m.rememberName(hash, dispositionFilename(resp.Header.Get("Content-Disposition")))
+	if resp.ContentLength >= 0 && path != "" {
+		info, err := os.Stat(path)
+		if err != nil || info.Size() != resp.ContentLength {
+			return "", "", false
+		}
+	}
+	return path, hash, true
+}
+
+// downloadTimeout returns how long a download of size bytes may take (size < 0 if unknown),
+// or 0 for no timeout
+func (m *Manager) downloadTimeout(size int64) time.Duration {
+	if m.timeout <= 0 {
+		return 0
+	}
+	if size <= 0 || m.timeoutPerMB <= 0 {
+		return m.timeout
+	}
+	megabytes := (size + 1<<20 - 1) >> 20 // Round up
+	return m.timeout + time.Duration(megabytes)*m.timeoutPerMB
+}
+
+// rememberURL records the hash a URL was downloaded as, if the server provided an ETag
+// Failures are ignored: the cache only saves bandwidth, so the next run simply downloads again
+func (m *Manager) rememberURL(imageURL string, hash string, etag string) {
+	if m.urlCache == nil || etag == "" {
+		return
+	}
+	m.urlCache.SetURLHash(imageURL, hash, etag)
+}
+
+// newHasher returns a new hash.Hash for the configured algorithm
+func (m *Manager) newHasher() hash.Hash {
+	switch m.hashAlgorithm {
+	case HashSHA1:
+		return sha1.New()
+	case HashBLAKE3:
+		return blake3.New(32, nil)
+	case HashXXHash:
+		return xxhash.New()
+	default:
+		return sha256.New()
+	}
+}
+
+// pixelHash hashes the dimensions and decoded RGBA pixels of a JPEG or PNG image with the
+// configured algorithm. Returns false if the file isn't one of those formats or can't be decoded
+func (m *Manager) pixelHash(path string) (string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	// Only the first frame of a GIF is decoded, so GIFs keep the file hash
+	if _, format, err := image.DecodeConfig(file); err != nil || (format != "jpeg" && format != "png") {
+		return "", false
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", false
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return "", false
+	}
+
+	// Convert a row at a time so large photos don't need a second full-size copy
+	hasher := m.newHasher()
+	bounds := img.Bounds()
+	fmt.Fprintf(hasher, "%dx%d\n", bounds.Dx(), bounds.Dy())
+	row := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), 1))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		draw.Draw(row, row.Bounds(), img, image.Pt(bounds.Min.X, y), draw.Src)
+		hasher.Write(row.Pix)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), true
+}
+
+// dispositionFilename returns the file name in a Content-Disposition header, or "" if the
+// header is missing, can't be parsed, or names no file
+func dispositionFilename(header string) string {
+	if header == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	// ParseMediaType decodes filename*=UTF-8''... into "filename" too
+	name := path.Base(strings.ReplaceAll(params["filename"], "\\", "/"))
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// rememberName records the file name an image was served as, if the server provided one
+func (m *Manager) rememberName(hash string, filename string) {
+	if filename == "" {
+		return
+	}
+	m.namesMu.Lock()
+	defer m.namesMu.Unlock()
+	if m.names == nil {
+		m.names = make(map[string]string)
+	}
+	m.names[hash] = filename
+}
+
+// OriginalName returns the file name (e.g. IMG_1234.HEIC) the image with the given hash was
+// last served as in its Content-Disposition header, or "" if the server didn't send one
+func (m *Manager) OriginalName(hash string) string {
+	m.namesMu.Lock()
+	defer m.namesMu.Unlock()
+	return m.names[hash]
+}
+
+// downloadExtension determines the file extension of a download from the file name in its
+// Content-Disposition header, falling back to its URL and Content-Type
+func (m *Manager) downloadExtension(filename string, url string, contentType string) string {
+	if ext := supportedExtension(filename); ext != "" {
+		return ext
+	}
+	return m.getFileExtension(url, contentType)
+}
+
+// supportedExtension returns the lowercase extension of a URL or file name if images may be
+// stored with it, or ""
+func supportedExtension(location string) string {
+	// Remove query parameters
+	ext := strings.ToLower(strings.Split(filepath.Ext(location), "?")[0])
+	for _, supported := range imageExtensions {
+		if ext == supported {
+			return ext
+		}
+	}
+	return ""
+}
+
 // getFileExtension determines the file extension from URL or Content-Type
 func (m *Manager) getFileExtension(url, contentType string) string {
 	// Try to get extension from URL
-	if ext := filepath.Ext(url); ext != "" {
-		// Remove query parameters
-		ext = strings.Split(ext, "?")[0]
-		if ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".gif" || ext == ".webp" {
-			return ext
-		}
+	if ext := supportedExtension(url); ext != "" {
+		return ext
 	}
 
 	// Try to get extension from Content-Type
@@ -116,16 +832,449 @@ func (m *Manager) getFileExtension(url, contentType string) string {
 	}
 }
 
+// mediaExtensions maps extensions of formats iCloud serves to MIME types, since the standard
+// library's table doesn't include HEIC or most video formats
+var mediaExtensions = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".heic": "image/heic",
+	".mov":  "video/quicktime",
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+}
+
+// ParseMediaType normalizes an allow/block list entry to a MIME type or type family: an
+// extension ("gif", ".JPG") becomes its MIME type, and "image/*" style wildcards are kept
+func ParseMediaType(entry string) (string, error) {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	if strings.Contains(entry, "/") {
+		major, minor, ok := strings.Cut(entry, "/")
+		if !ok || major == "" || minor == "" || strings.Contains(minor, "/") {
+			return "", fmt.Errorf("invalid media type %q", entry)
+		}
+		return entry, nil
+	}
+
+	ext := entry
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	if mediaType, ok := mediaExtensions[ext]; ok {
+		return mediaType, nil
+	}
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(ext)); err == nil {
+		return mediaType, nil
+	}
+	return "", fmt.Errorf("unknown file extension %q", entry)
+}
+
+// mediaType returns the MIME type of a download from its Content-Type or, if the server didn't
+// send a specific one, the extension in its URL or path
+func mediaType(location string, contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType != "application/octet-stream" {
+		return mediaType
+	}
+	ext := strings.ToLower(strings.Split(filepath.Ext(location), "?")[0])
+	if mediaType, ok := mediaExtensions[ext]; ok {
+		return mediaType
+	}
+	mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+	return mediaType
+}
+
+// statusError returns the error for an unsuccessful download response: ErrURLExpired for the
+// statuses iCloud's CDN serves for expired URLs, or a plain status error for transient ones
+func statusError(code int) error {
+	switch code {
+	case http.StatusForbidden, http.StatusNotFound, http.StatusGone:
+		return fmt.Errorf("%w: status code %d", ErrURLExpired, code)
+	}
+	return fmt.Errorf("unexpected status code: %d", code)
+}
+
+// checkResolution returns ErrImageTooSmall if the image at path is below the minimum width or
+// height. Files whose dimensions can't be decoded are allowed
+func (m *Manager) checkResolution(path string) error {
+	if m.minWidth <= 0 && m.minHeight <= 0 {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil // Reading the file will fail later with a clearer error
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil
+	}
+	if config.Width < m.minWidth || config.Height < m.minHeight {
+		return fmt.Errorf("%w: %dx%d is below %dx%d", ErrImageTooSmall, config.Width, config.Height, m.minWidth, m.minHeight)
+	}
+	return nil
+}
+
+// checkType returns ErrTypeNotAllowed if a media type is blocked, or an allow list is set and
+// doesn't include it
+func (m *Manager) checkType(mediaType string) error {
+	if matchMediaType(m.blockedTypes, mediaType) {
+		return fmt.Errorf("%w: %s is blocked", ErrTypeNotAllowed, mediaType)
+	}
+	if len(m.allowedTypes) > 0 && !matchMediaType(m.allowedTypes, mediaType) {
+		if mediaType == "" {
+			mediaType = "unknown type"
+		}
+		return fmt.Errorf("%w: %s is not in the allowed types", ErrTypeNotAllowed, mediaType)
+	}
+	return nil
+}
+
+// matchMediaType reports whether mediaType matches any of patterns
+func matchMediaType(patterns []string, mediaType string) bool {
+	if mediaType == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if pattern == mediaType {
+			return true
+		}
+		if family, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(mediaType, family+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// hashDir returns the directory a file with the given hash is stored in under the configured layout
+func (m *Manager) hashDir(hash string, album string) string {
+	dir := m.imageDir
+	if (m.layout == LayoutAlbum || m.layout == LayoutAlbumHash) && album != "" {
+		dir = filepath.Join(dir, sanitizeDirName(album))
+	}
+	if m.layout == LayoutHash || m.layout == LayoutAlbumHash {
+		dir = filepath.Join(dir, shardDir(hash))
+	}
+	return dir
+}
+
+// shardDir returns the subdirectory the hash and album-hash layouts store an image in: the
+// first two characters of its hash, or "" for a hash too short to shard
+func shardDir(hash string) string {
+	if len(hash) < 2 {
+		return ""
+	}
+	return hash[:2]
+}
+
+// sanitizeDirName makes an album name safe to use as a single directory name
+func sanitizeDirName(name string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == ' ', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	sanitized = strings.Trim(sanitized, " .")
+	if sanitized == "" {
+		return "album"
+	}
+	if sanitized == QuarantineDirName || sanitized == DerivativesDirName {
+		// Don't let an album collide with the quarantine or derivatives directory
+		return "album_" + sanitized
+	}
+	return sanitized
+}
+
 // GetImagePath returns the path to an image by hash
+// Every layout is searched so files stored before a layout change are still found
 func (m *Manager) GetImagePath(hash string) (string, error) {
-	// Try common extensions
-	extensions := []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
-	for _, ext := range extensions {
-		path := filepath.Join(m.imageDir, hash+ext)
-		if _, err := os.Stat(path); err == nil {
-			return path, nil
+	// The directory the current layout stores the hash in is checked first, then the flat
+	// layout of older versions and the hash-prefix layout, which can be checked directly
+	dirs := []string{m.hashDir(hash, "")}
+	for _, dir := range []string{m.imageDir, filepath.Join(m.imageDir, shardDir(hash))} {
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
 		}
 	}
-	return "", fmt.Errorf("image not found for hash: %s", hash)
+	for _, dir := range dirs {
+		for _, ext := range imageExtensions {
+			path := filepath.Join(dir, hash+ext)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+		}
+	}
+
+	// Album layouts require looking inside each album directory
+	for _, ext := range imageExtensions {
+		patterns := []string{filepath.Join(m.imageDir, "*", hash+ext)}
+		if shard := shardDir(hash); shard != "" {
+			patterns = append(patterns, filepath.Join(m.imageDir, "*", shard, hash+ext))
+		}
+		for _, pattern := range patterns {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				continue
+			}
+			for _, match := range matches {
+				if strings.HasPrefix(match, filepath.Join(m.imageDir, QuarantineDirName)+string(filepath.Separator)) {
+					continue
+				}
+				return match, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w for hash: %s", ErrImageNotFound, hash)
+}
+
+// StoredImage is an image file in the image directory
+type StoredImage struct {
+	Path string
+	Hash string // The hash the file is stored under (its name without the extension)
+}
+
+// ListImages returns every image stored in the image directory under any layout, leaving
+// out quarantined images, derivatives, and unfinished downloads
+func (m *Manager) ListImages() ([]StoredImage, error) {
+	var images []StoredImage
+	err := filepath.WalkDir(m.imageDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if filepath.Dir(path) == m.imageDir && (d.Name() == QuarantineDirName || d.Name() == DerivativesDirName) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := filepath.Ext(d.Name())
+		if !slices.Contains(imageExtensions, ext) || strings.HasPrefix(d.Name(), "download-") {
+			return nil
+		}
+		images = append(images, StoredImage{Path: path, Hash: strings.TrimSuffix(d.Name(), ext)})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	return images, nil
+}
+
+// HashFile calculates the hash of a stored file with the configured algorithm and content,
+// i.e. the hash downloading the same file would give it now
+func (m *Manager) HashFile(path string) (string, error) {
+	if m.hashContent == HashContentPixels {
+		if pixelHash, ok := m.pixelHash(path); ok {
+			return pixelHash, nil
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open image: %w", err)
+	}
+	defer file.Close()
+	hasher := m.newHasher()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", fmt.Errorf("failed to hash image: %w", err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
 
+// RenameImage moves a stored image, and any derivatives downloaded for it, to the name it has
+// under a new hash, keeping it in its album directory. If a file with the new hash is already
+// stored there, the image is removed instead. Returns the new path
+func (m *Manager) RenameImage(imagePath string, newHash string) (string, error) {
+	ext := filepath.Ext(imagePath)
+	oldHash := strings.TrimSuffix(filepath.Base(imagePath), ext)
+	dir := filepath.Dir(imagePath)
+	if dir != m.imageDir && len(oldHash) >= 2 && filepath.Base(dir) == oldHash[:2] {
+		dir = filepath.Dir(dir)
+	}
+	if (m.layout == LayoutHash || m.layout == LayoutAlbumHash) && len(newHash) >= 2 {
+		dir = filepath.Join(dir, newHash[:2])
+	}
+	newPath := filepath.Join(dir, newHash+ext)
+
+	if _, err := os.Stat(newPath); err == nil {
+		if err := os.Remove(imagePath); err != nil {
+			return "", fmt.Errorf("failed to remove duplicate image: %w", err)
+		}
+	} else {
+		if err := m.mkdirAll(dir); err != nil {
+			return "", fmt.Errorf("failed to create image subdirectory: %w", classifyWriteError(err))
+		}
+		if err := os.Rename(imagePath, newPath); err != nil {
+			return "", fmt.Errorf("failed to rename image: %w", classifyWriteError(err))
+		}
+	}
+
+	derivatives, _ := filepath.Glob(filepath.Join(m.imageDir, DerivativesDirName, oldHash+"-*"))
+	for _, derivative := range derivatives {
+		renamed := filepath.Join(filepath.Dir(derivative), newHash+strings.TrimPrefix(filepath.Base(derivative), oldHash))
+		if err := os.Rename(derivative, renamed); err != nil {
+			return "", fmt.Errorf("failed to rename derivative: %w", err)
+		}
+	}
+	return newPath, nil
+}
+
+// DeleteImage removes a delivered image, and any derivatives downloaded for it, from the image
+// directory. A file that is already gone is not an error
+func (m *Manager) DeleteImage(imagePath string) error {
+	if err := os.Remove(imagePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete image: %w", err)
+	}
+	hash := strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+	derivatives, _ := filepath.Glob(filepath.Join(m.imageDir, DerivativesDirName, hash+"-*"))
+	for _, derivative := range derivatives {
+		if err := os.Remove(derivative); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete derivative: %w", err)
+		}
+	}
+	return nil
+}
+
+// QuarantineImage moves an image into the quarantine subdirectory
+// If a quarantined copy already exists, the source file is removed instead
+// Returns the path of the quarantined file
+func (m *Manager) QuarantineImage(imagePath string) (string, error) {
+	quarantineDir := filepath.Join(m.imageDir, QuarantineDirName)
+	if err := m.mkdirAll(quarantineDir); err != nil {
+		return "", fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+
+	quarantinePath := filepath.Join(quarantineDir, filepath.Base(imagePath))
+	if _, err := os.Stat(quarantinePath); err == nil {
+		if err := os.Remove(imagePath); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to remove already quarantined file: %w", err)
+		}
+		return quarantinePath, nil
+	}
+
+	if err := os.Rename(imagePath, quarantinePath); err != nil {
+		return "", fmt.Errorf("failed to move file to quarantine: %w", err)
+	}
+	return quarantinePath, nil
+}
+
+// ZipArchive is a zip file created from a set of images
+type ZipArchive struct {
+	Path       string   // Path of the zip file (in the system temp directory; caller removes it)
+	ImagePaths []string // Images contained in the zip
+}
+
+// CreateZipArchives bundles the given images into one or more zip files in the system temp directory
+// A new zip is started whenever adding the next image would exceed maxBytes of image data
+// (an image larger than maxBytes gets a zip of its own). maxBytes <= 0 means no limit.
+func (m *Manager) CreateZipArchives(imagePaths []string, maxBytes int64) ([]ZipArchive, error) {
+	var archives []ZipArchive
+	var batch []string
+	var batchBytes int64
+
+	removeArchives := func() {
+		for _, archive := range archives {
+			os.Remove(archive.Path)
+		}
+	}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		zipPath, err := writeZip(batch)
+		if err != nil {
+			return err
+		}
+		archives = append(archives, ZipArchive{Path: zipPath, ImagePaths: batch})
+		batch = nil
+		batchBytes = 0
+		return nil
+	}
+
+	for _, imagePath := range imagePaths {
+		info, err := os.Stat(imagePath)
+		if err != nil {
+			removeArchives()
+			return nil, fmt.Errorf("failed to stat image %s: %w", imagePath, err)
+		}
+		if maxBytes > 0 && len(batch) > 0 && batchBytes+info.Size() > maxBytes {
+			if err := flush(); err != nil {
+				removeArchives()
+				return nil, err
+			}
+		}
+		batch = append(batch, imagePath)
+		batchBytes += info.Size()
+	}
+	if err := flush(); err != nil {
+		removeArchives()
+		return nil, err
+	}
+
+	return archives, nil
+}
+
+// writeZip writes the given images into a new temporary zip file and returns its path
+func writeZip(imagePaths []string) (string, error) {
+	zipFile, err := os.CreateTemp("", "icloud-photos-*.zip")
+	if err != nil {
+		return "", fmt.Errorf("failed to create zip file: %w", err)
+	}
+	zipPath := zipFile.Name()
+
+	writer := zip.NewWriter(zipFile)
+	for _, imagePath := range imagePaths {
+		if err := addFileToZip(writer, imagePath); err != nil {
+			writer.Close()
+			zipFile.Close()
+			os.Remove(zipPath)
+			return "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		zipFile.Close()
+		os.Remove(zipPath)
+		return "", fmt.Errorf("failed to finalize zip file: %w", err)
+	}
+	if err := zipFile.Close(); err != nil {
+		os.Remove(zipPath)
+		return "", fmt.Errorf("failed to close zip file: %w", err)
+	}
+	return zipPath, nil
+}
+
+// addFileToZip copies a single file into the zip writer under its base name
+func addFileToZip(writer *zip.Writer, imagePath string) error {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to open image %s: %w", imagePath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat image %s: %w", imagePath, err)
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return fmt.Errorf("failed to create zip header for %s: %w", imagePath, err)
+	}
+	header.Name = filepath.Base(imagePath)
+	// Images are already compressed, so store them as-is
+	header.Method = zip.Store
+
+	entry, err := writer.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to zip: %w", imagePath, err)
+	}
+	if _, err := io.Copy(entry, file); err != nil {
+		return fmt.Errorf("failed to write %s to zip: %w", imagePath, err)
+	}
+	return nil
+}
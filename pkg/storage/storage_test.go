@@ -1,13 +1,31 @@
 package storage
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"lukechampine.com/blake3"
 )
 
 func TestManager_DownloadAndHash(t *testing.T) {
@@ -104,6 +122,194 @@ func TestManager_DownloadAndHash_Duplicate(t *testing.T) {
 	}
 }
 
+func TestManager_DownloadDerivative(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("small image"))
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	manager, err := NewManager(tmpDir)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	imagePath := filepath.Join(tmpDir, "abc123.jpg")
+	if err := os.WriteFile(imagePath, []byte("original image"), 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+
+	path, err := manager.DownloadDerivative(server.URL, "abc123", "1280x960")
+	if err != nil {
+		t.Fatalf("DownloadDerivative() error = %v", err)
+	}
+	wantPath := filepath.Join(tmpDir, DerivativesDirName, "abc123-1280x960.jpg")
+	if path != wantPath {
+		t.Errorf("DownloadDerivative() = %v, want %v", path, wantPath)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "small image" {
+		t.Errorf("derivative content = %q, %v, want %q", data, err, "small image")
+	}
+
+	// A size already on disk is reused without downloading it again
+	if again, err := manager.DownloadDerivative(server.URL, "abc123", "1280x960"); err != nil || again != path {
+		t.Errorf("DownloadDerivative() again = %v, %v, want %v", again, err, path)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("server got %d requests, want 1", got)
+	}
+
+	// The derivative isn't mistaken for a stored image
+	if found, err := manager.GetImagePath("abc123"); err != nil || found != imagePath {
+		t.Errorf("GetImagePath() = %v, %v, want %v", found, err, imagePath)
+	}
+
+	// Deleting the image deletes its derivatives
+	if err := manager.DeleteImage(imagePath); err != nil {
+		t.Fatalf("DeleteImage() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("derivative still exists after DeleteImage(): %v", err)
+	}
+}
+
+func TestManager_DownloadAndHash_URLExpired(t *testing.T) {
+	tests := []struct {
+		status      int
+		wantExpired bool
+	}{
+		{http.StatusForbidden, true},
+		{http.StatusNotFound, true},
+		{http.StatusGone, true},
+		{http.StatusInternalServerError, false},
+		{http.StatusTooManyRequests, false},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			manager, err := NewManager(t.TempDir())
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+			_, _, err = manager.DownloadAndHash(server.URL)
+			if err == nil {
+				t.Fatal("DownloadAndHash() error = nil, want an error")
+			}
+			if got := errors.Is(err, ErrURLExpired); got != tt.wantExpired {
+				t.Errorf("errors.Is(%v, ErrURLExpired) = %v, want %v", err, got, tt.wantExpired)
+			}
+			if _, err := manager.DownloadDerivative(server.URL, "abc123", "1280x960"); errors.Is(err, ErrURLExpired) != tt.wantExpired {
+				t.Errorf("DownloadDerivative() error = %v, want expired %v", err, tt.wantExpired)
+			}
+		})
+	}
+}
+
+func TestManager_MaxDownloadsPerHost(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("image " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	manager, err := NewManagerWithOptions(t.TempDir(), Options{MaxDownloadsPerHost: 2})
+	if err != nil {
+		t.Fatalf("NewManagerWithOptions() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, _, err := manager.DownloadAndHash(fmt.Sprintf("%s/%d.jpg", server.URL, i)); err != nil {
+				t.Errorf("DownloadAndHash() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := peak.Load(); got > 2 {
+		t.Errorf("peak downloads in flight = %d, want at most 2", got)
+	}
+}
+
+func TestManager_DownloadAndHash_Concurrent(t *testing.T) {
+	testImageData := []byte("fake image data downloaded concurrently")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(testImageData)
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	manager, err := NewManager(tmpDir)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	const goroutines = 16
+	paths := make([]string, goroutines)
+	hashes := make([]string, goroutines)
+	errs := make([]error, goroutines)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			paths[i], hashes[i], errs[i] = manager.DownloadAndHash(server.URL + "/image.jpg")
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i := 0; i < goroutines; i++ {
+		if errs[i] != nil {
+			t.Fatalf("DownloadAndHash() error = %v", errs[i])
+		}
+		if paths[i] != paths[0] || hashes[i] != hashes[0] {
+			t.Errorf("DownloadAndHash() = (%s, %s), want (%s, %s)", paths[i], hashes[i], paths[0], hashes[0])
+		}
+	}
+
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 {
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Fatalf("image directory contains %v, want exactly one file", names)
+	}
+	content, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != string(testImageData) {
+		t.Errorf("file content = %q, want %q", content, testImageData)
+	}
+}
+
 func TestManager_GetFileExtension(t *testing.T) {
 	manager := &Manager{}
 
@@ -131,6 +337,12 @@ func TestManager_GetFileExtension(t *testing.T) {
 			contentType: "image/unknown",
 			want:        ".jpg",
 		},
+		{
+			name:        "uppercase extension",
+			url:         "https://example.com/IMG_1234.PNG",
+			contentType: "image/jpeg",
+			want:        ".png",
+		},
 		{
 			name:        "remove query params",
 			url:         "https://example.com/image.jpg?width=100",
@@ -149,6 +361,70 @@ func TestManager_GetFileExtension(t *testing.T) {
 	}
 }
 
+func TestManager_DownloadAndHash_ContentDisposition(t *testing.T) {
+	tests := []struct {
+		name         string
+		disposition  string
+		wantExt      string
+		wantOriginal string
+	}{
+		{name: "filename", disposition: `attachment; filename="IMG_1234.PNG"`, wantExt: ".png", wantOriginal: "IMG_1234.PNG"},
+		{name: "encoded filename", disposition: `attachment; filename*=UTF-8''Sommer%20Foto.webp`, wantExt: ".webp", wantOriginal: "Sommer Foto.webp"},
+		{name: "path in filename", disposition: `attachment; filename="../../IMG_1.gif"`, wantExt: ".gif", wantOriginal: "IMG_1.gif"},
+		{name: "unsupported extension falls back to Content-Type", disposition: `attachment; filename="IMG_1234.HEIC"`, wantExt: ".jpg", wantOriginal: "IMG_1234.HEIC"},
+		{name: "unparseable", disposition: `attachment; filename="IMG_1234.png`, wantExt: ".jpg"},
+		{name: "missing", wantExt: ".jpg"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte(fmt.Sprintf("image %d", i))
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.disposition != "" {
+					w.Header().Set("Content-Disposition", tt.disposition)
+				}
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write(data)
+			}))
+			defer server.Close()
+
+			manager, err := NewManager(t.TempDir())
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+			// The URL has no extension, as on the iCloud CDN
+			path, hash, err := manager.DownloadAndHash(server.URL + "/B/AbCdEf")
+			if err != nil {
+				t.Fatalf("DownloadAndHash() error = %v", err)
+			}
+			if filepath.Ext(path) != tt.wantExt {
+				t.Errorf("DownloadAndHash() path = %s, want extension %s", path, tt.wantExt)
+			}
+			if got := manager.OriginalName(hash); got != tt.wantOriginal {
+				t.Errorf("OriginalName() = %q, want %q", got, tt.wantOriginal)
+			}
+		})
+	}
+}
+
+func TestManager_DownloadAndHash_ContentDispositionType(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="IMG_1234.MOV"`)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte("video"))
+	}))
+	defer server.Close()
+
+	manager, err := NewManagerWithOptions(t.TempDir(), Options{BlockedTypes: []string{"video/*"}})
+	if err != nil {
+		t.Fatalf("NewManagerWithOptions() error = %v", err)
+	}
+	// The file name identifies the download as a video though neither URL nor type does
+	if _, _, err := manager.DownloadAndHash(server.URL + "/B/AbCdEf"); !errors.Is(err, ErrTypeNotAllowed) {
+		t.Errorf("DownloadAndHash() error = %v, want ErrTypeNotAllowed", err)
+	}
+}
+
 func TestManager_GetImagePath(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "storage-test-*")
 	if err != nil {
@@ -162,7 +438,7 @@ func TestManager_GetImagePath(t *testing.T) {
 	}
 
 	hash := "testhash123"
-	
+
 	// Create a test file
 	testFile := filepath.Join(tmpDir, hash+".jpg")
 	err = os.WriteFile(testFile, []byte("test"), 0644)
@@ -186,6 +462,98 @@ func TestManager_GetImagePath(t *testing.T) {
 	}
 }
 
+func TestManager_GetImagePath_Sharded(t *testing.T) {
+	tmpDir := t.TempDir()
+	manager, err := NewManagerWithOptions(tmpDir, Options{Layout: LayoutHash})
+	if err != nil {
+		t.Fatalf("NewManagerWithOptions() error = %v", err)
+	}
+
+	// Stored under the hash layout's shard directory
+	sharded := filepath.Join(tmpDir, "ab", "abcdef.png")
+	if err := os.MkdirAll(filepath.Dir(sharded), 0755); err != nil {
+		t.Fatalf("Failed to create shard directory: %v", err)
+	}
+	if err := os.WriteFile(sharded, []byte("sharded"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	// Stored flat by a version from before the layout was changed
+	flat := filepath.Join(tmpDir, "cd1234.jpg")
+	if err := os.WriteFile(flat, []byte("flat"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for hash, want := range map[string]string{"abcdef": sharded, "cd1234": flat} {
+		if path, err := manager.GetImagePath(hash); err != nil || path != want {
+			t.Errorf("GetImagePath(%s) = %v, %v, want %v", hash, path, err, want)
+		}
+	}
+	if _, err := manager.GetImagePath("a"); !errors.Is(err, ErrImageNotFound) {
+		t.Errorf("GetImagePath() error = %v for a hash too short to shard, want ErrImageNotFound", err)
+	}
+}
+
+func TestManager_RehashImages(t *testing.T) {
+	tmpDir := t.TempDir()
+	manager, err := NewManagerWithOptions(tmpDir, Options{Layout: LayoutAlbumHash, HashAlgorithm: HashSHA1})
+	if err != nil {
+		t.Fatalf("NewManagerWithOptions() error = %v", err)
+	}
+
+	// An image stored by sha256 in an album directory, with a derivative
+	data := []byte("stored image")
+	sha256Sum := sha256.Sum256(data)
+	oldHash := hex.EncodeToString(sha256Sum[:])
+	oldPath := filepath.Join(tmpDir, "Summer", oldHash[:2], oldHash+".jpg")
+	derivativePath := filepath.Join(tmpDir, DerivativesDirName, oldHash+"-320x240.jpg")
+	for _, path := range []string{oldPath, derivativePath} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write image: %v", err)
+		}
+	}
+	// Neither quarantined images nor unfinished downloads are listed
+	os.MkdirAll(filepath.Join(tmpDir, QuarantineDirName), 0755)
+	os.WriteFile(filepath.Join(tmpDir, QuarantineDirName, "bad.jpg"), data, 0644)
+	os.WriteFile(filepath.Join(tmpDir, "download-123.jpg"), data, 0644)
+
+	images, err := manager.ListImages()
+	if err != nil {
+		t.Fatalf("ListImages() error = %v", err)
+	}
+	if len(images) != 1 || images[0].Path != oldPath || images[0].Hash != oldHash {
+		t.Fatalf("ListImages() = %+v, want only %s", images, oldPath)
+	}
+
+	newHash, err := manager.HashFile(oldPath)
+	if err != nil {
+		t.Fatalf("HashFile() error = %v", err)
+	}
+	sha1Sum := sha1.Sum(data)
+	if want := hex.EncodeToString(sha1Sum[:]); newHash != want {
+		t.Errorf("HashFile() = %s, want %s", newHash, want)
+	}
+
+	newPath, err := manager.RenameImage(oldPath, newHash)
+	if err != nil {
+		t.Fatalf("RenameImage() error = %v", err)
+	}
+	if want := filepath.Join(tmpDir, "Summer", newHash[:2], newHash+".jpg"); newPath != want {
+		t.Errorf("RenameImage() = %s, want %s", newPath, want)
+	}
+	if found, err := manager.GetImagePath(newHash); err != nil || found != newPath {
+		t.Errorf("GetImagePath() = %v, %v, want %v", found, err, newPath)
+	}
+	if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+		t.Errorf("old image still exists after RenameImage(): %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, DerivativesDirName, newHash+"-320x240.jpg")); err != nil {
+		t.Errorf("derivative not renamed: %v", err)
+	}
+}
+
 func TestManager_NewManager_CreatesDirectory(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "storage-test-*")
 	if err != nil {
@@ -209,3 +577,786 @@ func TestManager_NewManager_CreatesDirectory(t *testing.T) {
 	}
 }
 
+func TestManager_QuarantineImage(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	manager, err := NewManager(tmpDir)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	imagePath := filepath.Join(tmpDir, "badhash.jpg")
+	if err := os.WriteFile(imagePath, []byte("bad"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	quarantinePath, err := manager.QuarantineImage(imagePath)
+	if err != nil {
+		t.Fatalf("QuarantineImage() error = %v", err)
+	}
+
+	wantPath := filepath.Join(tmpDir, QuarantineDirName, "badhash.jpg")
+	if quarantinePath != wantPath {
+		t.Errorf("QuarantineImage() = %v, want %v", quarantinePath, wantPath)
+	}
+	if _, err := os.Stat(quarantinePath); err != nil {
+		t.Errorf("quarantined file does not exist: %v", err)
+	}
+	if _, err := os.Stat(imagePath); !os.IsNotExist(err) {
+		t.Error("original file should have been moved")
+	}
+
+	// Quarantining a re-downloaded copy should remove it and keep the existing quarantined file
+	if err := os.WriteFile(imagePath, []byte("bad"), 0644); err != nil {
+		t.Fatalf("Failed to recreate test file: %v", err)
+	}
+	if _, err := manager.QuarantineImage(imagePath); err != nil {
+		t.Fatalf("QuarantineImage() second call error = %v", err)
+	}
+	if _, err := os.Stat(imagePath); !os.IsNotExist(err) {
+		t.Error("re-downloaded file should have been removed")
+	}
+}
+
+func TestManager_DownloadAndHash_Layouts(t *testing.T) {
+	testImageData := []byte("layout test image")
+	hashBytes := sha256.Sum256(testImageData)
+	hash := hex.EncodeToString(hashBytes[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.WriteHeader(http.StatusOK)
+		w.Write(testImageData)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		layout  Layout
+		album   string
+		wantDir []string
+	}{
+		{layout: LayoutFlat, album: "Family", wantDir: nil},
+		{layout: LayoutHash, album: "Family", wantDir: []string{hash[:2]}},
+		{layout: LayoutAlbum, album: "Family/Trip", wantDir: []string{"Family_Trip"}},
+		{layout: LayoutAlbumHash, album: "Family", wantDir: []string{"Family", hash[:2]}},
+		{layout: LayoutAlbum, album: "", wantDir: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.layout)+"/"+tt.album, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			manager, err := NewManagerWithOptions(tmpDir, Options{Layout: tt.layout})
+			if err != nil {
+				t.Fatalf("NewManagerWithOptions() error = %v", err)
+			}
+
+			imagePath, _, err := manager.DownloadAndHashForAlbum(server.URL, tt.album)
+			if err != nil {
+				t.Fatalf("DownloadAndHashForAlbum() error = %v", err)
+			}
+
+			wantPath := filepath.Join(append(append([]string{tmpDir}, tt.wantDir...), hash+".jpg")...)
+			if imagePath != wantPath {
+				t.Errorf("DownloadAndHashForAlbum() path = %v, want %v", imagePath, wantPath)
+			}
+
+			// GetImagePath must find the file regardless of the layout it was stored with
+			flatManager, err := NewManager(tmpDir)
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+			found, err := flatManager.GetImagePath(hash)
+			if err != nil {
+				t.Fatalf("GetImagePath() error = %v", err)
+			}
+			if found != wantPath {
+				t.Errorf("GetImagePath() = %v, want %v", found, wantPath)
+			}
+		})
+	}
+}
+
+func TestManager_FileModes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("private image"))
+	}))
+	defer server.Close()
+
+	// Modes are applied as configured: a typical umask of 022 would drop the group write bits
+	imageDir := filepath.Join(t.TempDir(), "images")
+	manager, err := NewManagerWithOptions(imageDir, Options{Layout: LayoutHash, DirMode: 0770, FileMode: 0660})
+	if err != nil {
+		t.Fatalf("NewManagerWithOptions() error = %v", err)
+	}
+	path, hash, err := manager.DownloadAndHash(server.URL + "/photo.jpg")
+	if err != nil {
+		t.Fatalf("DownloadAndHash() error = %v", err)
+	}
+	derivative, err := manager.DownloadDerivative(server.URL+"/small.jpg", hash, "320x240")
+	if err != nil {
+		t.Fatalf("DownloadDerivative() error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		path string
+		want os.FileMode
+	}{
+		{imageDir, 0770},
+		{filepath.Dir(path), 0770},
+		{filepath.Dir(derivative), 0770},
+		{path, 0660},
+		{derivative, 0660},
+	} {
+		info, err := os.Stat(tt.path)
+		if err != nil {
+			t.Fatalf("Stat(%s) error = %v", tt.path, err)
+		}
+		if got := info.Mode().Perm(); got != tt.want {
+			t.Errorf("mode of %s = %04o, want %04o", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNewManagerWithOptions_InvalidLayout(t *testing.T) {
+	_, err := NewManagerWithOptions(t.TempDir(), Options{Layout: "by-date"})
+	if err == nil {
+		t.Error("NewManagerWithOptions() expected error for unknown layout")
+	}
+}
+
+func TestManager_DownloadAndHash_HashAlgorithms(t *testing.T) {
+	testImageData := []byte("hash algorithm test image")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.WriteHeader(http.StatusOK)
+		w.Write(testImageData)
+	}))
+	defer server.Close()
+
+	sha256Sum := sha256.Sum256(testImageData)
+	sha1Sum := sha1.Sum(testImageData)
+	blake3Sum := blake3.Sum256(testImageData)
+
+	tests := []struct {
+		algorithm HashAlgorithm
+		wantHash  string
+	}{
+		{algorithm: "", wantHash: hex.EncodeToString(sha256Sum[:])},
+		{algorithm: HashSHA256, wantHash: hex.EncodeToString(sha256Sum[:])},
+		{algorithm: HashSHA1, wantHash: hex.EncodeToString(sha1Sum[:])},
+		{algorithm: HashBLAKE3, wantHash: hex.EncodeToString(blake3Sum[:])},
+		{algorithm: HashXXHash, wantHash: fmt.Sprintf("%016x", xxhash.Sum64(testImageData))},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.algorithm), func(t *testing.T) {
+			manager, err := NewManagerWithOptions(t.TempDir(), Options{HashAlgorithm: tt.algorithm})
+			if err != nil {
+				t.Fatalf("NewManagerWithOptions() error = %v", err)
+			}
+
+			_, hash, err := manager.DownloadAndHash(server.URL)
+			if err != nil {
+				t.Fatalf("DownloadAndHash() error = %v", err)
+			}
+			if hash != tt.wantHash {
+				t.Errorf("DownloadAndHash() hash = %v, want %v", hash, tt.wantHash)
+			}
+		})
+	}
+}
+
+func TestNewManagerWithOptions_InvalidHashAlgorithm(t *testing.T) {
+	_, err := NewManagerWithOptions(t.TempDir(), Options{HashAlgorithm: "md5"})
+	if err == nil {
+		t.Error("NewManagerWithOptions() expected error for unknown hash algorithm")
+	}
+}
+
+func TestManager_DownloadAndHash_PixelHash(t *testing.T) {
+	encode := func(c color.Color) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 16; x++ {
+				img.Set(x, y, c)
+			}
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, nil); err != nil {
+			t.Fatalf("jpeg.Encode() error = %v", err)
+		}
+		return buf.Bytes()
+	}
+	original := encode(color.RGBA{R: 200, G: 40, B: 40, A: 255})
+	// The same JPEG with an EXIF segment inserted after the SOI marker
+	exif := []byte{0xFF, 0xE1, 0x00, 0x0E, 'E', 'x', 'i', 'f', 0, 0, 'M', 'M', 0, 42, 0, 0, 0, 8}
+	withExif := append(append(append([]byte{}, original[:2]...), exif...), original[2:]...)
+	different := encode(color.RGBA{R: 40, G: 40, B: 200, A: 255})
+	notAnImage := []byte("not an image")
+
+	files := map[string][]byte{
+		"/original.jpg":  original,
+		"/exif.jpg":      withExif,
+		"/different.jpg": different,
+		"/text.jpg":      notAnImage,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(files[r.URL.Path])
+	}))
+	defer server.Close()
+
+	hashOf := func(content HashContent, path string) string {
+		manager, err := NewManagerWithOptions(t.TempDir(), Options{HashContent: content})
+		if err != nil {
+			t.Fatalf("NewManagerWithOptions() error = %v", err)
+		}
+		_, hash, err := manager.DownloadAndHash(server.URL + path)
+		if err != nil {
+			t.Fatalf("DownloadAndHash(%s) error = %v", path, err)
+		}
+		return hash
+	}
+
+	if hashOf(HashContentFile, "/original.jpg") == hashOf(HashContentFile, "/exif.jpg") {
+		t.Error("file hashes of the original and EXIF-edited copy are equal, want different")
+	}
+	if got, want := hashOf(HashContentPixels, "/exif.jpg"), hashOf(HashContentPixels, "/original.jpg"); got != want {
+		t.Errorf("pixel hash of EXIF-edited copy = %v, want %v", got, want)
+	}
+	if hashOf(HashContentPixels, "/different.jpg") == hashOf(HashContentPixels, "/original.jpg") {
+		t.Error("pixel hashes of different images are equal, want different")
+	}
+	sum := sha256.Sum256(notAnImage)
+	if got, want := hashOf(HashContentPixels, "/text.jpg"), hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("pixel hash of undecodable file = %v, want file hash %v", got, want)
+	}
+}
+
+func TestNewManagerWithOptions_InvalidHashContent(t *testing.T) {
+	_, err := NewManagerWithOptions(t.TempDir(), Options{HashContent: "exif"})
+	if err == nil {
+		t.Error("NewManagerWithOptions() expected error for unknown hash content")
+	}
+}
+
+func TestManager_CreateZipArchives(t *testing.T) {
+	tmpDir := t.TempDir()
+	manager, err := NewManager(tmpDir)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	var imagePaths []string
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		imagePaths = append(imagePaths, path)
+	}
+
+	// 250 bytes fits two 100-byte images per zip
+	archives, err := manager.CreateZipArchives(imagePaths, 250)
+	if err != nil {
+		t.Fatalf("CreateZipArchives() error = %v", err)
+	}
+	defer func() {
+		for _, archive := range archives {
+			os.Remove(archive.Path)
+		}
+	}()
+
+	if len(archives) != 2 {
+		t.Fatalf("CreateZipArchives() created %d archives, want 2", len(archives))
+	}
+	if len(archives[0].ImagePaths) != 2 || len(archives[1].ImagePaths) != 1 {
+		t.Errorf("CreateZipArchives() split = %d/%d, want 2/1", len(archives[0].ImagePaths), len(archives[1].ImagePaths))
+	}
+
+	reader, err := zip.OpenReader(archives[0].Path)
+	if err != nil {
+		t.Fatalf("Failed to open zip: %v", err)
+	}
+	defer reader.Close()
+	if len(reader.File) != 2 || reader.File[0].Name != "a.jpg" || reader.File[1].Name != "b.jpg" {
+		t.Errorf("zip contents unexpected: %v", reader.File)
+	}
+
+	// No limit puts everything in one zip
+	single, err := manager.CreateZipArchives(imagePaths, 0)
+	if err != nil {
+		t.Fatalf("CreateZipArchives() error = %v", err)
+	}
+	defer os.Remove(single[0].Path)
+	if len(single) != 1 || len(single[0].ImagePaths) != 3 {
+		t.Errorf("CreateZipArchives() without limit = %d archives, want 1 with 3 images", len(single))
+	}
+}
+
+type memoryURLCache map[string][2]string
+
+func (c memoryURLCache) GetURLHash(imageURL string) (string, string, error) {
+	entry := c[imageURL]
+	return entry[0], entry[1], nil
+}
+
+func (c memoryURLCache) SetURLHash(imageURL string, hash string, etag string) error {
+	c[imageURL] = [2]string{hash, etag}
+	return nil
+}
+
+func TestManager_URLCache(t *testing.T) {
+	testImageData := []byte("fake image data for url cache")
+	etag := `"v1"`
+	gets := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("ETag", etag)
+		if r.Method == http.MethodGet {
+			gets++
+		}
+		w.Write(testImageData)
+	}))
+	defer server.Close()
+
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	cache := memoryURLCache{}
+	manager.SetURLCache(cache)
+
+	path, hash, err := manager.DownloadAndHash(server.URL + "/image.jpg")
+	if err != nil {
+		t.Fatalf("DownloadAndHash() error = %v", err)
+	}
+	if gets != 1 {
+		t.Fatalf("GET count after first download = %d, want 1", gets)
+	}
+
+	// Same ETag: the HEAD pre-check should skip the GET
+	path2, hash2, err := manager.DownloadAndHash(server.URL + "/image.jpg")
+	if err != nil {
+		t.Fatalf("DownloadAndHash() error = %v", err)
+	}
+	if gets != 1 {
+		t.Errorf("GET count with unchanged ETag = %d, want 1", gets)
+	}
+	if path2 != path || hash2 != hash {
+		t.Errorf("DownloadAndHash() = (%s, %s), want (%s, %s)", path2, hash2, path, hash)
+	}
+
+	// Changed ETag: fall back to a full GET
+	etag = `"v2"`
+	if _, _, err := manager.DownloadAndHash(server.URL + "/image.jpg"); err != nil {
+		t.Fatalf("DownloadAndHash() error = %v", err)
+	}
+	if gets != 2 {
+		t.Errorf("GET count with changed ETag = %d, want 2", gets)
+	}
+
+	// Missing local file: fall back to a full GET
+	os.Remove(path)
+	if _, _, err := manager.DownloadAndHash(server.URL + "/image.jpg"); err != nil {
+		t.Fatalf("DownloadAndHash() error = %v", err)
+	}
+	if gets != 3 {
+		t.Errorf("GET count with missing file = %d, want 3", gets)
+	}
+}
+
+func TestManager_AllowDeletedFiles(t *testing.T) {
+	testImageData := []byte("fake image data for deleted files")
+	gets := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Method == http.MethodGet {
+			gets++
+		}
+		w.Write(testImageData)
+	}))
+	defer server.Close()
+
+	manager, err := NewManagerWithOptions(t.TempDir(), Options{AllowDeletedFiles: true})
+	if err != nil {
+		t.Fatalf("NewManagerWithOptions() error = %v", err)
+	}
+	manager.SetURLCache(memoryURLCache{})
+
+	path, hash, err := manager.DownloadAndHash(server.URL + "/image.jpg")
+	if err != nil {
+		t.Fatalf("DownloadAndHash() error = %v", err)
+	}
+	if err := manager.DeleteImage(path); err != nil {
+		t.Fatalf("DeleteImage() error = %v", err)
+	}
+	if _, err := manager.GetImagePath(hash); !errors.Is(err, ErrImageNotFound) {
+		t.Errorf("GetImagePath() error = %v, want ErrImageNotFound", err)
+	}
+	// Deleting again is not an error
+	if err := manager.DeleteImage(path); err != nil {
+		t.Errorf("DeleteImage() of missing file error = %v", err)
+	}
+
+	// Unchanged and deleted: the hash is reported without a path or a GET
+	path2, hash2, err := manager.DownloadAndHash(server.URL + "/image.jpg")
+	if err != nil {
+		t.Fatalf("DownloadAndHash() error = %v", err)
+	}
+	if path2 != "" || hash2 != hash {
+		t.Errorf("DownloadAndHash() = (%q, %s), want (\"\", %s)", path2, hash2, hash)
+	}
+	if gets != 1 {
+		t.Errorf("GET count for deleted unchanged file = %d, want 1", gets)
+	}
+
+	// A notifier still needs it: fetch it again
+	path3, hash3, err := manager.RedownloadForAlbum(server.URL+"/image.jpg", "")
+	if err != nil {
+		t.Fatalf("RedownloadForAlbum() error = %v", err)
+	}
+	if path3 != path || hash3 != hash {
+		t.Errorf("RedownloadForAlbum() = (%s, %s), want (%s, %s)", path3, hash3, path, hash)
+	}
+	if gets != 2 {
+		t.Errorf("GET count after redownload = %d, want 2", gets)
+	}
+}
+
+func TestParseMediaType(t *testing.T) {
+	tests := []struct {
+		entry   string
+		want    string
+		wantErr bool
+	}{
+		{entry: "jpg", want: "image/jpeg"},
+		{entry: ".JPEG", want: "image/jpeg"},
+		{entry: "mov", want: "video/quicktime"},
+		{entry: "image/GIF", want: "image/gif"},
+		{entry: "video/*", want: "video/*"},
+		{entry: "notatype", wantErr: true},
+		{entry: "image/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			got, err := ParseMediaType(tt.entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMediaType(%q) error = %v, wantErr %v", tt.entry, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMediaType(%q) = %q, want %q", tt.entry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_DownloadAndHash_TypeFilters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photo":
+			w.Header().Set("Content-Type", "image/jpeg")
+		case "/animation":
+			w.Header().Set("Content-Type", "image/gif")
+		case "/video":
+			w.Header().Set("Content-Type", "video/mp4")
+		}
+		w.Write([]byte("media data " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		opts    Options
+		allowed map[string]bool
+	}{
+		{
+			name:    "no filters",
+			allowed: map[string]bool{"/photo": true, "/animation": true, "/video": true},
+		},
+		{
+			name:    "allow list",
+			opts:    Options{AllowedTypes: []string{"image/jpeg", "image/png"}},
+			allowed: map[string]bool{"/photo": true},
+		},
+		{
+			name:    "block list with wildcard",
+			opts:    Options{BlockedTypes: []string{"image/gif", "video/*"}},
+			allowed: map[string]bool{"/photo": true},
+		},
+		{
+			name:    "block overrides allow",
+			opts:    Options{AllowedTypes: []string{"image/*"}, BlockedTypes: []string{"image/gif"}},
+			allowed: map[string]bool{"/photo": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, err := NewManagerWithOptions(t.TempDir(), tt.opts)
+			if err != nil {
+				t.Fatalf("NewManagerWithOptions() error = %v", err)
+			}
+			for _, path := range []string{"/photo", "/animation", "/video"} {
+				_, _, err := manager.DownloadAndHash(server.URL + path)
+				if tt.allowed[path] {
+					if err != nil {
+						t.Errorf("DownloadAndHash(%s) error = %v, want nil", path, err)
+					}
+				} else if !errors.Is(err, ErrTypeNotAllowed) {
+					t.Errorf("DownloadAndHash(%s) error = %v, want ErrTypeNotAllowed", path, err)
+				}
+			}
+		})
+	}
+}
+
+func TestManager_DownloadTimeout(t *testing.T) {
+	// A 2 MB body trickled out over ~300ms: too slow for the base timeout alone
+	body := make([]byte, 2<<20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		for i := 0; i < 4; i++ {
+			w.Write(body[i*len(body)/4 : (i+1)*len(body)/4])
+			w.(http.Flusher).Flush()
+			time.Sleep(75 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "base timeout only", opts: Options{DownloadTimeout: 100 * time.Millisecond}, wantErr: true},
+		{name: "size-aware timeout", opts: Options{DownloadTimeout: 100 * time.Millisecond, DownloadTimeoutPerMB: time.Second}},
+		{name: "no timeout", opts: Options{DownloadTimeout: -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, err := NewManagerWithOptions(t.TempDir(), tt.opts)
+			if err != nil {
+				t.Fatalf("NewManagerWithOptions() error = %v", err)
+			}
+			_, _, err = manager.DownloadAndHash(server.URL + "/video")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DownloadAndHash() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "timed out") {
+				t.Errorf("DownloadAndHash() error = %v, want a timeout error", err)
+			}
+		})
+	}
+}
+
+func TestManager_CheckWritable(t *testing.T) {
+	dir := t.TempDir()
+	manager, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if err := manager.CheckWritable(); err != nil {
+		t.Fatalf("CheckWritable() error = %v, want nil", err)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("Skipping read-only check: root ignores directory permissions")
+	}
+	if err := os.Chmod(dir, 0555); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	defer os.Chmod(dir, 0755)
+	if err := manager.CheckWritable(); !errors.Is(err, ErrImageDirUnwritable) {
+		t.Errorf("CheckWritable() error = %v, want ErrImageDirUnwritable", err)
+	}
+}
+
+func TestClassifyWriteError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "read-only file system", err: &os.PathError{Op: "open", Path: "/images/x", Err: syscall.EROFS}, want: true},
+		{name: "disk full", err: &os.PathError{Op: "write", Path: "/images/x", Err: syscall.ENOSPC}, want: true},
+		{name: "permission denied", err: &os.PathError{Op: "open", Path: "/images/x", Err: syscall.EACCES}, want: true},
+		{name: "connection reset", err: syscall.ECONNRESET, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(classifyWriteError(tt.err), ErrImageDirUnwritable); got != tt.want {
+				t.Errorf("classifyWriteError(%v) unwritable = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewManagerWithOptions_ConnectionLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		wantMax  int
+		wantIdle int
+	}{
+		{name: "defaults", opts: Options{}, wantMax: 8, wantIdle: 8},
+		{name: "custom", opts: Options{MaxConnsPerHost: 2, MaxIdleConnsPerHost: 1}, wantMax: 2, wantIdle: 1},
+		{name: "no limit", opts: Options{MaxConnsPerHost: -1}, wantMax: 0, wantIdle: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, err := NewManagerWithOptions(t.TempDir(), tt.opts)
+			if err != nil {
+				t.Fatalf("NewManagerWithOptions() error = %v", err)
+			}
+			transport, ok := manager.client.Transport.(*http.Transport)
+			if !ok {
+				t.Fatalf("client.Transport = %T, want *http.Transport", manager.client.Transport)
+			}
+			if transport.MaxConnsPerHost != tt.wantMax {
+				t.Errorf("MaxConnsPerHost = %d, want %d", transport.MaxConnsPerHost, tt.wantMax)
+			}
+			if transport.MaxIdleConnsPerHost != tt.wantIdle {
+				t.Errorf("MaxIdleConnsPerHost = %d, want %d", transport.MaxIdleConnsPerHost, tt.wantIdle)
+			}
+		})
+	}
+}
+
+func TestNewManagerWithOptions_CACertPath(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("image behind an inspecting proxy"))
+	}))
+	defer server.Close()
+
+	// Without the extra CA the test server's certificate is untrusted
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if _, _, err := manager.DownloadAndHash(server.URL + "/photo.jpg"); err == nil {
+		t.Fatal("DownloadAndHash() error = nil, want certificate error without the CA")
+	}
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caPath, caPEM, 0644); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	manager, err = NewManagerWithOptions(t.TempDir(), Options{CACertPath: caPath})
+	if err != nil {
+		t.Fatalf("NewManagerWithOptions() error = %v", err)
+	}
+	if _, _, err := manager.DownloadAndHash(server.URL + "/photo.jpg"); err != nil {
+		t.Errorf("DownloadAndHash() error = %v, want the extra CA trusted", err)
+	}
+
+	invalidPath := filepath.Join(t.TempDir(), "invalid.pem")
+	if err := os.WriteFile(invalidPath, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	if _, err := NewManagerWithOptions(t.TempDir(), Options{CACertPath: invalidPath}); err == nil {
+		t.Error("NewManagerWithOptions() error = nil, want error for a file without certificates")
+	}
+}
+
+func TestManager_MaxDownloadBytesPerSec(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 10000)
+	var next atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		// Distinct content so each download is its own file
+		w.Write(append([]byte{byte(next.Add(1))}, body...))
+	}))
+	defer server.Close()
+
+	manager, err := NewManagerWithOptions(t.TempDir(), Options{MaxDownloadBytesPerSec: 10000})
+	if err != nil {
+		t.Fatalf("NewManagerWithOptions() error = %v", err)
+	}
+
+	// The first second's worth is allowed at once; the rest of the ~20KB shared by both downloads
+	// must take about another second
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, _, err := manager.DownloadAndHash(fmt.Sprintf("%s/image%d.jpg", server.URL, i)); err != nil {
+				t.Errorf("DownloadAndHash() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("two 10KB downloads at 10KB/s took %v, want about 1s", elapsed)
+	}
+}
+
+func TestRateLimiter_WaitCanceled(t *testing.T) {
+	limiter := newRateLimiter(100)
+	if err := limiter.wait(context.Background(), 100); err != nil {
+		t.Fatalf("wait() within burst error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.wait(ctx, 100); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait() error = %v, want context.DeadlineExceeded", err)
+	}
+	if newRateLimiter(0) != nil {
+		t.Error("newRateLimiter(0) should be nil (no limit)")
+	}
+}
+
+func TestManager_MinResolution(t *testing.T) {
+	encode := func(width, height int) []byte {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+			t.Fatalf("Failed to encode image: %v", err)
+		}
+		return buf.Bytes()
+	}
+	bodies := map[string][]byte{
+		"/small.jpg":  encode(100, 80),
+		"/narrow.jpg": encode(100, 800),
+		"/large.jpg":  encode(640, 480),
+		"/video.mp4":  []byte("not an image"),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bodies[r.URL.Path])
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	manager, err := NewManagerWithOptions(dir, Options{MinWidth: 320, MinHeight: 240})
+	if err != nil {
+		t.Fatalf("NewManagerWithOptions() error = %v", err)
+	}
+	for path, wantSkipped := range map[string]bool{"/small.jpg": true, "/narrow.jpg": true, "/large.jpg": false, "/video.mp4": false} {
+		_, _, err := manager.DownloadAndHash(server.URL + path)
+		if wantSkipped && !errors.Is(err, ErrImageTooSmall) {
+			t.Errorf("DownloadAndHash(%s) error = %v, want ErrImageTooSmall", path, err)
+		}
+		if !wantSkipped && err != nil {
+			t.Errorf("DownloadAndHash(%s) error = %v, want kept", path, err)
+		}
+	}
+
+	// Skipped downloads leave no files behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("image directory has %d files, want 2 (the large image and the video)", len(entries))
+	}
+}
@@ -0,0 +1,182 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+	"github.com/jsteffee/icloud-photo-sync/pkg/ratelimit"
+)
+
+// DefaultAPIURL is the Bot API server used unless TelegramConfig.APIURL is set
+const DefaultAPIURL = "https://api.telegram.org"
+
+// maxPhotoBytes is the largest file the Bot API accepts as a photo; larger files (and formats
+// Telegram doesn't show as photos) are sent as documents
+const maxPhotoBytes = 10 << 20
+
+// maxCaptionLength is the longest caption Telegram accepts, in characters
+const maxCaptionLength = 1024
+
+// Client posts photos to a Telegram chat through the Bot API
+type Client struct {
+	config     *config.TelegramConfig
+	apiURL     string
+	httpClient *http.Client
+}
+
+// NewClient creates a new Telegram client
+func NewClient(cfg *config.TelegramConfig) (*Client, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("Telegram config is required")
+	}
+	if cfg.BotToken == "" || cfg.ChatID == "" {
+		return nil, fmt.Errorf("Telegram bot token and chat ID are required")
+	}
+	apiURL := cfg.APIURL
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+
+	return &Client{
+		config: cfg,
+		apiURL: apiURL,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Minute, // Large files can take a while
+		},
+	}, nil
+}
+
+// apiResponse is the envelope of every Bot API response
+type apiResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"` // Seconds to wait when rate limited
+	} `json:"parameters"`
+}
+
+// PostPhoto sends the file at filePath to the chat with an optional caption, as a photo when
+// Telegram can show it as one and as a document otherwise. A rate-limited request is retried
+// once after the wait Telegram asks for
+func (c *Client) PostPhoto(filePath string, caption string) error {
+	method, field := "sendPhoto", "photo"
+	if !isPhoto(filePath) {
+		method, field = "sendDocument", "document"
+	}
+
+	resp, err := c.send(method, field, filePath, caption)
+	if err == nil && resp.ErrorCode == http.StatusTooManyRequests {
+		wait := ratelimit.RetryAfter(resp.Parameters.RetryAfter)
+		logging.Debugf("Telegram rate limit reached, retrying in %s", wait)
+		time.Sleep(wait)
+		resp, err = c.send(method, field, filePath, caption)
+	}
+	if err == nil && method == "sendPhoto" && resp.ErrorCode == http.StatusBadRequest && strings.Contains(resp.Description, "PHOTO_INVALID_DIMENSIONS") {
+		// Panoramas and other extreme shapes are only accepted as documents
+		method = "sendDocument"
+		resp, err = c.send(method, "document", filePath, caption)
+	}
+	if err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("Telegram %s failed with status %d: %s", method, resp.ErrorCode, resp.Description)
+	}
+	return nil
+}
+
+// send makes one multipart Bot API call uploading the file as field
+func (c *Client) send(method string, field string, filePath string, caption string) (*apiResponse, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	// Stream the form so large files aren't held in memory
+	body, form := io.Pipe()
+	writer := multipart.NewWriter(form)
+	go func() {
+		form.CloseWithError(writeForm(writer, file, field, c.config.ChatID, truncateCaption(caption)))
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bot%s/%s", c.apiURL, c.config.BotToken, method), body)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// The error includes the URL, and with it the bot token
+		return nil, fmt.Errorf("failed to call Telegram %s: %w", method, redactToken(err, c.config.BotToken))
+	}
+	defer resp.Body.Close()
+
+	var result apiResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("Telegram %s failed with status %d", method, resp.StatusCode)
+	}
+	if !result.OK && result.ErrorCode == 0 {
+		result.ErrorCode = resp.StatusCode
+	}
+	return &result, nil
+}
+
+// writeForm writes the fields and file of a send call and closes the form
+func writeForm(writer *multipart.Writer, file *os.File, field string, chatID string, caption string) error {
+	if err := writer.WriteField("chat_id", chatID); err != nil {
+		return fmt.Errorf("failed to write form field: %w", err)
+	}
+	if caption != "" {
+		if err := writer.WriteField("caption", caption); err != nil {
+			return fmt.Errorf("failed to write form field: %w", err)
+		}
+	}
+
+	part, err := writer.CreateFormFile(field, filepath.Base(file.Name()))
+	if err != nil {
+		return fmt.Errorf("failed to create file part: %w", err)
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return writer.Close()
+}
+
+// isPhoto reports whether Telegram accepts the file as a photo: a JPEG, PNG or WebP small
+// enough for sendPhoto
+func isPhoto(filePath string) bool {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".jpg", ".jpeg", ".png", ".webp":
+	default:
+		return false
+	}
+	info, err := os.Stat(filePath)
+	return err == nil && info.Size() <= maxPhotoBytes
+}
+
+// truncateCaption shortens a caption to the length Telegram accepts
+func truncateCaption(caption string) string {
+	runes := []rune(caption)
+	if len(runes) <= maxCaptionLength {
+		return caption
+	}
+	return string(runes[:maxCaptionLength-1]) + "…"
+}
+
+// redactToken removes the bot token from an error message
+func redactToken(err error, token string) error {
+	return fmt.Errorf("%s", strings.ReplaceAll(err.Error(), token, "<token>"))
+}
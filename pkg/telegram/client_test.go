@@ -0,0 +1,187 @@
+package telegram
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+)
+
+func TestNewClient_RequiresConfig(t *testing.T) {
+	if _, err := NewClient(nil); err == nil {
+		t.Error("NewClient(nil) error = nil, want error")
+	}
+	if _, err := NewClient(&config.TelegramConfig{BotToken: "123:abc"}); err == nil {
+		t.Error("NewClient() without chat ID error = nil, want error")
+	}
+}
+
+// request is a Bot API call received by the test server
+type request struct {
+	path     string
+	fields   map[string]string
+	file     string // Form field the file was sent as
+	fileName string
+	fileData string
+}
+
+// newTestServer records each call and answers with the responses in turn (the last one
+// repeatedly)
+func newTestServer(t *testing.T, responses ...string) (*httptest.Server, *[]request) {
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := request{path: r.URL.Path, fields: map[string]string{}}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("Failed to parse form: %v", err)
+			return
+		}
+		for key, values := range r.MultipartForm.Value {
+			req.fields[key] = values[0]
+		}
+		for field, files := range r.MultipartForm.File {
+			req.file, req.fileName = field, files[0].Filename
+			if file, err := files[0].Open(); err == nil {
+				data, _ := io.ReadAll(file)
+				req.fileData = string(data)
+				file.Close()
+			}
+		}
+		response := responses[min(len(requests), len(responses)-1)]
+		requests = append(requests, req)
+		w.Write([]byte(response))
+	}))
+	return server, &requests
+}
+
+func writeImage(t *testing.T, name string, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write test image: %v", err)
+	}
+	return path
+}
+
+func TestClient_PostPhoto(t *testing.T) {
+	server, requests := newTestServer(t, `{"ok": true, "result": {}}`)
+	defer server.Close()
+
+	client, err := NewClient(&config.TelegramConfig{BotToken: "123:abc", ChatID: "-10042", APIURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if err := client.PostPhoto(writeImage(t, "abc123.jpg", "fake jpeg data"), "Beach day"); err != nil {
+		t.Fatalf("PostPhoto() error = %v", err)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("server got %d requests, want 1", len(*requests))
+	}
+	req := (*requests)[0]
+	if req.path != "/bot123:abc/sendPhoto" {
+		t.Errorf("path = %s, want /bot123:abc/sendPhoto", req.path)
+	}
+	if req.fields["chat_id"] != "-10042" || req.fields["caption"] != "Beach day" {
+		t.Errorf("fields = %v, want the chat ID and caption", req.fields)
+	}
+	if req.file != "photo" || req.fileName != "abc123.jpg" || req.fileData != "fake jpeg data" {
+		t.Errorf("file = %s %s %q, want photo abc123.jpg with the image data", req.file, req.fileName, req.fileData)
+	}
+
+	// Formats Telegram doesn't show as photos are sent as documents, without an empty caption
+	if err := client.PostPhoto(writeImage(t, "def456.gif", "fake gif data"), ""); err != nil {
+		t.Fatalf("PostPhoto() error = %v", err)
+	}
+	req = (*requests)[1]
+	if req.path != "/bot123:abc/sendDocument" || req.file != "document" {
+		t.Errorf("request = %s with file %s, want sendDocument with document", req.path, req.file)
+	}
+	if _, ok := req.fields["caption"]; ok {
+		t.Error("caption sent for an image without one")
+	}
+}
+
+func TestClient_PostPhoto_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []string
+		wantPaths []string
+		wantErr   string
+	}{
+		{
+			name:      "rejected",
+			responses: []string{`{"ok": false, "error_code": 400, "description": "Bad Request: chat not found"}`},
+			wantPaths: []string{"/bot123:abc/sendPhoto"},
+			wantErr:   "chat not found",
+		},
+		{
+			name: "rate limited then sent",
+			responses: []string{
+				`{"ok": false, "error_code": 429, "description": "Too Many Requests", "parameters": {"retry_after": 0}}`,
+				`{"ok": true}`,
+			},
+			wantPaths: []string{"/bot123:abc/sendPhoto", "/bot123:abc/sendPhoto"},
+		},
+		{
+			name: "too large a shape for a photo",
+			responses: []string{
+				`{"ok": false, "error_code": 400, "description": "Bad Request: PHOTO_INVALID_DIMENSIONS"}`,
+				`{"ok": true}`,
+			},
+			wantPaths: []string{"/bot123:abc/sendPhoto", "/bot123:abc/sendDocument"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newTestServer(t, tt.responses...)
+			defer server.Close()
+
+			client, err := NewClient(&config.TelegramConfig{BotToken: "123:abc", ChatID: "-10042", APIURL: server.URL})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			err = client.PostPhoto(writeImage(t, "abc123.jpg", "fake jpeg data"), "")
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("PostPhoto() error = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("PostPhoto() error = %v, want one containing %q", err, tt.wantErr)
+			}
+			var paths []string
+			for _, req := range *requests {
+				paths = append(paths, req.path)
+			}
+			if strings.Join(paths, " ") != strings.Join(tt.wantPaths, " ") {
+				t.Errorf("requests = %v, want %v", paths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestClient_PostPhoto_RedactsToken(t *testing.T) {
+	client, err := NewClient(&config.TelegramConfig{BotToken: "123:secret", ChatID: "-10042", APIURL: "http://127.0.0.1:1"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	err = client.PostPhoto(writeImage(t, "abc123.jpg", "fake jpeg data"), "")
+	if err == nil {
+		t.Fatal("PostPhoto() error = nil, want a connection error")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("PostPhoto() error = %v, want the bot token redacted", err)
+	}
+}
+
+func TestTruncateCaption(t *testing.T) {
+	if got := truncateCaption("short"); got != "short" {
+		t.Errorf("truncateCaption() = %q, want it unchanged", got)
+	}
+	got := truncateCaption(strings.Repeat("é", maxCaptionLength+10))
+	if n := len([]rune(got)); n != maxCaptionLength || !strings.HasSuffix(got, "…") {
+		t.Errorf("truncateCaption() = %d characters ending %q, want %d ending with an ellipsis", n, got[len(got)-3:], maxCaptionLength)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+	"github.com/jsteffee/icloud-photo-sync/pkg/redis"
+)
+
+// runProgress records in Redis the images a run has finished, so a run that is interrupted
+// (RUN_PROGRESS_TTL) is resumed by the next one without downloading and checking those images
+// again. Images are identified within their album by asset GUID, or by URL when iCloud gives
+// none. Safe for concurrent use
+type runProgress struct {
+	redisClient *redis.Client
+	albumURLs   []string
+	ttl         time.Duration
+	done        []map[string]bool // Images the interrupted run finished, by album; read-only once loaded
+}
+
+// loadRunProgress reads the images the previous run finished before it was interrupted
+func loadRunProgress(redisClient *redis.Client, albumURLs []string, ttl time.Duration) *runProgress {
+	progress := &runProgress{
+		redisClient: redisClient,
+		albumURLs:   albumURLs,
+		ttl:         ttl,
+		done:        make([]map[string]bool, len(albumURLs)),
+	}
+	total := 0
+	for i, albumURL := range albumURLs {
+		images, err := redisClient.RunProgress(albumURL)
+		if err != nil {
+			logging.Errorf("Error reading run progress for album %d from Redis, checking all of its images: %v", i+1, err)
+			continue
+		}
+		progress.done[i] = make(map[string]bool, len(images))
+		for _, image := range images {
+			progress.done[i][image] = true
+		}
+		total += len(images)
+	}
+	if total > 0 {
+		logging.Infof("Resuming an interrupted run: skipping %d images it already finished", total)
+	}
+	return progress
+}
+
+// progressKey identifies an image within its album
+func progressKey(image albumImage) string {
+	if image.guid != "" {
+		return image.guid
+	}
+	return image.url
+}
+
+// finished reports whether the interrupted run already finished an image
+func (r *runProgress) finished(image albumImage) bool {
+	if image.album >= len(r.done) {
+		return false
+	}
+	return r.done[image.album][progressKey(image)]
+}
+
+// record notes that this run has finished an image
+func (r *runProgress) record(image albumImage) {
+	if image.album >= len(r.albumURLs) {
+		return
+	}
+	if err := r.redisClient.AddRunProgress(r.albumURLs[image.album], progressKey(image), r.ttl); err != nil {
+		logging.Errorf("Error storing run progress in Redis: %v", err)
+	}
+}
+
+// clear forgets the progress once a run has gone through every image, so the next run checks
+// them all again. Albums left out of the run (MAX_ALBUMS_PER_RUN) keep theirs, since their
+// interrupted run hasn't been resumed yet
+func (r *runProgress) clear(skipped []bool) {
+	for i, albumURL := range r.albumURLs {
+		if i < len(skipped) && skipped[i] {
+			continue
+		}
+		if err := r.redisClient.ClearRunProgress(albumURL); err != nil {
+			logging.Errorf("Error clearing run progress for album %d in Redis: %v", i+1, err)
+		}
+	}
+}
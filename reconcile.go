@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+	"github.com/jsteffee/icloud-photo-sync/pkg/notify"
+	"github.com/jsteffee/icloud-photo-sync/pkg/photos"
+	"github.com/jsteffee/icloud-photo-sync/pkg/redis"
+	"github.com/jsteffee/icloud-photo-sync/pkg/storage"
+)
+
+// reconcileGooglePhotos uploads every image that was emailed but never uploaded to Google
+// Photos, from the files in the image directory, without scraping the albums or applying the
+// per-run limits. Images whose files are gone (e.g. DELETE_AFTER_UPLOAD) are downloaded again
+// from their recorded URL with REDOWNLOAD_MISSING_FILES, or else reported and left to the
+// regular sync. Returns the number of images that couldn't be uploaded
+func reconcileGooglePhotos(cfg *config.Config, redisClient *redis.Client, storageManager *storage.Manager, photosClient *photos.Client) (int, error) {
+	return runLocked(cfg, redisClient, func(ctx context.Context) (int, error) {
+		return reconcileGooglePhotosLocked(ctx, cfg, redisClient, storageManager, photosClient)
+	})
+}
+
+// reconcileGooglePhotosLocked does the work of reconcileGooglePhotos, stopping early if ctx is
+// canceled (the sync lock was lost)
+func reconcileGooglePhotosLocked(ctx context.Context, cfg *config.Config, redisClient *redis.Client, storageManager *storage.Manager, photosClient *photos.Client) (int, error) {
+	emailed, err := redisClient.ListEmailHashes()
+	if err != nil {
+		return 0, err
+	}
+	uploaded, err := redisClient.ListGooglePhotosHashes()
+	if err != nil {
+		return 0, err
+	}
+	var hashes []string
+	for hash := range emailed {
+		if _, ok := uploaded[hash]; !ok {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+	logging.Infof("Found %d emailed images not yet uploaded to Google Photos", len(hashes))
+	if len(hashes) == 0 {
+		return 0, nil
+	}
+
+	notifier := notify.NewGooglePhotosNotifier(photosClient, cfg.GooglePhotosConfig.AlbumName, nil)
+	notifier.SetAlbumRotation(cfg.GooglePhotosConfig.AlbumRotation)
+	if err := notifier.Prepare(); err != nil {
+		return 0, err
+	}
+
+	done, missing, failed := 0, 0, 0
+	for i, hash := range hashes {
+		if err := context.Cause(ctx); err != nil {
+			return failed, err
+		}
+		if deadLettered, err := redisClient.IsDeadLetteredFor(notifier.Name(), hash); err != nil {
+			return failed, err
+		} else if deadLettered {
+			logging.Debugf("Image with hash %s is dead-lettered for %s, skipping it", hash, notifier.Name())
+			continue
+		}
+
+		imagePath, err := storageManager.GetImagePath(hash)
+		if errors.Is(err, storage.ErrImageNotFound) && cfg.RedownloadMissingFiles {
+			imagePath, err = redownloadMissing(storageManager, hash, emailed[hash])
+		}
+		if errors.Is(err, storage.ErrImageNotFound) {
+			logging.Warnf("Image with hash %s is no longer in %s; the next sync uploads it if it's still in its album", hash, cfg.ImageDir)
+			missing++
+			continue
+		}
+		if err != nil {
+			logging.Errorf("Error locating image with hash %s: %v", hash, err)
+			failed++
+			continue
+		}
+
+		imageURL := emailed[hash]
+		logging.Infof("Uploading %d/%d to Google Photos: %s (hash: %s)", i+1, len(hashes), imagePath, hash)
+		metadata := notify.Metadata{ImageURL: imageURL, FileName: storageManager.OriginalName(hash)}
+		if err := notifier.Process(hash, imagePath, metadata); err != nil {
+			if errors.Is(err, notify.ErrUnavailable) {
+				// Every further upload would fail the same way
+				return failed, err
+			}
+			logging.Errorf("Error uploading image with hash %s to Google Photos: %v", hash, err)
+			failed++
+			continue
+		}
+		if err := redisClient.SetHashForGooglePhotos(hash, imageURL); err != nil {
+			return failed, fmt.Errorf("failed to record upload of %s: %w", hash, err)
+		}
+		done++
+	}
+
+	logging.Infof("Reconciliation finished: %d uploaded, %d no longer on disk, %d failed", done, missing, failed)
+	return failed, nil
+}
+
+// redownloadMissing downloads an image whose file is no longer on disk again from the URL it
+// was recorded with (REDOWNLOAD_MISSING_FILES). Returns storage.ErrImageNotFound if that fails
+// or the URL now serves different content, as iCloud URLs expire after a while
+func redownloadMissing(storageManager *storage.Manager, hash string, imageURL string) (string, error) {
+	if imageURL == "" {
+		return "", storage.ErrImageNotFound
+	}
+	imagePath, newHash, err := storageManager.RedownloadForAlbum(imageURL, "")
+	if err != nil {
+		logging.Debugf("Could not download missing image with hash %s again: %v", hash, err)
+		return "", storage.ErrImageNotFound
+	}
+	if newHash != hash {
+		logging.Debugf("URL of missing image with hash %s now has different content (hash: %s)", hash, newHash)
+		return "", storage.ErrImageNotFound
+	}
+	logging.Infof("Downloaded missing image with hash %s again: %s", hash, imagePath)
+	return imagePath, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+)
+
+// syncStatus tracks the outcome of the runs so far for the status endpoint (STATUS_ADDR).
+// Safe for concurrent use
+type syncStatus struct {
+	mu              sync.Mutex
+	lastSuccessTime time.Time // When the last run without failures finished
+	lastError       string    // The most recent failure of any run
+	lastErrorTime   time.Time
+	processedTotal  int       // New images across every run since startup
+	nextRunTime     time.Time // When the next run is scheduled (zero if none is)
+}
+
+// statusResponse is the JSON served by the status endpoint; unset times are null
+type statusResponse struct {
+	LastSuccessTime      *time.Time `json:"last_success_time"`
+	LastError            string     `json:"last_error,omitempty"`
+	LastErrorTime        *time.Time `json:"last_error_time"`
+	ImagesProcessedTotal int        `json:"images_processed_total"`
+	NextRunTime          *time.Time `json:"next_run_time"`
+}
+
+// recordRun updates the status with a run's summary and the error runSync returned
+func (s *syncStatus) recordRun(summary runSummary, err error, finished time.Time) {
+	if err == nil && len(summary.Errors) > 0 {
+		err = summary.Errors[len(summary.Errors)-1]
+	}
+	if err == nil && summary.Failed > 0 {
+		err = errors.New("run finished with failures")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processedTotal += summary.New
+	s.nextRunTime = time.Time{}
+	if err != nil {
+		s.lastError = err.Error()
+		s.lastErrorTime = finished
+		return
+	}
+	s.lastSuccessTime = finished
+}
+
+// setNextRun records when the next run is scheduled
+func (s *syncStatus) setNextRun(next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextRunTime = next
+}
+
+// ServeHTTP serves the status as JSON
+func (s *syncStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	response := statusResponse{
+		LastSuccessTime:      optionalTime(s.lastSuccessTime),
+		LastError:            s.lastError,
+		LastErrorTime:        optionalTime(s.lastErrorTime),
+		ImagesProcessedTotal: s.processedTotal,
+		NextRunTime:          optionalTime(s.nextRunTime),
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logging.Debugf("Error writing status response: %v", err)
+	}
+}
+
+// optionalTime returns nil for the zero time, so it is encoded as null
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// serveStatus serves the status as JSON on /status, and a liveness probe on /healthz, at addr
+// in the background. A server that can't start is logged without stopping the syncs
+func serveStatus(addr string, status *syncStatus) {
+	mux := http.NewServeMux()
+	mux.Handle("/status", status)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		logging.Infof("Serving sync status on %s (/status and /healthz)", addr)
+		if err := server.ListenAndServe(); err != nil {
+			logging.Errorf("Status server on %s stopped: %v", addr, err)
+		}
+	}()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/jsteffee/icloud-photo-sync/pkg/config"
+	"github.com/jsteffee/icloud-photo-sync/pkg/logging"
+	"github.com/jsteffee/icloud-photo-sync/pkg/storage"
+)
+
+// verifyMinImages is the smallest image directory in which a majority of mismatches is taken
+// to mean the keying scheme changed rather than that the files are corrupt
+const verifyMinImages = 10
+
+// verifyExistingFiles hashes every stored image again and reports those whose content no
+// longer matches the hash they're named after, e.g. files truncated by a crash mid-write
+// (VERIFY_EXISTING_FILES). With quarantine, they're moved to the quarantine directory so they
+// are never delivered; the next run downloads them again if they're still in an album.
+// Returns the number of mismatched images
+func verifyExistingFiles(cfg *config.Config, storageManager *storage.Manager) int {
+	images, err := storageManager.ListImages()
+	if err != nil {
+		logging.Errorf("Error listing stored images to verify: %v", err)
+		return 0
+	}
+	logging.Infof("Verifying %d stored images against their hashes (VERIFY_EXISTING_FILES=%s)", len(images), cfg.VerifyExistingFiles)
+
+	var mismatched []storage.StoredImage
+	for _, image := range images {
+		hash, err := storageManager.HashFile(image.Path)
+		if err != nil {
+			logging.Errorf("Error hashing %s: %v", image.Path, err)
+			continue
+		}
+		if hash != image.Hash {
+			logging.Warnf("Stored image %s doesn't match its hash (content hashes to %s); it may be corrupt or truncated", image.Path, hash)
+			mismatched = append(mismatched, image)
+		}
+	}
+	if len(mismatched) == 0 {
+		logging.Infof("All %d stored images match their hashes", len(images))
+		return 0
+	}
+
+	// Files stored under another HASH_ALGO or HASH_CONTENT all mismatch, but aren't corrupt
+	if len(images) >= verifyMinImages && len(mismatched)*2 > len(images) {
+		logging.Errorf("%d of %d stored images don't match their hashes, more likely from a change of HASH_ALGO or HASH_CONTENT than corruption; run --migrate-keys. Leaving them in place", len(mismatched), len(images))
+		return len(mismatched)
+	}
+	if cfg.VerifyExistingFiles != "quarantine" {
+		logging.Warnf("%d of %d stored images don't match their hashes", len(mismatched), len(images))
+		return len(mismatched)
+	}
+	quarantined := 0
+	for _, image := range mismatched {
+		quarantinePath, err := storageManager.QuarantineImage(image.Path)
+		if err != nil {
+			logging.Errorf("Error quarantining %s: %v", image.Path, err)
+			continue
+		}
+		logging.Infof("Quarantined mismatched image %s to %s", image.Path, quarantinePath)
+		quarantined++
+	}
+	logging.Warnf("Quarantined %d of %d stored images that didn't match their hashes", quarantined, len(images))
+	return len(mismatched)
+}